@@ -2,9 +2,9 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net/http"
-	"net/http/pprof"
 	"os"
 	"os/signal"
 	"syscall"
@@ -32,60 +32,513 @@ var flags []cli.Flag = []cli.Flag{
 		Usage:   "address to listen on for orderflow proxy API for other network participants",
 		EnvVars: []string{"PUBLIC_LISTEN_ADDR"},
 	},
+	&cli.BoolFlag{
+		Name:    "public-proxy-protocol",
+		Value:   false,
+		Usage:   "require PROXY protocol v2 header on the public listener and use client address from it, enable only behind TCP load balancer that sends it",
+		EnvVars: []string{"PUBLIC_PROXY_PROTOCOL"},
+	},
 	&cli.StringFlag{
 		Name:    "cert-listen-addr",
 		Value:   "127.0.0.1:14727",
-		Usage:   "address to listen on for orderflow proxy serving its SSL certificate on /cert",
+		Usage:   "address to listen on for orderflow proxy serving its SSL certificate on /cert and build info on /buildinfo",
 		EnvVars: []string{"CERT_LISTEN_ADDR"},
 	},
+	&cli.StringFlag{
+		Name:    "events-listen-addr",
+		Value:   "",
+		Usage:   "address to listen on for Server-Sent Events stream of accepted orderflow on /events and recent rejections on /admin/rejections, should not be exposed outside of the operator network",
+		EnvVars: []string{"EVENTS_LISTEN_ADDR"},
+	},
 	&cli.StringFlag{
 		Name:    "builder-endpoint",
 		Value:   "http://127.0.0.1:8645",
 		Usage:   "address to send local ordeflow to",
 		EnvVars: []string{"BUILDER_ENDPOINT"},
 	},
+	&cli.StringSliceFlag{
+		Name:    "local-tenants",
+		Usage:   "additional builders served on /<name> path of the local endpoint in name=builder_endpoint format, their orderflow is not shared with peers",
+		EnvVars: []string{"LOCAL_TENANTS"},
+	},
+	&cli.DurationFlag{
+		Name:    "builder-timeout",
+		Value:   time.Second * 10,
+		Usage:   "timeout of each request to the builder endpoint",
+		EnvVars: []string{"BUILDER_TIMEOUT"},
+	},
+	&cli.StringFlag{
+		Name:    "builder-auth-token",
+		Value:   "",
+		Usage:   "bearer token sent in the Authorization header of the requests to the builder endpoint",
+		EnvVars: []string{"BUILDER_AUTH_TOKEN"},
+	},
+	&cli.BoolFlag{
+		Name:    "sign-builder-requests",
+		Value:   false,
+		Usage:   "sign requests to the builder endpoint with the orderflow signer (X-Flashbots-Signature header)",
+		EnvVars: []string{"SIGN_BUILDER_REQUESTS"},
+	},
+	&cli.BoolFlag{
+		Name:    "raw-tx-to-bundle",
+		Value:   false,
+		Usage:   "send eth_sendRawTransaction to the builder as single transaction eth_sendBundle targeting the next block",
+		EnvVars: []string{"RAW_TX_TO_BUNDLE"},
+	},
+	&cli.BoolFlag{
+		Name:    "peer-compression",
+		Value:   true,
+		Usage:   "compress requests forwarded to other proxies with zstd or gzip if the peer accepts it",
+		EnvVars: []string{"PEER_COMPRESSION"},
+	},
+	&cli.DurationFlag{
+		Name:    "peer-timeout",
+		Value:   time.Second * 10,
+		Usage:   "timeout of each request forwarded to other proxies",
+		EnvVars: []string{"PEER_TIMEOUT"},
+	},
+	&cli.DurationFlag{
+		Name:    "request-deadline",
+		Value:   0,
+		Usage:   "drop requests that were not sent to a peer or the builder within this time after they were received, disabled if 0",
+		EnvVars: []string{"REQUEST_DEADLINE"},
+	},
+	&cli.DurationFlag{
+		Name:    "peer-cert-cache-ttl",
+		Value:   time.Hour,
+		Usage:   "time verified peer certificates and their connections are reused across peer list updates",
+		EnvVars: []string{"PEER_CERT_CACHE_TTL"},
+	},
 	&cli.StringFlag{
 		Name:    "rpc-endpoint",
 		Value:   "http://127.0.0.1:8545",
 		Usage:   "address of the node RPC that supports eth_blockNumber",
 		EnvVars: []string{"RPC_ENDPOINT"},
 	},
+	&cli.StringSliceFlag{
+		Name:    "rpc-fallback-endpoints",
+		Usage:   "node RPC addresses used in order when rpc-endpoint fails",
+		EnvVars: []string{"RPC_FALLBACK_ENDPOINTS"},
+	},
+	&cli.DurationFlag{
+		Name:    "block-number-cache-ttl",
+		Value:   time.Second * 3,
+		Usage:   "time the block number fetched from rpc-endpoint is cached",
+		EnvVars: []string{"BLOCK_NUMBER_CACHE_TTL"},
+	},
+	&cli.StringFlag{
+		Name:    "rpc-ws-endpoint",
+		Value:   "",
+		Usage:   "websocket address of the node RPC, if set block number is updated from newHeads subscription with polling of rpc-endpoint as fallback",
+		EnvVars: []string{"RPC_WS_ENDPOINT"},
+	},
+	&cli.StringFlag{
+		Name:    "mempool-ws-endpoint",
+		Value:   "",
+		Usage:   "websocket address of the node RPC, if set pending transactions from newPendingTransactions subscription are sent to the builder",
+		EnvVars: []string{"MEMPOOL_WS_ENDPOINT"},
+	},
 	&cli.StringFlag{
 		Name:    "builder-confighub-endpoint",
 		Value:   "http://127.0.0.1:14892",
 		Usage:   "address of the builder config hub enpoint (directly or using the cvm-proxy)",
 		EnvVars: []string{"BUILDER_CONFIGHUB_ENDPOINT"},
 	},
+	&cli.StringFlag{
+		Name:    "peer-list-stale-webhook",
+		Value:   "",
+		Usage:   "URL that is called with POST request when peer list could not be fetched from builder config hub for peer-list-stale-after",
+		EnvVars: []string{"PEER_LIST_STALE_WEBHOOK"},
+	},
+	&cli.DurationFlag{
+		Name:    "peer-list-stale-after",
+		Value:   time.Minute * 10,
+		Usage:   "time without successful fetch of the peer list after which peer-list-stale-webhook is called",
+		EnvVars: []string{"PEER_LIST_STALE_AFTER"},
+	},
 	&cli.StringFlag{
 		Name:    "orderflow-archive-endpoint",
 		Value:   "http://127.0.0.1:14893",
 		Usage:   "address of the ordreflow archive endpoint (block-processor)",
 		EnvVars: []string{"ORDERFLOW_ARCHIVE_ENDPOINT"},
 	},
+	&cli.StringFlag{
+		Name:    "archive-parquet-dir",
+		Value:   "",
+		Usage:   "if set, archived orderflow is written to parquet files partitioned by date and block in this directory instead of the orderflow archive endpoint",
+		EnvVars: []string{"ARCHIVE_PARQUET_DIR"},
+	},
+	&cli.StringFlag{
+		Name:    "archive-spill-dir",
+		Value:   "",
+		Usage:   "directory where batches are kept when the archive is down or slow and sent when it recovers, disabled if empty",
+		EnvVars: []string{"ARCHIVE_SPILL_DIR"},
+	},
+	&cli.Int64Flag{
+		Name:    "archive-spill-max-bytes",
+		Value:   proxy.DefaultArchiveSpillMaxBytes,
+		Usage:   "max size of the batches kept in the archive spill directory, new batches are dropped when it is full",
+		EnvVars: []string{"ARCHIVE_SPILL_MAX_BYTES"},
+	},
+	&cli.BoolFlag{
+		Name:    "archive-public-requests",
+		Value:   false,
+		Usage:   "archive requests received from peers as well, they are marked with the peer name",
+		EnvVars: []string{"ARCHIVE_PUBLIC_REQUESTS"},
+	},
+	&cli.BoolFlag{
+		Name:    "disable-peer-sharing",
+		Value:   false,
+		Usage:   "forward requests only to the local builder and archive, never share them with the peers",
+		EnvVars: []string{"DISABLE_PEER_SHARING"},
+	},
+	&cli.BoolFlag{
+		Name:    "receive-only",
+		Value:   false,
+		Usage:   "forward requests only to the local builder and never share them with the peers, for staging builders, archive flags still apply",
+		EnvVars: []string{"RECEIVE_ONLY"},
+	},
+	&cli.StringSliceFlag{
+		Name:    "archive-redacted-sinks",
+		Usage:   "archive sinks (rpc, parquet) that get only metadata of the requests with hashes and sizes of the transactions instead of raw transactions",
+		EnvVars: []string{"ARCHIVE_REDACTED_SINKS"},
+	},
+	&cli.StringFlag{
+		Name:    "archive-encryption-public-key",
+		Value:   "",
+		Usage:   "X25519 public key (hex or base64), if set archived orderflow is encrypted to this key before it leaves the proxy",
+		EnvVars: []string{"ARCHIVE_ENCRYPTION_PUBLIC_KEY"},
+	},
+	&cli.StringFlag{
+		Name:    "archive-encryption-kms-key-id",
+		Value:   "",
+		Usage:   "AWS KMS key (SYMMETRIC_DEFAULT), if set archived orderflow is encrypted with data keys generated by this key",
+		EnvVars: []string{"ARCHIVE_ENCRYPTION_KMS_KEY_ID"},
+	},
 	&cli.StringFlag{
 		Name:    "flashbots-orderflow-signer-address",
 		Value:   "0x5015Fa72E34f75A9eC64f44a4Fcf0837919D1bB7",
 		Usage:   "ordreflow from Flashbots will be signed with this address",
 		EnvVars: []string{"FLASHBOTS_ORDERFLOW_SIGNER_ADDRESS"},
 	},
+	&cli.BoolFlag{
+		Name:    "tdx-attestation",
+		Value:   false,
+		Usage:   "serve TDX quote bound to the TLS certificate on the public endpoint (uses configfs-tsm)",
+		EnvVars: []string{"TDX_ATTESTATION"},
+	},
+	&cli.StringFlag{
+		Name:    "orderflow-signer-kms-key-id",
+		Value:   "",
+		Usage:   "AWS KMS key (ECC_SECG_P256K1) used to sign requests to peers and archive, if empty random key is generated",
+		EnvVars: []string{"ORDERFLOW_SIGNER_KMS_KEY_ID"},
+	},
+	&cli.DurationFlag{
+		Name:    "signer-rotation-interval",
+		Value:   0,
+		Usage:   "if set orderflow signer is periodically replaced with a new random key",
+		EnvVars: []string{"SIGNER_ROTATION_INTERVAL"},
+	},
+	&cli.DurationFlag{
+		Name:    "signer-rotation-grace-period",
+		Value:   time.Minute,
+		Usage:   "time between registering a new signer and using it, previous signers of peers are accepted for the same time",
+		EnvVars: []string{"SIGNER_ROTATION_GRACE_PERIOD"},
+	},
+	&cli.StringSliceFlag{
+		Name:    "privileged-signers",
+		Usage:   "additional privileged orderflow signers as name=address, they are treated like Flashbots signer",
+		EnvVars: []string{"PRIVILEGED_SIGNERS"},
+	},
+	&cli.StringSliceFlag{
+		Name:    "disabled-public-methods",
+		Usage:   "RPC methods that are not served on the public endpoint, calls to them return method not found",
+		EnvVars: []string{"DISABLED_PUBLIC_METHODS"},
+	},
+	&cli.StringSliceFlag{
+		Name:    "disabled-local-methods",
+		Usage:   "RPC methods that are not served on the local endpoint, calls to them return method not found",
+		EnvVars: []string{"DISABLED_LOCAL_METHODS"},
+	},
+	&cli.StringSliceFlag{
+		Name:    "method-aliases",
+		Usage:   "additional RPC method names as alias=method, e.g. eth_sendPrivateRawTransaction=eth_sendRawTransaction",
+		EnvVars: []string{"METHOD_ALIASES"},
+	},
+	&cli.BoolFlag{
+		Name:    "strict-json-decoding",
+		Value:   false,
+		Usage:   "reject requests with params that have unknown fields or values of wrong type instead of ignoring them",
+		EnvVars: []string{"STRICT_JSON_DECODING"},
+	},
+	&cli.BoolFlag{
+		Name:    "log-http-requests",
+		Value:   false,
+		Usage:   "log every HTTP request to the public and local servers with the response status and duration",
+		EnvVars: []string{"LOG_HTTP_REQUESTS"},
+	},
+	&cli.StringSliceFlag{
+		Name:    "wasm-policy",
+		Usage:   "WASM policy modules applied in order to every request before it is forwarded, see proxy.WASMPolicyFilter for the module interface",
+		EnvVars: []string{"WASM_POLICY"},
+	},
+	&cli.DurationFlag{
+		Name:    "wasm-policy-timeout",
+		Value:   proxy.DefaultWASMPolicyTimeout,
+		Usage:   "max time one WASM policy module can run for one request, request is rejected when it is exceeded",
+		EnvVars: []string{"WASM_POLICY_TIMEOUT"},
+	},
+	&cli.IntFlag{
+		Name:    "wasm-policy-max-memory-bytes",
+		Value:   proxy.DefaultWASMPolicyMaxMemoryBytes,
+		Usage:   "max memory of one WASM policy module instance, rounded down to 64KiB pages",
+		EnvVars: []string{"WASM_POLICY_MAX_MEMORY_BYTES"},
+	},
 	&cli.Int64Flag{
 		Name:    "max-request-body-size-bytes",
 		Value:   0,
 		Usage:   "Maximum size of the request body, if 0 default will be used",
 		EnvVars: []string{"MAX_REQUEST_BODY_SIZE_BYTES"},
 	},
+	&cli.StringFlag{
+		Name:    "peer-public-port",
+		Value:   proxy.DefaultOrderflowProxyPublicPort,
+		Usage:   "public port of the peers that don't have port set in builder config hub",
+		EnvVars: []string{"PEER_PUBLIC_PORT"},
+	},
 	&cli.IntFlag{
 		Name:    "connections-per-peer",
 		Value:   10,
 		Usage:   "Number of parallel connections for each peer and archival RPC",
 		EnvVars: []string{"CONN_PER_PEER"},
 	},
+	&cli.IntFlag{
+		Name:    "share-workers-per-peer",
+		Value:   0,
+		Usage:   "Number of concurrent share queue workers for each peer, local and peer requests have separate workers, if 0 connections-per-peer is used",
+		EnvVars: []string{"SHARE_WORKERS_PER_PEER"},
+	},
+	&cli.IntFlag{
+		Name:    "share-queue-size",
+		Value:   proxy.ReceiverProxyWorkerQueueSize,
+		Usage:   "capacity of the share queues of the local and peer requests, every queued request keeps its params in memory, see README",
+		EnvVars: []string{"SHARE_QUEUE_SIZE"},
+	},
+	&cli.IntFlag{
+		Name:    "share-worker-queue-size",
+		Value:   proxy.ShareWorkerQueueSize,
+		Usage:   "capacity of the share worker queues of every peer and the local builder, split between the workers, see README",
+		EnvVars: []string{"SHARE_WORKER_QUEUE_SIZE"},
+	},
+	&cli.IntFlag{
+		Name:    "archive-queue-size",
+		Value:   proxy.ReceiverProxyWorkerQueueSize,
+		Usage:   "capacity of the archive queue, see README",
+		EnvVars: []string{"ARCHIVE_QUEUE_SIZE"},
+	},
+	&cli.IntFlag{
+		Name:    "share-batch-size",
+		Value:   0,
+		Usage:   "max number of requests sent to a peer in one JSON-RPC batch, batches are sent only to peers that accept them, 0 disables batching",
+		EnvVars: []string{"SHARE_BATCH_SIZE"},
+	},
+	&cli.IntFlag{
+		Name:    "share-batch-max-bytes",
+		Value:   1024 * 1024,
+		Usage:   "no more requests are added to the batch after its params reach this size, 0 means no limit",
+		EnvVars: []string{"SHARE_BATCH_MAX_BYTES"},
+	},
+	&cli.DurationFlag{
+		Name:    "share-batch-latency",
+		Value:   0,
+		Usage:   "time to wait for more requests to fill the batch, if 0 only already queued requests are batched",
+		EnvVars: []string{"SHARE_BATCH_LATENCY"},
+	},
+	&cli.DurationFlag{
+		Name:    "slow-peer-latency",
+		Value:   0,
+		Usage:   "peers with average latency above it get requests after the other peers got all queued requests, disabled if 0",
+		EnvVars: []string{"SLOW_PEER_LATENCY"},
+	},
+	&cli.DurationFlag{
+		Name:    "peer-probe-interval",
+		Value:   0,
+		Usage:   "interval of the canary requests sent to every peer to measure round trip and TLS handshake time, disabled if 0",
+		EnvVars: []string{"PEER_PROBE_INTERVAL"},
+	},
+	&cli.IntFlag{
+		Name:    "peer-shard-index",
+		Value:   0,
+		Usage:   "index of the peer shard this instance replicates to, peers are assigned to shards by consistent hashing of their names",
+		EnvVars: []string{"PEER_SHARD_INDEX"},
+	},
+	&cli.IntFlag{
+		Name:    "peer-shard-count",
+		Value:   0,
+		Usage:   "number of instances replication to peers is split between, all of them must receive the same local orderflow, sharding is disabled if <= 1",
+		EnvVars: []string{"PEER_SHARD_COUNT"},
+	},
+	&cli.StringFlag{
+		Name:    "backpressure-policy",
+		Value:   proxy.BackpressureBlock,
+		Usage:   "what to do when share or archive queue is full: block, drop-oldest or reject, with drop-oldest full queues of the share workers evict the oldest requests too",
+		EnvVars: []string{"BACKPRESSURE_POLICY"},
+	},
 	&cli.IntFlag{
 		Name:    "max-local-requests-per-second",
 		Value:   100,
 		Usage:   "Maximum number of unique local requests per second",
 		EnvVars: []string{"MAX_LOCAL_RPS"},
 	},
+	&cli.IntFlag{
+		Name:    "max-public-signer-requests-per-second",
+		Value:   0,
+		Usage:   "maximum number of requests per second of each peer on the public endpoint, scaled down with the signer reputation, disabled if 0",
+		EnvVars: []string{"MAX_PUBLIC_SIGNER_RPS"},
+	},
+	&cli.IntFlag{
+		Name:    "max-public-ip-requests-per-second",
+		Value:   0,
+		Usage:   "maximum number of requests per second of each client IP (/64 for IPv6) on the public endpoint, checked before the signature, disabled if 0",
+		EnvVars: []string{"MAX_PUBLIC_IP_RPS"},
+	},
+	&cli.StringFlag{
+		Name:    "reputation-db",
+		Value:   "",
+		Usage:   "path of the file where signer reputation is saved so it is kept across restarts, if empty reputation is kept only in memory",
+		EnvVars: []string{"REPUTATION_DB"},
+	},
+	&cli.StringFlag{
+		Name:    "audit-log",
+		Value:   "",
+		Usage:   "path of the append-only audit log of forwarded requests signed by the orderflow signer, disabled if empty",
+		EnvVars: []string{"AUDIT_LOG"},
+	},
+	&cli.StringSliceFlag{
+		Name:    "stats-windows",
+		Value:   cli.NewStringSlice("1m", "5m", "15m"),
+		Usage:   "windows of the rolling counts returned by orderflow_getStats on the local endpoint",
+		EnvVars: []string{"STATS_WINDOWS"},
+	},
+	&cli.DurationFlag{
+		Name:    "load-shed-builder-latency",
+		Value:   0,
+		Usage:   "reject requests from peers with overloaded error when average latency of the local builder is above this value, disabled if 0",
+		EnvVars: []string{"LOAD_SHED_BUILDER_LATENCY"},
+	},
+	&cli.StringFlag{
+		Name:    "ha-redis-url",
+		Value:   "",
+		Usage:   "redis URL of the store shared by replicas running behind the same public address, if set each request is handled only by one replica",
+		EnvVars: []string{"HA_REDIS_URL"},
+	},
+	&cli.Uint64Flag{
+		Name:    "chain-id",
+		Value:   0,
+		Usage:   "Chain ID of the transactions in bundles, transactions for other chains are rejected, if 0 check is disabled",
+		EnvVars: []string{"CHAIN_ID"},
+	},
+	&cli.Uint64Flag{
+		Name:    "max-past-blocks",
+		Value:   1,
+		Usage:   "Reject bundles that target only blocks more than this many blocks before the chain head, if 0 check is disabled",
+		EnvVars: []string{"MAX_PAST_BLOCKS"},
+	},
+	&cli.Uint64Flag{
+		Name:    "max-future-blocks",
+		Value:   100,
+		Usage:   "Reject bundles that target blocks more than this many blocks after the chain head, if 0 check is disabled",
+		EnvVars: []string{"MAX_FUTURE_BLOCKS"},
+	},
+	&cli.Uint64Flag{
+		Name:    "max-block-span",
+		Value:   0,
+		Usage:   "Reject bundles with max block more than this many blocks after the first target block, if 0 check is disabled",
+		EnvVars: []string{"MAX_BLOCK_SPAN"},
+	},
+	&cli.Uint64Flag{
+		Name:    "max-tx-gas-limit",
+		Value:   0,
+		Usage:   "Maximum gas limit of a transaction in bundles, if 0 default will be used",
+		EnvVars: []string{"MAX_TX_GAS_LIMIT"},
+	},
+	&cli.Uint64Flag{
+		Name:    "max-tx-size-bytes",
+		Value:   0,
+		Usage:   "Maximum size of a transaction excluding blob sidecar, if 0 default will be used",
+		EnvVars: []string{"MAX_TX_SIZE_BYTES"},
+	},
+	&cli.IntFlag{
+		Name:    "max-blobs-per-tx",
+		Value:   0,
+		Usage:   "Maximum number of blobs in a blob transaction, if 0 default will be used",
+		EnvVars: []string{"MAX_BLOBS_PER_TX"},
+	},
+	&cli.BoolFlag{
+		Name:    "disable-blob-txs",
+		Value:   false,
+		Usage:   "reject blob transactions for builders that don't support them",
+		EnvVars: []string{"DISABLE_BLOB_TXS"},
+	},
+	&cli.IntFlag{
+		Name:    "max-mev-bundle-depth",
+		Value:   0,
+		Usage:   "Maximum nesting depth of mev_sendBundle bundles, if 0 default will be used, negative value disallows nested bundles",
+		EnvVars: []string{"MAX_MEV_BUNDLE_DEPTH"},
+	},
+	&cli.IntFlag{
+		Name:    "max-mev-bundle-elements",
+		Value:   0,
+		Usage:   "Maximum number of body elements of mev_sendBundle including nested bundles, if 0 default will be used",
+		EnvVars: []string{"MAX_MEV_BUNDLE_ELEMENTS"},
+	},
+
+	&cli.DurationFlag{
+		Name:    "signature-freshness-window",
+		Value:   time.Minute,
+		Usage:   "max allowed clock difference for replay protected requests from peers",
+		EnvVars: []string{"SIGNATURE_FRESHNESS_WINDOW"},
+	},
+	&cli.BoolFlag{
+		Name:    "require-replay-protection",
+		Value:   true,
+		Usage:   "reject requests from peers without replay protection header, disable only while peers are upgraded to send it",
+		EnvVars: []string{"REQUIRE_REPLAY_PROTECTION"},
+	},
+	&cli.BoolFlag{
+		Name:    "allow-unsigned-local",
+		Value:   false,
+		Usage:   "accept unsigned requests on the local endpoint as coming from an unknown signer",
+		EnvVars: []string{"ALLOW_UNSIGNED_LOCAL"},
+	},
+	&cli.StringSliceFlag{
+		Name:    "mev-share-required-hints",
+		Usage:   "privacy hints that must be set in mev_sendBundle requests on the local endpoint",
+		EnvVars: []string{"MEV_SHARE_REQUIRED_HINTS"},
+	},
+	&cli.StringSliceFlag{
+		Name:    "mev-share-disallowed-builders",
+		Usage:   "builders that can't be listed in privacy of mev_sendBundle requests on the local endpoint",
+		EnvVars: []string{"MEV_SHARE_DISALLOWED_BUILDERS"},
+	},
+	&cli.StringFlag{
+		Name:    "blocklist",
+		Value:   "",
+		Usage:   "file path or URL of the list of blocked addresses (one per line or JSON array), transactions interacting with them are rejected",
+		EnvVars: []string{"BLOCKLIST"},
+	},
+	&cli.DurationFlag{
+		Name:    "blocklist-refresh-interval",
+		Value:   time.Minute * 10,
+		Usage:   "how often blocklist is reloaded",
+		EnvVars: []string{"BLOCKLIST_REFRESH_INTERVAL"},
+	},
+	&cli.BoolFlag{
+		Name:    "blocklist-flag-only",
+		Value:   false,
+		Usage:   "only log and count transactions interacting with blocked addresses instead of rejecting them",
+		EnvVars: []string{"BLOCKLIST_FLAG_ONLY"},
+	},
 
 	// certificate config
 	&cli.DurationFlag{
@@ -94,10 +547,16 @@ var flags []cli.Flag = []cli.Flag{
 		Usage:   "generated certificate duration",
 		EnvVars: []string{"CERT_DURATION"},
 	},
+	&cli.DurationFlag{
+		Name:    "cert-renew-before",
+		Value:   time.Hour * 24 * 7,
+		Usage:   "generate a new certificate and register it on the builder config hub this long before the certificate expires, disabled if 0",
+		EnvVars: []string{"CERT_RENEW_BEFORE"},
+	},
 	&cli.StringSliceFlag{
 		Name:    "cert-hosts",
 		Value:   cli.NewStringSlice("127.0.0.1", "localhost"),
-		Usage:   "generated certificate hosts",
+		Usage:   "generated certificate hosts (IPv4, IPv6 or DNS names)",
 		EnvVars: []string{"CERT_HOSTS"},
 	},
 
@@ -108,6 +567,30 @@ var flags []cli.Flag = []cli.Flag{
 		Usage:   "address to listen on for Prometheus metrics (metrics are served on $metrics-addr/metrics)",
 		EnvVars: []string{"METRICS_ADDR"},
 	},
+	&cli.StringFlag{
+		Name:    "metrics-tls-cert",
+		Value:   "",
+		Usage:   "path of the TLS certificate of the metrics server, if set metrics are served over TLS",
+		EnvVars: []string{"METRICS_TLS_CERT"},
+	},
+	&cli.StringFlag{
+		Name:    "metrics-tls-key",
+		Value:   "",
+		Usage:   "path of the TLS key of the metrics server",
+		EnvVars: []string{"METRICS_TLS_KEY"},
+	},
+	&cli.StringFlag{
+		Name:    "metrics-client-ca",
+		Value:   "",
+		Usage:   "path of the CA certificates, if set metrics clients must present certificate signed by them",
+		EnvVars: []string{"METRICS_CLIENT_CA"},
+	},
+	&cli.StringFlag{
+		Name:    "metrics-basic-auth",
+		Value:   "",
+		Usage:   "user:password required by the metrics server",
+		EnvVars: []string{"METRICS_BASIC_AUTH"},
+	},
 	&cli.BoolFlag{
 		Name:    "log-json",
 		Value:   false,
@@ -135,9 +618,39 @@ var flags []cli.Flag = []cli.Flag{
 	&cli.BoolFlag{
 		Name:    "pprof",
 		Value:   false,
-		Usage:   "enable pprof debug endpoint (pprof is served on $metrics-addr/debug/pprof/*)",
+		Usage:   "enable profiling on start, it can be toggled at runtime on $metrics-addr/admin/pprof (pprof is served on $pprof-addr/debug/pprof/*)",
 		EnvVars: []string{"PPROF"},
 	},
+	&cli.StringFlag{
+		Name:    "pprof-addr",
+		Value:   "",
+		Usage:   "address of the dedicated pprof listener, pprof is not served if empty",
+		EnvVars: []string{"PPROF_ADDR"},
+	},
+	&cli.StringFlag{
+		Name:    "pprof-token",
+		Value:   "",
+		Usage:   "bearer token required by the pprof listener and the pprof admin endpoint, required if pprof-addr is set",
+		EnvVars: []string{"PPROF_TOKEN"},
+	},
+	&cli.Int64Flag{
+		Name:    "go-memory-limit",
+		Value:   0,
+		Usage:   "GOMEMLIMIT in bytes, if 0 it is set from the cgroup memory limit or the memory of the VM",
+		EnvVars: []string{"GO_MEMORY_LIMIT"},
+	},
+	&cli.Float64Flag{
+		Name:    "go-memory-limit-ratio",
+		Value:   common.DefaultMemoryLimitRatio,
+		Usage:   "part of the detected memory limit used as GOMEMLIMIT",
+		EnvVars: []string{"GO_MEMORY_LIMIT_RATIO"},
+	},
+	&cli.IntFlag{
+		Name:    "go-max-procs",
+		Value:   0,
+		Usage:   "GOMAXPROCS, if 0 it is set from the cgroup CPU limit",
+		EnvVars: []string{"GO_MAX_PROCS"},
+	},
 }
 
 func main() {
@@ -163,23 +676,57 @@ func main() {
 				log = log.With("uid", id.String())
 			}
 
+			common.TuneRuntime(log, &common.RuntimeOpts{
+				MemoryLimitBytes: cCtx.Int64("go-memory-limit"),
+				MemoryLimitRatio: cCtx.Float64("go-memory-limit-ratio"),
+				MaxProcs:         cCtx.Int("go-max-procs"),
+			})
+
 			exit := make(chan os.Signal, 1)
 			signal.Notify(exit, os.Interrupt, syscall.SIGTERM)
 
+			metricsServerConfig := proxy.MetricsServerConfig{
+				TLSCertFile:  cCtx.String("metrics-tls-cert"),
+				TLSKeyFile:   cCtx.String("metrics-tls-key"),
+				ClientCAFile: cCtx.String("metrics-client-ca"),
+				BasicAuth:    cCtx.String("metrics-basic-auth"),
+			}
+			err := metricsServerConfig.Validate()
+			if err != nil {
+				return err
+			}
+
+			// pprof server
+			var pprofServer *proxy.PprofServer
+			if pprofAddr := cCtx.String("pprof-addr"); pprofAddr != "" {
+				if cCtx.String("pprof-token") == "" {
+					return errors.New("pprof-addr requires pprof-token to be set")
+				}
+				pprofServer = proxy.NewPprofServer(cCtx.String("pprof-token"), cCtx.Bool("pprof"))
+				go func() {
+					server := &http.Server{
+						Addr:              pprofAddr,
+						ReadHeaderTimeout: 5 * time.Second,
+						Handler:           pprofServer.Handler(),
+					}
+					err := server.ListenAndServe()
+					if err != nil {
+						log.Error("Failed to start pprof server", "err", err)
+					}
+				}()
+			} else if cCtx.Bool("pprof") {
+				return errors.New("pprof requires pprof-addr to be set")
+			}
+
 			// metrics server
 			go func() {
 				metricsAddr := cCtx.String("metrics-addr")
-				usePprof := cCtx.Bool("pprof")
 				metricsMux := http.NewServeMux()
 				metricsMux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
 					metrics.WritePrometheus(w, true)
 				})
-				if usePprof {
-					metricsMux.Handle("/debug/pprof/", http.HandlerFunc(pprof.Index))
-					metricsMux.Handle("/debug/pprof/cmdline", http.HandlerFunc(pprof.Cmdline))
-					metricsMux.Handle("/debug/pprof/profile", http.HandlerFunc(pprof.Profile))
-					metricsMux.Handle("/debug/pprof/symbol", http.HandlerFunc(pprof.Symbol))
-					metricsMux.Handle("/debug/pprof/trace", http.HandlerFunc(pprof.Trace))
+				if pprofServer != nil {
+					metricsMux.Handle(proxy.PprofAdminPath, pprofServer.AdminHandler())
 				}
 
 				metricsServer := &http.Server{
@@ -188,36 +735,250 @@ func main() {
 					Handler:           metricsMux,
 				}
 
-				err := metricsServer.ListenAndServe()
+				err := metricsServerConfig.ListenAndServe(metricsServer)
 				if err != nil {
 					log.Error("Failed to start metrics server", "err", err)
 				}
 			}()
 
 			builderEndpoint := cCtx.String("builder-endpoint")
+			builderTimeout := cCtx.Duration("builder-timeout")
+			localTenants, err := proxy.ParseLocalTenants(cCtx.StringSlice("local-tenants"))
+			if err != nil {
+				return err
+			}
+			builderAuthToken := cCtx.String("builder-auth-token")
+			signBuilderRequests := cCtx.Bool("sign-builder-requests")
+			rawTxToBundle := cCtx.Bool("raw-tx-to-bundle")
+			peerTimeout := cCtx.Duration("peer-timeout")
+			peerCertCacheTTL := cCtx.Duration("peer-cert-cache-ttl")
+			peerCompression := cCtx.Bool("peer-compression")
 			rpcEndpoint := cCtx.String("rpc-endpoint")
+			rpcFallbackEndpoints := cCtx.StringSlice("rpc-fallback-endpoints")
+			rpcWSEndpoint := cCtx.String("rpc-ws-endpoint")
+			mempoolWSEndpoint := cCtx.String("mempool-ws-endpoint")
+			blockNumberCacheTTL := cCtx.Duration("block-number-cache-ttl")
 			certDuration := cCtx.Duration("cert-duration")
 			certHosts := cCtx.StringSlice("cert-hosts")
+			certRenewBefore := cCtx.Duration("cert-renew-before")
 			builderConfigHubEndpoint := cCtx.String("builder-confighub-endpoint")
+			var peerListStaleWebhook *proxy.PeerListStaleWebhook
+			if webhookURL := cCtx.String("peer-list-stale-webhook"); webhookURL != "" {
+				peerListStaleWebhook = &proxy.PeerListStaleWebhook{
+					URL:        webhookURL,
+					StaleAfter: cCtx.Duration("peer-list-stale-after"),
+				}
+			}
 			archiveEndpoint := cCtx.String("orderflow-archive-endpoint")
+			archiveParquetDir := cCtx.String("archive-parquet-dir")
+			archiveSpillDir := cCtx.String("archive-spill-dir")
+			archiveSpillMaxBytes := cCtx.Int64("archive-spill-max-bytes")
 			flashbotsSignerStr := cCtx.String("flashbots-orderflow-signer-address")
 			flashbotsSignerAddress := eth.HexToAddress(flashbotsSignerStr)
+			privilegedSigners, err := proxy.ParsePrivilegedSigners(cCtx.StringSlice("privileged-signers"))
+			if err != nil {
+				return err
+			}
+			disabledPublicMethods := cCtx.StringSlice("disabled-public-methods")
+			disabledLocalMethods := cCtx.StringSlice("disabled-local-methods")
+			methodAliases, err := proxy.ParseMethodAliases(cCtx.StringSlice("method-aliases"))
+			if err != nil {
+				return err
+			}
 			maxRequestBodySizeBytes := cCtx.Int64("max-request-body-size-bytes")
 			connectionsPerPeer := cCtx.Int("connections-per-peer")
+			proxy.DefaultOrderflowProxyPublicPort = cCtx.String("peer-public-port")
+			shareWorkersPerPeer := cCtx.Int("share-workers-per-peer")
+			shareBatchSize := cCtx.Int("share-batch-size")
+			shareQueueSize := cCtx.Int("share-queue-size")
+			shareWorkerQueueSize := cCtx.Int("share-worker-queue-size")
+			archiveQueueSize := cCtx.Int("archive-queue-size")
+			shareBatchMaxBytes := cCtx.Int("share-batch-max-bytes")
+			shareBatchLatency := cCtx.Duration("share-batch-latency")
+			peerProbeInterval := cCtx.Duration("peer-probe-interval")
+			slowPeerLatency := cCtx.Duration("slow-peer-latency")
+			peerShard := proxy.PeerShard{
+				Index: cCtx.Int("peer-shard-index"),
+				Count: cCtx.Int("peer-shard-count"),
+			}
+			backpressurePolicy := cCtx.String("backpressure-policy")
 			maxLocalRPS := cCtx.Int("max-local-requests-per-second")
+			maxPublicSignerRPS := cCtx.Int("max-public-signer-requests-per-second")
+			maxPublicIPRPS := cCtx.Int("max-public-ip-requests-per-second")
+			reputationDB := cCtx.String("reputation-db")
+			auditLog := cCtx.String("audit-log")
+			loadShedBuilderLatency := cCtx.Duration("load-shed-builder-latency")
+			statsWindows, err := proxy.ParseStatsWindows(cCtx.StringSlice("stats-windows"))
+			if err != nil {
+				return err
+			}
+			privacyPolicy := proxy.PrivacyPolicy{
+				RequiredHints:      cCtx.StringSlice("mev-share-required-hints"),
+				DisallowedBuilders: cCtx.StringSlice("mev-share-disallowed-builders"),
+			}
+			blocklistSource := cCtx.String("blocklist")
+			blocklistRefreshInterval := cCtx.Duration("blocklist-refresh-interval")
+			blocklistFlagOnly := cCtx.Bool("blocklist-flag-only")
+			signerRotationInterval := cCtx.Duration("signer-rotation-interval")
+			signerRotationGracePeriod := cCtx.Duration("signer-rotation-grace-period")
+			signaturePolicy := proxy.SignaturePolicy{
+				MaxClockSkew:            cCtx.Duration("signature-freshness-window"),
+				RequireReplayProtection: cCtx.Bool("require-replay-protection"),
+				AllowUnsignedLocal:      cCtx.Bool("allow-unsigned-local"),
+			}
+			txValidation := proxy.TxValidationOpts{
+				ChainID:              cCtx.Uint64("chain-id"),
+				MaxGasLimit:          cCtx.Uint64("max-tx-gas-limit"),
+				MaxTxSizeBytes:       cCtx.Uint64("max-tx-size-bytes"),
+				MaxBlobsPerTx:        cCtx.Int("max-blobs-per-tx"),
+				DisableBlobTxs:       cCtx.Bool("disable-blob-txs"),
+				MaxMevBundleDepth:    cCtx.Int("max-mev-bundle-depth"),
+				MaxMevBundleElements: cCtx.Int("max-mev-bundle-elements"),
+			}
+			var requestFilters []proxy.RequestFilter
+			wasmPolicyOpts := proxy.WASMPolicyOpts{
+				Timeout:        cCtx.Duration("wasm-policy-timeout"),
+				MaxMemoryBytes: cCtx.Int("wasm-policy-max-memory-bytes"),
+			}
+			for _, path := range cCtx.StringSlice("wasm-policy") {
+				policy, err := proxy.LoadWASMPolicyFilter(path, wasmPolicyOpts)
+				if err != nil {
+					log.Error("Failed to load WASM policy", "path", path, "err", err)
+					return err
+				}
+				defer policy.Close()
+				requestFilters = append(requestFilters, policy)
+			}
+			var publicMiddlewares, localMiddlewares []proxy.Middleware
+			// access log is the outermost so it logs requests that panicked as well
+			if cCtx.Bool("log-http-requests") {
+				publicMiddlewares = append(publicMiddlewares, proxy.AccessLogMiddleware(log, "public"))
+				localMiddlewares = append(localMiddlewares, proxy.AccessLogMiddleware(log, "local"))
+			}
+			publicMiddlewares = append(publicMiddlewares, proxy.RecoveryMiddleware(log))
+			localMiddlewares = append(localMiddlewares, proxy.RecoveryMiddleware(log))
+			blockRange := proxy.BlockRangeOpts{
+				MaxPastBlocks:   cCtx.Uint64("max-past-blocks"),
+				MaxFutureBlocks: cCtx.Uint64("max-future-blocks"),
+				MaxBlockSpan:    cCtx.Uint64("max-block-span"),
+			}
 
 			proxyConfig := &proxy.ReceiverProxyConfig{
-				ReceiverProxyConstantConfig: proxy.ReceiverProxyConstantConfig{Log: log, FlashbotsSignerAddress: flashbotsSignerAddress},
-				CertValidDuration:           certDuration,
-				CertHosts:                   certHosts,
-				BuilderConfigHubEndpoint:    builderConfigHubEndpoint,
-				ArchiveEndpoint:             archiveEndpoint,
-				ArchiveConnections:          connectionsPerPeer,
-				LocalBuilderEndpoint:        builderEndpoint,
-				EthRPC:                      rpcEndpoint,
-				MaxRequestBodySizeBytes:     maxRequestBodySizeBytes,
-				ConnectionsPerPeer:          connectionsPerPeer,
-				MaxLocalRPS:                 maxLocalRPS,
+				ReceiverProxyConstantConfig: proxy.ReceiverProxyConstantConfig{
+					Log:                    log,
+					FlashbotsSignerAddress: flashbotsSignerAddress,
+					PrivilegedSigners:      privilegedSigners,
+					TxValidation:           txValidation,
+					BlockRange:             blockRange,
+					PrivacyPolicy:          privacyPolicy,
+					SignaturePolicy:        signaturePolicy,
+					DisabledPublicMethods:  disabledPublicMethods,
+					DisabledLocalMethods:   disabledLocalMethods,
+					MethodAliases:          methodAliases,
+					PublicProxyProtocol:    cCtx.Bool("public-proxy-protocol"),
+					StrictJSONDecoding:     cCtx.Bool("strict-json-decoding"),
+					RequestFilters:         requestFilters,
+					PublicMiddlewares:      publicMiddlewares,
+					LocalMiddlewares:       localMiddlewares,
+				},
+				CertValidDuration:         certDuration,
+				CertHosts:                 certHosts,
+				CertRenewBefore:           certRenewBefore,
+				BuilderConfigHubEndpoint:  builderConfigHubEndpoint,
+				PeerListStaleWebhook:      peerListStaleWebhook,
+				ArchiveEndpoint:           archiveEndpoint,
+				ArchiveConnections:        connectionsPerPeer,
+				ArchiveParquetDir:         archiveParquetDir,
+				ArchiveSpillDir:           archiveSpillDir,
+				ArchiveSpillMaxBytes:      archiveSpillMaxBytes,
+				ArchivePublicRequests:     cCtx.Bool("archive-public-requests"),
+				DisablePeerSharing:        cCtx.Bool("disable-peer-sharing"),
+				MetricsMTLS:               metricsServerConfig.MTLS(),
+				ReceiveOnly:               cCtx.Bool("receive-only"),
+				ArchiveRedactedSinks:      cCtx.StringSlice("archive-redacted-sinks"),
+				LocalBuilderEndpoint:      builderEndpoint,
+				BuilderTimeout:            builderTimeout,
+				LocalTenants:              localTenants,
+				BuilderAuthToken:          builderAuthToken,
+				SignBuilderRequests:       signBuilderRequests,
+				RawTxToBundle:             rawTxToBundle,
+				PeerTimeout:               peerTimeout,
+				RequestDeadline:           cCtx.Duration("request-deadline"),
+				PeerCertCacheTTL:          peerCertCacheTTL,
+				PeerCompression:           peerCompression,
+				EthRPC:                    rpcEndpoint,
+				EthRPCFallbacks:           rpcFallbackEndpoints,
+				EthWSRPC:                  rpcWSEndpoint,
+				MempoolWSRPC:              mempoolWSEndpoint,
+				BlockNumberCacheTTL:       blockNumberCacheTTL,
+				MaxRequestBodySizeBytes:   maxRequestBodySizeBytes,
+				ConnectionsPerPeer:        connectionsPerPeer,
+				ShareWorkersPerPeer:       shareWorkersPerPeer,
+				ShareQueueSize:            shareQueueSize,
+				ShareWorkerQueueSize:      shareWorkerQueueSize,
+				ArchiveQueueSize:          archiveQueueSize,
+				ShareBatchSize:            shareBatchSize,
+				ShareBatchMaxBytes:        shareBatchMaxBytes,
+				ShareBatchLatency:         shareBatchLatency,
+				PeerProbeInterval:         peerProbeInterval,
+				SlowPeerLatency:           slowPeerLatency,
+				PeerShard:                 peerShard,
+				BackpressurePolicy:        backpressurePolicy,
+				MaxLocalRPS:               maxLocalRPS,
+				MaxPublicSignerRPS:        maxPublicSignerRPS,
+				MaxPublicIPRPS:            maxPublicIPRPS,
+				ReputationStorePath:       reputationDB,
+				AuditLogPath:              auditLog,
+				LoadShedBuilderLatency:    loadShedBuilderLatency,
+				StatsWindows:              statsWindows,
+				BlocklistSource:           blocklistSource,
+				BlocklistRefreshInterval:  blocklistRefreshInterval,
+				BlocklistFlagOnly:         blocklistFlagOnly,
+				SignerRotationInterval:    signerRotationInterval,
+				SignerRotationGracePeriod: signerRotationGracePeriod,
+			}
+			if kmsKeyID := cCtx.String("orderflow-signer-kms-key-id"); kmsKeyID != "" {
+				if signerRotationInterval != 0 {
+					return errors.New("signer rotation can't be used with KMS signer")
+				}
+				kmsSigner, err := proxy.NewAWSKMSSigner(kmsKeyID)
+				if err != nil {
+					log.Error("Failed to get signer from KMS", "err", err)
+					return err
+				}
+				proxyConfig.OrderflowSigner = kmsSigner
+			}
+			archiveEncryptionPublicKey := cCtx.String("archive-encryption-public-key")
+			archiveEncryptionKMSKeyID := cCtx.String("archive-encryption-kms-key-id")
+			if archiveEncryptionPublicKey != "" && archiveEncryptionKMSKeyID != "" {
+				return errors.New("only one of archive-encryption-public-key and archive-encryption-kms-key-id can be set")
+			}
+			if archiveEncryptionPublicKey != "" {
+				archiveEncryptor, err := proxy.NewX25519ArchiveEncryptor(archiveEncryptionPublicKey)
+				if err != nil {
+					log.Error("Failed to create archive encryptor", "err", err)
+					return err
+				}
+				proxyConfig.ArchiveEncryptor = archiveEncryptor
+			}
+			if archiveEncryptionKMSKeyID != "" {
+				archiveEncryptor, err := proxy.NewAWSKMSArchiveEncryptor(archiveEncryptionKMSKeyID)
+				if err != nil {
+					log.Error("Failed to create archive encryptor", "err", err)
+					return err
+				}
+				proxyConfig.ArchiveEncryptor = archiveEncryptor
+			}
+			if haRedisURL := cCtx.String("ha-redis-url"); haRedisURL != "" {
+				sharedStore, err := proxy.NewRedisSharedStore(haRedisURL)
+				if err != nil {
+					log.Error("Failed to create shared store", "err", err)
+					return err
+				}
+				proxyConfig.SharedStore = sharedStore
+			}
+			if cCtx.Bool("tdx-attestation") {
+				proxyConfig.QuoteProvider = &proxy.ConfigfsTSMQuoteProvider{Path: proxy.DefaultConfigfsTSMReportPath}
 			}
 
 			instance, err := proxy.NewReceiverProxy(*proxyConfig)
@@ -244,8 +1005,9 @@ func main() {
 			localListenAddr := cCtx.String("local-listen-addr")
 			publicListenAddr := cCtx.String("public-listen-addr")
 			certListenAddr := cCtx.String("cert-listen-addr")
+			eventsListenAddr := cCtx.String("events-listen-addr")
 
-			servers, err := proxy.StartReceiverServers(instance, publicListenAddr, localListenAddr, certListenAddr)
+			servers, err := proxy.StartReceiverServers(instance, publicListenAddr, localListenAddr, certListenAddr, eventsListenAddr)
 			if err != nil {
 				log.Error("Failed to start proxy server", "err", err)
 				return err
@@ -1,9 +1,9 @@
 package main
 
 import (
+	"errors"
 	"log"
 	"net/http"
-	"net/http/pprof"
 	"os"
 	"os/signal"
 	"syscall"
@@ -31,24 +31,147 @@ var flags []cli.Flag = []cli.Flag{
 		Usage:   "address of the builder config hub enpoint (directly or using the cvm-proxy)",
 		EnvVars: []string{"BUILDER_CONFIGHUB_ENDPOINT"},
 	},
+	&cli.StringFlag{
+		Name:    "peer-list-stale-webhook",
+		Value:   "",
+		Usage:   "URL that is called with POST request when peer list could not be fetched from builder config hub for peer-list-stale-after",
+		EnvVars: []string{"PEER_LIST_STALE_WEBHOOK"},
+	},
+	&cli.DurationFlag{
+		Name:    "peer-list-stale-after",
+		Value:   time.Minute * 10,
+		Usage:   "time without successful fetch of the peer list after which peer-list-stale-webhook is called",
+		EnvVars: []string{"PEER_LIST_STALE_AFTER"},
+	},
+	&cli.StringSliceFlag{
+		Name:    "receiver-endpoints",
+		Usage:   "local endpoints of receiver proxies, if set each request is sent to one healthy receiver instead of all peers from builder config hub",
+		EnvVars: []string{"RECEIVER_ENDPOINTS"},
+	},
+	&cli.BoolFlag{
+		Name:    "receiver-discovery",
+		Value:   false,
+		Usage:   "send each request to one healthy receiver fetched from builder config hub instead of all of them",
+		EnvVars: []string{"RECEIVER_DISCOVERY"},
+	},
+	&cli.DurationFlag{
+		Name:    "receiver-health-check-interval",
+		Value:   time.Second * 5,
+		Usage:   "interval of receiver endpoints health checks",
+		EnvVars: []string{"RECEIVER_HEALTH_CHECK_INTERVAL"},
+	},
+	&cli.StringFlag{
+		Name:    "attestation-verifier-url",
+		Value:   "",
+		Usage:   "URL of the TDX quote verification service, if set receivers from builder config hub are used only after attestation",
+		EnvVars: []string{"ATTESTATION_VERIFIER_URL"},
+	},
+	&cli.StringFlag{
+		Name:    "attestation-measurements",
+		Value:   "",
+		Usage:   "JSON file with the list of allowed TDX measurements (mrtd, rtmr0-3) of the receivers",
+		EnvVars: []string{"ATTESTATION_MEASUREMENTS"},
+	},
+	&cli.IntFlag{
+		Name:    "max-rps-per-receiver",
+		Value:   0,
+		Usage:   "maximum number of requests per second forwarded to each receiver, 0 means no limit",
+		EnvVars: []string{"MAX_RPS_PER_RECEIVER"},
+	},
+	&cli.IntFlag{
+		Name:    "max-retries",
+		Value:   0,
+		Usage:   "number of retries of the failed requests",
+		EnvVars: []string{"MAX_RETRIES"},
+	},
+	&cli.DurationFlag{
+		Name:    "retry-backoff",
+		Value:   time.Millisecond * 100,
+		Usage:   "delay before the first retry, doubled for each next retry",
+		EnvVars: []string{"RETRY_BACKOFF"},
+	},
+	&cli.StringFlag{
+		Name:    "dead-letter",
+		Value:   "",
+		Usage:   "file path or http(s) URL where requests are recorded when all retries fail",
+		EnvVars: []string{"DEAD_LETTER"},
+	},
+	&cli.StringFlag{
+		Name:    "fallback-relay-endpoint",
+		Value:   "",
+		Usage:   "relay (e.g. https://relay.flashbots.net) that receives requests when all receivers are unreachable, requires receiver-endpoints or receiver-discovery",
+		EnvVars: []string{"FALLBACK_RELAY_ENDPOINT"},
+	},
+	&cli.StringSliceFlag{
+		Name:    "disabled-methods",
+		Usage:   "RPC methods that are not served, calls to them return method not found",
+		EnvVars: []string{"DISABLED_METHODS"},
+	},
+	&cli.StringSliceFlag{
+		Name:    "method-aliases",
+		Usage:   "additional RPC method names as alias=method, e.g. eth_sendPrivateRawTransaction=eth_sendRawTransaction",
+		EnvVars: []string{"METHOD_ALIASES"},
+	},
+	&cli.StringFlag{
+		Name:    "leader-lock",
+		Value:   "",
+		Usage:   "file path or redis URL of the leader lock, if set only the instance holding the lock forwards requests and others stand by",
+		EnvVars: []string{"LEADER_LOCK"},
+	},
+	&cli.DurationFlag{
+		Name:    "leader-lock-ttl",
+		Value:   time.Second * 5,
+		Usage:   "time after which the leader lock held in redis expires if the leader stops extending it",
+		EnvVars: []string{"LEADER_LOCK_TTL"},
+	},
 	&cli.StringFlag{
 		Name:    "orderflow-signer-key",
 		Value:   "0xfb5ad18432422a84514f71d63b45edf51165d33bef9c2bd60957a48d4c4cb68e",
 		Usage:   "ordreflow will be signed with this address",
 		EnvVars: []string{"ORDERFLOW_SIGNER_KEY"},
 	},
+	&cli.StringFlag{
+		Name:    "orderflow-signer-kms-key-id",
+		Value:   "",
+		Usage:   "AWS KMS key (ECC_SECG_P256K1) used to sign orderflow instead of orderflow-signer-key",
+		EnvVars: []string{"ORDERFLOW_SIGNER_KMS_KEY_ID"},
+	},
 	&cli.Int64Flag{
 		Name:    "max-request-body-size-bytes",
 		Value:   0,
 		Usage:   "Maximum size of the request body, if 0 default will be used",
 		EnvVars: []string{"MAX_REQUEST_BODY_SIZE_BYTES"},
 	},
+	&cli.StringFlag{
+		Name:    "peer-public-port",
+		Value:   proxy.DefaultOrderflowProxyPublicPort,
+		Usage:   "public port of the peers that don't have port set in builder config hub",
+		EnvVars: []string{"PEER_PUBLIC_PORT"},
+	},
 	&cli.IntFlag{
 		Name:    "connections-per-peer",
 		Value:   10,
 		Usage:   "Number of parallel connections for each peer",
 		EnvVars: []string{"CONN_PER_PEER"},
 	},
+	&cli.DurationFlag{
+		Name:    "peer-timeout",
+		Value:   time.Second * 10,
+		Usage:   "timeout of each request forwarded to receivers or peers",
+		EnvVars: []string{"PEER_TIMEOUT"},
+	},
+	&cli.DurationFlag{
+		Name:    "peer-cert-cache-ttl",
+		Value:   time.Hour,
+		Usage:   "time verified peer certificates and their connections are reused across peer list updates",
+		EnvVars: []string{"PEER_CERT_CACHE_TTL"},
+	},
+	&cli.IntFlag{
+		Name:    "share-workers-per-peer",
+		Value:   0,
+		Usage:   "Number of concurrent share queue workers for each peer, if 0 connections-per-peer is used",
+		EnvVars: []string{"SHARE_WORKERS_PER_PEER"},
+	},
 
 	// logging, metrics and debug
 	&cli.StringFlag{
@@ -57,6 +180,30 @@ var flags []cli.Flag = []cli.Flag{
 		Usage:   "address to listen on for Prometheus metrics (metrics are served on $metrics-addr/metrics)",
 		EnvVars: []string{"METRICS_ADDR"},
 	},
+	&cli.StringFlag{
+		Name:    "metrics-tls-cert",
+		Value:   "",
+		Usage:   "path of the TLS certificate of the metrics server, if set metrics are served over TLS",
+		EnvVars: []string{"METRICS_TLS_CERT"},
+	},
+	&cli.StringFlag{
+		Name:    "metrics-tls-key",
+		Value:   "",
+		Usage:   "path of the TLS key of the metrics server",
+		EnvVars: []string{"METRICS_TLS_KEY"},
+	},
+	&cli.StringFlag{
+		Name:    "metrics-client-ca",
+		Value:   "",
+		Usage:   "path of the CA certificates, if set metrics clients must present certificate signed by them",
+		EnvVars: []string{"METRICS_CLIENT_CA"},
+	},
+	&cli.StringFlag{
+		Name:    "metrics-basic-auth",
+		Value:   "",
+		Usage:   "user:password required by the metrics server",
+		EnvVars: []string{"METRICS_BASIC_AUTH"},
+	},
 	&cli.BoolFlag{
 		Name:    "log-json",
 		Value:   false,
@@ -84,9 +231,39 @@ var flags []cli.Flag = []cli.Flag{
 	&cli.BoolFlag{
 		Name:    "pprof",
 		Value:   false,
-		Usage:   "enable pprof debug endpoint (pprof is served on $metrics-addr/debug/pprof/*)",
+		Usage:   "enable profiling on start, it can be toggled at runtime on $metrics-addr/admin/pprof (pprof is served on $pprof-addr/debug/pprof/*)",
 		EnvVars: []string{"PPROF"},
 	},
+	&cli.StringFlag{
+		Name:    "pprof-addr",
+		Value:   "",
+		Usage:   "address of the dedicated pprof listener, pprof is not served if empty",
+		EnvVars: []string{"PPROF_ADDR"},
+	},
+	&cli.StringFlag{
+		Name:    "pprof-token",
+		Value:   "",
+		Usage:   "bearer token required by the pprof listener and the pprof admin endpoint, required if pprof-addr is set",
+		EnvVars: []string{"PPROF_TOKEN"},
+	},
+	&cli.Int64Flag{
+		Name:    "go-memory-limit",
+		Value:   0,
+		Usage:   "GOMEMLIMIT in bytes, if 0 it is set from the cgroup memory limit or the memory of the VM",
+		EnvVars: []string{"GO_MEMORY_LIMIT"},
+	},
+	&cli.Float64Flag{
+		Name:    "go-memory-limit-ratio",
+		Value:   common.DefaultMemoryLimitRatio,
+		Usage:   "part of the detected memory limit used as GOMEMLIMIT",
+		EnvVars: []string{"GO_MEMORY_LIMIT_RATIO"},
+	},
+	&cli.IntFlag{
+		Name:    "go-max-procs",
+		Value:   0,
+		Usage:   "GOMAXPROCS, if 0 it is set from the cgroup CPU limit",
+		EnvVars: []string{"GO_MAX_PROCS"},
+	},
 }
 
 func main() {
@@ -112,19 +289,75 @@ func main() {
 				log = log.With("uid", id.String())
 			}
 
+			common.TuneRuntime(log, &common.RuntimeOpts{
+				MemoryLimitBytes: cCtx.Int64("go-memory-limit"),
+				MemoryLimitRatio: cCtx.Float64("go-memory-limit-ratio"),
+				MaxProcs:         cCtx.Int("go-max-procs"),
+			})
+
 			exit := make(chan os.Signal, 1)
 			signal.Notify(exit, os.Interrupt, syscall.SIGTERM)
 
 			builderConfigHubEndpoint := cCtx.String("builder-confighub-endpoint")
-			orderflowSignerKeyStr := cCtx.String("orderflow-signer-key")
-			orderflowSigner, err := signature.NewSignerFromHexPrivateKey(orderflowSignerKeyStr)
-			if err != nil {
-				log.Error("Failed to get signer from private key", "error", err)
+			var peerListStaleWebhook *proxy.PeerListStaleWebhook
+			if webhookURL := cCtx.String("peer-list-stale-webhook"); webhookURL != "" {
+				peerListStaleWebhook = &proxy.PeerListStaleWebhook{
+					URL:        webhookURL,
+					StaleAfter: cCtx.Duration("peer-list-stale-after"),
+				}
+			}
+			var orderflowSigner proxy.RequestSigner
+			if kmsKeyID := cCtx.String("orderflow-signer-kms-key-id"); kmsKeyID != "" {
+				kmsSigner, err := proxy.NewAWSKMSSigner(kmsKeyID)
+				if err != nil {
+					log.Error("Failed to get signer from KMS", "error", err)
+					return err
+				}
+				orderflowSigner = kmsSigner
+			} else {
+				orderflowSignerKeyStr := cCtx.String("orderflow-signer-key")
+				keySigner, err := signature.NewSignerFromHexPrivateKey(orderflowSignerKeyStr)
+				if err != nil {
+					log.Error("Failed to get signer from private key", "error", err)
+					return err
+				}
+				orderflowSigner = keySigner
 			}
 			log.Info("Ordeflow signing address", "address", orderflowSigner.Address())
 			maxRequestBodySizeBytes := cCtx.Int64("max-request-body-size-bytes")
 
 			connectionsPerPeer := cCtx.Int("connections-per-peer")
+			proxy.DefaultOrderflowProxyPublicPort = cCtx.String("peer-public-port")
+			shareWorkersPerPeer := cCtx.Int("share-workers-per-peer")
+			peerTimeout := cCtx.Duration("peer-timeout")
+			peerCertCacheTTL := cCtx.Duration("peer-cert-cache-ttl")
+			receiverEndpoints := cCtx.StringSlice("receiver-endpoints")
+			receiverDiscovery := cCtx.Bool("receiver-discovery")
+			receiverHealthCheckInterval := cCtx.Duration("receiver-health-check-interval")
+			maxRPSPerReceiver := cCtx.Int("max-rps-per-receiver")
+			retryPolicy := proxy.RetryPolicy{
+				MaxRetries: cCtx.Int("max-retries"),
+				Backoff:    cCtx.Duration("retry-backoff"),
+			}
+			deadLetter := cCtx.String("dead-letter")
+			disabledMethods := cCtx.StringSlice("disabled-methods")
+			methodAliases, err := proxy.ParseMethodAliases(cCtx.StringSlice("method-aliases"))
+			if err != nil {
+				return err
+			}
+			leaderLockTTL := cCtx.Duration("leader-lock-ttl")
+			var attestation *proxy.AttestationVerifier
+			if verifierURL := cCtx.String("attestation-verifier-url"); verifierURL != "" {
+				measurements, err := proxy.LoadTDXMeasurements(cCtx.String("attestation-measurements"))
+				if err != nil {
+					log.Error("Failed to load allowed measurements", "err", err)
+					return err
+				}
+				attestation, err = proxy.NewAttestationVerifier(proxy.NewHTTPQuoteVerifier(verifierURL), measurements)
+				if err != nil {
+					return err
+				}
+			}
 
 			proxyConfig := &proxy.SenderProxyConfig{
 				SenderProxyConstantConfig: proxy.SenderProxyConstantConfig{
@@ -132,8 +365,32 @@ func main() {
 					OrderflowSigner: orderflowSigner,
 				},
 				BuilderConfigHubEndpoint: builderConfigHubEndpoint,
+				PeerListStaleWebhook:     peerListStaleWebhook,
 				MaxRequestBodySizeBytes:  maxRequestBodySizeBytes,
 				ConnectionsPerPeer:       connectionsPerPeer,
+				ShareWorkersPerPeer:      shareWorkersPerPeer,
+				PeerTimeout:              peerTimeout,
+				PeerCertCacheTTL:         peerCertCacheTTL,
+
+				ReceiverEndpoints:           receiverEndpoints,
+				ReceiverDiscovery:           receiverDiscovery,
+				ReceiverHealthCheckInterval: receiverHealthCheckInterval,
+				MaxRPSPerReceiver:           maxRPSPerReceiver,
+				Retry:                       retryPolicy,
+				DeadLetter:                  deadLetter,
+				FallbackRelayEndpoint:       cCtx.String("fallback-relay-endpoint"),
+				DisabledMethods:             disabledMethods,
+				MethodAliases:               methodAliases,
+				Attestation:                 attestation,
+				LeaderLockTTL:               leaderLockTTL,
+			}
+			if leaderLock := cCtx.String("leader-lock"); leaderLock != "" {
+				lock, err := proxy.NewLeaderLock(leaderLock)
+				if err != nil {
+					log.Error("Failed to create leader lock", "err", err)
+					return err
+				}
+				proxyConfig.LeaderLock = lock
 			}
 
 			instance, err := proxy.NewSenderProxy(*proxyConfig)
@@ -151,10 +408,42 @@ func main() {
 
 			log.Info("Started sender proxy", "listenAddres", listenAddr)
 
+			metricsServerConfig := proxy.MetricsServerConfig{
+				TLSCertFile:  cCtx.String("metrics-tls-cert"),
+				TLSKeyFile:   cCtx.String("metrics-tls-key"),
+				ClientCAFile: cCtx.String("metrics-client-ca"),
+				BasicAuth:    cCtx.String("metrics-basic-auth"),
+			}
+			err = metricsServerConfig.Validate()
+			if err != nil {
+				return err
+			}
+
+			// pprof server
+			var pprofServer *proxy.PprofServer
+			if pprofAddr := cCtx.String("pprof-addr"); pprofAddr != "" {
+				if cCtx.String("pprof-token") == "" {
+					return errors.New("pprof-addr requires pprof-token to be set")
+				}
+				pprofServer = proxy.NewPprofServer(cCtx.String("pprof-token"), cCtx.Bool("pprof"))
+				go func() {
+					server := &http.Server{
+						Addr:              pprofAddr,
+						ReadHeaderTimeout: 5 * time.Second,
+						Handler:           pprofServer.Handler(),
+					}
+					err := server.ListenAndServe()
+					if err != nil {
+						log.Error("Failed to start pprof server", "err", err)
+					}
+				}()
+			} else if cCtx.Bool("pprof") {
+				return errors.New("pprof requires pprof-addr to be set")
+			}
+
 			// metrics server
 			go func() {
 				metricsAddr := cCtx.String("metrics-addr")
-				usePprof := cCtx.Bool("pprof")
 				metricsMux := http.NewServeMux()
 				metricsMux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
 					metrics.WritePrometheus(w, true)
@@ -166,12 +455,8 @@ func main() {
 					}
 					w.WriteHeader(http.StatusOK)
 				})
-				if usePprof {
-					metricsMux.Handle("/debug/pprof/", http.HandlerFunc(pprof.Index))
-					metricsMux.Handle("/debug/pprof/cmdline", http.HandlerFunc(pprof.Cmdline))
-					metricsMux.Handle("/debug/pprof/profile", http.HandlerFunc(pprof.Profile))
-					metricsMux.Handle("/debug/pprof/symbol", http.HandlerFunc(pprof.Symbol))
-					metricsMux.Handle("/debug/pprof/trace", http.HandlerFunc(pprof.Trace))
+				if pprofServer != nil {
+					metricsMux.Handle(proxy.PprofAdminPath, pprofServer.AdminHandler())
 				}
 
 				metricsServer := &http.Server{
@@ -180,7 +465,7 @@ func main() {
 					Handler:           metricsMux,
 				}
 
-				err := metricsServer.ListenAndServe()
+				err := metricsServerConfig.ListenAndServe(metricsServer)
 				if err != nil {
 					log.Error("Failed to start metrics server", "err", err)
 				}
@@ -0,0 +1,127 @@
+package common
+
+import (
+	"bufio"
+	"log/slog"
+	"math"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// DefaultMemoryLimitRatio is the part of the detected memory limit used as GOMEMLIMIT,
+// the rest is left for memory not managed by the Go runtime
+const DefaultMemoryLimitRatio = 0.9
+
+var (
+	cgroupRoot = "/sys/fs/cgroup"
+	meminfo    = "/proc/meminfo"
+)
+
+type RuntimeOpts struct {
+	// MemoryLimitBytes overrides detected limit if > 0
+	MemoryLimitBytes int64
+	// MemoryLimitRatio is the part of the detected limit used as GOMEMLIMIT, DefaultMemoryLimitRatio if 0
+	MemoryLimitRatio float64
+	// MaxProcs overrides detected CPU limit if > 0
+	MaxProcs int
+}
+
+// TuneRuntime sets GOMEMLIMIT and GOMAXPROCS from the cgroup limits or the memory of the VM
+// so the garbage collector runs before the process is OOM-killed, values set in the environment are not changed
+func TuneRuntime(log *slog.Logger, opts *RuntimeOpts) {
+	if os.Getenv("GOMEMLIMIT") == "" {
+		limit := opts.MemoryLimitBytes
+		if limit <= 0 {
+			ratio := opts.MemoryLimitRatio
+			if ratio <= 0 {
+				ratio = DefaultMemoryLimitRatio
+			}
+			if detected, ok := detectMemoryLimit(); ok {
+				limit = int64(float64(detected) * ratio)
+			}
+		}
+		if limit > 0 {
+			debug.SetMemoryLimit(limit)
+			log.Info("Set memory limit", slog.Int64("GOMEMLIMIT", limit))
+		}
+	}
+	if os.Getenv("GOMAXPROCS") == "" {
+		procs := opts.MaxProcs
+		if procs <= 0 {
+			if detected, ok := detectCPULimit(); ok {
+				procs = min(detected, runtime.NumCPU())
+			}
+		}
+		if procs > 0 {
+			runtime.GOMAXPROCS(procs)
+			log.Info("Set max procs", slog.Int("GOMAXPROCS", procs))
+		}
+	}
+}
+
+// detectMemoryLimit returns cgroup v2 or v1 memory limit, or the total memory of the VM if there is no limit
+func detectMemoryLimit() (int64, bool) {
+	for _, path := range []string{cgroupRoot + "/memory.max", cgroupRoot + "/memory/memory.limit_in_bytes"} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		limit, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+		// "max" or huge value of cgroup v1 mean no limit
+		if err == nil && limit > 0 && limit < math.MaxInt64/2 {
+			return limit, true
+		}
+	}
+	return totalMemory()
+}
+
+func totalMemory() (int64, bool) {
+	file, err := os.Open(meminfo)
+	if err != nil {
+		return 0, false
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 3 && fields[0] == "MemTotal:" && fields[2] == "kB" {
+			kb, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return 0, false
+			}
+			return kb * 1024, true
+		}
+	}
+	return 0, false
+}
+
+// detectCPULimit returns number of CPUs allowed by cgroup v2 or v1 quota rounded up
+func detectCPULimit() (int, bool) {
+	var quota, period float64
+	if data, err := os.ReadFile(cgroupRoot + "/cpu.max"); err == nil {
+		fields := strings.Fields(string(data))
+		if len(fields) != 2 || fields[0] == "max" {
+			return 0, false
+		}
+		quota, _ = strconv.ParseFloat(fields[0], 64)
+		period, _ = strconv.ParseFloat(fields[1], 64)
+	} else {
+		quotaData, err := os.ReadFile(cgroupRoot + "/cpu/cpu.cfs_quota_us")
+		if err != nil {
+			return 0, false
+		}
+		periodData, err := os.ReadFile(cgroupRoot + "/cpu/cpu.cfs_period_us")
+		if err != nil {
+			return 0, false
+		}
+		quota, _ = strconv.ParseFloat(strings.TrimSpace(string(quotaData)), 64)
+		period, _ = strconv.ParseFloat(strings.TrimSpace(string(periodData)), 64)
+	}
+	if quota <= 0 || period <= 0 {
+		return 0, false
+	}
+	return max(int(math.Ceil(quota/period)), 1), true
+}
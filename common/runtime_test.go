@@ -0,0 +1,143 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testMeminfo = `MemTotal:       16318576 kB
+MemFree:         1020340 kB
+MemAvailable:    9174884 kB
+`
+
+// withFixtures writes files relative to a temporary cgroup root and meminfo for the test
+func withFixtures(t *testing.T, files map[string]string) {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o700))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	}
+	prevRoot, prevMeminfo := cgroupRoot, meminfo
+	cgroupRoot = filepath.Join(dir, "cgroup")
+	meminfo = filepath.Join(dir, "meminfo")
+	t.Cleanup(func() {
+		cgroupRoot, meminfo = prevRoot, prevMeminfo
+	})
+}
+
+func TestDetectMemoryLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		limit   int64
+		limitOK bool
+	}{
+		{
+			name:    "cgroup v2 limit",
+			files:   map[string]string{"cgroup/memory.max": "2147483648\n", "meminfo": testMeminfo},
+			limit:   2147483648,
+			limitOK: true,
+		},
+		{
+			name:    "cgroup v2 max falls back to meminfo",
+			files:   map[string]string{"cgroup/memory.max": "max\n", "meminfo": testMeminfo},
+			limit:   16318576 * 1024,
+			limitOK: true,
+		},
+		{
+			name:    "cgroup v1 limit",
+			files:   map[string]string{"cgroup/memory/memory.limit_in_bytes": "1073741824\n", "meminfo": testMeminfo},
+			limit:   1073741824,
+			limitOK: true,
+		},
+		{
+			name:    "cgroup v1 unlimited sentinel falls back to meminfo",
+			files:   map[string]string{"cgroup/memory/memory.limit_in_bytes": "9223372036854771712\n", "meminfo": testMeminfo},
+			limit:   16318576 * 1024,
+			limitOK: true,
+		},
+		{
+			name:    "no cgroup uses meminfo",
+			files:   map[string]string{"meminfo": testMeminfo},
+			limit:   16318576 * 1024,
+			limitOK: true,
+		},
+		{
+			name:  "meminfo without total",
+			files: map[string]string{"meminfo": "MemFree:         1020340 kB\n"},
+		},
+		{
+			name: "missing files",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withFixtures(t, tt.files)
+			limit, ok := detectMemoryLimit()
+			require.Equal(t, tt.limitOK, ok)
+			require.Equal(t, tt.limit, limit)
+		})
+	}
+}
+
+func TestDetectCPULimit(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		procs int
+		ok    bool
+	}{
+		{
+			name:  "cgroup v2 quota",
+			files: map[string]string{"cgroup/cpu.max": "200000 100000\n"},
+			procs: 2,
+			ok:    true,
+		},
+		{
+			name:  "cgroup v2 fractional quota is rounded up",
+			files: map[string]string{"cgroup/cpu.max": "150000 100000\n"},
+			procs: 2,
+			ok:    true,
+		},
+		{
+			name:  "cgroup v2 small quota is at least one cpu",
+			files: map[string]string{"cgroup/cpu.max": "10000 100000\n"},
+			procs: 1,
+			ok:    true,
+		},
+		{
+			name:  "cgroup v2 max",
+			files: map[string]string{"cgroup/cpu.max": "max 100000\n"},
+		},
+		{
+			name:  "cgroup v1 quota",
+			files: map[string]string{"cgroup/cpu/cpu.cfs_quota_us": "350000\n", "cgroup/cpu/cpu.cfs_period_us": "100000\n"},
+			procs: 4,
+			ok:    true,
+		},
+		{
+			name:  "cgroup v1 unlimited",
+			files: map[string]string{"cgroup/cpu/cpu.cfs_quota_us": "-1\n", "cgroup/cpu/cpu.cfs_period_us": "100000\n"},
+		},
+		{
+			name:  "cgroup v1 missing period",
+			files: map[string]string{"cgroup/cpu/cpu.cfs_quota_us": "200000\n"},
+		},
+		{
+			name: "missing files",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withFixtures(t, tt.files)
+			procs, ok := detectCPULimit()
+			require.Equal(t, tt.ok, ok)
+			require.Equal(t, tt.procs, procs)
+		})
+	}
+}
@@ -1,6 +1,11 @@
 package common
 
-var Version = "dev"
+var (
+	Version = "dev"
+	// GitCommit and BuildDate are set at build time using ldflags
+	GitCommit = "unknown"
+	BuildDate = "unknown"
+)
 
 const (
 	PackageName = "github.com/flashbots/tdx-orderflow-proxy"
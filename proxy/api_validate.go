@@ -1,25 +1,392 @@
 package proxy
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"math"
+	"math/big"
 
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/flashbots/go-utils/rpctypes"
+	"github.com/holiman/uint256"
+)
+
+const (
+	DefaultMaxTxGasLimit = uint64(60_000_000)
+	// DefaultMaxTxSizeBytes limits the size of one transaction excluding blob sidecar
+	DefaultMaxTxSizeBytes = uint64(128 * 1024)
+	DefaultMaxBlobsPerTx  = 6
+	// DefaultMaxMevBundleDepth is the depth allowed by the builders, deeper bundles are never valid
+	DefaultMaxMevBundleDepth = rpctypes.MevBundleMaxDepth
+	// DefaultMaxMevBundleElements limits body elements of mev-share bundle together with its nested bundles
+	DefaultMaxMevBundleElements = rpctypes.BundleTxLimit
 )
 
 var (
 	errSigningAddress   = errors.New("signing address field should not be set")
 	errReplacementNonce = errors.New("replacement nonce field should not be set")
 
-	errDroppingTxHashed = errors.New("dropping tx hashes field should not be set")
-	errUUID             = errors.New("uuid field should not be set")
-	errRefundPercent    = errors.New("refund percent field should not be set")
-	errRefundRecipient  = errors.New("refund recipient field should not be set")
-	errRefundTxHashes   = errors.New("refund tx hashes field should not be set")
+	errRevertingTxHashes = errors.New("reverting tx hashes must be unique hashes of the bundle transactions")
+	errDroppingTxHashes  = errors.New("dropping tx hashes must be unique hashes of the bundle transactions")
+	errUUID              = errors.New("uuid field should not be set")
+	errRefundPercent     = errors.New("refund percent must be between 0 and 100")
+	errRefundRecipient   = errors.New("refund recipient must be a non-zero address")
+	errRefundTxHashes    = errors.New("refund tx hashes must be 32 byte hex encoded hashes")
 
 	errLocalEndpointSbundleMetadata = errors.New("mev share bundle should not containt metadata when sent to local endpoint")
+
+	errTxDecode        = errors.New("failed to decode transaction")
+	errTxChainID       = errors.New("transaction has wrong chain id")
+	errTxSignature     = errors.New("transaction has invalid signature")
+	errTxZeroGas       = errors.New("transaction has zero gas limit")
+	errTxGasLimit      = errors.New("transaction gas limit is too high")
+	errTxNonceConflict = errors.New("bundle contains multiple transactions with the same sender and nonce")
+	errTxSize          = errors.New("transaction is too big")
+
+	errBlobTxDisabled     = errors.New("blob transactions are not accepted")
+	errBlobTxNoBlobs      = errors.New("blob transaction without blobs")
+	errBlobTxTooManyBlobs = errors.New("blob transaction has too many blobs")
+	errBlobTxSidecar      = errors.New("blob transaction sidecar does not match blob hashes")
+
+	errSetCodeTxEmptyAuthList = errors.New("set code transaction with empty authorization list")
+	errSetCodeTxAuthChainID   = errors.New("set code authorization has wrong chain id")
+	errSetCodeTxAuthSignature = errors.New("set code authorization has invalid signature")
+	errSetCodeTxAuthNonce     = errors.New("set code authorization has invalid nonce")
+
+	errPrivacyParse           = errors.New("failed to parse mev share bundle privacy field")
+	errPrivacyUnknownHint     = errors.New("unknown privacy hint")
+	errPrivacyMissingHint     = errors.New("mev share bundle is missing required privacy hint")
+	errPrivacyBuilderNotAllow = errors.New("mev share bundle privacy lists disallowed builder")
+
+	errBlockRangePast   = errors.New("bundle target block is too far in the past")
+	errBlockRangeFuture = errors.New("bundle target block is too far in the future")
+	errBlockRangeSpan   = errors.New("bundle targets too many blocks")
 )
 
-func ValidateEthSendBundle(args *rpctypes.EthSendBundleArgs, publicEndpoint bool) error {
+var knownPrivacyHints = map[string]struct{}{
+	"calldata":          {},
+	"contract_address":  {},
+	"logs":              {},
+	"function_selector": {},
+	"hash":              {},
+	"tx_hash":           {},
+	"special_logs":      {},
+	"default_logs":      {},
+	"full":              {},
+}
+
+// PrivacyPolicy configures what privacy settings are accepted in mev share bundles
+type PrivacyPolicy struct {
+	// RequiredHints must be present in the privacy hints of the bundle
+	RequiredHints []string
+	// DisallowedBuilders must not be present in the privacy builders list of the bundle
+	DisallowedBuilders []string
+}
+
+func (p *PrivacyPolicy) Enabled() bool {
+	return len(p.RequiredHints) > 0 || len(p.DisallowedBuilders) > 0
+}
+
+// Validate checks that all required hints are known, otherwise every bundle would be rejected
+func (p *PrivacyPolicy) Validate() error {
+	for _, hint := range p.RequiredHints {
+		if _, ok := knownPrivacyHints[hint]; !ok {
+			return fmt.Errorf("%w: %s", errPrivacyUnknownHint, hint)
+		}
+	}
+	return nil
+}
+
+type mevBundlePrivacy struct {
+	Hints    []string `json:"hints,omitempty"`
+	Builders []string `json:"builders,omitempty"`
+}
+
+// ValidateMevSendBundlePrivacy checks privacy field of the bundle and all nested bundles against the policy
+func ValidateMevSendBundlePrivacy(args *rpctypes.MevSendBundleArgs, policy *PrivacyPolicy) error {
+	var privacy mevBundlePrivacy
+	if args.Privacy != nil {
+		err := json.Unmarshal(*args.Privacy, &privacy)
+		if err != nil {
+			return errors.Join(errPrivacyParse, err)
+		}
+	}
+
+	hints := make(map[string]struct{}, len(privacy.Hints))
+	for _, hint := range privacy.Hints {
+		if _, ok := knownPrivacyHints[hint]; !ok {
+			return fmt.Errorf("%w: %s", errPrivacyUnknownHint, hint)
+		}
+		hints[hint] = struct{}{}
+	}
+	for _, hint := range policy.RequiredHints {
+		if _, ok := hints[hint]; !ok {
+			return fmt.Errorf("%w: %s", errPrivacyMissingHint, hint)
+		}
+	}
+	for _, builder := range privacy.Builders {
+		for _, disallowed := range policy.DisallowedBuilders {
+			if builder == disallowed {
+				return fmt.Errorf("%w: %s", errPrivacyBuilderNotAllow, builder)
+			}
+		}
+	}
+
+	for _, body := range args.Body {
+		if body.Bundle != nil {
+			err := ValidateMevSendBundlePrivacy(body.Bundle, policy)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// BlockRangeOpts configures how far from the current chain head bundles can target
+type BlockRangeOpts struct {
+	// MaxPastBlocks rejects bundles with the last target block more than this many blocks before the head, 0 disables the check
+	MaxPastBlocks uint64
+	// MaxFutureBlocks rejects bundles with the first target block more than this many blocks after the head, 0 disables the check
+	MaxFutureBlocks uint64
+	// MaxBlockSpan rejects bundles with max block more than this many blocks after the first target block, 0 disables the check
+	MaxBlockSpan uint64
+}
+
+func (o *BlockRangeOpts) Enabled() bool {
+	return o.MaxPastBlocks != 0 || o.MaxFutureBlocks != 0
+}
+
+// ValidateBlockRange checks that bundle targeting blocks [blockNumber, maxBlock] is not too far from the head
+// maxBlock can be 0 if the bundle targets only one block
+func ValidateBlockRange(blockNumber, maxBlock, head uint64, opts BlockRangeOpts) error {
+	lastBlock := max(blockNumber, maxBlock)
+	if opts.MaxPastBlocks != 0 && lastBlock+opts.MaxPastBlocks < head {
+		return errBlockRangePast
+	}
+	if opts.MaxFutureBlocks != 0 && blockNumber > head+opts.MaxFutureBlocks {
+		return errBlockRangeFuture
+	}
+	return nil
+}
+
+// ValidateBlockSpan checks that bundle targeting blocks [blockNumber, maxBlock] does not target too many blocks,
+// it does not depend on the head so it is checked even if the head is not available
+func ValidateBlockSpan(blockNumber, maxBlock uint64, opts BlockRangeOpts) error {
+	if opts.MaxBlockSpan != 0 && maxBlock > blockNumber && maxBlock-blockNumber > opts.MaxBlockSpan {
+		return errBlockRangeSpan
+	}
+	return nil
+}
+
+// TxValidationOpts configures validation of the raw transactions contained in the requests
+type TxValidationOpts struct {
+	// ChainID is the expected chain id of the transactions, 0 disables the check
+	ChainID uint64
+	// MaxGasLimit is a maximum gas limit of one transaction, if 0 DefaultMaxTxGasLimit is used
+	MaxGasLimit uint64
+	// MaxTxSizeBytes is a maximum size of one transaction, if 0 DefaultMaxTxSizeBytes is used
+	// blob sidecars are not counted towards this limit
+	MaxTxSizeBytes uint64
+	// MaxBlobsPerTx is a maximum number of blobs in one blob transaction, if 0 DefaultMaxBlobsPerTx is used
+	MaxBlobsPerTx int
+	// DisableBlobTxs rejects all blob transactions
+	DisableBlobTxs bool
+	// MaxMevBundleDepth is a maximum nesting depth of mev-share bundles, if 0 DefaultMaxMevBundleDepth is used,
+	// negative value disallows nested bundles
+	// bundles deeper than rpctypes.MevBundleMaxDepth are rejected regardless of this limit
+	MaxMevBundleDepth int
+	// MaxMevBundleElements is a maximum number of body elements of mev-share bundle including elements of nested bundles,
+	// if 0 DefaultMaxMevBundleElements is used
+	MaxMevBundleElements int
+	// Blocklist, if set, is used to filter transactions that interact with blocked addresses
+	Blocklist *AddressBlocklist
+}
+
+type txSenderNonce struct {
+	sender common.Address
+	nonce  uint64
+}
+
+// txValidator decodes and validates raw transactions of one request
+type txValidator struct {
+	opts *TxValidationOpts
+	seen map[txSenderNonce]struct{}
+}
+
+func newTxValidator(opts *TxValidationOpts) *txValidator {
+	return &txValidator{
+		opts: opts,
+		seen: make(map[txSenderNonce]struct{}),
+	}
+}
+
+func (v *txValidator) validateRawTx(rawTx []byte) error {
+	var tx types.Transaction
+	err := tx.UnmarshalBinary(rawTx)
+	if err != nil {
+		incAPITxValidationRejections("decode")
+		return errors.Join(errTxDecode, err)
+	}
+
+	txSize := uint64(len(rawTx))
+	if tx.Type() == types.BlobTxType {
+		if v.opts.DisableBlobTxs {
+			incAPITxValidationRejections("blob_disabled")
+			return errBlobTxDisabled
+		}
+		err = v.validateBlobTx(&tx)
+		if err != nil {
+			return err
+		}
+		// sidecar is accounted separately from the size of the transaction itself
+		txSize = tx.WithoutBlobTxSidecar().Size()
+		addAPIBlobTxSidecarBytes(uint64(len(rawTx)) - txSize)
+	}
+	if tx.Type() == types.SetCodeTxType {
+		err = v.validateSetCodeTx(&tx)
+		if err != nil {
+			return err
+		}
+	}
+	maxTxSize := DefaultMaxTxSizeBytes
+	if v.opts.MaxTxSizeBytes != 0 {
+		maxTxSize = v.opts.MaxTxSizeBytes
+	}
+	if txSize > maxTxSize {
+		incAPITxValidationRejections("tx_size")
+		return errTxSize
+	}
+
+	if tx.Gas() == 0 {
+		incAPITxValidationRejections("zero_gas")
+		return errTxZeroGas
+	}
+	maxGasLimit := DefaultMaxTxGasLimit
+	if v.opts.MaxGasLimit != 0 {
+		maxGasLimit = v.opts.MaxGasLimit
+	}
+	if tx.Gas() > maxGasLimit {
+		incAPITxValidationRejections("gas_limit")
+		return errTxGasLimit
+	}
+
+	chainID := tx.ChainId()
+	if v.opts.ChainID != 0 {
+		expectedChainID := new(big.Int).SetUint64(v.opts.ChainID)
+		if tx.Protected() && chainID.Cmp(expectedChainID) != 0 {
+			incAPITxValidationRejections("chain_id")
+			return errTxChainID
+		}
+		chainID = expectedChainID
+	}
+	sender, err := types.Sender(types.LatestSignerForChainID(chainID), &tx)
+	if err != nil {
+		incAPITxValidationRejections("signature")
+		return errors.Join(errTxSignature, err)
+	}
+
+	if v.opts.Blocklist != nil {
+		err = v.opts.Blocklist.CheckTx(sender, &tx)
+		if err != nil {
+			return err
+		}
+	}
+
+	key := txSenderNonce{sender: sender, nonce: tx.Nonce()}
+	if _, ok := v.seen[key]; ok {
+		incAPITxValidationRejections("nonce")
+		return errTxNonceConflict
+	}
+	v.seen[key] = struct{}{}
+	return nil
+}
+
+func (v *txValidator) validateBlobTx(tx *types.Transaction) error {
+	blobHashes := tx.BlobHashes()
+	if len(blobHashes) == 0 {
+		incAPITxValidationRejections("blob_no_blobs")
+		return errBlobTxNoBlobs
+	}
+	maxBlobs := DefaultMaxBlobsPerTx
+	if v.opts.MaxBlobsPerTx != 0 {
+		maxBlobs = v.opts.MaxBlobsPerTx
+	}
+	if len(blobHashes) > maxBlobs {
+		incAPITxValidationRejections("blob_too_many_blobs")
+		return errBlobTxTooManyBlobs
+	}
+
+	// transactions without sidecar are allowed, builder can have blobs from the mempool
+	sidecar := tx.BlobTxSidecar()
+	if sidecar == nil {
+		return nil
+	}
+	if len(sidecar.Blobs) != len(blobHashes) || len(sidecar.Commitments) != len(blobHashes) || len(sidecar.Proofs) != len(blobHashes) {
+		incAPITxValidationRejections("blob_sidecar")
+		return errBlobTxSidecar
+	}
+	// @note: we don't verify kzg proofs here because its expensive, builder will do that
+	for i, hash := range sidecar.BlobHashes() {
+		if hash != blobHashes[i] {
+			incAPITxValidationRejections("blob_sidecar")
+			return errBlobTxSidecar
+		}
+	}
+	return nil
+}
+
+func (v *txValidator) validateSetCodeTx(tx *types.Transaction) error {
+	authList := tx.SetCodeAuthorizations()
+	if len(authList) == 0 {
+		incAPITxValidationRejections("setcode_empty_auth_list")
+		return errSetCodeTxEmptyAuthList
+	}
+	chainID := uint256.NewInt(v.opts.ChainID)
+	// authorizations that fail these checks would be skipped during execution, so the sender most likely made a mistake
+	for _, auth := range authList {
+		// authorization with chain id 0 is valid on any chain
+		if !chainID.IsZero() && !auth.ChainID.IsZero() && !auth.ChainID.Eq(chainID) {
+			incAPITxValidationRejections("setcode_auth_chain_id")
+			return errSetCodeTxAuthChainID
+		}
+		if auth.Nonce == math.MaxUint64 {
+			incAPITxValidationRejections("setcode_auth_nonce")
+			return errSetCodeTxAuthNonce
+		}
+		_, err := auth.Authority()
+		if err != nil {
+			incAPITxValidationRejections("setcode_auth_signature")
+			return errors.Join(errSetCodeTxAuthSignature, err)
+		}
+	}
+	return nil
+}
+
+func (v *txValidator) validateMevBundle(args *rpctypes.MevSendBundleArgs) error {
+	for _, body := range args.Body {
+		if body.Tx != nil {
+			err := v.validateRawTx(*body.Tx)
+			if err != nil {
+				return err
+			}
+		}
+		if body.Bundle != nil {
+			err := v.validateMevBundle(body.Bundle)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ValidateEthSendBundle validates fields of the bundle
+// if txOpts is not nil, transactions of the bundle are decoded and validated as well
+func ValidateEthSendBundle(args *rpctypes.EthSendBundleArgs, publicEndpoint bool, txOpts *TxValidationOpts) error {
 	if !publicEndpoint {
 		if args.SigningAddress != nil {
 			return errSigningAddress
@@ -29,24 +396,86 @@ func ValidateEthSendBundle(args *rpctypes.EthSendBundleArgs, publicEndpoint bool
 			return errReplacementNonce
 		}
 	}
-	if len(args.DroppingTxHashes) > 0 {
-		return errDroppingTxHashed
-	}
 	if args.UUID != nil {
 		return errUUID
 	}
-	if args.RefundPercent != nil {
+	err := validateRefund(args)
+	if err != nil {
+		return err
+	}
+	if txOpts != nil {
+		validator := newTxValidator(txOpts)
+		for _, tx := range args.Txs {
+			err := validator.validateRawTx(tx)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return validateTxHashReferences(args)
+}
+
+// validateTxHashReferences checks that reverting and dropping tx hashes reference transactions of the bundle,
+// transactions are decoded only if the bundle has such hashes
+func validateTxHashReferences(args *rpctypes.EthSendBundleArgs) error {
+	if len(args.RevertingTxHashes) == 0 && len(args.DroppingTxHashes) == 0 {
+		return nil
+	}
+	txHashes := make(map[common.Hash]struct{}, len(args.Txs))
+	for _, rawTx := range args.Txs {
+		var tx types.Transaction
+		err := tx.UnmarshalBinary(rawTx)
+		if err != nil {
+			return errors.Join(errTxDecode, err)
+		}
+		txHashes[tx.Hash()] = struct{}{}
+	}
+	err := validateTxHashes(args.RevertingTxHashes, txHashes, errRevertingTxHashes)
+	if err != nil {
+		return err
+	}
+	return validateTxHashes(args.DroppingTxHashes, txHashes, errDroppingTxHashes)
+}
+
+func validateTxHashes(hashes []common.Hash, txHashes map[common.Hash]struct{}, errInvalid error) error {
+	seen := make(map[common.Hash]struct{}, len(hashes))
+	for _, hash := range hashes {
+		if _, ok := txHashes[hash]; !ok {
+			return errInvalid
+		}
+		if _, ok := seen[hash]; ok {
+			return errInvalid
+		}
+		seen[hash] = struct{}{}
+	}
+	return nil
+}
+
+// validateRefund checks refund extensions of eth_sendBundle, they are forwarded to the builders unchanged
+func validateRefund(args *rpctypes.EthSendBundleArgs) error {
+	if args.RefundPercent != nil && *args.RefundPercent > 100 {
 		return errRefundPercent
 	}
-	if args.RefundRecipient != nil {
+	if args.RefundRecipient != nil && *args.RefundRecipient == (common.Address{}) {
 		return errRefundRecipient
 	}
-	if len(args.RefundTxHashes) > 0 {
-		return errRefundTxHashes
+	for _, txHash := range args.RefundTxHashes {
+		decoded, err := hexutil.Decode(txHash)
+		if err != nil || len(decoded) != common.HashLength {
+			return errRefundTxHashes
+		}
 	}
 	return nil
 }
 
+// ValidateEthSendRawTransaction decodes and validates transaction if txOpts is not nil
+func ValidateEthSendRawTransaction(args *rpctypes.EthSendRawTransactionArgs, txOpts *TxValidationOpts) error {
+	if txOpts == nil {
+		return nil
+	}
+	return newTxValidator(txOpts).validateRawTx(*args)
+}
+
 func ValidateEthCancelBundle(args *rpctypes.EthCancelBundleArgs, publicEndpoint bool) error {
 	if !publicEndpoint {
 		if args.SigningAddress != nil {
@@ -56,9 +485,23 @@ func ValidateEthCancelBundle(args *rpctypes.EthCancelBundleArgs, publicEndpoint
 	return nil
 }
 
-func ValidateMevSendBundle(args *rpctypes.MevSendBundleArgs, publicEndpoint bool) error {
+// ValidateMevSendBundle validates fields of the bundle
+// if txOpts is not nil, transactions of the bundle are decoded and validated as well
+func ValidateMevSendBundle(args *rpctypes.MevSendBundleArgs, publicEndpoint bool, txOpts *TxValidationOpts) error {
+	maxDepth, maxElements := DefaultMaxMevBundleDepth, DefaultMaxMevBundleElements
+	if txOpts != nil && txOpts.MaxMevBundleDepth != 0 {
+		maxDepth = max(txOpts.MaxMevBundleDepth, 0)
+	}
+	if txOpts != nil && txOpts.MaxMevBundleElements != 0 {
+		maxElements = txOpts.MaxMevBundleElements
+	}
+	err := validateMevBundleElements(args, maxDepth, maxElements)
+	if err != nil {
+		return err
+	}
+
 	// @perf it calculates hash
-	_, err := args.Validate()
+	_, err = args.Validate()
 	if err != nil {
 		return err
 	}
@@ -69,5 +512,9 @@ func ValidateMevSendBundle(args *rpctypes.MevSendBundleArgs, publicEndpoint bool
 		}
 	}
 
+	if txOpts != nil {
+		return newTxValidator(txOpts).validateMevBundle(args)
+	}
+
 	return nil
 }
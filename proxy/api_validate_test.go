@@ -0,0 +1,460 @@
+package proxy
+
+import (
+	"encoding/json"
+	"log/slog"
+	"math"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/crypto/kzg4844"
+	"github.com/flashbots/go-utils/rpctypes"
+	"github.com/holiman/uint256"
+	"github.com/stretchr/testify/require"
+)
+
+func signTestTx(t *testing.T, chainID, nonce, gas uint64) hexutil.Bytes {
+	t.Helper()
+	privateKey, err := crypto.HexToECDSA("c7589782d55a642c8ced7794ddcb24b62d4ebefbb81001034cb46545ff80e39e")
+	require.NoError(t, err)
+
+	signer := types.LatestSignerForChainID(new(big.Int).SetUint64(chainID))
+	tx, err := types.SignNewTx(privateKey, signer, &types.DynamicFeeTx{
+		ChainID:   new(big.Int).SetUint64(chainID),
+		Nonce:     nonce,
+		GasTipCap: big.NewInt(1),
+		GasFeeCap: big.NewInt(1),
+		Gas:       gas,
+		To:        &common.Address{},
+		Value:     big.NewInt(0),
+	})
+	require.NoError(t, err)
+	binary, err := tx.MarshalBinary()
+	require.NoError(t, err)
+	return binary
+}
+
+func TestValidateEthSendBundleTxs(t *testing.T) {
+	opts := &TxValidationOpts{ChainID: 1, MaxGasLimit: 1_000_000}
+
+	// signature with zero r value can't be recovered
+	unsignedTx := types.NewTx(&types.DynamicFeeTx{ChainID: big.NewInt(1), Gas: 21000, To: &common.Address{}})
+	invalidSignature := make([]byte, crypto.SignatureLength)
+	invalidSignature[crypto.SignatureLength-2] = 1
+	badSignatureTx, err := unsignedTx.WithSignature(types.LatestSignerForChainID(big.NewInt(1)), invalidSignature)
+	require.NoError(t, err)
+	badSignature, err := badSignatureTx.MarshalBinary()
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name string
+		txs  []hexutil.Bytes
+		err  error
+	}{
+		{"valid", []hexutil.Bytes{signTestTx(t, 1, 0, 21000), signTestTx(t, 1, 1, 21000)}, nil},
+		{"garbage", []hexutil.Bytes{hexutil.MustDecode("0x1234")}, errTxDecode},
+		{"wrong chain id", []hexutil.Bytes{signTestTx(t, 5, 0, 21000)}, errTxChainID},
+		{"zero gas", []hexutil.Bytes{signTestTx(t, 1, 0, 0)}, errTxZeroGas},
+		{"gas limit", []hexutil.Bytes{signTestTx(t, 1, 0, 2_000_000)}, errTxGasLimit},
+		{"bad signature", []hexutil.Bytes{badSignature}, errTxSignature},
+		{"nonce conflict", []hexutil.Bytes{signTestTx(t, 1, 0, 21000), signTestTx(t, 1, 0, 30000)}, errTxNonceConflict},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateEthSendBundle(&rpctypes.EthSendBundleArgs{Txs: tc.txs, BlockNumber: 1}, true, opts)
+			if tc.err == nil {
+				require.NoError(t, err)
+			} else {
+				require.ErrorIs(t, err, tc.err)
+			}
+		})
+	}
+}
+
+func TestValidateEthSendBundleTxHashes(t *testing.T) {
+	rawTx := signTestTx(t, 1, 0, 21000)
+	var tx types.Transaction
+	require.NoError(t, tx.UnmarshalBinary(rawTx))
+	otherRawTx := signTestTx(t, 1, 1, 21000)
+	var otherTx types.Transaction
+	require.NoError(t, otherTx.UnmarshalBinary(otherRawTx))
+
+	testCases := map[string]struct {
+		reverting []common.Hash
+		dropping  []common.Hash
+		err       error
+	}{
+		"valid":              {reverting: []common.Hash{tx.Hash()}, dropping: []common.Hash{otherTx.Hash()}},
+		"unknown reverting":  {reverting: []common.Hash{{1}}, err: errRevertingTxHashes},
+		"duplicate dropping": {dropping: []common.Hash{tx.Hash(), tx.Hash()}, err: errDroppingTxHashes},
+		"zero dropping":      {dropping: []common.Hash{{}}, err: errDroppingTxHashes},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := ValidateEthSendBundle(&rpctypes.EthSendBundleArgs{
+				Txs:               []hexutil.Bytes{rawTx, otherRawTx},
+				BlockNumber:       1,
+				RevertingTxHashes: tc.reverting,
+				DroppingTxHashes:  tc.dropping,
+			}, true, nil)
+			require.ErrorIs(t, err, tc.err)
+		})
+	}
+}
+
+func TestValidateMevSendBundleNestedTxs(t *testing.T) {
+	opts := &TxValidationOpts{ChainID: 1}
+
+	validTx := signTestTx(t, 1, 0, 21000)
+	wrongChainTx := signTestTx(t, 5, 0, 21000)
+	args := &rpctypes.MevSendBundleArgs{
+		Version: "v0.1",
+		Body: []rpctypes.MevBundleBody{
+			{Tx: &validTx},
+			{Bundle: &rpctypes.MevSendBundleArgs{
+				Version: "v0.1",
+				Body:    []rpctypes.MevBundleBody{{Tx: &wrongChainTx}},
+			}},
+		},
+	}
+	err := ValidateMevSendBundle(args, true, opts)
+	require.ErrorIs(t, err, errTxChainID)
+
+	err = ValidateMevSendBundle(args, true, nil)
+	require.NoError(t, err)
+}
+
+func TestValidateMevSendBundleLimits(t *testing.T) {
+	nonce := 0
+	bundle := func(elements int, nested ...*rpctypes.MevSendBundleArgs) *rpctypes.MevSendBundleArgs {
+		args := &rpctypes.MevSendBundleArgs{Version: "v0.1"}
+		for range elements {
+			tx := signTestTx(t, 1, uint64(nonce), 21000)
+			nonce++
+			args.Body = append(args.Body, rpctypes.MevBundleBody{Tx: &tx})
+		}
+		for _, bundle := range nested {
+			args.Body = append(args.Body, rpctypes.MevBundleBody{Bundle: bundle})
+		}
+		return args
+	}
+
+	testCases := map[string]struct {
+		args *rpctypes.MevSendBundleArgs
+		opts *TxValidationOpts
+		err  error
+	}{
+		"nested":             {args: bundle(1, bundle(1))},
+		"too deep":           {args: bundle(1, bundle(1, bundle(1))), err: errMevBundleTooDeep},
+		"nesting disabled":   {args: bundle(1, bundle(1)), opts: &TxValidationOpts{MaxMevBundleDepth: -1}, err: errMevBundleTooDeep},
+		"total elements":     {args: bundle(40, bundle(40)), opts: &TxValidationOpts{MaxMevBundleElements: 80}, err: errMevBundleTooManyElements},
+		"total within limit": {args: bundle(39, bundle(40)), opts: &TxValidationOpts{MaxMevBundleElements: 80}},
+		"default total":      {args: bundle(0, bundle(40), bundle(40), bundle(40)), err: errMevBundleTooManyElements},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := ValidateMevSendBundle(tc.args, true, tc.opts)
+			require.ErrorIs(t, err, tc.err)
+		})
+	}
+}
+
+func TestValidateBlockRange(t *testing.T) {
+	opts := BlockRangeOpts{MaxPastBlocks: 2, MaxFutureBlocks: 10}
+	head := uint64(100)
+
+	testCases := []struct {
+		name        string
+		blockNumber uint64
+		maxBlock    uint64
+		opts        BlockRangeOpts
+		err         error
+	}{
+		{"next block", 101, 0, opts, nil},
+		{"recent past", 98, 0, opts, nil},
+		{"old block", 97, 0, opts, errBlockRangePast},
+		{"old block with max block in range", 90, 101, opts, nil},
+		{"far future", 111, 0, opts, errBlockRangeFuture},
+		{"edge of future window", 110, 120, opts, nil},
+		{"checks disabled", 1, 0, BlockRangeOpts{}, nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateBlockRange(tc.blockNumber, tc.maxBlock, head, tc.opts)
+			require.ErrorIs(t, err, tc.err)
+		})
+	}
+}
+
+func TestValidateBlockSpan(t *testing.T) {
+	opts := BlockRangeOpts{MaxBlockSpan: 20}
+	require.NoError(t, ValidateBlockSpan(100, 0, opts))
+	require.NoError(t, ValidateBlockSpan(100, 120, opts))
+	require.ErrorIs(t, ValidateBlockSpan(100, 121, opts), errBlockRangeSpan)
+	// max block before the first target block is not a range
+	require.NoError(t, ValidateBlockSpan(100, 50, opts))
+	require.NoError(t, ValidateBlockSpan(100, 1000, BlockRangeOpts{}))
+}
+
+func signTestBlobTx(t *testing.T, blobs int, withSidecar bool) hexutil.Bytes {
+	t.Helper()
+	privateKey, err := crypto.HexToECDSA("c7589782d55a642c8ced7794ddcb24b62d4ebefbb81001034cb46545ff80e39e")
+	require.NoError(t, err)
+
+	sidecar := &types.BlobTxSidecar{}
+	for range blobs {
+		var blob kzg4844.Blob
+		commitment, err := kzg4844.BlobToCommitment(&blob)
+		require.NoError(t, err)
+		proof, err := kzg4844.ComputeBlobProof(&blob, commitment)
+		require.NoError(t, err)
+		sidecar.Blobs = append(sidecar.Blobs, blob)
+		sidecar.Commitments = append(sidecar.Commitments, commitment)
+		sidecar.Proofs = append(sidecar.Proofs, proof)
+	}
+	txData := &types.BlobTx{
+		ChainID:    uint256.NewInt(1),
+		GasTipCap:  uint256.NewInt(1),
+		GasFeeCap:  uint256.NewInt(1),
+		Gas:        21000,
+		BlobFeeCap: uint256.NewInt(1),
+		BlobHashes: sidecar.BlobHashes(),
+	}
+	if withSidecar {
+		txData.Sidecar = sidecar
+	}
+	tx, err := types.SignNewTx(privateKey, types.LatestSignerForChainID(big.NewInt(1)), txData)
+	require.NoError(t, err)
+	binary, err := tx.MarshalBinary()
+	require.NoError(t, err)
+	return binary
+}
+
+func TestValidateBlobTxs(t *testing.T) {
+	blobTx := signTestBlobTx(t, 2, true)
+	// sidecar does not count towards the transaction size limit
+	require.Greater(t, uint64(len(blobTx)), DefaultMaxTxSizeBytes)
+
+	args := rpctypes.EthSendRawTransactionArgs(blobTx)
+	err := ValidateEthSendRawTransaction(&args, &TxValidationOpts{ChainID: 1})
+	require.NoError(t, err)
+
+	args = rpctypes.EthSendRawTransactionArgs(signTestBlobTx(t, 1, false))
+	err = ValidateEthSendRawTransaction(&args, &TxValidationOpts{ChainID: 1})
+	require.NoError(t, err)
+
+	args = rpctypes.EthSendRawTransactionArgs(blobTx)
+	err = ValidateEthSendRawTransaction(&args, &TxValidationOpts{ChainID: 1, MaxBlobsPerTx: 1})
+	require.ErrorIs(t, err, errBlobTxTooManyBlobs)
+
+	err = ValidateEthSendRawTransaction(&args, &TxValidationOpts{ChainID: 1, DisableBlobTxs: true})
+	require.ErrorIs(t, err, errBlobTxDisabled)
+
+	args = rpctypes.EthSendRawTransactionArgs(signTestBlobTx(t, 0, false))
+	err = ValidateEthSendRawTransaction(&args, &TxValidationOpts{ChainID: 1})
+	require.ErrorIs(t, err, errBlobTxNoBlobs)
+}
+
+func signTestSetCodeTx(t *testing.T, authList []types.SetCodeAuthorization) hexutil.Bytes {
+	t.Helper()
+	privateKey, err := crypto.HexToECDSA("c7589782d55a642c8ced7794ddcb24b62d4ebefbb81001034cb46545ff80e39e")
+	require.NoError(t, err)
+
+	tx, err := types.SignNewTx(privateKey, types.LatestSignerForChainID(big.NewInt(1)), &types.SetCodeTx{
+		ChainID:   uint256.NewInt(1),
+		GasTipCap: uint256.NewInt(1),
+		GasFeeCap: uint256.NewInt(1),
+		Gas:       100000,
+		Value:     uint256.NewInt(0),
+		AuthList:  authList,
+	})
+	require.NoError(t, err)
+	binary, err := tx.MarshalBinary()
+	require.NoError(t, err)
+	return binary
+}
+
+func TestValidateSetCodeTxs(t *testing.T) {
+	authorityKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	signAuth := func(chainID, nonce uint64) types.SetCodeAuthorization {
+		auth, err := types.SignSetCode(authorityKey, types.SetCodeAuthorization{
+			ChainID: *uint256.NewInt(chainID),
+			Address: common.HexToAddress("0x1111111111111111111111111111111111111111"),
+			Nonce:   nonce,
+		})
+		require.NoError(t, err)
+		return auth
+	}
+	invalidSignatureAuth := signAuth(1, 0)
+	invalidSignatureAuth.V = 5
+
+	testCases := []struct {
+		name     string
+		authList []types.SetCodeAuthorization
+		err      error
+	}{
+		{"valid", []types.SetCodeAuthorization{signAuth(1, 0)}, nil},
+		{"any chain authorization", []types.SetCodeAuthorization{signAuth(0, 0)}, nil},
+		{"empty auth list", nil, errSetCodeTxEmptyAuthList},
+		{"wrong chain id", []types.SetCodeAuthorization{signAuth(1, 0), signAuth(5, 1)}, errSetCodeTxAuthChainID},
+		{"max nonce", []types.SetCodeAuthorization{signAuth(1, math.MaxUint64)}, errSetCodeTxAuthNonce},
+		{"invalid signature", []types.SetCodeAuthorization{invalidSignatureAuth}, errSetCodeTxAuthSignature},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			args := rpctypes.EthSendRawTransactionArgs(signTestSetCodeTx(t, tc.authList))
+			err := ValidateEthSendRawTransaction(&args, &TxValidationOpts{ChainID: 1})
+			require.ErrorIs(t, err, tc.err)
+		})
+	}
+}
+
+func TestAddressBlocklist(t *testing.T) {
+	blockedAddress := common.Address{} // test transactions are sent to the zero address
+	otherAddress := common.HexToAddress("0x1111111111111111111111111111111111111111")
+
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	err := os.WriteFile(path, []byte("# comment\n"+otherAddress.Hex()+"\n"), 0o600)
+	require.NoError(t, err)
+
+	log := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	blocklist, err := NewAddressBlocklist(log, path, false)
+	require.NoError(t, err)
+	require.True(t, blocklist.Contains(otherAddress))
+
+	opts := &TxValidationOpts{ChainID: 1, Blocklist: blocklist}
+	args := rpctypes.EthSendRawTransactionArgs(signTestTx(t, 1, 0, 21000))
+	require.NoError(t, ValidateEthSendRawTransaction(&args, opts))
+
+	err = os.WriteFile(path, []byte(`["`+blockedAddress.Hex()+`"]`), 0o600)
+	require.NoError(t, err)
+	require.NoError(t, blocklist.Refresh())
+	require.False(t, blocklist.Contains(otherAddress))
+	require.ErrorIs(t, ValidateEthSendRawTransaction(&args, opts), errBlockedAddress)
+
+	flagOnlyBlocklist, err := NewAddressBlocklist(log, path, true)
+	require.NoError(t, err)
+	require.NoError(t, ValidateEthSendRawTransaction(&args, &TxValidationOpts{ChainID: 1, Blocklist: flagOnlyBlocklist}))
+
+	// invalid list does not replace the previous one
+	err = os.WriteFile(path, []byte("not an address"), 0o600)
+	require.NoError(t, err)
+	require.ErrorIs(t, blocklist.Refresh(), errBlocklistAddress)
+	require.True(t, blocklist.Contains(blockedAddress))
+
+	// blocklist fetched from URL is limited in size
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(otherAddress.Hex() + "\n"))
+	}))
+	defer server.Close()
+	remoteBlocklist, err := NewAddressBlocklist(log, server.URL, false)
+	require.NoError(t, err)
+	require.True(t, remoteBlocklist.Contains(otherAddress))
+	maxSize := BlocklistMaxSizeBytes
+	BlocklistMaxSizeBytes = 10
+	defer func() {
+		BlocklistMaxSizeBytes = maxSize
+	}()
+	require.ErrorIs(t, remoteBlocklist.Refresh(), errBlocklistTooLarge)
+	require.True(t, remoteBlocklist.Contains(otherAddress))
+}
+
+func TestValidateMevSendBundlePrivacy(t *testing.T) {
+	privacy := func(s string) *json.RawMessage {
+		raw := json.RawMessage(s)
+		return &raw
+	}
+	policy := &PrivacyPolicy{
+		RequiredHints:      []string{"hash"},
+		DisallowedBuilders: []string{"bad-builder"},
+	}
+
+	testCases := map[string]struct {
+		privacy *json.RawMessage
+		nested  *json.RawMessage
+		err     error
+	}{
+		"valid":             {privacy: privacy(`{"hints":["hash","calldata"],"builders":["flashbots"]}`)},
+		"no privacy":        {err: errPrivacyMissingHint},
+		"missing hint":      {privacy: privacy(`{"hints":["calldata"]}`), err: errPrivacyMissingHint},
+		"unknown hint":      {privacy: privacy(`{"hints":["hash","everything"]}`), err: errPrivacyUnknownHint},
+		"invalid privacy":   {privacy: privacy(`{"hints":"hash"}`), err: errPrivacyParse},
+		"disallowed":        {privacy: privacy(`{"hints":["hash"],"builders":["bad-builder"]}`), err: errPrivacyBuilderNotAllow},
+		"nested disallowed": {privacy: privacy(`{"hints":["hash"]}`), nested: privacy(`{"hints":["hash"],"builders":["bad-builder"]}`), err: errPrivacyBuilderNotAllow},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			args := &rpctypes.MevSendBundleArgs{
+				Privacy: tc.privacy,
+				Body: []rpctypes.MevBundleBody{{
+					Bundle: &rpctypes.MevSendBundleArgs{Privacy: privacy(`{"hints":["hash"]}`)},
+				}},
+			}
+			if tc.nested != nil {
+				args.Body[0].Bundle.Privacy = tc.nested
+			}
+			err := ValidateMevSendBundlePrivacy(args, policy)
+			require.ErrorIs(t, err, tc.err)
+		})
+	}
+
+	// unknown required hint is rejected when the proxy is created
+	require.NoError(t, policy.Validate())
+	_, err := NewReceiverProxy(ReceiverProxyConfig{
+		ReceiverProxyConstantConfig: ReceiverProxyConstantConfig{
+			PrivacyPolicy: PrivacyPolicy{RequiredHints: []string{"hash", "tx-hash"}},
+		},
+	})
+	require.ErrorIs(t, err, errPrivacyUnknownHint)
+}
+
+func TestValidateEthSendBundleRefund(t *testing.T) {
+	percent := func(v uint64) *uint64 { return &v }
+	recipient := common.HexToAddress("0x0000000000000000000000000000000000000001")
+
+	testCases := map[string]struct {
+		args rpctypes.EthSendBundleArgs
+		err  error
+	}{
+		"valid": {args: rpctypes.EthSendBundleArgs{
+			RefundPercent:   percent(90),
+			RefundRecipient: &recipient,
+			RefundTxHashes:  []string{common.Hash{1}.Hex()},
+		}},
+		"zero percent":      {args: rpctypes.EthSendBundleArgs{RefundPercent: percent(0)}},
+		"max percent":       {args: rpctypes.EthSendBundleArgs{RefundPercent: percent(100)}},
+		"percent over 100":  {args: rpctypes.EthSendBundleArgs{RefundPercent: percent(101)}, err: errRefundPercent},
+		"zero recipient":    {args: rpctypes.EthSendBundleArgs{RefundRecipient: &common.Address{}}, err: errRefundRecipient},
+		"short tx hash":     {args: rpctypes.EthSendBundleArgs{RefundTxHashes: []string{"0x1234"}}, err: errRefundTxHashes},
+		"tx hash no prefix": {args: rpctypes.EthSendBundleArgs{RefundTxHashes: []string{common.Hash{1}.Hex()[2:]}}, err: errRefundTxHashes},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			tc.args.BlockNumber = 1
+			err := ValidateEthSendBundle(&tc.args, true, nil)
+			require.ErrorIs(t, err, tc.err)
+		})
+	}
+
+	// bundles that differ only in refunds are not deduplicated
+	args := rpctypes.EthSendBundleArgs{BlockNumber: 1, SigningAddress: &recipient}
+	withRefund := rpctypes.EthSendBundleArgs{BlockNumber: 1, SigningAddress: &recipient, RefundPercent: percent(50)}
+	require.Equal(t, args.UniqueKey(), ethSendBundleUniqueKey(&args))
+	require.NotEqual(t, ethSendBundleUniqueKey(&args), ethSendBundleUniqueKey(&withRefund))
+}
@@ -22,6 +22,7 @@ var (
 
 	errArchivePublicRequest = errors.New("public RPC request should not reach archive")
 	errArchiveReturnedError = errors.New("ordreflow archive returned error")
+	errArchiveEmptyEvent    = errors.New("archive event has no request")
 
 	ArchiveRequestTimeout = time.Second * 15
 	ArchiveRetryMaxTime   = time.Second * 120
@@ -30,12 +31,22 @@ var (
 )
 
 type ArchiveQueue struct {
-	log               *slog.Logger
-	queue             chan *ParsedRequest
-	flushQueue        chan struct{}
-	archiveClient     rpcclient.RPCClient
+	log           *slog.Logger
+	queue         chan *ParsedRequest
+	flushQueue    chan struct{}
+	archiveClient rpcclient.RPCClient
+	// parquet is optional, if set batches are written to parquet files instead of being sent to archiveClient
+	parquet           *ParquetArchiveWriter
 	blockNumberSource *BlockNumberSource
 	workerCount       int
+	// spill is optional, if set batches that can't be sent are written to disk and sent later
+	spill *ArchiveSpill
+	// if set, requests received from peers are archived, otherwise they are rejected
+	publicRequests bool
+	// if set, only metadata of the requests is archived, raw transactions are replaced by their hashes
+	redact bool
+	// encryptor is optional, if set events are encrypted before they are sent or spilled
+	encryptor ArchiveEncryptor
 }
 
 func (aq *ArchiveQueue) Run() {
@@ -47,19 +58,32 @@ func (aq *ArchiveQueue) Run() {
 	workersQueue := make(chan *ParsedRequest, ArchiveWorkerQueueSize)
 	for w := range workerCount {
 		worker := &archiveQueueWorker{
-			log:           aq.log.With(slog.Int("worker", w)),
-			archiveClient: aq.archiveClient,
-			queue:         workersQueue,
-			flushQueue:    make(chan struct{}),
+			log:        aq.log.With(slog.Int("worker", w)),
+			send:       aq.send,
+			redact:     aq.redact,
+			encryptor:  aq.encryptor,
+			queue:      workersQueue,
+			flushQueue: make(chan struct{}),
 		}
 		go worker.runWorker()
 		workers = append(workers, worker)
 	}
 	aq.log.Info("Started archival workers", slog.Int("workers", workerCount))
+	drainStop := make(chan struct{})
+	if aq.spill != nil {
+		go aq.drainSpill(drainStop)
+	}
 	defer func() {
+		close(drainStop)
 		for _, worker := range workers {
 			worker.close()
 		}
+		if aq.spill != nil {
+			err := aq.spill.Close()
+			if err != nil {
+				aq.log.Error("Failed to close archive spill", slog.Any("error", err))
+			}
+		}
 		aq.log.Info("Stopped archival workers", slog.Int("workers", workerCount))
 	}()
 
@@ -91,6 +115,7 @@ func (aq *ArchiveQueue) Run() {
 				return
 			}
 			archiveEventsProcessedTotalCounter.Inc()
+			timeQueueWait(queueNameArchive, req.method, req.archiveQueuedAt)
 			processedReq, err := aq.updateParsedRequest(req)
 			if err != nil {
 				aq.log.Error("Failed to prepare request for archive", slog.Any("error", err))
@@ -103,16 +128,61 @@ func (aq *ArchiveQueue) Run() {
 			select {
 			case workersQueue <- processedReq:
 			default:
-				aq.log.Error("Archive workers are stalling")
+				if aq.spill == nil {
+					aq.log.Error("Archive workers are stalling")
+					continue
+				}
+				args := archiveEventsArgs(aq.log, aq.redact, aq.encryptor, []*ParsedRequest{processedReq})
+				if len(args.OrderEvents) == 0 {
+					continue
+				}
+				err = aq.spill.Write(&args)
+				if err != nil {
+					aq.log.Error("Archive workers are stalling, failed to spill request", slog.Any("error", err))
+				}
 			}
 		}
 	}
 }
 
+// drainSpill periodically sends spilled batches to the archive until stop is closed
+func (aq *ArchiveQueue) drainSpill(stop chan struct{}) {
+	ticker := time.NewTicker(ArchiveSpillDrainInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			err := aq.spill.Drain(func(args *FlashbotsNewOrderEventsArgs) error {
+				return aq.send(args)
+			})
+			if err != nil {
+				aq.log.Warn("Failed to drain archive spill", slog.Any("error", err))
+			}
+		}
+	}
+}
+
+// send makes one attempt to write the batch to the archive sink
+func (aq *ArchiveQueue) send(args *FlashbotsNewOrderEventsArgs) error {
+	if aq.parquet != nil {
+		err := aq.parquet.Write(args)
+		if err != nil {
+			aq.log.Error("Failed to write parquet archive", slog.Any("error", err))
+			archiveEventsRPCErrors.Inc()
+			return err
+		}
+		archiveEventsRPCSentCounter.AddInt64(int64(len(args.OrderEvents)))
+		return nil
+	}
+	return sendArchiveBatch(aq.log, aq.archiveClient, args)
+}
+
 // updateParsedRequest will return updated request that can be used to send data to orderflow archive
 // result can be nil without error meaning we don't need to archive that
 func (aq *ArchiveQueue) updateParsedRequest(input *ParsedRequest) (*ParsedRequest, error) {
-	if input.publicEndpoint {
+	if input.publicEndpoint && !aq.publicRequests {
 		return nil, errArchivePublicRequest
 	}
 	if input.bidSubsidiseBlock != nil {
@@ -136,6 +206,7 @@ func (aq *ArchiveQueue) updateParsedRequest(input *ParsedRequest) (*ParsedReques
 
 		input = &ParsedRequest{
 			publicEndpoint: input.publicEndpoint,
+			peerName:       input.peerName,
 			signer:         input.signer,
 			method:         input.method,
 			receivedAt:     input.receivedAt,
@@ -146,10 +217,13 @@ func (aq *ArchiveQueue) updateParsedRequest(input *ParsedRequest) (*ParsedReques
 }
 
 type archiveQueueWorker struct {
-	log           *slog.Logger
-	archiveClient rpcclient.RPCClient
-	queue         chan *ParsedRequest
-	flushQueue    chan struct{}
+	log        *slog.Logger
+	send       func(args *FlashbotsNewOrderEventsArgs) error
+	spill      *ArchiveSpill
+	redact     bool
+	encryptor  ArchiveEncryptor
+	queue      chan *ParsedRequest
+	flushQueue chan struct{}
 }
 
 func (aqw *archiveQueueWorker) close() {
@@ -188,11 +262,45 @@ func (aqw *archiveQueueWorker) runWorker() {
 }
 
 func (aqw *archiveQueueWorker) flush(batch []*ParsedRequest) {
+	args := archiveEventsArgs(aqw.log, aqw.redact, aqw.encryptor, batch)
+	if len(args.OrderEvents) == 0 {
+		return
+	}
+
+	aqw.log.Info("Sending batch to the archive", slog.Int("size", len(args.OrderEvents)))
+
+	exp := backoff.NewExponentialBackOff()
+	exp.MaxElapsedTime = ArchiveRetryMaxTime
+
+	err := backoff.Retry(func() error {
+		return aqw.send(&args)
+	}, exp)
+
+	if err != nil {
+		aqw.log.Error("Failed to submit batch to the archive", slog.Any("error", err))
+		if aqw.spill != nil {
+			err = aqw.spill.Write(&args)
+			if err != nil {
+				aqw.log.Error("Failed to spill batch to disk", slog.Any("error", err))
+			}
+		}
+	} else {
+		aqw.log.Info("Successfully submitted batch to the archive")
+	}
+}
+
+// archiveEventsArgs converts requests to archive events, redacted events are encrypted as well if encryptor is set,
+// events that can't be redacted or encrypted are dropped
+func archiveEventsArgs(log *slog.Logger, redact bool, encryptor ArchiveEncryptor, batch []*ParsedRequest) FlashbotsNewOrderEventsArgs {
 	args := FlashbotsNewOrderEventsArgs{}
 	for _, request := range batch {
 		event := ArchiveEvent{}
 		metadata := ArchiveEventMetadata{
 			ReceivedAt: request.receivedAt.UnixMilli(),
+			Tenant:     request.tenant,
+		}
+		if request.publicEndpoint {
+			metadata.Peer = request.peerName
 		}
 		if request.ethSendBundle != nil {
 			event.EthSendBundle = &ArchiveEventEthSendBundle{
@@ -210,48 +318,53 @@ func (aqw *archiveQueueWorker) flush(batch []*ParsedRequest) {
 				Metadata: &metadata,
 			}
 		} else {
-			aqw.log.Error("Incorrect request for orderflow archival", slog.String("method", request.method))
+			log.Error("Incorrect request for orderflow archival", slog.String("method", request.method))
 			archiveEventsProcessedErrCounter.Inc()
 			continue
 		}
+		var err error
+		if redact {
+			event, err = redactArchiveEvent(event)
+			if err != nil {
+				log.Error("Failed to redact archive event", slog.String("method", request.method), slog.Any("error", err))
+				archiveEventsProcessedErrCounter.Inc()
+				continue
+			}
+		}
+		if encryptor != nil {
+			event, err = encryptArchiveEvent(encryptor, event)
+			if err != nil {
+				log.Error("Failed to encrypt archive event", slog.String("method", request.method), slog.Any("error", err))
+				archiveEncryptionErrors.Inc()
+				continue
+			}
+		}
 		args.OrderEvents = append(args.OrderEvents, event)
 	}
-	if len(args.OrderEvents) == 0 {
-		return
-	}
-
-	aqw.log.Info("Sending batch to the archive", slog.Int("size", len(args.OrderEvents)))
-
-	exp := backoff.NewExponentialBackOff()
-	exp.MaxElapsedTime = ArchiveRetryMaxTime
-
-	err := backoff.Retry(func() error {
-		ctx, cancel := context.WithTimeout(context.Background(), ArchiveRequestTimeout)
-		defer cancel()
+	return args
+}
 
-		start := time.Now()
-		res, err := aqw.archiveClient.Call(ctx, NewOrderEventsMethod, args)
-		archiveEventsRPCDuration.Update(float64(time.Since(start).Milliseconds()))
+// sendArchiveBatch makes one attempt to send the batch to the archive
+func sendArchiveBatch(log *slog.Logger, archiveClient rpcclient.RPCClient, args *FlashbotsNewOrderEventsArgs) error {
+	ctx, cancel := context.WithTimeout(context.Background(), ArchiveRequestTimeout)
+	defer cancel()
 
-		if err != nil {
-			aqw.log.Error("Error while making RPC request to archive", slog.Any("error", err))
-			archiveEventsRPCErrors.Inc()
-			return err
-		}
-		if res != nil && res.Error != nil {
-			aqw.log.Error("Archive returned error", slog.Any("error", res.Error))
-			archiveEventsRPCErrors.Inc()
-			return errArchiveReturnedError
-		}
-		return nil
-	}, exp)
+	start := time.Now()
+	res, err := archiveClient.Call(ctx, NewOrderEventsMethod, args)
+	archiveEventsRPCDuration.Update(float64(time.Since(start).Milliseconds()))
 
 	if err != nil {
-		aqw.log.Error("Failed to submit batch to the archive", slog.Any("error", err))
-	} else {
-		aqw.log.Info("Successfully submitted batch to the archive")
-		archiveEventsRPCSentCounter.AddInt64(int64(len(args.OrderEvents)))
+		log.Error("Error while making RPC request to archive", slog.Any("error", err))
+		archiveEventsRPCErrors.Inc()
+		return err
+	}
+	if res != nil && res.Error != nil {
+		log.Error("Archive returned error", slog.Any("error", res.Error))
+		archiveEventsRPCErrors.Inc()
+		return errArchiveReturnedError
 	}
+	archiveEventsRPCSentCounter.AddInt64(int64(len(args.OrderEvents)))
+	return nil
 }
 
 type FlashbotsNewOrderEventsArgs struct {
@@ -262,11 +375,17 @@ type ArchiveEvent struct {
 	EthSendBundle   *ArchiveEventEthSendBundle   `json:"eth_sendBundle,omitempty"`
 	MevSendBundle   *ArchiveEventMevSendBundle   `json:"mev_sendBundle,omitempty"`
 	EthCancelBundle *ArchiveEventEthCancelBundle `json:"eth_cancelBundle,omitempty"`
+	Redacted        *ArchiveEventRedacted        `json:"redacted,omitempty"`
+	Encrypted       *ArchiveEventEncrypted       `json:"encrypted,omitempty"`
 }
 
 type ArchiveEventMetadata struct {
 	// ReceivedAt is a unix millisecond timestamp
 	ReceivedAt int64 `json:"receivedAt"`
+	// Tenant is set for requests received on the tenant local endpoint
+	Tenant string `json:"tenant,omitempty"`
+	// Peer is the name of the peer the request was received from, it is empty for local requests
+	Peer string `json:"peer,omitempty"`
 }
 
 type ArchiveEventEthSendBundle struct {
@@ -283,3 +402,12 @@ type ArchiveEventEthCancelBundle struct {
 	Params   *rpctypes.EthCancelBundleArgs `json:"params"`
 	Metadata *ArchiveEventMetadata         `json:"metadata"`
 }
+
+// ArchiveEventEncrypted is the event encrypted with ArchiveEncryptor, payload is the JSON of the original ArchiveEvent
+// method, target block and metadata are not encrypted
+type ArchiveEventEncrypted struct {
+	Method      string                   `json:"method"`
+	BlockNumber int64                    `json:"blockNumber"`
+	Metadata    *ArchiveEventMetadata    `json:"metadata"`
+	Payload     *ArchiveEncryptedPayload `json:"payload"`
+}
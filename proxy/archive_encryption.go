@@ -0,0 +1,253 @@
+package proxy
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// ArchiveEncryptionX25519 encrypts every event with a key derived from X25519 exchange with an ephemeral key
+	ArchiveEncryptionX25519 = "x25519-hkdf-sha256-aes256gcm"
+	// ArchiveEncryptionKMS encrypts events with AWS KMS data key, encrypted data key is stored with the event
+	ArchiveEncryptionKMS = "aws-kms-aes256gcm"
+
+	archiveEncryptionX25519Info = "orderflow-proxy archive v1"
+)
+
+var (
+	// ArchiveKMSDataKeyTTL is the time one KMS data key is used before a new one is generated
+	ArchiveKMSDataKeyTTL = time.Minute * 10
+
+	errArchiveEncryptionKey    = errors.New("archive encryption key must be 32 byte X25519 public key encoded as hex or base64")
+	errArchiveEncryptionScheme = errors.New("unknown archive encryption scheme")
+)
+
+// ArchiveEncryptor encrypts archive events before they leave the enclave, they are never sent or written to disk in plaintext
+type ArchiveEncryptor interface {
+	Encrypt(plaintext []byte) (*ArchiveEncryptedPayload, error)
+}
+
+// ArchiveEncryptedPayload is the encrypted JSON of the archive event
+type ArchiveEncryptedPayload struct {
+	Scheme string `json:"scheme"`
+	// KeyID identifies the key that can decrypt the payload: hex of the X25519 public key or KMS key ARN
+	KeyID string `json:"keyId"`
+	// EncapsulatedKey is the ephemeral X25519 public key or the data key encrypted by KMS
+	EncapsulatedKey hexutil.Bytes `json:"encapsulatedKey"`
+	Nonce           hexutil.Bytes `json:"nonce"`
+	Ciphertext      hexutil.Bytes `json:"ciphertext"`
+}
+
+// X25519ArchiveEncryptor encrypts events to the X25519 public key, only the holder of the private key can decrypt them
+type X25519ArchiveEncryptor struct {
+	publicKey *ecdh.PublicKey
+}
+
+// NewX25519ArchiveEncryptor creates encryptor for the public key encoded as hex or base64
+func NewX25519ArchiveEncryptor(publicKey string) (*X25519ArchiveEncryptor, error) {
+	raw, err := hex.DecodeString(strings.TrimPrefix(publicKey, "0x"))
+	if err != nil {
+		raw, err = base64.StdEncoding.DecodeString(publicKey)
+	}
+	if err != nil {
+		return nil, errArchiveEncryptionKey
+	}
+	key, err := ecdh.X25519().NewPublicKey(raw)
+	if err != nil {
+		return nil, errArchiveEncryptionKey
+	}
+	return &X25519ArchiveEncryptor{publicKey: key}, nil
+}
+
+func (e *X25519ArchiveEncryptor) Encrypt(plaintext []byte) (*ArchiveEncryptedPayload, error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := ephemeral.ECDH(e.publicKey)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x25519ArchiveKey(shared, ephemeral.PublicKey().Bytes(), e.publicKey.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	nonce, ciphertext, err := sealAESGCM(key, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return &ArchiveEncryptedPayload{
+		Scheme:          ArchiveEncryptionX25519,
+		KeyID:           hex.EncodeToString(e.publicKey.Bytes()),
+		EncapsulatedKey: ephemeral.PublicKey().Bytes(),
+		Nonce:           nonce,
+		Ciphertext:      ciphertext,
+	}, nil
+}
+
+// DecryptX25519ArchivePayload decrypts payload encrypted by X25519ArchiveEncryptor
+func DecryptX25519ArchivePayload(payload *ArchiveEncryptedPayload, privateKey *ecdh.PrivateKey) ([]byte, error) {
+	if payload.Scheme != ArchiveEncryptionX25519 {
+		return nil, errArchiveEncryptionScheme
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(payload.EncapsulatedKey)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := privateKey.ECDH(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x25519ArchiveKey(shared, payload.EncapsulatedKey, privateKey.PublicKey().Bytes())
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAESGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, payload.Nonce, payload.Ciphertext, nil)
+}
+
+// x25519ArchiveKey derives AES key from the shared secret bound to both public keys
+func x25519ArchiveKey(shared, ephemeralPublicKey, recipientPublicKey []byte) ([]byte, error) {
+	salt := append(append([]byte{}, ephemeralPublicKey...), recipientPublicKey...)
+	key := make([]byte, 32)
+	_, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte(archiveEncryptionX25519Info)), key)
+	return key, err
+}
+
+type kmsDataKeyAPI interface {
+	GenerateDataKey(ctx context.Context, params *kms.GenerateDataKeyInput, optFns ...func(*kms.Options)) (*kms.GenerateDataKeyOutput, error)
+}
+
+// AWSKMSArchiveEncryptor encrypts events with data keys generated by AWS KMS, events are decrypted
+// by decrypting the data key with KMS, data key is reused for ArchiveKMSDataKeyTTL to avoid a KMS call per event
+type AWSKMSArchiveEncryptor struct {
+	client kmsDataKeyAPI
+	keyID  string
+
+	mu sync.Mutex
+	// plaintext and encrypted data key currently used
+	dataKey          []byte
+	encryptedDataKey []byte
+	dataKeyARN       string
+	dataKeyExpiresAt time.Time
+}
+
+// NewAWSKMSArchiveEncryptor creates encryptor for the KMS key, AWS credentials are loaded from the environment
+func NewAWSKMSArchiveEncryptor(keyID string) (*AWSKMSArchiveEncryptor, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), KMSRequestTimeout)
+	defer cancel()
+	cfg, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &AWSKMSArchiveEncryptor{client: kms.NewFromConfig(cfg), keyID: keyID}, nil
+}
+
+func (e *AWSKMSArchiveEncryptor) Encrypt(plaintext []byte) (*ArchiveEncryptedPayload, error) {
+	key, encryptedKey, keyARN, err := e.currentDataKey()
+	if err != nil {
+		return nil, err
+	}
+	nonce, ciphertext, err := sealAESGCM(key, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return &ArchiveEncryptedPayload{
+		Scheme:          ArchiveEncryptionKMS,
+		KeyID:           keyARN,
+		EncapsulatedKey: encryptedKey,
+		Nonce:           nonce,
+		Ciphertext:      ciphertext,
+	}, nil
+}
+
+func (e *AWSKMSArchiveEncryptor) currentDataKey() (key, encryptedKey []byte, keyARN string, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.dataKey != nil && time.Now().Before(e.dataKeyExpiresAt) {
+		return e.dataKey, e.encryptedDataKey, e.dataKeyARN, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), KMSRequestTimeout)
+	defer cancel()
+	resp, err := e.client.GenerateDataKey(ctx, &kms.GenerateDataKeyInput{
+		KeyId:   aws.String(e.keyID),
+		KeySpec: kmstypes.DataKeySpecAes256,
+	})
+	if err != nil {
+		return nil, nil, "", err
+	}
+	e.dataKey, e.encryptedDataKey = resp.Plaintext, resp.CiphertextBlob
+	e.dataKeyARN = aws.ToString(resp.KeyId)
+	e.dataKeyExpiresAt = time.Now().Add(ArchiveKMSDataKeyTTL)
+	return e.dataKey, e.encryptedDataKey, e.dataKeyARN, nil
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func sealAESGCM(key, plaintext []byte) (nonce, ciphertext []byte, err error) {
+	aead, err := newAESGCM(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce = make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, nil, err
+	}
+	return nonce, aead.Seal(nil, nonce, plaintext, nil), nil
+}
+
+// encryptArchiveEvent replaces the event with its encrypted JSON, method, target block and metadata are kept in plaintext
+// so events can still be partitioned and ordered
+func encryptArchiveEvent(encryptor ArchiveEncryptor, event ArchiveEvent) (ArchiveEvent, error) {
+	row, err := archiveParquetRowOf(event)
+	if err != nil {
+		return event, err
+	}
+	plaintext, err := json.Marshal(event)
+	if err != nil {
+		return event, err
+	}
+	payload, err := encryptor.Encrypt(plaintext)
+	if err != nil {
+		return event, err
+	}
+	return ArchiveEvent{Encrypted: &ArchiveEventEncrypted{
+		Method:      row.Method,
+		BlockNumber: row.BlockNumber,
+		Metadata: &ArchiveEventMetadata{
+			ReceivedAt: row.ReceivedAt,
+			Tenant:     row.Tenant,
+			Peer:       row.Peer,
+		},
+		Payload: payload,
+	}}, nil
+}
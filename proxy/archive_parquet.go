@@ -0,0 +1,193 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress/zstd"
+)
+
+// ArchiveParquetSchemaVersion is incremented on every incompatible change of ArchiveParquetRow
+// files of different versions are written to different directories so they are never read as one table
+const ArchiveParquetSchemaVersion = 1
+
+// ArchiveParquetRow is one archived order in the parquet files
+type ArchiveParquetRow struct {
+	SchemaVersion int32  `parquet:"schema_version"`
+	ReceivedAt    int64  `parquet:"received_at,timestamp(millisecond)"`
+	Method        string `parquet:"method,dict"`
+	Tenant        string `parquet:"tenant,optional,dict"`
+	Peer          string `parquet:"peer,optional,dict"`
+	Signer        string `parquet:"signer,optional"`
+	// BlockNumber is the target block of the bundle, 0 for cancellations
+	BlockNumber     int64    `parquet:"block_number"`
+	MaxBlockNumber  int64    `parquet:"max_block_number,optional"`
+	ReplacementUUID string   `parquet:"replacement_uuid,optional"`
+	Txs             [][]byte `parquet:"txs,list"`
+	// Params are the JSON params of the request as they are sent to the RPC archive
+	Params []byte `parquet:"params,json"`
+}
+
+// ParquetArchiveWriter writes archive batches to parquet files partitioned by the date and the target block
+// files are written to dir/schema=v<version>/date=<yyyy-mm-dd>/block=<number>/ so the directory can be synced
+// to object storage and queried as a hive partitioned table
+type ParquetArchiveWriter struct {
+	dir string
+	seq atomic.Uint64
+}
+
+func NewParquetArchiveWriter(dir string) (*ParquetArchiveWriter, error) {
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, err
+	}
+	return &ParquetArchiveWriter{dir: dir}, nil
+}
+
+type archiveParquetPartition struct {
+	date  string
+	block int64
+}
+
+// Write writes one file to each partition of the batch
+func (w *ParquetArchiveWriter) Write(args *FlashbotsNewOrderEventsArgs) error {
+	partitions := make(map[archiveParquetPartition][]ArchiveParquetRow)
+	var order []archiveParquetPartition
+	for _, event := range args.OrderEvents {
+		row, err := archiveParquetRowOf(event)
+		if err != nil {
+			return err
+		}
+		partition := archiveParquetPartition{
+			date:  time.UnixMilli(row.ReceivedAt).UTC().Format(time.DateOnly),
+			block: row.BlockNumber,
+		}
+		if _, ok := partitions[partition]; !ok {
+			order = append(order, partition)
+		}
+		partitions[partition] = append(partitions[partition], row)
+	}
+	for _, partition := range order {
+		err := w.writeFile(partition, partitions[partition])
+		if err != nil {
+			return err
+		}
+	}
+	archiveParquetRowsWritten.AddInt64(int64(len(args.OrderEvents)))
+	return nil
+}
+
+func (w *ParquetArchiveWriter) writeFile(partition archiveParquetPartition, rows []ArchiveParquetRow) error {
+	dir := filepath.Join(w.dir,
+		"schema=v"+strconv.Itoa(ArchiveParquetSchemaVersion),
+		"date="+partition.date,
+		"block="+strconv.FormatInt(partition.block, 10),
+	)
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("part-%d-%d.parquet", time.Now().UnixNano(), w.seq.Add(1))
+	// files starting with dot are skipped by the query engines until they are renamed
+	tmp := filepath.Join(dir, "."+name)
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	writer := parquet.NewGenericWriter[ArchiveParquetRow](file,
+		parquet.Compression(&zstd.Codec{}),
+		parquet.KeyValueMetadata("orderflow_archive_schema_version", strconv.Itoa(ArchiveParquetSchemaVersion)),
+	)
+	_, err = writer.Write(rows)
+	if err == nil {
+		err = writer.Close()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, name))
+}
+
+func archiveParquetRowOf(event ArchiveEvent) (ArchiveParquetRow, error) {
+	row := ArchiveParquetRow{SchemaVersion: ArchiveParquetSchemaVersion}
+	var (
+		params   any
+		metadata *ArchiveEventMetadata
+	)
+	switch {
+	case event.EthSendBundle != nil:
+		args := event.EthSendBundle.Params
+		params, metadata = args, event.EthSendBundle.Metadata
+		row.Method = EthSendBundleMethod
+		row.BlockNumber = args.BlockNumber.Int64()
+		if args.SigningAddress != nil {
+			row.Signer = args.SigningAddress.Hex()
+		}
+		if args.ReplacementUUID != nil {
+			row.ReplacementUUID = *args.ReplacementUUID
+		}
+		for _, tx := range args.Txs {
+			row.Txs = append(row.Txs, tx)
+		}
+	case event.MevSendBundle != nil:
+		args := event.MevSendBundle.Params
+		params, metadata = args, event.MevSendBundle.Metadata
+		row.Method = MevSendBundleMethod
+		row.BlockNumber = int64(args.Inclusion.BlockNumber)
+		row.MaxBlockNumber = int64(args.Inclusion.MaxBlock)
+		if args.Metadata != nil && args.Metadata.Signer != nil {
+			row.Signer = args.Metadata.Signer.Hex()
+		}
+		row.ReplacementUUID = args.ReplacementUUID
+		for _, body := range args.Body {
+			if body.Tx != nil {
+				row.Txs = append(row.Txs, *body.Tx)
+			}
+		}
+	case event.EthCancelBundle != nil:
+		args := event.EthCancelBundle.Params
+		params, metadata = args, event.EthCancelBundle.Metadata
+		row.Method = EthCancelBundleMethod
+		if args.SigningAddress != nil {
+			row.Signer = args.SigningAddress.Hex()
+		}
+		row.ReplacementUUID = args.ReplacementUUID
+	case event.Redacted != nil:
+		// raw transactions are not archived, params are the redacted event with the transaction hashes
+		params, metadata = event.Redacted, event.Redacted.Metadata
+		row.Method = event.Redacted.Method
+		row.Signer = event.Redacted.Signer
+		row.BlockNumber = event.Redacted.BlockNumber
+		row.MaxBlockNumber = event.Redacted.MaxBlockNumber
+		row.ReplacementUUID = event.Redacted.ReplacementUUID
+	case event.Encrypted != nil:
+		// only the fields that are not encrypted are set, params are the encrypted event
+		params, metadata = event.Encrypted, event.Encrypted.Metadata
+		row.Method = event.Encrypted.Method
+		row.BlockNumber = event.Encrypted.BlockNumber
+	default:
+		return row, errArchiveEmptyEvent
+	}
+	if metadata != nil {
+		row.ReceivedAt = metadata.ReceivedAt
+		row.Tenant = metadata.Tenant
+		row.Peer = metadata.Peer
+	}
+	var err error
+	row.Params, err = json.Marshal(params)
+	return row, err
+}
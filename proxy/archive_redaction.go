@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+var errUnknownArchiveSink = errors.New("unknown archive sink")
+
+// ArchiveEventRedacted is archived instead of the request by redacted sinks,
+// raw transactions are replaced by their hashes and sizes and other params are dropped
+type ArchiveEventRedacted struct {
+	Method          string                `json:"method"`
+	Signer          string                `json:"signer,omitempty"`
+	BlockNumber     int64                 `json:"blockNumber,omitempty"`
+	MaxBlockNumber  int64                 `json:"maxBlockNumber,omitempty"`
+	ReplacementUUID string                `json:"replacementUuid,omitempty"`
+	Txs             []ArchiveRedactedTx   `json:"txs,omitempty"`
+	Metadata        *ArchiveEventMetadata `json:"metadata"`
+}
+
+type ArchiveRedactedTx struct {
+	Hash common.Hash `json:"hash"`
+	// Size is the size of the raw transaction in bytes
+	Size int `json:"size"`
+}
+
+func validateArchiveRedactedSinks(sinks []string) error {
+	for _, sink := range sinks {
+		if sink != ArchiveSinkRPC && sink != ArchiveSinkParquet {
+			return fmt.Errorf("%w: %s", errUnknownArchiveSink, sink)
+		}
+	}
+	return nil
+}
+
+// redactArchiveEvent replaces the event with its metadata, fields are the same as the parquet columns of the event
+func redactArchiveEvent(event ArchiveEvent) (ArchiveEvent, error) {
+	row, err := archiveParquetRowOf(event)
+	if err != nil {
+		return event, err
+	}
+	redacted := &ArchiveEventRedacted{
+		Method:          row.Method,
+		Signer:          row.Signer,
+		BlockNumber:     row.BlockNumber,
+		MaxBlockNumber:  row.MaxBlockNumber,
+		ReplacementUUID: row.ReplacementUUID,
+		Metadata: &ArchiveEventMetadata{
+			ReceivedAt: row.ReceivedAt,
+			Tenant:     row.Tenant,
+			Peer:       row.Peer,
+		},
+	}
+	for _, rawTx := range row.Txs {
+		redacted.Txs = append(redacted.Txs, ArchiveRedactedTx{Hash: archiveTxHash(rawTx), Size: len(rawTx)})
+	}
+	return ArchiveEvent{Redacted: redacted}, nil
+}
+
+// archiveTxHash returns hash of the transaction, raw bytes are hashed if it can't be decoded
+func archiveTxHash(rawTx []byte) common.Hash {
+	var tx types.Transaction
+	if tx.UnmarshalBinary(rawTx) != nil {
+		return crypto.Keccak256Hash(rawTx)
+	}
+	return tx.Hash()
+}
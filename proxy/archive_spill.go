@@ -0,0 +1,216 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// ArchiveSpillDrainInterval is the interval of sending spilled events to the archive
+	ArchiveSpillDrainInterval = time.Second * 5
+	// DefaultArchiveSpillMaxBytes is the max size of all spill segments on disk
+	DefaultArchiveSpillMaxBytes int64 = 1 << 30
+	// ArchiveSpillSegmentMaxBytes is the size after which new segment is started
+	ArchiveSpillSegmentMaxBytes int64 = 16 << 20
+
+	archiveSpillSegmentPrefix = "archive-spill-"
+	archiveSpillSegmentSuffix = ".jsonl"
+)
+
+// ArchiveSpill keeps batches of archive events that could not be sent in bounded segment files on disk
+// each line of a segment is one batch, segments are sent in the order they were written when archive recovers
+type ArchiveSpill struct {
+	log      *slog.Logger
+	dir      string
+	maxBytes int64
+
+	mu sync.Mutex
+	// segment currently written to, nil if there is none
+	current      *os.File
+	currentBytes int64
+	totalBytes   int64
+	// used to keep names of segments created in the same nanosecond ordered
+	lastSegmentID int64
+}
+
+func NewArchiveSpill(log *slog.Logger, dir string, maxBytes int64) (*ArchiveSpill, error) {
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, err
+	}
+	if maxBytes <= 0 {
+		maxBytes = DefaultArchiveSpillMaxBytes
+	}
+	spill := &ArchiveSpill{
+		log:      log,
+		dir:      dir,
+		maxBytes: maxBytes,
+	}
+	// segments left by the previous run are drained too
+	segments, err := spill.segments()
+	if err != nil {
+		return nil, err
+	}
+	for _, segment := range segments {
+		info, err := os.Stat(segment)
+		if err != nil {
+			return nil, err
+		}
+		spill.totalBytes += info.Size()
+	}
+	archiveSpillBytes.Set(float64(spill.totalBytes))
+	return spill, nil
+}
+
+// segments returns paths of all segments, oldest first
+func (s *ArchiveSpill) segments() ([]string, error) {
+	segments, err := filepath.Glob(filepath.Join(s.dir, archiveSpillSegmentPrefix+"*"+archiveSpillSegmentSuffix))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(segments)
+	return segments, nil
+}
+
+// Write appends batch to the current segment, batch is dropped if spill is full
+func (s *ArchiveSpill) Write(args *FlashbotsNewOrderEventsArgs) error {
+	data, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.totalBytes+int64(len(data)) > s.maxBytes {
+		archiveSpillDroppedEvents.AddInt64(int64(len(args.OrderEvents)))
+		return fmt.Errorf("archive spill is full, %d events dropped", len(args.OrderEvents))
+	}
+	if s.current == nil || s.currentBytes >= ArchiveSpillSegmentMaxBytes {
+		err = s.rotate()
+		if err != nil {
+			return err
+		}
+		s.current, err = os.OpenFile(s.nextSegmentPath(), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err != nil {
+			return err
+		}
+	}
+	n, err := s.current.Write(data)
+	s.currentBytes += int64(n)
+	s.totalBytes += int64(n)
+	archiveSpillBytes.Set(float64(s.totalBytes))
+	if err != nil {
+		return err
+	}
+	archiveSpilledEvents.AddInt64(int64(len(args.OrderEvents)))
+	return nil
+}
+
+func (s *ArchiveSpill) nextSegmentPath() string {
+	id := max(time.Now().UnixNano(), s.lastSegmentID+1)
+	s.lastSegmentID = id
+	return filepath.Join(s.dir, fmt.Sprintf("%s%020d%s", archiveSpillSegmentPrefix, id, archiveSpillSegmentSuffix))
+}
+
+// rotate closes the current segment so it can be drained, it must be called with mu locked
+func (s *ArchiveSpill) rotate() error {
+	if s.current == nil {
+		return nil
+	}
+	err := s.current.Close()
+	s.current = nil
+	s.currentBytes = 0
+	return err
+}
+
+// Drain sends batches of closed segments to the archive until send fails
+// segment is removed when all of its batches are sent, not sent batches are kept for the next drain
+func (s *ArchiveSpill) Drain(send func(args *FlashbotsNewOrderEventsArgs) error) error {
+	s.mu.Lock()
+	err := s.rotate()
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	segments, err := s.segments()
+	if err != nil {
+		return err
+	}
+	for _, segment := range segments {
+		err = s.drainSegment(segment, send)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *ArchiveSpill) drainSegment(segment string, send func(args *FlashbotsNewOrderEventsArgs) error) error {
+	data, err := os.ReadFile(segment)
+	if err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+	// bytes of the segment that were sent
+	sent := 0
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		var args FlashbotsNewOrderEventsArgs
+		err = json.Unmarshal(line, &args)
+		if err != nil {
+			s.log.Error("Dropping corrupted archive spill batch", slog.String("segment", segment), slog.Any("error", err))
+			archiveSpillDroppedEvents.Inc()
+		} else if err = send(&args); err != nil {
+			break
+		} else {
+			archiveSpillDrainedEvents.AddInt64(int64(len(args.OrderEvents)))
+		}
+		sent += len(line) + 1
+		err = nil
+	}
+	if err == nil {
+		err = os.Remove(segment)
+		if err != nil {
+			return err
+		}
+		s.release(int64(len(data)))
+		return nil
+	}
+	if sent > 0 {
+		// keep only batches that were not sent
+		tmp := segment + ".tmp"
+		writeErr := os.WriteFile(tmp, data[sent:], 0o600)
+		if writeErr == nil {
+			writeErr = os.Rename(tmp, segment)
+		}
+		if writeErr != nil {
+			return writeErr
+		}
+		s.release(int64(sent))
+	}
+	return err
+}
+
+func (s *ArchiveSpill) release(n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.totalBytes -= n
+	archiveSpillBytes.Set(float64(s.totalBytes))
+}
+
+// Close closes the current segment, spilled batches are kept on disk
+func (s *ArchiveSpill) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rotate()
+}
@@ -0,0 +1,298 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AttestationPath is served by the receiver public server and returns TDX quote that binds its TLS certificate
+const AttestationPath = "/attestation"
+
+var (
+	DefaultConfigfsTSMReportPath = "/sys/kernel/config/tsm/report"
+	AttestationRequestTimeout    = time.Second * 10
+
+	maxQuoteSizeBytes int64 = 1 << 16
+
+	errQuoteFormat           = errors.New("invalid TDX quote")
+	errQuoteReportData       = errors.New("TDX quote is not bound to the certificate")
+	errQuoteMeasurements     = errors.New("TDX quote measurements are not allowed")
+	errQuoteSignature        = errors.New("TDX quote verification failed")
+	errAttestationNoVerifier = errors.New("attestation requires quote verifier and allowed measurements")
+)
+
+const (
+	tdxQuoteVersion      = 4
+	tdxQuoteTeeType      = 0x81
+	tdxQuoteHeaderSize   = 48
+	tdxReportBodySize    = 584
+	tdxMeasurementSize   = 48
+	tdxReportMRTDOffset  = tdxQuoteHeaderSize + 136
+	tdxReportRTMROffset  = tdxQuoteHeaderSize + 328
+	tdxReportDataOffset  = tdxQuoteHeaderSize + 520
+	tdxQuoteMinSizeBytes = tdxQuoteHeaderSize + tdxReportBodySize
+)
+
+// TDXMeasurements are hex encoded measurement registers of the TD
+type TDXMeasurements struct {
+	MRTD  string `json:"mrtd"`
+	RTMR0 string `json:"rtmr0"`
+	RTMR1 string `json:"rtmr1"`
+	RTMR2 string `json:"rtmr2"`
+	RTMR3 string `json:"rtmr3"`
+}
+
+// matches returns true if all registers are equal, empty registers in the allowlist entry match any value
+func (m TDXMeasurements) matches(actual TDXMeasurements) bool {
+	match := func(allowed, value string) bool {
+		return allowed == "" || strings.EqualFold(strings.TrimPrefix(allowed, "0x"), value)
+	}
+	return match(m.MRTD, actual.MRTD) && match(m.RTMR0, actual.RTMR0) && match(m.RTMR1, actual.RTMR1) &&
+		match(m.RTMR2, actual.RTMR2) && match(m.RTMR3, actual.RTMR3)
+}
+
+type tdxQuote struct {
+	measurements TDXMeasurements
+	reportData   [64]byte
+}
+
+// parseTDXQuote extracts measurements and report data from TDX quote v4, signature is not checked
+func parseTDXQuote(quote []byte) (tdxQuote, error) {
+	var result tdxQuote
+	if len(quote) < tdxQuoteMinSizeBytes {
+		return result, fmt.Errorf("%w: too short", errQuoteFormat)
+	}
+	if version := binary.LittleEndian.Uint16(quote[0:2]); version != tdxQuoteVersion {
+		return result, fmt.Errorf("%w: unsupported version %d", errQuoteFormat, version)
+	}
+	if teeType := binary.LittleEndian.Uint32(quote[4:8]); teeType != tdxQuoteTeeType {
+		return result, fmt.Errorf("%w: unsupported tee type %d", errQuoteFormat, teeType)
+	}
+	register := func(offset int) string {
+		return hex.EncodeToString(quote[offset : offset+tdxMeasurementSize])
+	}
+	result.measurements = TDXMeasurements{
+		MRTD:  register(tdxReportMRTDOffset),
+		RTMR0: register(tdxReportRTMROffset),
+		RTMR1: register(tdxReportRTMROffset + tdxMeasurementSize),
+		RTMR2: register(tdxReportRTMROffset + 2*tdxMeasurementSize),
+		RTMR3: register(tdxReportRTMROffset + 3*tdxMeasurementSize),
+	}
+	copy(result.reportData[:], quote[tdxReportDataOffset:])
+	return result, nil
+}
+
+// certReportData is the report data that binds TLS certificate to the quote, sha256 of the PEM followed by zeros
+func certReportData(certPEM []byte) [64]byte {
+	var reportData [64]byte
+	hash := sha256.Sum256(certPEM)
+	copy(reportData[:], hash[:])
+	return reportData
+}
+
+// QuoteProvider generates TDX quote with the given report data
+type QuoteProvider interface {
+	Quote(reportData [64]byte) ([]byte, error)
+}
+
+// ConfigfsTSMQuoteProvider gets quotes using Linux configfs-tsm interface
+type ConfigfsTSMQuoteProvider struct {
+	Path string
+}
+
+func (p *ConfigfsTSMQuoteProvider) Quote(reportData [64]byte) ([]byte, error) {
+	reportPath := filepath.Join(p.Path, uuid.NewString())
+	err := os.Mkdir(reportPath, 0o700)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(reportPath)
+
+	err = os.WriteFile(filepath.Join(reportPath, "inblob"), reportData[:], 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(filepath.Join(reportPath, "outblob"))
+}
+
+// QuoteVerifier checks the signature and TCB status of the quote
+type QuoteVerifier interface {
+	VerifyQuote(ctx context.Context, quote []byte) error
+}
+
+// HTTPQuoteVerifier posts raw quote to the DCAP verification service that returns 200 for valid quotes
+type HTTPQuoteVerifier struct {
+	URL    string
+	Client *http.Client
+}
+
+func NewHTTPQuoteVerifier(url string) *HTTPQuoteVerifier {
+	return &HTTPQuoteVerifier{
+		URL:    url,
+		Client: &http.Client{Timeout: AttestationRequestTimeout},
+	}
+}
+
+func (v *HTTPQuoteVerifier) VerifyQuote(ctx context.Context, quote []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.URL, bytes.NewReader(quote))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := v.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%w: code: %d, body: %s", errQuoteSignature, resp.StatusCode, string(respBody))
+	}
+	return nil
+}
+
+// AttestationVerifier checks that receiver certificate belongs to TD with allowed measurements
+type AttestationVerifier struct {
+	quoteVerifier       QuoteVerifier
+	allowedMeasurements []TDXMeasurements
+
+	// sha256 of the certificates that were already verified
+	verifiedMu sync.Mutex
+	verified   map[[32]byte]struct{}
+}
+
+func NewAttestationVerifier(quoteVerifier QuoteVerifier, allowedMeasurements []TDXMeasurements) (*AttestationVerifier, error) {
+	if quoteVerifier == nil || len(allowedMeasurements) == 0 {
+		return nil, errAttestationNoVerifier
+	}
+	return &AttestationVerifier{
+		quoteVerifier:       quoteVerifier,
+		allowedMeasurements: allowedMeasurements,
+		verified:            make(map[[32]byte]struct{}),
+	}, nil
+}
+
+// LoadTDXMeasurements reads JSON list of allowed measurements from the file
+func LoadTDXMeasurements(path string) ([]TDXMeasurements, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var measurements []TDXMeasurements
+	err = json.Unmarshal(data, &measurements)
+	if err != nil {
+		return nil, err
+	}
+	return measurements, nil
+}
+
+// Verify fetches the quote from the endpoint using connection pinned to certPEM and checks that
+// quote is valid, has allowed measurements and its report data is bound to certPEM
+func (v *AttestationVerifier) Verify(endpoint string, certPEM []byte) (err error) {
+	defer func() {
+		if err != nil {
+			attestationVerificationErrors.Inc()
+		}
+	}()
+
+	certHash := sha256.Sum256(certPEM)
+	v.verifiedMu.Lock()
+	_, ok := v.verified[certHash]
+	v.verifiedMu.Unlock()
+	if ok {
+		return nil
+	}
+
+	transport, err := createTransportForSelfSignedCert(certPEM)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Transport: transport, Timeout: AttestationRequestTimeout}
+	ctx, cancel := context.WithTimeout(context.Background(), AttestationRequestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+AttestationPath, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("attestation endpoint returned code: %d", resp.StatusCode)
+	}
+	quote, err := io.ReadAll(io.LimitReader(resp.Body, maxQuoteSizeBytes))
+	if err != nil {
+		return err
+	}
+
+	parsed, err := parseTDXQuote(quote)
+	if err != nil {
+		return err
+	}
+	if parsed.reportData != certReportData(certPEM) {
+		return errQuoteReportData
+	}
+	allowed := false
+	for _, measurements := range v.allowedMeasurements {
+		if measurements.matches(parsed.measurements) {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return errQuoteMeasurements
+	}
+	err = v.quoteVerifier.VerifyQuote(ctx, quote)
+	if err != nil {
+		return errors.Join(errQuoteSignature, err)
+	}
+
+	v.verifiedMu.Lock()
+	v.verified[certHash] = struct{}{}
+	v.verifiedMu.Unlock()
+	return nil
+}
+
+// serveAttestation returns quote bound to the certificate registered on the config hub
+// quote is generated once for each registered certificate
+func (prx *ReceiverProxy) serveAttestation(w http.ResponseWriter, r *http.Request) {
+	certPEM := prx.registeredCertPEM()
+	prx.attestationQuoteMu.Lock()
+	if prx.attestationQuote == nil || !bytes.Equal(prx.attestationQuotePEM, certPEM) {
+		quote, err := prx.quoteProvider.Quote(certReportData(certPEM))
+		if err != nil {
+			prx.attestationQuoteMu.Unlock()
+			prx.Log.Error("Failed to get attestation quote", slog.Any("error", err))
+			http.Error(w, "failed to get attestation quote", http.StatusInternalServerError)
+			return
+		}
+		prx.attestationQuote = quote
+		prx.attestationQuotePEM = certPEM
+	}
+	quote := prx.attestationQuote
+	prx.attestationQuoteMu.Unlock()
+
+	w.Header().Add("Content-Type", "application/octet-stream")
+	_, err := w.Write(quote)
+	if err != nil {
+		prx.Log.Warn("Failed to serve attestation quote", slog.Any("error", err))
+	}
+}
@@ -0,0 +1,297 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/flashbots/go-utils/signature"
+	"github.com/google/uuid"
+)
+
+var (
+	// AuditLogQueueSize is the number of entries waiting to be signed and written, new entries are dropped when it is full
+	AuditLogQueueSize = 10000
+	// AuditHeadPublishInterval is the interval of logging the head of the audit log chain
+	AuditHeadPublishInterval = time.Minute
+	// last entry is searched in this many bytes at the end of the existing log
+	auditLogTailSize int64 = 64 * 1024
+
+	errAuditLogDisabled = errors.New("audit log is disabled")
+	errAuditChainBroken = errors.New("audit log chain is broken")
+	errAuditPartialLine = errors.New("partial last entry of the audit log is longer than the searched tail")
+)
+
+// AuditEntry records request forwarded by the proxy
+// entries are chained, each one has the hash of the previous entry so removed or altered entries are detected
+type AuditEntry struct {
+	Sequence  uint64         `json:"sequence"`
+	PrevHash  common.Hash    `json:"prevHash"`
+	Method    string         `json:"method"`
+	UniqueKey *uuid.UUID     `json:"uniqueKey,omitempty"`
+	Signer    common.Address `json:"signer"`
+	// Destinations are the names of the local builder and the peers request was queued to
+	Destinations []string  `json:"destinations"`
+	ReceivedAt   time.Time `json:"receivedAt"`
+	ForwardedAt  time.Time `json:"forwardedAt"`
+}
+
+// SignedAuditEntry is one line of the audit log
+// signature is X-Flashbots-Signature of the entry created with the orderflow key of the proxy
+type SignedAuditEntry struct {
+	Entry     json.RawMessage `json:"entry"`
+	Signature string          `json:"signature"`
+}
+
+// AuditHead is the last entry of the audit log chain
+type AuditHead struct {
+	Sequence uint64 `json:"sequence"`
+	// Hash is keccak256 of the last entry, zero if the log is empty
+	Hash common.Hash `json:"hash"`
+}
+
+// VerifyAuditEntry checks signature of the audit log line and returns the entry and the address of the proxy that signed it
+func VerifyAuditEntry(line []byte) (*AuditEntry, common.Address, error) {
+	var signed SignedAuditEntry
+	err := json.Unmarshal(line, &signed)
+	if err != nil {
+		return nil, common.Address{}, err
+	}
+	signer, err := signature.Verify(signed.Signature, signed.Entry)
+	if err != nil {
+		return nil, common.Address{}, err
+	}
+	var entry AuditEntry
+	err = json.Unmarshal(signed.Entry, &entry)
+	if err != nil {
+		return nil, common.Address{}, err
+	}
+	return &entry, signer, nil
+}
+
+// VerifyAuditChain checks signatures and chaining of all entries of the audit log and returns its head
+func VerifyAuditChain(r io.Reader) (AuditHead, error) {
+	var head AuditHead
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, int(auditLogTailSize))
+	for line := 1; scanner.Scan(); line++ {
+		entry, _, err := VerifyAuditEntry(scanner.Bytes())
+		if err != nil {
+			return head, fmt.Errorf("line %d: %w", line, err)
+		}
+		if entry.Sequence != head.Sequence+1 || entry.PrevHash != head.Hash {
+			return head, fmt.Errorf("%w at line %d", errAuditChainBroken, line)
+		}
+		head, err = auditHeadOf(scanner.Bytes())
+		if err != nil {
+			return head, fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	return head, scanner.Err()
+}
+
+// auditHeadOf returns head of the chain ending with the signed entry
+func auditHeadOf(line []byte) (AuditHead, error) {
+	var signed SignedAuditEntry
+	err := json.Unmarshal(line, &signed)
+	if err != nil {
+		return AuditHead{}, err
+	}
+	var entry AuditEntry
+	err = json.Unmarshal(signed.Entry, &entry)
+	if err != nil {
+		return AuditHead{}, err
+	}
+	return AuditHead{Sequence: entry.Sequence, Hash: crypto.Keccak256Hash(signed.Entry)}, nil
+}
+
+// lastAuditHead returns head of the existing audit log so the chain is continued after restart
+// partial last entry left by a crash during the write is truncated, the chain continues from the last complete entry
+func lastAuditHead(log *slog.Logger, file *os.File) (AuditHead, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return AuditHead{}, err
+	}
+	offset := max(info.Size()-auditLogTailSize, 0)
+	tail := make([]byte, info.Size()-offset)
+	_, err = file.ReadAt(tail, offset)
+	if err != nil {
+		return AuditHead{}, err
+	}
+	if len(tail) > 0 && tail[len(tail)-1] != '\n' {
+		end := bytes.LastIndexByte(tail, '\n') + 1
+		if end == 0 && offset > 0 {
+			return AuditHead{}, errAuditPartialLine
+		}
+		log.Error("Audit log ends with partial entry, truncating it", slog.Int("bytes", len(tail)-end))
+		auditLogErrors.Inc()
+		err = file.Truncate(offset + int64(end))
+		if err != nil {
+			return AuditHead{}, err
+		}
+		tail = tail[:end]
+	}
+	lines := bytes.Split(bytes.TrimSpace(tail), []byte{'\n'})
+	last := lines[len(lines)-1]
+	if len(last) == 0 {
+		return AuditHead{}, nil
+	}
+	return auditHeadOf(last)
+}
+
+// AuditLog appends signed entries of the forwarded requests to a file, one JSON per line
+// entries are signed and written in the background so forwarding is not slowed down
+type AuditLog struct {
+	log    *slog.Logger
+	signer RequestSigner
+	file   *os.File
+
+	mu      sync.Mutex
+	closed  bool
+	entries chan *AuditEntry
+	done    chan struct{}
+
+	headMu sync.Mutex
+	head   AuditHead
+}
+
+func NewAuditLog(log *slog.Logger, path string, signer RequestSigner) (*AuditLog, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	head, err := lastAuditHead(log, file)
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("failed to read head of the audit log: %w", err)
+	}
+	auditLog := &AuditLog{
+		head:    head,
+		log:     log,
+		signer:  signer,
+		file:    file,
+		entries: make(chan *AuditEntry, AuditLogQueueSize),
+		done:    make(chan struct{}),
+	}
+	go auditLog.run()
+	return auditLog, nil
+}
+
+// Record queues entry of the request forwarded to the destinations
+func (a *AuditLog) Record(req *ParsedRequest, destinations []string) {
+	if a == nil || len(destinations) == 0 {
+		return
+	}
+	entry := &AuditEntry{
+		Method:       req.method,
+		UniqueKey:    req.requestArgUniqueKey,
+		Signer:       req.signer,
+		Destinations: destinations,
+		ReceivedAt:   req.receivedAt.UTC(),
+		ForwardedAt:  time.Now().UTC(),
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return
+	}
+	select {
+	case a.entries <- entry:
+	default:
+		a.log.Error("Audit log is stalling, entry dropped", slog.String("method", req.method))
+		auditLogErrors.Inc()
+	}
+}
+
+// Head returns the last entry of the chain
+func (a *AuditLog) Head() AuditHead {
+	a.headMu.Lock()
+	defer a.headMu.Unlock()
+	return a.head
+}
+
+func (a *AuditLog) run() {
+	defer close(a.done)
+	ticker := time.NewTicker(AuditHeadPublishInterval)
+	defer ticker.Stop()
+	a.publishHead()
+	for {
+		select {
+		case entry, more := <-a.entries:
+			if !more {
+				a.publishHead()
+				return
+			}
+			err := a.write(entry)
+			if err != nil {
+				a.log.Error("Failed to write audit log entry", slog.Any("error", err))
+				auditLogErrors.Inc()
+				continue
+			}
+			auditLogEntries.Inc()
+		case <-ticker.C:
+			a.publishHead()
+		}
+	}
+}
+
+// publishHead logs the head so it is recorded outside of the proxy
+func (a *AuditLog) publishHead() {
+	head := a.Head()
+	a.log.Info("Audit log head", slog.Uint64("sequence", head.Sequence), slog.String("hash", head.Hash.Hex()))
+}
+
+func (a *AuditLog) write(entry *AuditEntry) error {
+	head := a.Head()
+	entry.Sequence = head.Sequence + 1
+	entry.PrevHash = head.Hash
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	sig, err := a.signer.Create(data)
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(SignedAuditEntry{Entry: data, Signature: sig})
+	if err != nil {
+		return err
+	}
+	_, err = a.file.Write(append(line, '\n'))
+	if err != nil {
+		return err
+	}
+	a.headMu.Lock()
+	a.head = AuditHead{Sequence: entry.Sequence, Hash: crypto.Keccak256Hash(data)}
+	a.headMu.Unlock()
+	setAuditLogHead(a.head)
+	return nil
+}
+
+// Close writes queued entries and closes the file
+func (a *AuditLog) Close() error {
+	a.mu.Lock()
+	a.closed = true
+	close(a.entries)
+	a.mu.Unlock()
+	<-a.done
+	return a.file.Close()
+}
+
+func (prx *ReceiverProxy) GetAuditHead(ctx context.Context) (*AuditHead, error) {
+	if prx.audit == nil {
+		return nil, errAuditLogDisabled
+	}
+	head := prx.audit.Head()
+	return &head, nil
+}
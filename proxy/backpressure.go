@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Backpressure policies applied when share or archive queue is full
+const (
+	// BackpressureBlock blocks the handler until there is space in the queue or request times out
+	BackpressureBlock = "block"
+	// BackpressureDropOldest removes the oldest queued request to make space for the new one
+	BackpressureDropOldest = "drop-oldest"
+	// BackpressureReject rejects the request with overloaded error
+	BackpressureReject = "reject"
+)
+
+const (
+	queueNameShare = "share"
+	// requests received on the public endpoint are shared from their own queue
+	queueNamePeerShare = "peer-share"
+	queueNameArchive   = "archive"
+)
+
+var (
+	errOverloaded                = errors.New("proxy is overloaded, try again later")
+	errUnknownBackpressurePolicy = errors.New("unknown backpressure policy")
+)
+
+func validateBackpressurePolicy(policy string) error {
+	switch policy {
+	case "", BackpressureBlock, BackpressureDropOldest, BackpressureReject:
+		return nil
+	}
+	return fmt.Errorf("%w: %s", errUnknownBackpressurePolicy, policy)
+}
+
+// enqueueRequest sends request to the queue applying backpressure policy when the queue is full
+// errOverloaded is returned when request was not queued because of reject policy or timeout,
+// evicted is called with the requests removed from the queue by drop-oldest policy if it is set
+func enqueueRequest(ctx context.Context, queue chan *ParsedRequest, request *ParsedRequest, policy, queueName string, evicted func(*ParsedRequest)) error {
+	select {
+	case queue <- request:
+		return nil
+	default:
+	}
+
+	switch policy {
+	case BackpressureReject:
+		incQueueOverflow(queueName, policy)
+		return errOverloaded
+	case BackpressureDropOldest:
+		sendDropOldest(queue, request, func(oldest *ParsedRequest) {
+			incQueueOverflow(queueName, policy)
+			if evicted != nil {
+				evicted(oldest)
+			}
+		})
+		return nil
+	default:
+		select {
+		case <-ctx.Done():
+			incQueueOverflow(queueName, BackpressureBlock)
+			return errOverloaded
+		case queue <- request:
+			return nil
+		}
+	}
+}
+
+// sendDropOldest sends request to the queue removing the oldest queued requests until there is space for it,
+// oldest requests are the most likely to be stale already
+func sendDropOldest(queue chan *ParsedRequest, request *ParsedRequest, evicted func(*ParsedRequest)) {
+	for {
+		select {
+		case queue <- request:
+			return
+		default:
+		}
+		select {
+		case oldest := <-queue:
+			evicted(oldest)
+		default:
+		}
+	}
+}
+
+// publishEvicted returns callback that reports requests evicted from the queue as dropped with the reason
+func (prx *ReceiverProxy) publishEvicted(ctx context.Context, reason string) func(*ParsedRequest) {
+	return func(req *ParsedRequest) {
+		prx.requestEvents.publish(ctx, requestEvent{kind: requestEventDropped, req: req, reason: reason})
+	}
+}
@@ -0,0 +1,134 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/flashbots/go-utils/signature"
+)
+
+// BatchRequestsHeader is sent in the responses of the public endpoint with the max number of requests
+// accepted in one JSON-RPC batch, peers send batches only to proxies that advertise it
+const BatchRequestsHeader = "X-Orderflow-Proxy-Max-Batch"
+
+var (
+	// MaxBatchRequests is the max number of requests in a batch accepted on the public endpoint
+	MaxBatchRequests = 100
+
+	errBatchTooLarge = errors.New("batch has too many requests")
+)
+
+// bufferedResponseWriter collects response so it can be inspected before it is sent
+type bufferedResponseWriter struct {
+	header     http.Header
+	body       bytes.Buffer
+	statusCode int
+}
+
+func (w *bufferedResponseWriter) Header() http.Header         { return w.header }
+func (w *bufferedResponseWriter) Write(b []byte) (int, error) { return w.body.Write(b) }
+func (w *bufferedResponseWriter) WriteHeader(statusCode int)  { w.statusCode = statusCode }
+
+// batchHandler serves JSON-RPC batches, other requests are passed to the next handler
+// signature of the batch is verified once and each element is handled by elementHandler with the verified signer
+// elementHandler must extract signer from the header without verifying it
+// large mev_sendBundle requests are decoded by streamer and passed to elementHandler after the signature is verified
+func batchHandler(next, elementHandler http.Handler, streamer *bundleStreamer, maxRequestBodySizeBytes int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(BatchRequestsHeader, strconv.Itoa(MaxBatchRequests))
+		if r.Body == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// rpcserver and batch elements copy the body so the buffer is reused after the request is served
+		buf, err := readPooled(http.MaxBytesReader(w, r.Body, maxRequestBodySizeBytes), r.ContentLength)
+		if err != nil {
+			writeJSONRPCError(w, fmt.Sprintf("request body is too big, max size: %d", maxRequestBodySizeBytes))
+			return
+		}
+		defer putBuffer(buf)
+		body := bytes.TrimSpace(buf.Bytes())
+		if len(body) == 0 || body[0] != '[' {
+			if streamed, ok := streamer.request(r, body); ok {
+				signer, err := signature.Verify(r.Header.Get(signature.HTTPHeader), body)
+				if err == nil {
+					streamed.Header.Set(signature.HTTPHeader, signer.Hex()+":")
+					elementHandler.ServeHTTP(w, streamed)
+					return
+				}
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		signer, err := signature.Verify(r.Header.Get(signature.HTTPHeader), body)
+		if err != nil {
+			writeJSONRPCError(w, err.Error())
+			return
+		}
+		var elements []json.RawMessage
+		err = json.Unmarshal(body, &elements)
+		if err != nil {
+			writeJSONRPCError(w, err.Error())
+			return
+		}
+		if len(elements) > MaxBatchRequests {
+			writeJSONRPCError(w, errBatchTooLarge.Error())
+			return
+		}
+		updateAPIBatchSize(len(elements))
+
+		var idempotencyKeys []string
+		if header := r.Header.Get(IdempotencyKeyHeader); header != "" {
+			idempotencyKeys = strings.Split(header, ",")
+		}
+
+		responses := make([]json.RawMessage, 0, len(elements))
+		for i, element := range elements {
+			elementReq := r.Clone(r.Context())
+			elementReq.Body = io.NopCloser(bytes.NewReader(element))
+			elementReq.ContentLength = int64(len(element))
+			elementReq.Header.Set(signature.HTTPHeader, signer.Hex()+":")
+			elementReq.Header.Del(IdempotencyKeyHeader)
+			if i < len(idempotencyKeys) && idempotencyKeys[i] != "" {
+				elementReq.Header.Set(IdempotencyKeyHeader, idempotencyKeys[i])
+			}
+			if streamed, ok := streamer.request(elementReq, element); ok {
+				elementReq = streamed
+			}
+			elementResp := &bufferedResponseWriter{header: make(http.Header)}
+			elementHandler.ServeHTTP(elementResp, elementReq)
+			response := bytes.TrimSpace(elementResp.body.Bytes())
+			if !json.Valid(response) {
+				response, _ = json.Marshal(jsonRPCError(string(response)))
+			}
+			responses = append(responses, response)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(responses)
+	})
+}
+
+// batchCapabilityTransport records the max batch size advertised by the peer in the responses
+type batchCapabilityTransport struct {
+	base     http.RoundTripper
+	maxBatch *atomic.Int64
+}
+
+func (t *batchCapabilityTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	maxBatch, _ := strconv.ParseInt(resp.Header.Get(BatchRequestsHeader), 10, 64)
+	t.maxBatch.Store(maxBatch)
+	return resp, nil
+}
@@ -0,0 +1,275 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/flashbots/go-utils/rpcclient"
+)
+
+var (
+	blockNumberCacheTTL = time.Second * 3
+	// each endpoint has this long to return block number before the next one is tried
+	blockNumberRequestTimeout = time.Second * 2
+
+	// if no head is received for this long cached block number is refreshed with polling even if subscription is active
+	newHeadsStaleTimeout   = time.Minute
+	newHeadsReconnectDelay = time.Second * 5
+	// unhealthy endpoint is tried again in configured order after this interval
+	blockNumberEndpointRetryInterval = time.Second * 30
+
+	errNewHeadsSubscriptionClosed = errors.New("newHeads subscription closed")
+	errNoBlockNumberEndpoint      = errors.New("no block number RPC endpoint configured")
+	errBlockNumberNotCached       = errors.New("block number was not fetched yet")
+)
+
+// blockNumberEndpoint is one of the RPC endpoints used to poll block number
+type blockNumberEndpoint struct {
+	// host is used in metrics so that credentials in the URL are not exposed
+	host    string
+	client  rpcclient.RPCClient
+	healthy atomic.Bool
+	// unix nano time of the last failure
+	failedAt atomic.Int64
+}
+
+func (e *blockNumberEndpoint) isHealthy() bool {
+	return e.healthy.Load() || time.Since(time.Unix(0, e.failedAt.Load())) > blockNumberEndpointRetryInterval
+}
+
+type BlockNumberSource struct {
+	// endpoints are tried in order, healthy endpoints first
+	endpoints      []*blockNumberEndpoint
+	cacheTTL       time.Duration
+	cacheMu        sync.RWMutex
+	cacheTimestamp time.Time
+	cachedNumber   uint64
+	// held while stale cache is refreshed, concurrent callers wait for the refresh instead of starting their own
+	refreshMu sync.Mutex
+
+	// unix nano time when a higher block number was cached, head age shows that the source is stale
+	headUpdatedAt atomic.Int64
+
+	backgroundUpdate atomic.Bool
+	// set while newHeads subscription delivers heads, cache TTL is not used then
+	subscribed atomic.Bool
+}
+
+// NewBlockNumberSource creates source that polls the first endpoint and falls back to the next ones when it fails
+func NewBlockNumberSource(endpoints ...string) *BlockNumberSource {
+	bs := &BlockNumberSource{
+		cacheTTL: blockNumberCacheTTL,
+	}
+	for _, endpoint := range endpoints {
+		host := endpoint
+		if parsed, err := url.Parse(endpoint); err == nil && parsed.Host != "" {
+			host = parsed.Host
+		}
+		e := &blockNumberEndpoint{
+			host:   host,
+			client: rpcclient.NewClient(endpoint),
+		}
+		e.healthy.Store(true)
+		bs.endpoints = append(bs.endpoints, e)
+	}
+	return bs
+}
+
+func (bs *BlockNumberSource) setCachedBlockNumber(number uint64) {
+	bs.cacheMu.Lock()
+	bs.cacheTimestamp = time.Now()
+	advanced := number > bs.cachedNumber
+	bs.cachedNumber = number
+	bs.cacheMu.Unlock()
+	if advanced {
+		bs.headUpdatedAt.Store(time.Now().UnixNano())
+	}
+}
+
+// headAgeSeconds returns time since the cached block number last advanced, 0 if it was never fetched
+func (bs *BlockNumberSource) headAgeSeconds() float64 {
+	updatedAt := bs.headUpdatedAt.Load()
+	if updatedAt == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, updatedAt)).Seconds()
+}
+
+// registerHeadAgeMetric exports head age of the source labeled with its primary endpoint
+func (bs *BlockNumberSource) registerHeadAgeMetric() {
+	if len(bs.endpoints) == 0 {
+		return
+	}
+	registerBlockNumberHeadAge(bs.endpoints[0].host, bs.headAgeSeconds)
+}
+
+// RunRefresh refreshes stale cache every cache TTL until close is closed,
+// so the head age shows staleness of the chain head and not the time since the last request
+func (bs *BlockNumberSource) RunRefresh(close chan struct{}) {
+	ticker := time.NewTicker(bs.cacheTTL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-close:
+			return
+		case <-ticker.C:
+			_ = bs.refresh()
+		}
+	}
+}
+
+func (bs *BlockNumberSource) isFresh(timestamp time.Time) bool {
+	age := time.Since(timestamp)
+	if bs.subscribed.Load() {
+		return age <= newHeadsStaleTimeout
+	}
+	return age <= bs.cacheTTL
+}
+
+// orderedEndpoints returns healthy endpoints followed by unhealthy ones, keeping configured order
+// endpoints that failed long ago are considered healthy so that primary endpoint is used again after recovery
+func (bs *BlockNumberSource) orderedEndpoints() []*blockNumberEndpoint {
+	healthy := make([]*blockNumberEndpoint, 0, len(bs.endpoints))
+	var unhealthy []*blockNumberEndpoint
+	for _, endpoint := range bs.endpoints {
+		if endpoint.isHealthy() {
+			healthy = append(healthy, endpoint)
+		} else {
+			unhealthy = append(unhealthy, endpoint)
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
+func (bs *BlockNumberSource) UpdateCachedBlockNumber() error {
+	var errs []error
+	for _, endpoint := range bs.orderedEndpoints() {
+		var numberHex hexutil.Uint64
+		ctx, cancel := context.WithTimeout(context.Background(), blockNumberRequestTimeout)
+		err := endpoint.client.CallFor(ctx, &numberHex, "eth_blockNumber")
+		cancel()
+		if err != nil {
+			endpoint.healthy.Store(false)
+			endpoint.failedAt.Store(time.Now().UnixNano())
+			incBlockNumberRPCErrors(endpoint.host)
+			errs = append(errs, err)
+			continue
+		}
+		endpoint.healthy.Store(true)
+		bs.setCachedBlockNumber(uint64(numberHex))
+		return nil
+	}
+	blockNumberUpdateErrors.Inc()
+	if len(errs) == 0 {
+		return errNoBlockNumberEndpoint
+	}
+	return errors.Join(errs...)
+}
+
+// refresh updates cached block number if it is stale, only one caller updates it at a time
+func (bs *BlockNumberSource) refresh() error {
+	bs.refreshMu.Lock()
+	defer bs.refreshMu.Unlock()
+	bs.cacheMu.RLock()
+	fresh := bs.isFresh(bs.cacheTimestamp)
+	bs.cacheMu.RUnlock()
+	if fresh {
+		return nil
+	}
+	return bs.UpdateCachedBlockNumber()
+}
+
+func (bs *BlockNumberSource) BlockNumber() (uint64, error) {
+	bs.cacheMu.RLock()
+	if !bs.isFresh(bs.cacheTimestamp) {
+		bs.cacheMu.RUnlock()
+		err := bs.refresh()
+		if err != nil {
+			return 0, err
+		}
+		bs.cacheMu.RLock()
+	}
+	res := bs.cachedNumber
+	bs.cacheMu.RUnlock()
+	return res, nil
+}
+
+// CachedBlockNumber returns last fetched block number without blocking, ok is false if it was never fetched
+// if the cached value is stale update is started in the background
+func (bs *BlockNumberSource) CachedBlockNumber() (number uint64, ok bool) {
+	bs.cacheMu.RLock()
+	number = bs.cachedNumber
+	timestamp := bs.cacheTimestamp
+	bs.cacheMu.RUnlock()
+
+	if !bs.isFresh(timestamp) && bs.backgroundUpdate.CompareAndSwap(false, true) {
+		go func() {
+			defer bs.backgroundUpdate.Store(false)
+			_ = bs.refresh()
+		}()
+	}
+	return number, !timestamp.IsZero()
+}
+
+type newHead struct {
+	Number hexutil.Uint64 `json:"number"`
+}
+
+// RunNewHeadsSubscription updates cached block number from eth_subscribe("newHeads") on the websocket endpoint
+// until close is closed, polling is used while the subscription is down
+func (bs *BlockNumberSource) RunNewHeadsSubscription(log *slog.Logger, endpoint string, close chan struct{}) {
+	for {
+		err := bs.subscribeNewHeads(endpoint, close)
+		bs.subscribed.Store(false)
+		if err == nil {
+			return
+		}
+		log.Warn("NewHeads subscription failed, using polling", slog.Any("error", err))
+		blockNumberSubscriptionErrors.Inc()
+		select {
+		case <-close:
+			return
+		case <-time.After(newHeadsReconnectDelay):
+		}
+	}
+}
+
+// subscribeNewHeads returns nil only if close is closed
+func (bs *BlockNumberSource) subscribeNewHeads(endpoint string, close chan struct{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	client, err := rpc.DialContext(ctx, endpoint)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	heads := make(chan newHead, 16)
+	sub, err := client.EthSubscribe(ctx, heads, "newHeads")
+	if err != nil {
+		return err
+	}
+	defer sub.Unsubscribe()
+
+	for {
+		select {
+		case <-close:
+			return nil
+		case err := <-sub.Err():
+			if err == nil {
+				err = errNewHeadsSubscriptionClosed
+			}
+			return err
+		case head := <-heads:
+			bs.setCachedBlockNumber(uint64(head.Number))
+			bs.subscribed.Store(true)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+var (
+	BlocklistFetchTimeout = time.Second * 30
+	// BlocklistMaxSizeBytes is the max size of the blocklist fetched from URL
+	BlocklistMaxSizeBytes int64 = 64 << 20
+
+	errBlockedAddress    = errors.New("transaction interacts with a blocked address")
+	errBlocklistAddress  = errors.New("invalid address in blocklist")
+	errBlocklistTooLarge = errors.New("blocklist is too large")
+)
+
+// AddressBlocklist is a list of addresses loaded from a file or URL
+// file contains one address per line (lines starting with # are ignored) or JSON array of addresses
+type AddressBlocklist struct {
+	log    *slog.Logger
+	source string
+	// if true transactions are only logged and counted instead of being rejected
+	flagOnly bool
+
+	mu        sync.RWMutex
+	addresses map[common.Address]struct{}
+}
+
+// NewAddressBlocklist creates blocklist and loads it from the source
+func NewAddressBlocklist(log *slog.Logger, source string, flagOnly bool) (*AddressBlocklist, error) {
+	blocklist := &AddressBlocklist{
+		log:      log,
+		source:   source,
+		flagOnly: flagOnly,
+	}
+	err := blocklist.Refresh()
+	if err != nil {
+		return nil, err
+	}
+	return blocklist, nil
+}
+
+// Refresh reloads the blocklist from the source, on error previous list is kept
+func (b *AddressBlocklist) Refresh() error {
+	data, err := b.fetch()
+	if err != nil {
+		blocklistRefreshErrors.Inc()
+		return err
+	}
+	addresses, err := parseAddressBlocklist(data)
+	if err != nil {
+		blocklistRefreshErrors.Inc()
+		return err
+	}
+
+	b.mu.Lock()
+	b.addresses = addresses
+	b.mu.Unlock()
+
+	blocklistSize.Set(float64(len(addresses)))
+	b.log.Info("Loaded address blocklist", slog.Int("size", len(addresses)))
+	return nil
+}
+
+// RunRefresh periodically refreshes the blocklist until close is closed
+func (b *AddressBlocklist) RunRefresh(interval time.Duration, close chan struct{}) {
+	for {
+		select {
+		case <-close:
+			return
+		case <-time.After(interval):
+			err := b.Refresh()
+			if err != nil {
+				b.log.Error("Failed to refresh address blocklist", slog.Any("error", err))
+			}
+		}
+	}
+}
+
+func (b *AddressBlocklist) fetch() ([]byte, error) {
+	if !strings.HasPrefix(b.source, "http://") && !strings.HasPrefix(b.source, "https://") {
+		return os.ReadFile(b.source)
+	}
+
+	client := http.Client{Timeout: BlocklistFetchTimeout}
+	resp, err := client.Get(b.source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("blocklist fetch failed, code: %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, BlocklistMaxSizeBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > BlocklistMaxSizeBytes {
+		return nil, errBlocklistTooLarge
+	}
+	return data, nil
+}
+
+func parseAddressBlocklist(data []byte) (map[common.Address]struct{}, error) {
+	var entries []string
+	trimmed := bytes.TrimSpace(data)
+	if bytes.HasPrefix(trimmed, []byte("[")) {
+		err := json.Unmarshal(trimmed, &entries)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		scanner := bufio.NewScanner(bytes.NewReader(trimmed))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			entries = append(entries, line)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	addresses := make(map[common.Address]struct{}, len(entries))
+	for _, entry := range entries {
+		if !common.IsHexAddress(entry) {
+			return nil, fmt.Errorf("%w: %s", errBlocklistAddress, entry)
+		}
+		addresses[common.HexToAddress(entry)] = struct{}{}
+	}
+	return addresses, nil
+}
+
+func (b *AddressBlocklist) Contains(address common.Address) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	_, ok := b.addresses[address]
+	return ok
+}
+
+// CheckTx checks sender, recipient and set code delegations of the transaction against the blocklist
+func (b *AddressBlocklist) CheckTx(sender common.Address, tx *types.Transaction) error {
+	addresses := []common.Address{sender}
+	if tx.To() != nil {
+		addresses = append(addresses, *tx.To())
+	}
+	for _, auth := range tx.SetCodeAuthorizations() {
+		addresses = append(addresses, auth.Address)
+	}
+
+	for _, address := range addresses {
+		if !b.Contains(address) {
+			continue
+		}
+		if b.flagOnly {
+			b.log.Warn("Transaction interacts with a blocked address", slog.String("tx", tx.Hash().Hex()), slog.String("address", address.Hex()))
+			incBlocklistMatches("flag")
+			return nil
+		}
+		incBlocklistMatches("drop")
+		return errBlockedAddress
+	}
+	return nil
+}
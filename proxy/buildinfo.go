@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+
+	"github.com/flashbots/go-utils/rpcserver"
+	"github.com/flashbots/tdx-orderflow-proxy/common"
+)
+
+const (
+	ArchiveSinkNone = "none"
+	ArchiveSinkRPC  = "rpc"
+	// ArchiveSinkParquet means archived orderflow is written to local parquet files
+	ArchiveSinkParquet = "parquet"
+)
+
+// BuildInfo describes the running binary and the capabilities enabled in this deployment
+type BuildInfo struct {
+	Version   string            `json:"version"`
+	GitCommit string            `json:"gitCommit"`
+	BuildDate string            `json:"buildDate"`
+	Features  BuildInfoFeatures `json:"features"`
+	Methods   BuildInfoMethods  `json:"methods"`
+}
+
+type BuildInfoFeatures struct {
+	ArchiveSink string `json:"archiveSink"`
+	// ArchiveRedacted is true if only metadata of the requests is archived
+	ArchiveRedacted bool `json:"archiveRedacted"`
+	// ArchiveEncrypted is true if archived events are encrypted before they leave the proxy
+	ArchiveEncrypted bool `json:"archiveEncrypted"`
+	// MTLS is true if the metrics server requires client certificates
+	MTLS        bool `json:"mtls"`
+	Attestation bool `json:"attestation"`
+	// PeerSharingDisabled is true if requests are forwarded only to the local builder
+	PeerSharingDisabled bool `json:"peerSharingDisabled"`
+	// ReceiveOnly is true if the proxy runs in receive-only mode for staging builders and never shares requests with the peers
+	ReceiveOnly bool `json:"receiveOnly"`
+}
+
+type BuildInfoMethods struct {
+	Public []string `json:"public"`
+	Local  []string `json:"local"`
+}
+
+func methodNames(methods rpcserver.Methods) []string {
+	names := make([]string, 0, len(methods))
+	for name := range methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (prx *ReceiverProxy) BuildInfo() BuildInfo {
+	return BuildInfo{
+		Version:   common.Version,
+		GitCommit: common.GitCommit,
+		BuildDate: common.BuildDate,
+		Features:  prx.features,
+		Methods: BuildInfoMethods{
+			Public: methodNames(prx.publicMethods()),
+			Local:  methodNames(prx.localMethods()),
+		},
+	}
+}
+
+func (prx *ReceiverProxy) ProxyVersion(ctx context.Context) (BuildInfo, error) {
+	return prx.BuildInfo(), nil
+}
+
+func (prx *ReceiverProxy) serveBuildInfo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(prx.BuildInfo())
+	if err != nil {
+		prx.Log.Warn("Failed to serve build info", slog.Any("error", err))
+	}
+}
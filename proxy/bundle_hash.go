@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/flashbots/go-utils/rpctypes"
+)
+
+// EthSendBundleResponse is the result of eth_sendBundle
+type EthSendBundleResponse struct {
+	BundleHash common.Hash `json:"bundleHash"`
+}
+
+// ethBundleHash returns keccak256 of the concatenated transaction hashes, the same hash is used by the relay
+// bundles without transactions (cancellations with replacementUuid) don't have the hash
+func ethBundleHash(bundle *rpctypes.EthSendBundleArgs) (common.Hash, bool) {
+	if len(bundle.Txs) == 0 {
+		return common.Hash{}, false
+	}
+	hashes := make([]byte, 0, len(bundle.Txs)*common.HashLength)
+	for _, rawTx := range bundle.Txs {
+		var tx types.Transaction
+		if err := tx.UnmarshalBinary(rawTx); err != nil {
+			return common.Hash{}, false
+		}
+		hashes = append(hashes, tx.Hash().Bytes()...)
+	}
+	return crypto.Keccak256Hash(hashes), true
+}
+
+func newEthSendBundleResponse(bundle *rpctypes.EthSendBundleArgs) *EthSendBundleResponse {
+	hash, ok := ethBundleHash(bundle)
+	if !ok {
+		return nil
+	}
+	return &EthSendBundleResponse{BundleHash: hash}
+}
+
+// MevSendBundleResponse is the result of mev_sendBundle
+type MevSendBundleResponse struct {
+	BundleHash common.Hash `json:"bundleHash"`
+}
+
+// mevBundleHash returns keccak256 of the hashes of the bundle body elements, inner bundles are hashed recursively
+// and backrun targets are hashed with their transaction hash, cancellations without body don't have the hash
+func mevBundleHash(bundle *rpctypes.MevSendBundleArgs) (common.Hash, bool) {
+	if len(bundle.Body) == 0 {
+		return common.Hash{}, false
+	}
+	return hashMevBundleBody(bundle, 0)
+}
+
+func hashMevBundleBody(bundle *rpctypes.MevSendBundleArgs, level int) (common.Hash, bool) {
+	if level > rpctypes.MevBundleMaxDepth {
+		return common.Hash{}, false
+	}
+	hashes := make([]byte, 0, len(bundle.Body)*common.HashLength)
+	for _, body := range bundle.Body {
+		switch {
+		case body.Tx != nil:
+			var tx types.Transaction
+			if err := tx.UnmarshalBinary(*body.Tx); err != nil {
+				return common.Hash{}, false
+			}
+			hashes = append(hashes, tx.Hash().Bytes()...)
+		case body.Hash != nil:
+			hashes = append(hashes, body.Hash.Bytes()...)
+		case body.Bundle != nil:
+			innerHash, ok := hashMevBundleBody(body.Bundle, level+1)
+			if !ok {
+				return common.Hash{}, false
+			}
+			hashes = append(hashes, innerHash.Bytes()...)
+		}
+	}
+	return crypto.Keccak256Hash(hashes), true
+}
+
+func newMevSendBundleResponse(bundle *rpctypes.MevSendBundleArgs) *MevSendBundleResponse {
+	hash, ok := mevBundleHash(bundle)
+	if !ok {
+		return nil
+	}
+	return &MevSendBundleResponse{BundleHash: hash}
+}
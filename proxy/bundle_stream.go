@@ -0,0 +1,353 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/flashbots/go-utils/rpcserver"
+	"github.com/flashbots/go-utils/rpctypes"
+)
+
+var (
+	// StreamingBundleThresholdBytes is the size of the request after which mev_sendBundle params are decoded
+	// from the received body directly instead of being copied by rpcserver
+	StreamingBundleThresholdBytes = int64(1024 * 1024)
+	// MaxMevBundleBodyElements is the max number of body elements of mev_sendBundle and each of its nested bundles
+	MaxMevBundleBodyElements = rpctypes.MevBundleTxLimit
+
+	errMevBundleTooManyElements = errors.New("mev share bundle has too many body elements")
+	errMevBundleTooDeep         = errors.New("mev share bundle is nested too deep")
+	errStreamedRequest          = errors.New("request can't be decoded by streaming")
+)
+
+type streamedBundleContextKey struct{}
+
+// bundleStreamer decodes large mev_sendBundle requests token by token, the decoded bundle is passed to the method
+// in the request context while rpcserver gets the request with empty params
+// in strict mode bundles with unknown fields are not streamed, they are rejected by strictParamsHandler
+type bundleStreamer struct {
+	methods map[string]struct{}
+	strict  bool
+}
+
+func newBundleStreamer(aliases map[string]string, strict bool) *bundleStreamer {
+	methods := map[string]struct{}{MevSendBundleMethod: {}}
+	for alias, method := range aliases {
+		if method == MevSendBundleMethod {
+			methods[alias] = struct{}{}
+		}
+	}
+	return &bundleStreamer{methods: methods, strict: strict}
+}
+
+// request returns request with the decoded bundle, false if the body is small or it is not mev_sendBundle
+// request is not changed so rpcserver handles it and reports the errors as usual
+func (s *bundleStreamer) request(r *http.Request, body []byte) (*http.Request, bool) {
+	if s == nil || int64(len(body)) <= StreamingBundleThresholdBytes {
+		return nil, false
+	}
+	envelope, bundle, err := s.decode(body)
+	if err != nil {
+		return nil, false
+	}
+	compact, err := json.Marshal(envelope)
+	if err != nil {
+		return nil, false
+	}
+	apiStreamedBundles.Inc()
+	ctx := context.WithValue(r.Context(), streamedBundleContextKey{}, bundle)
+	ctx = context.WithValue(ctx, requestSizeContextKey{}, &countingReader{n: len(body)})
+	streamed := r.Clone(ctx)
+	streamed.Body = io.NopCloser(bytes.NewReader(compact))
+	streamed.ContentLength = int64(len(compact))
+	return streamed, true
+}
+
+// streamedEnvelope is the request passed to rpcserver instead of the original one
+type streamedEnvelope struct {
+	JSONRPC json.RawMessage `json:"jsonrpc,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  [1]struct{}     `json:"params"`
+}
+
+// decode reads JSON-RPC request, params are decoded only if the method is already known when they are reached
+func (s *bundleStreamer) decode(body []byte) (*streamedEnvelope, *rpctypes.MevSendBundleArgs, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	err := expectDelim(dec, '{')
+	if err != nil {
+		return nil, nil, err
+	}
+	var (
+		envelope streamedEnvelope
+		bundle   *rpctypes.MevSendBundleArgs
+	)
+	for dec.More() {
+		key, err := objectKey(dec)
+		if err != nil {
+			return nil, nil, err
+		}
+		switch {
+		case strings.EqualFold(key, "jsonrpc"):
+			err = dec.Decode(&envelope.JSONRPC)
+		case strings.EqualFold(key, "id"):
+			err = dec.Decode(&envelope.ID)
+		case strings.EqualFold(key, "method"):
+			err = dec.Decode(&envelope.Method)
+		case strings.EqualFold(key, "params"):
+			if _, ok := s.methods[envelope.Method]; !ok {
+				return nil, nil, errStreamedRequest
+			}
+			bundle, err = s.decodeParams(dec)
+		default:
+			err = dec.Decode(&json.RawMessage{})
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if bundle == nil {
+		return nil, nil, errStreamedRequest
+	}
+	return &envelope, bundle, nil
+}
+
+// decodeParams decodes params with exactly one bundle
+func (s *bundleStreamer) decodeParams(dec *json.Decoder) (*rpctypes.MevSendBundleArgs, error) {
+	err := expectDelim(dec, '[')
+	if err != nil {
+		return nil, err
+	}
+	if !dec.More() {
+		return nil, errStreamedRequest
+	}
+	bundle, err := s.decodeBundle(dec, 0)
+	if err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errStreamedRequest
+	}
+	return bundle, expectDelim(dec, ']')
+}
+
+// decodeBundle decodes transactions directly from the body, other fields are small
+// and decoded through json.RawMessage, elements over MaxMevBundleBodyElements and bundles nested too deep are skipped without decoding,
+// bundle keeps one empty element or empty nested bundle in their place so it is rejected by ValidateMevSendBundle
+func (s *bundleStreamer) decodeBundle(dec *json.Decoder, level int) (*rpctypes.MevSendBundleArgs, error) {
+	bundle := new(rpctypes.MevSendBundleArgs)
+	if level > rpctypes.MevBundleMaxDepth {
+		return bundle, skipValue(dec)
+	}
+	err := expectDelim(dec, '{')
+	if err != nil {
+		return nil, err
+	}
+	var body []rpctypes.MevBundleBody
+	fields := make(map[string]json.RawMessage)
+	for dec.More() {
+		key, err := objectKey(dec)
+		if err != nil {
+			return nil, err
+		}
+		if strings.EqualFold(key, "body") {
+			body, err = s.decodeBundleBody(dec, level)
+		} else {
+			var value json.RawMessage
+			err = dec.Decode(&value)
+			fields[key] = value
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = expectDelim(dec, '}')
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	err = s.unmarshal(encoded, bundle)
+	if err != nil {
+		return nil, err
+	}
+	bundle.Body = body
+	return bundle, nil
+}
+
+func (s *bundleStreamer) decodeBundleBody(dec *json.Decoder, level int) ([]rpctypes.MevBundleBody, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if token == nil {
+		return nil, nil
+	}
+	if token != json.Delim('[') {
+		return nil, errStreamedRequest
+	}
+	var body []rpctypes.MevBundleBody
+	for dec.More() {
+		if len(body) > MaxMevBundleBodyElements {
+			err = skipValue(dec)
+		} else if len(body) == MaxMevBundleBodyElements {
+			body = append(body, rpctypes.MevBundleBody{})
+			err = skipValue(dec)
+		} else {
+			var element rpctypes.MevBundleBody
+			element, err = s.decodeBundleBodyElement(dec, level)
+			body = append(body, element)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return body, expectDelim(dec, ']')
+}
+
+func (s *bundleStreamer) decodeBundleBodyElement(dec *json.Decoder, level int) (rpctypes.MevBundleBody, error) {
+	var element rpctypes.MevBundleBody
+	err := expectDelim(dec, '{')
+	if err != nil {
+		return element, err
+	}
+	fields := make(map[string]json.RawMessage)
+	for dec.More() {
+		key, err := objectKey(dec)
+		if err != nil {
+			return element, err
+		}
+		switch {
+		case strings.EqualFold(key, "tx"):
+			err = dec.Decode(&element.Tx)
+		case strings.EqualFold(key, "bundle"):
+			element.Bundle, err = s.decodeBundle(dec, level+1)
+		default:
+			var value json.RawMessage
+			err = dec.Decode(&value)
+			fields[key] = value
+		}
+		if err != nil {
+			return element, err
+		}
+	}
+	err = expectDelim(dec, '}')
+	if err != nil || len(fields) == 0 {
+		return element, err
+	}
+	tx, bundle := element.Tx, element.Bundle
+	encoded, err := json.Marshal(fields)
+	if err != nil {
+		return element, err
+	}
+	err = s.unmarshal(encoded, &element)
+	element.Tx, element.Bundle = tx, bundle
+	return element, err
+}
+
+// skipValue reads the next value token by token so it is never buffered as a whole
+func skipValue(dec *json.Decoder) error {
+	depth := 0
+	for {
+		token, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch token {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+func (s *bundleStreamer) unmarshal(data []byte, v any) error {
+	if s.strict {
+		return decodeStrict(data, v)
+	}
+	return json.Unmarshal(data, v)
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return errStreamedRequest
+	}
+	return nil
+}
+
+func objectKey(dec *json.Decoder) (string, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return "", err
+	}
+	key, ok := token.(string)
+	if !ok {
+		return "", errStreamedRequest
+	}
+	return key, nil
+}
+
+// streamedBundles wraps methods so mev_sendBundle gets the bundle decoded by bundleStreamer instead of empty params,
+// it must be the outermost wrapper so other wrappers see the decoded bundle
+func streamedBundles(methods rpcserver.Methods) rpcserver.Methods {
+	bundleType := reflect.TypeOf(rpctypes.MevSendBundleArgs{})
+	for name, method := range methods {
+		fn := reflect.ValueOf(method)
+		if fn.Type().NumIn() != 2 || fn.Type().In(1) != bundleType {
+			continue
+		}
+		methods[name] = reflect.MakeFunc(fn.Type(), func(args []reflect.Value) []reflect.Value {
+			ctx, _ := args[0].Interface().(context.Context)
+			if bundle, ok := ctx.Value(streamedBundleContextKey{}).(*rpctypes.MevSendBundleArgs); ok {
+				args[1] = reflect.ValueOf(*bundle)
+			}
+			return fn.Call(args)
+		}).Interface()
+	}
+	return methods
+}
+
+// validateMevBundleElements checks nesting depth of the bundle and number of body elements of each level and in total,
+// it stops at the first violation so huge bundles are not walked completely
+func validateMevBundleElements(args *rpctypes.MevSendBundleArgs, maxDepth, maxElements int) error {
+	total := 0
+	return countMevBundleElements(args, 0, maxDepth, maxElements, &total)
+}
+
+func countMevBundleElements(args *rpctypes.MevSendBundleArgs, level, maxDepth, maxElements int, total *int) error {
+	if level > maxDepth {
+		return errMevBundleTooDeep
+	}
+	if len(args.Body) > MaxMevBundleBodyElements {
+		return errMevBundleTooManyElements
+	}
+	*total += len(args.Body)
+	if *total > maxElements {
+		return errMevBundleTooManyElements
+	}
+	for _, body := range args.Body {
+		if body.Bundle != nil {
+			err := countMevBundleElements(body.Bundle, level+1, maxDepth, maxElements, total)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
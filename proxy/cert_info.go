@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+var errCertPEM = errors.New("no certificate found in PEM")
+
+// CertInfo is the JSON response of the cert endpoint, it has everything needed to validate and pin the certificate
+type CertInfo struct {
+	PEM string `json:"pem"`
+	// FingerprintSHA256 is hex encoded SHA-256 of the DER certificate
+	FingerprintSHA256 string    `json:"fingerprintSha256"`
+	NotBefore         time.Time `json:"notBefore"`
+	NotAfter          time.Time `json:"notAfter"`
+	DNSNames          []string  `json:"dnsNames"`
+	IPAddresses       []string  `json:"ipAddresses"`
+	KeyType           string    `json:"keyType"`
+	// SignerAddress is the address of the orderflow signer of the proxy
+	SignerAddress common.Address `json:"signerAddress"`
+}
+
+// CertFingerprint returns hex encoded SHA-256 of the DER certificate
+func CertFingerprint(cert *x509.Certificate) string {
+	fingerprint := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(fingerprint[:])
+}
+
+// NewCertInfo parses the first certificate of the PEM
+func NewCertInfo(certPEM []byte) (*CertInfo, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errCertPEM
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	info := &CertInfo{
+		PEM:               string(certPEM),
+		FingerprintSHA256: CertFingerprint(cert),
+		NotBefore:         cert.NotBefore.UTC(),
+		NotAfter:          cert.NotAfter.UTC(),
+		DNSNames:          cert.DNSNames,
+		IPAddresses:       make([]string, 0, len(cert.IPAddresses)),
+		KeyType:           certKeyType(cert.PublicKey),
+	}
+	if info.DNSNames == nil {
+		info.DNSNames = []string{}
+	}
+	for _, ip := range cert.IPAddresses {
+		info.IPAddresses = append(info.IPAddresses, ip.String())
+	}
+	return info, nil
+}
+
+func certKeyType(key any) string {
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		return "ECDSA " + key.Curve.Params().Name
+	case *rsa.PublicKey:
+		return "RSA " + strconv.Itoa(key.N.BitLen())
+	case ed25519.PublicKey:
+		return "Ed25519"
+	default:
+		return "unknown"
+	}
+}
+
+// wantsJSON is true if the client asked for the JSON variant of the endpoint
+func wantsJSON(r *http.Request) bool {
+	return r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+func (prx *ReceiverProxy) serveCertInfo(w http.ResponseWriter) {
+	info, err := NewCertInfo(prx.certPEM())
+	if err != nil {
+		prx.Log.Error("Failed to parse certificate", slog.Any("error", err))
+		http.Error(w, "failed to parse certificate", http.StatusInternalServerError)
+		return
+	}
+	info.SignerAddress = prx.OrderflowSigner.Address()
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(info)
+	if err != nil {
+		prx.Log.Warn("Failed to serve certificate", slog.Any("error", err))
+	}
+}
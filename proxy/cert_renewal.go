@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"log/slog"
+	"slices"
+	"time"
+
+	utils_tls "github.com/flashbots/go-utils/tls"
+)
+
+var (
+	// CertRenewalRetryInterval is the time between attempts to renew the certificate after failure
+	CertRenewalRetryInterval = time.Minute
+
+	errCertRenewBefore = errors.New("certificate renewal must start before the certificate expires, cert renew before must be shorter than cert duration")
+)
+
+// certPEM returns PEM of the certificate served by the proxy
+func (prx *ReceiverProxy) certPEM() []byte {
+	prx.certMu.RLock()
+	defer prx.certMu.RUnlock()
+	return prx.PublicCertPEM
+}
+
+// registeredCertPEM returns PEM registered on the config hub, during renewal it has both the new and the current certificate
+func (prx *ReceiverProxy) registeredCertPEM() []byte {
+	prx.certMu.RLock()
+	defer prx.certMu.RUnlock()
+	if prx.renewalCertPEM != nil {
+		return prx.renewalCertPEM
+	}
+	return prx.PublicCertPEM
+}
+
+// getCertificate returns copy of the served certificate, handshakes read it without holding certMu
+func (prx *ReceiverProxy) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	prx.certMu.RLock()
+	defer prx.certMu.RUnlock()
+	certificate := prx.Certificate
+	return &certificate, nil
+}
+
+// certNotAfter returns expiry of the served certificate
+func (prx *ReceiverProxy) certNotAfter() (time.Time, error) {
+	prx.certMu.RLock()
+	leaf := prx.Certificate.Leaf
+	prx.certMu.RUnlock()
+	if leaf == nil {
+		info, err := NewCertInfo(prx.certPEM())
+		if err != nil {
+			return time.Time{}, err
+		}
+		return info.NotAfter, nil
+	}
+	return leaf.NotAfter, nil
+}
+
+// RenewCertificate generates a new certificate and starts serving it after the grace period
+// peers trust both certificates during the grace period because both are registered on the config hub
+func (prx *ReceiverProxy) RenewCertificate(ctx context.Context, gracePeriod time.Duration) error {
+	certPEM, key, err := utils_tls.GenerateTLS(prx.certValidDuration, CertHostsForSANs(prx.certHosts))
+	if err != nil {
+		return err
+	}
+	certificate, err := tls.X509KeyPair(certPEM, key)
+	if err != nil {
+		return err
+	}
+	if certificate.Leaf == nil {
+		certificate.Leaf, err = x509.ParseCertificate(certificate.Certificate[0])
+		if err != nil {
+			return err
+		}
+	}
+
+	prx.certMu.Lock()
+	prx.renewalCertPEM = slices.Concat(certPEM, prx.PublicCertPEM)
+	prx.certMu.Unlock()
+	err = prx.registerCredentials(ctx, prx.OrderflowSigner.Address())
+	if err != nil {
+		prx.certMu.Lock()
+		prx.renewalCertPEM = nil
+		prx.certMu.Unlock()
+		return err
+	}
+
+	// both certificates are already on the config hub so we switch even if the context is done
+	select {
+	case <-ctx.Done():
+	case <-time.After(gracePeriod):
+	}
+	prx.certMu.Lock()
+	prx.PublicCertPEM = certPEM
+	prx.Certificate = certificate
+	prx.renewalCertPEM = nil
+	prx.certMu.Unlock()
+	certRenewals.Inc()
+	setCertExpiry(certificate.Leaf.NotAfter)
+	prx.Log.Info("Renewed certificate", slog.Time("notAfter", certificate.Leaf.NotAfter))
+
+	// old certificate is removed from the config hub, if it fails peers still trust the new one from the bundle
+	return prx.registerCredentials(context.WithoutCancel(ctx), prx.OrderflowSigner.Address())
+}
+
+// RunCertRenewal renews the certificate renewBefore its expiry until close is closed
+func (prx *ReceiverProxy) RunCertRenewal(renewBefore, gracePeriod time.Duration, close chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-close
+		cancel()
+	}()
+	for {
+		wait := CertRenewalRetryInterval
+		notAfter, err := prx.certNotAfter()
+		if err != nil {
+			prx.Log.Error("Failed to get certificate expiry", slog.Any("error", err))
+		} else {
+			setCertExpiry(notAfter)
+			wait = max(time.Until(notAfter.Add(-renewBefore)), 0)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+			if err != nil {
+				continue
+			}
+			err = prx.RenewCertificate(ctx, gracePeriod)
+			if err != nil {
+				certRenewalErrors.Inc()
+				prx.Log.Error("Failed to renew certificate", slog.Any("error", err))
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(CertRenewalRetryInterval):
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	ContentEncodingZstd = "zstd"
+	ContentEncodingGzip = "gzip"
+
+	// acceptedContentEncodings is sent in the Accept-Encoding response header of the public endpoint (RFC 7694)
+	acceptedContentEncodings = ContentEncodingZstd + ", " + ContentEncodingGzip
+)
+
+var (
+	// PeerCompressionMinSizeBytes is the smallest request body that is compressed
+	PeerCompressionMinSizeBytes = 1024
+
+	zstdEncoder, _ = zstd.NewWriter(nil)
+)
+
+// compressingTransport compresses request bodies with encoding accepted by the server
+// the first request is sent uncompressed and serves as a probe, encoding is selected from the
+// Accept-Encoding response header so peers that don't support compression always get plain requests
+type compressingTransport struct {
+	base     http.RoundTripper
+	encoding atomic.Pointer[string]
+}
+
+func (t *compressingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	encoding := t.encoding.Load()
+	if encoding == nil || req.Body == nil || req.GetBody == nil || req.ContentLength < int64(PeerCompressionMinSizeBytes) {
+		return t.roundTrip(req)
+	}
+	bodyReader, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	buf, err := readPooled(bodyReader, req.ContentLength)
+	if err != nil {
+		return nil, err
+	}
+	bodySize := buf.Len()
+	compressed, err := compressBody(*encoding, buf.Bytes())
+	putBuffer(buf)
+	if err != nil {
+		return nil, err
+	}
+	compressedReq := req.Clone(req.Context())
+	compressedReq.Body = io.NopCloser(bytes.NewReader(compressed))
+	compressedReq.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(compressed)), nil
+	}
+	compressedReq.ContentLength = int64(len(compressed))
+	compressedReq.Header.Set("Content-Encoding", *encoding)
+	resp, err := t.base.RoundTrip(compressedReq)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnsupportedMediaType {
+		// peer stopped accepting the encoding, fall back to plain request
+		_ = resp.Body.Close()
+		t.encoding.Store(nil)
+		return t.roundTrip(req)
+	}
+	peerCompressionBytesIn.Add(bodySize)
+	peerCompressionBytesOut.Add(len(compressed))
+	return resp, nil
+}
+
+// roundTrip sends request as is and updates encoding from the response
+func (t *compressingTransport) roundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if encoding, ok := selectContentEncoding(resp.Header.Get("Accept-Encoding")); ok {
+		t.encoding.Store(&encoding)
+	}
+	return resp, nil
+}
+
+// selectContentEncoding returns preferred encoding from the Accept-Encoding header
+func selectContentEncoding(acceptEncoding string) (string, bool) {
+	gzipAccepted := false
+	for _, encoding := range strings.Split(acceptEncoding, ",") {
+		encoding, _, _ = strings.Cut(encoding, ";")
+		switch strings.TrimSpace(encoding) {
+		case ContentEncodingZstd:
+			return ContentEncodingZstd, true
+		case ContentEncodingGzip:
+			gzipAccepted = true
+		}
+	}
+	if gzipAccepted {
+		return ContentEncodingGzip, true
+	}
+	return "", false
+}
+
+func compressBody(encoding string, body []byte) ([]byte, error) {
+	if encoding == ContentEncodingZstd {
+		return zstdEncoder.EncodeAll(body, nil), nil
+	}
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	_, err := writer.Write(body)
+	if err != nil {
+		return nil, err
+	}
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompressingHandler decompresses request bodies and advertises accepted encodings
+// decompressed body is limited to maxRequestBodySizeBytes
+func decompressingHandler(next http.Handler, maxRequestBodySizeBytes int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Encoding", acceptedContentEncodings)
+		var body io.Reader
+		switch r.Header.Get("Content-Encoding") {
+		case "":
+			next.ServeHTTP(w, r)
+			return
+		case ContentEncodingZstd:
+			decoder, err := zstd.NewReader(r.Body, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			defer decoder.Close()
+			body = decoder
+		case ContentEncodingGzip:
+			decoder, err := gzip.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			defer decoder.Close()
+			body = decoder
+		default:
+			http.Error(w, "unsupported content encoding", http.StatusUnsupportedMediaType)
+			return
+		}
+		decompressed, err := io.ReadAll(io.LimitReader(body, maxRequestBodySizeBytes+1))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if int64(len(decompressed)) > maxRequestBodySizeBytes {
+			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r = r.Clone(r.Context())
+		r.Header.Del("Content-Encoding")
+		r.Body = io.NopCloser(bytes.NewReader(decompressed))
+		r.ContentLength = int64(len(decompressed))
+		next.ServeHTTP(w, r)
+	})
+}
@@ -8,32 +8,150 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 )
 
+var (
+	PeerListWebhookTimeout = time.Second * 5
+
+	// updated by all config hub clients, used only by metrics
+	confighubLastSuccess         atomic.Int64
+	confighubConsecutiveFailures atomic.Int64
+)
+
 type ConfighubOrderflowProxyCredentials struct {
 	TLSCert            string         `json:"tls_cert"`
 	EcdsaPubkeyAddress common.Address `json:"ecdsa_pubkey_address"`
+	// TLSCertFingerprints are optional hex encoded SHA-256 of the DER certificates, if set peer certificate is pinned to them
+	TLSCertFingerprints []string `json:"tls_cert_fingerprints,omitempty"`
 }
 
 type ConfighubBuilder struct {
-	Name           string                             `json:"name"`
-	IP             string                             `json:"ip"`
+	Name string `json:"name"`
+	IP   string `json:"ip"`
+	// PublicPort is optional, if not set DefaultOrderflowProxyPublicPort is used unless IP includes the port
+	PublicPort     uint16                             `json:"orderflow_proxy_public_port,omitempty"`
 	OrderflowProxy ConfighubOrderflowProxyCredentials `json:"orderflow_proxy"`
 }
 
+// OrderflowProxyURL returns URL of the builder public orderflow proxy endpoint
+func (b *ConfighubBuilder) OrderflowProxyURL() string {
+	if b.PublicPort != 0 && !strings.Contains(b.IP, "://") {
+		if _, _, err := net.SplitHostPort(b.IP); err != nil {
+			host := strings.TrimSuffix(strings.TrimPrefix(b.IP, "["), "]")
+			return "https://" + net.JoinHostPort(host, strconv.Itoa(int(b.PublicPort)))
+		}
+	}
+	return OrderflowProxyURLFromIP(b.IP)
+}
+
 type BuilderConfigHub struct {
 	log      *slog.Logger
 	endpoint string
+
+	mu                  sync.Mutex
+	lastSuccess         time.Time
+	consecutiveFailures int
+	staleWebhook        *PeerListStaleWebhook
+	staleWebhookFired   bool
+}
+
+// PeerListStaleWebhook is called once when the peer list was not fetched successfully for StaleAfter,
+// it is called again only after the next successful fetch
+type PeerListStaleWebhook struct {
+	URL        string
+	StaleAfter time.Duration
+}
+
+// PeerListStaleAlert is posted as JSON to the PeerListStaleWebhook URL
+type PeerListStaleAlert struct {
+	Endpoint            string    `json:"endpoint"`
+	LastSuccess         time.Time `json:"lastSuccess"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	Error               string    `json:"error"`
 }
 
 func NewBuilderConfigHub(log *slog.Logger, endpoint string) *BuilderConfigHub {
 	return &BuilderConfigHub{
-		log:      log,
-		endpoint: endpoint,
+		log:         log,
+		endpoint:    endpoint,
+		lastSuccess: time.Now(),
+	}
+}
+
+func confighubLastSuccessAgeSeconds() float64 {
+	lastSuccess := confighubLastSuccess.Load()
+	if lastSuccess == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, lastSuccess)).Seconds()
+}
+
+// recordFetch updates freshness of the peer list and fires the webhook when it becomes stale
+func (b *BuilderConfigHub) recordFetch(fetchErr error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	if fetchErr == nil {
+		b.lastSuccess = now
+		b.consecutiveFailures = 0
+		b.staleWebhookFired = false
+		confighubLastSuccess.Store(now.UnixNano())
+		confighubConsecutiveFailures.Store(0)
+		return
+	}
+	b.consecutiveFailures++
+	confighubConsecutiveFailures.Add(1)
+	if b.staleWebhook == nil || b.staleWebhookFired || now.Sub(b.lastSuccess) < b.staleWebhook.StaleAfter {
+		return
+	}
+	b.staleWebhookFired = true
+	alert := PeerListStaleAlert{
+		Endpoint:            b.endpoint,
+		LastSuccess:         b.lastSuccess,
+		ConsecutiveFailures: b.consecutiveFailures,
+		Error:               fetchErr.Error(),
+	}
+	go b.postStaleAlert(b.staleWebhook.URL, alert)
+}
+
+func (b *BuilderConfigHub) postStaleAlert(url string, alert PeerListStaleAlert) {
+	err := func() error {
+		body, err := json.Marshal(alert)
+		if err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), PeerListWebhookTimeout)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode >= http.StatusMultipleChoices {
+			return fmt.Errorf("webhook returned error, code: %d", resp.StatusCode)
+		}
+		return nil
+	}()
+	if err != nil {
+		peerListWebhookErrors.Inc()
+		b.log.Error("Failed to call peer list stale webhook", slog.Any("error", err))
+		return
 	}
+	b.log.Warn("Peer list is stale, webhook called", slog.Time("lastSuccess", alert.LastSuccess), slog.Int("consecutiveFailures", alert.ConsecutiveFailures))
 }
 
 func (b *BuilderConfigHub) RegisterCredentials(ctx context.Context, info ConfighubOrderflowProxyCredentials) error {
@@ -65,6 +183,7 @@ func (b *BuilderConfigHub) Builders(internal bool) (result []ConfighubBuilder, e
 			confighubErrorsCounter.Inc()
 			b.log.Error("Failed to fetch peer list from config hub", slog.Any("error", err))
 		}
+		b.recordFetch(err)
 	}()
 
 	var resp *http.Response
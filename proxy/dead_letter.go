@@ -0,0 +1,125 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var DeadLetterRequestTimeout = time.Second * 5
+
+// RetryPolicy configures retries of the failed requests with exponential backoff
+type RetryPolicy struct {
+	MaxRetries int
+	// Backoff is the delay before the first retry, it is doubled for every next retry
+	Backoff time.Duration
+}
+
+// Do calls fn until it succeeds or retries are exhausted, last error is returned
+// if the destination rate limited the request, retry waits at least for the requested time
+func (p RetryPolicy) Do(fn func() error) error {
+	backoff := p.Backoff
+	err := fn()
+	for retry := 0; err != nil && retry < p.MaxRetries; retry++ {
+		delay := backoff
+		var rateLimitErr *RateLimitError
+		if errors.As(err, &rateLimitErr) {
+			delay = max(delay, rateLimitErr.RetryAfter)
+		}
+		time.Sleep(delay)
+		backoff *= 2
+		incRetries()
+		err = fn()
+	}
+	return err
+}
+
+// DeadLetter is a record of the request that could not be delivered
+type DeadLetter struct {
+	Time        time.Time `json:"time"`
+	Destination string    `json:"destination"`
+	Method      string    `json:"method"`
+	Params      any       `json:"params"`
+	Error       string    `json:"error"`
+}
+
+// DeadLetterQueue records permanently failed requests to a file (one JSON per line) or posts them to http(s) endpoint
+type DeadLetterQueue struct {
+	log    *slog.Logger
+	target string
+
+	mu     sync.Mutex
+	file   *os.File
+	client *http.Client
+}
+
+func NewDeadLetterQueue(log *slog.Logger, target string) (*DeadLetterQueue, error) {
+	queue := &DeadLetterQueue{
+		log:    log,
+		target: target,
+	}
+	if strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") {
+		queue.client = &http.Client{Timeout: DeadLetterRequestTimeout}
+		return queue, nil
+	}
+	file, err := os.OpenFile(target, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	queue.file = file
+	return queue, nil
+}
+
+// Record stores failed request, errors are only logged
+func (q *DeadLetterQueue) Record(destination, method string, params any, reqErr error) {
+	record := DeadLetter{
+		Time:        time.Now().UTC(),
+		Destination: destination,
+		Method:      method,
+		Params:      params,
+		Error:       reqErr.Error(),
+	}
+	data, err := json.Marshal(record)
+	if err == nil {
+		err = q.write(data)
+	}
+	if err != nil {
+		q.log.Error("Failed to record dead letter", slog.String("method", method), slog.Any("error", err))
+		deadLetterErrors.Inc()
+		return
+	}
+	deadLetterRecords.Inc()
+}
+
+func (q *DeadLetterQueue) write(data []byte) error {
+	if q.client != nil {
+		resp, err := q.client.Post(q.target, "application/json", bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode >= http.StatusMultipleChoices {
+			return fmt.Errorf("dead letter endpoint returned code: %d", resp.StatusCode)
+		}
+		return nil
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	_, err := q.file.Write(append(data, '\n'))
+	return err
+}
+
+func (q *DeadLetterQueue) Close() error {
+	if q.file != nil {
+		return q.file.Close()
+	}
+	return nil
+}
@@ -0,0 +1,256 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// EventsPath is served on the events listen address
+const EventsPath = "/events"
+
+var (
+	// EventStreamBufferSize is the number of events buffered per subscriber, events are dropped for slow subscribers
+	EventStreamBufferSize  = 1024
+	eventStreamKeepAlive   = time.Second * 15
+	activeEventSubscribers atomic.Int64
+
+	errEventFilter = errors.New("invalid event filter")
+)
+
+// OrderflowEvent is a sanitized description of the accepted request, it does not contain transaction payloads
+type OrderflowEvent struct {
+	Method   string         `json:"method"`
+	Signer   common.Address `json:"signer"`
+	PeerName string         `json:"peerName"`
+	// BlockNumber and MaxBlock are the target blocks of the bundle
+	BlockNumber uint64        `json:"blockNumber,omitempty"`
+	MaxBlock    uint64        `json:"maxBlock,omitempty"`
+	TxHashes    []common.Hash `json:"txHashes,omitempty"`
+	// ReceivedAt is unix time in milliseconds
+	ReceivedAt int64 `json:"receivedAt"`
+}
+
+func txHash(rawTx hexutil.Bytes) (common.Hash, bool) {
+	var tx types.Transaction
+	if err := tx.UnmarshalBinary(rawTx); err != nil {
+		return common.Hash{}, false
+	}
+	return tx.Hash(), true
+}
+
+func newOrderflowEvent(req *ParsedRequest) *OrderflowEvent {
+	event := &OrderflowEvent{
+		Method:     req.method,
+		Signer:     req.originalSigner(),
+		PeerName:   req.peerName,
+		ReceivedAt: req.receivedAt.UnixMilli(),
+	}
+	addTx := func(rawTx hexutil.Bytes) {
+		if hash, ok := txHash(rawTx); ok {
+			event.TxHashes = append(event.TxHashes, hash)
+		}
+	}
+	switch {
+	case req.ethSendBundle != nil:
+		event.BlockNumber = uint64(req.ethSendBundle.BlockNumber)
+		for _, tx := range req.ethSendBundle.Txs {
+			addTx(tx)
+		}
+	case req.mevSendBundle != nil:
+		event.BlockNumber = uint64(req.mevSendBundle.Inclusion.BlockNumber)
+		event.MaxBlock = uint64(req.mevSendBundle.Inclusion.MaxBlock)
+		for _, body := range req.mevSendBundle.Body {
+			if body.Tx != nil {
+				addTx(*body.Tx)
+			} else if body.Hash != nil {
+				event.TxHashes = append(event.TxHashes, *body.Hash)
+			}
+		}
+	case req.ethSendRawTransaction != nil:
+		addTx(hexutil.Bytes(*req.ethSendRawTransaction))
+	}
+	return event
+}
+
+// EventFilter selects events sent to the subscriber, empty fields match any event
+type EventFilter struct {
+	Methods []string
+	Signers []common.Address
+	Peers   []string
+	// FromBlock and ToBlock select bundles that target any block in the range, 0 means unbounded
+	FromBlock uint64
+	ToBlock   uint64
+}
+
+// ParseEventFilter parses filter from the query parameters: method, signer and peer
+// can be repeated, fromBlock and toBlock are decimal block numbers
+func ParseEventFilter(query url.Values) (*EventFilter, error) {
+	filter := &EventFilter{
+		Methods: query["method"],
+		Peers:   query["peer"],
+	}
+	for _, signer := range query["signer"] {
+		if !common.IsHexAddress(signer) {
+			return nil, fmt.Errorf("%w: signer %s", errEventFilter, signer)
+		}
+		filter.Signers = append(filter.Signers, common.HexToAddress(signer))
+	}
+	parseBlock := func(name string) (uint64, error) {
+		value := query.Get(name)
+		if value == "" {
+			return 0, nil
+		}
+		block, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %s %s", errEventFilter, name, value)
+		}
+		return block, nil
+	}
+	var err error
+	filter.FromBlock, err = parseBlock("fromBlock")
+	if err != nil {
+		return nil, err
+	}
+	filter.ToBlock, err = parseBlock("toBlock")
+	if err != nil {
+		return nil, err
+	}
+	if filter.ToBlock != 0 && filter.FromBlock > filter.ToBlock {
+		return nil, fmt.Errorf("%w: fromBlock is after toBlock", errEventFilter)
+	}
+	return filter, nil
+}
+
+// Matches returns true if event passes the filter, events without target blocks don't match block range
+func (f *EventFilter) Matches(event *OrderflowEvent) bool {
+	if len(f.Methods) > 0 && !slices.Contains(f.Methods, event.Method) {
+		return false
+	}
+	if len(f.Signers) > 0 && !slices.Contains(f.Signers, event.Signer) {
+		return false
+	}
+	if len(f.Peers) > 0 && !slices.Contains(f.Peers, event.PeerName) {
+		return false
+	}
+	if f.FromBlock != 0 || f.ToBlock != 0 {
+		if event.BlockNumber == 0 {
+			return false
+		}
+		lastBlock := max(event.BlockNumber, event.MaxBlock)
+		if lastBlock < f.FromBlock || (f.ToBlock != 0 && event.BlockNumber > f.ToBlock) {
+			return false
+		}
+	}
+	return true
+}
+
+// eventStream broadcasts events of the accepted requests to the subscribers
+type eventStream struct {
+	mu          sync.Mutex
+	subscribers map[chan *OrderflowEvent]*EventFilter
+}
+
+func newEventStream() *eventStream {
+	return &eventStream{
+		subscribers: make(map[chan *OrderflowEvent]*EventFilter),
+	}
+}
+
+func (s *eventStream) subscribe(filter *EventFilter) chan *OrderflowEvent {
+	ch := make(chan *OrderflowEvent, EventStreamBufferSize)
+	s.mu.Lock()
+	s.subscribers[ch] = filter
+	s.mu.Unlock()
+	activeEventSubscribers.Add(1)
+	return ch
+}
+
+func (s *eventStream) unsubscribe(ch chan *OrderflowEvent) {
+	s.mu.Lock()
+	delete(s.subscribers, ch)
+	s.mu.Unlock()
+	activeEventSubscribers.Add(-1)
+}
+
+// publish sends event of the request to all subscribers, event is created only if there are any
+func (s *eventStream) publish(req *ParsedRequest) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.subscribers) == 0 {
+		return
+	}
+	event := newOrderflowEvent(req)
+	for ch, filter := range s.subscribers {
+		if !filter.Matches(event) {
+			continue
+		}
+		select {
+		case ch <- event:
+		default:
+			eventStreamDropped.Inc()
+		}
+	}
+}
+
+// serveEvents streams events as Server-Sent Events until the client disconnects
+// events can be filtered with query parameters, see ParseEventFilter
+func (prx *ReceiverProxy) serveEvents(w http.ResponseWriter, r *http.Request) {
+	filter, err := ParseEventFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	// stream is not limited by the server write timeout
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	events := prx.events.subscribe(filter)
+	defer prx.events.unsubscribe(events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		prx.Log.Warn("Event stream does not support flushing", slog.Any("error", err))
+		return
+	}
+
+	keepAlive := time.NewTicker(eventStreamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			_, err = fmt.Fprint(w, ": keepalive\n\n")
+		case event := <-events:
+			var data []byte
+			data, err = json.Marshal(event)
+			if err == nil {
+				_, err = fmt.Fprintf(w, "data: %s\n\n", data)
+			}
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			prx.Log.Debug("Event stream closed", slog.Any("error", err))
+			return
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// IdempotencyKeyHeader carries unique key of the forwarded request, for batches it is a comma separated
+// list of keys in the order of the batch elements with empty entries for requests without the key
+const IdempotencyKeyHeader = "X-Orderflow-Idempotency-Key"
+
+type idempotencyKeysContextKey struct{}
+
+// withIdempotencyKeys sets keys that are sent in the IdempotencyKeyHeader of the request
+func withIdempotencyKeys(ctx context.Context, keys []string) context.Context {
+	return context.WithValue(ctx, idempotencyKeysContextKey{}, keys)
+}
+
+// idempotencyKeyFromContext returns the key of the received request
+func idempotencyKeyFromContext(ctx context.Context) (uuid.UUID, bool) {
+	keys, ok := ctx.Value(idempotencyKeysContextKey{}).([]string)
+	if !ok || len(keys) != 1 {
+		return uuid.UUID{}, false
+	}
+	key, err := uuid.Parse(keys[0])
+	if err != nil {
+		return uuid.UUID{}, false
+	}
+	return key, true
+}
+
+func (c shareCall) idempotencyKey() string {
+	if c.key == nil {
+		return ""
+	}
+	return c.key.String()
+}
+
+// idempotencyKeyTransport sets IdempotencyKeyHeader from the request context
+type idempotencyKeyTransport struct {
+	base http.RoundTripper
+}
+
+func (t *idempotencyKeyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	keys, ok := req.Context().Value(idempotencyKeysContextKey{}).([]string)
+	if !ok {
+		return t.base.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set(IdempotencyKeyHeader, strings.Join(keys, ","))
+	return t.base.RoundTrip(req)
+}
+
+// idempotencyKeyHandler puts the key from IdempotencyKeyHeader to the request context
+func idempotencyKeyHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get(IdempotencyKeyHeader)
+		if header != "" {
+			r = r.WithContext(withIdempotencyKeys(r.Context(), []string{header}))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
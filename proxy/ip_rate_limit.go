@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// IPRateLimiterIdleTTL is the time after which limiter of the client that sent no requests is removed
+var IPRateLimiterIdleTTL = time.Minute * 10
+
+type ipLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// IPRateLimiter limits requests of each client IP with token bucket, IPv6 clients are limited by /64 prefix
+// it runs before the signature is verified so garbage requests can't be used to burn CPU on signature recovery
+type IPRateLimiter struct {
+	maxRPS int
+
+	mu          sync.Mutex
+	limiters    map[netip.Addr]*ipLimiter
+	lastCleanup time.Time
+}
+
+func NewIPRateLimiter(maxRPS int) *IPRateLimiter {
+	return &IPRateLimiter{
+		maxRPS:      maxRPS,
+		limiters:    make(map[netip.Addr]*ipLimiter),
+		lastCleanup: time.Now(),
+	}
+}
+
+// ipRateLimitKey returns address of the client or its /64 prefix for IPv6
+func ipRateLimitKey(remoteAddr string) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	ip = ip.Unmap()
+	if ip.Is6() {
+		prefix, err := ip.Prefix(64)
+		if err != nil {
+			return netip.Addr{}, false
+		}
+		ip = prefix.Addr()
+	}
+	return ip, true
+}
+
+// Allow returns RateLimitError if the client exceeded its rate limit
+func (l *IPRateLimiter) Allow(ip netip.Addr) *RateLimitError {
+	now := time.Now()
+	l.mu.Lock()
+	if now.Sub(l.lastCleanup) >= IPRateLimiterIdleTTL {
+		for key, limiter := range l.limiters {
+			if now.Sub(limiter.lastSeen) >= IPRateLimiterIdleTTL {
+				delete(l.limiters, key)
+			}
+		}
+		l.lastCleanup = now
+	}
+	limiter, ok := l.limiters[ip]
+	if !ok {
+		limiter = &ipLimiter{limiter: rate.NewLimiter(rate.Limit(l.maxRPS), l.maxRPS)}
+		l.limiters[ip] = limiter
+	}
+	limiter.lastSeen = now
+	l.mu.Unlock()
+
+	reservation := limiter.limiter.ReserveN(now, 1)
+	delay := reservation.DelayFrom(now)
+	if delay == 0 {
+		return nil
+	}
+	reservation.CancelAt(now)
+	return &RateLimitError{RetryAfter: delay}
+}
+
+// Handler rejects requests of the clients that exceeded their rate limit, requests with unknown address are not limited
+func (l *IPRateLimiter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, ok := ipRateLimitKey(r.RemoteAddr)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		err := l.Allow(ip)
+		if err != nil {
+			apiIPRateLimits.Inc()
+			writeRateLimitError(w, err)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,147 @@
+package proxy
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/flashbots/go-utils/signature"
+)
+
+// DefaultSignerRotationGracePeriod is long enough for peers to fetch the new address from the config hub
+var DefaultSignerRotationGracePeriod = peerUpdateTime * 2
+
+// RotatingSigner is a RequestSigner that can be replaced at runtime
+// pending signer is already registered on the config hub but not used for signing yet
+type RotatingSigner struct {
+	mu      sync.RWMutex
+	current RequestSigner
+	pending RequestSigner
+}
+
+func NewRotatingSigner(signer RequestSigner) *RotatingSigner {
+	return &RotatingSigner{current: signer}
+}
+
+func (s *RotatingSigner) Address() common.Address {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.current.Address()
+}
+
+func (s *RotatingSigner) Create(body []byte) (string, error) {
+	s.mu.RLock()
+	current := s.current
+	s.mu.RUnlock()
+	return current.Create(body)
+}
+
+// OwnsAddress returns true for addresses of the current and the pending signer
+func (s *RotatingSigner) OwnsAddress(address common.Address) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.current.Address() == address || (s.pending != nil && s.pending.Address() == address)
+}
+
+func (s *RotatingSigner) setPending(signer RequestSigner) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending = signer
+}
+
+func (s *RotatingSigner) activatePending() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pending != nil {
+		s.current = s.pending
+		s.pending = nil
+	}
+}
+
+func isOwnAddress(signer RequestSigner, address common.Address) bool {
+	if rotating, ok := signer.(*RotatingSigner); ok {
+		return rotating.OwnsAddress(address)
+	}
+	return signer.Address() == address
+}
+
+// previousPeerAddress is the address used by the peer before it rotated its key
+type previousPeerAddress struct {
+	name      string
+	expiresAt time.Time
+}
+
+// RotateSigner registers new signer on the config hub and starts using it after the grace period
+// so that peers fetch the new address before receiving requests signed by it
+func (prx *ReceiverProxy) RotateSigner(ctx context.Context, newSigner RequestSigner, gracePeriod time.Duration) error {
+	oldAddress := prx.rotatingSigner.Address()
+	prx.rotatingSigner.setPending(newSigner)
+	err := prx.registerCredentials(ctx, newSigner.Address())
+	if err != nil {
+		prx.rotatingSigner.setPending(nil)
+		return err
+	}
+
+	// new address is already on the config hub so we switch even if the context is done
+	select {
+	case <-ctx.Done():
+	case <-time.After(gracePeriod):
+	}
+	prx.rotatingSigner.activatePending()
+	signerRotations.Inc()
+	prx.Log.Info("Rotated orderflow signer", slog.String("oldAddress", oldAddress.Hex()), slog.String("newAddress", newSigner.Address().Hex()))
+	return nil
+}
+
+// RunSignerRotation periodically replaces orderflow signer with a new random key until close is closed
+func (prx *ReceiverProxy) RunSignerRotation(interval, gracePeriod time.Duration, close chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-close
+		cancel()
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+			newSigner, err := signature.NewRandomSigner()
+			if err != nil {
+				prx.Log.Error("Failed to generate new orderflow signer", slog.Any("error", err))
+				continue
+			}
+			err = prx.RotateSigner(ctx, newSigner, gracePeriod)
+			if err != nil {
+				prx.Log.Error("Failed to rotate orderflow signer", slog.Any("error", err))
+			}
+		}
+	}
+}
+
+// updatePreviousPeerAddresses remembers addresses of peers that changed their key, must be called with peersMu held
+func (prx *ReceiverProxy) updatePreviousPeerAddresses(newPeers []ConfighubBuilder, now time.Time) {
+	if prx.previousPeerAddresses == nil {
+		prx.previousPeerAddresses = make(map[common.Address]previousPeerAddress)
+	}
+	for address, previous := range prx.previousPeerAddresses {
+		if now.After(previous.expiresAt) {
+			delete(prx.previousPeerAddresses, address)
+		}
+	}
+
+	newAddresses := make(map[string]common.Address, len(newPeers))
+	for _, peer := range newPeers {
+		newAddresses[peer.Name] = peer.OrderflowProxy.EcdsaPubkeyAddress
+	}
+	for _, peer := range prx.lastFetchedPeers {
+		newAddress, ok := newAddresses[peer.Name]
+		if ok && newAddress != peer.OrderflowProxy.EcdsaPubkeyAddress {
+			prx.previousPeerAddresses[peer.OrderflowProxy.EcdsaPubkeyAddress] = previousPeerAddress{
+				name:      peer.Name,
+				expiresAt: now.Add(prx.signerRotationGracePeriod),
+			}
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	DefaultLeaderLockTTL = time.Second * 5
+	leaderLockKey        = "orderflow-proxy:sender-leader"
+
+	isSenderLeader atomic.Bool
+
+	errNotLeader = errors.New("proxy is on standby, requests are forwarded by the leader")
+)
+
+// LeaderLock is held by the sender proxy instance that forwards requests
+type LeaderLock interface {
+	// Acquire takes the lock or extends it if it is already held by this instance,
+	// false is returned if the lock is held by another instance
+	Acquire(ctx context.Context, ttl time.Duration) (bool, error)
+	Release(ctx context.Context) error
+}
+
+// NewLeaderLock creates lock from redis:// or rediss:// URL or a file path
+func NewLeaderLock(source string) (LeaderLock, error) {
+	if strings.HasPrefix(source, "redis://") || strings.HasPrefix(source, "rediss://") {
+		return NewRedisLeaderLock(source)
+	}
+	return NewFileLeaderLock(source), nil
+}
+
+// FileLeaderLock uses flock on the file, it is released by the OS when the holder exits
+// so it works only for instances on the same host or on a shared filesystem with flock support
+type FileLeaderLock struct {
+	path string
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+func NewFileLeaderLock(path string) *FileLeaderLock {
+	return &FileLeaderLock{path: path}
+}
+
+func (l *FileLeaderLock) Acquire(ctx context.Context, ttl time.Duration) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil {
+		return true, nil
+	}
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return false, err
+	}
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		_ = file.Close()
+		return false, nil
+	}
+	if err != nil {
+		_ = file.Close()
+		return false, err
+	}
+	l.file = file
+	return true, nil
+}
+
+func (l *FileLeaderLock) Release(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN)
+	_ = l.file.Close()
+	l.file = nil
+	return err
+}
+
+var (
+	// acquire or extend the lock if it is held by the same id
+	redisLeaderAcquireScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return 1
+end
+return 0`)
+	redisLeaderReleaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+)
+
+// RedisLeaderLock is a lease in Redis that expires if the holder does not extend it
+type RedisLeaderLock struct {
+	client *redis.Client
+	id     string
+}
+
+func NewRedisLeaderLock(redisURL string) (*RedisLeaderLock, error) {
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	return &RedisLeaderLock{client: redis.NewClient(opts), id: uuid.NewString()}, nil
+}
+
+func (l *RedisLeaderLock) Acquire(ctx context.Context, ttl time.Duration) (bool, error) {
+	acquired, err := redisLeaderAcquireScript.Run(ctx, l.client, []string{leaderLockKey}, l.id, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	return acquired == 1, nil
+}
+
+func (l *RedisLeaderLock) Release(ctx context.Context) error {
+	return redisLeaderReleaseScript.Run(ctx, l.client, []string{leaderLockKey}, l.id).Err()
+}
+
+// runLeaderElection acquires the lock and extends it every third of ttl until close is closed
+// leadership is dropped on errors so two instances never forward at the same time
+func (prx *SenderProxy) runLeaderElection(lock LeaderLock, ttl time.Duration, close chan struct{}) {
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), ttl/3)
+		acquired, err := lock.Acquire(ctx, ttl)
+		cancel()
+		if err != nil {
+			prx.Log.Warn("Failed to acquire leader lock", slog.Any("error", err))
+			leaderElectionErrors.Inc()
+		}
+		if prx.leader.Swap(acquired) != acquired {
+			prx.Log.Info("Leadership changed", slog.Bool("leader", acquired))
+		}
+		isSenderLeader.Store(acquired)
+
+		select {
+		case <-close:
+			prx.leader.Store(false)
+			isSenderLeader.Store(false)
+			ctx, cancel := context.WithTimeout(context.Background(), ttl/3)
+			err := lock.Release(ctx)
+			cancel()
+			if err != nil {
+				prx.Log.Warn("Failed to release leader lock", slog.Any("error", err))
+			}
+			return
+		case <-time.After(ttl / 3):
+		}
+	}
+}
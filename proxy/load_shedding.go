@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+)
+
+var (
+	// weight of the latest request in the average builder latency
+	builderLatencyEWMAWeight = 0.1
+	// average is not used for shedding if there were no requests to the builder for this time
+	builderLatencyWindow = time.Second * 10
+)
+
+// builderLatencyTracker keeps moving average of the local builder request latency
+type builderLatencyTracker struct {
+	// requests from peers are rejected when average latency is above threshold, disabled if 0
+	threshold time.Duration
+
+	mu         sync.Mutex
+	average    time.Duration
+	lastSample time.Time
+}
+
+func newBuilderLatencyTracker(threshold time.Duration) *builderLatencyTracker {
+	return &builderLatencyTracker{threshold: threshold}
+}
+
+func (t *builderLatencyTracker) record(latency time.Duration) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.lastSample.IsZero() || time.Since(t.lastSample) > builderLatencyWindow {
+		t.average = latency
+	} else {
+		t.average += time.Duration(builderLatencyEWMAWeight * float64(latency-t.average))
+	}
+	t.lastSample = time.Now()
+	builderLatencyAverage.Set(float64(t.average.Milliseconds()))
+}
+
+// overloaded returns true if the builder is slow and low priority requests should be rejected
+// threshold is scaled by the priority of the request between 0 and 1 so low priority requests are rejected first
+func (t *builderLatencyTracker) overloaded(priority float64) bool {
+	if t == nil || t.threshold <= 0 {
+		return false
+	}
+	threshold := time.Duration(float64(t.threshold) * max(priority, reputationMinScale))
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.average > threshold && time.Since(t.lastSample) <= builderLatencyWindow
+}
+
+// shedRequest returns true if the request should be rejected because the builder is slow
+// only requests from peers are shed, local orderflow, privileged signers and cancellations are always accepted
+// requests of the signers with lower reputation are shed at lower builder latency
+func (prx *ReceiverProxy) shedRequest(req *ParsedRequest) bool {
+	if !req.publicEndpoint || req.ethCancelBundle != nil {
+		return false
+	}
+	if _, privileged := prx.privilegedSignerName(req.signer); privileged {
+		return false
+	}
+	if !prx.builderLatency.overloaded(prx.reputation.Score(req.signer)) {
+		return false
+	}
+	apiLoadShedRequests.Inc()
+	return true
+}
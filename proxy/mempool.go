@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/flashbots/go-utils/rpctypes"
+)
+
+const MempoolPeerName = "mempool"
+
+var (
+	mempoolReconnectDelay = time.Second * 5
+
+	errMempoolSubscriptionClosed = errors.New("newPendingTransactions subscription closed")
+)
+
+// RunMempoolIngestion subscribes to full pending transactions of the node and handles them
+// as local eth_sendRawTransaction requests until close is closed
+func (prx *ReceiverProxy) RunMempoolIngestion(endpoint string, close chan struct{}) {
+	for {
+		err := prx.subscribeMempool(endpoint, close)
+		if err == nil {
+			return
+		}
+		prx.Log.Warn("Mempool subscription failed", slog.Any("error", err))
+		mempoolSubscriptionErrors.Inc()
+		select {
+		case <-close:
+			return
+		case <-time.After(mempoolReconnectDelay):
+		}
+	}
+}
+
+// subscribeMempool returns nil only if close is closed
+func (prx *ReceiverProxy) subscribeMempool(endpoint string, close chan struct{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	client, err := rpc.DialContext(ctx, endpoint)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	txs := make(chan *types.Transaction, 1024)
+	sub, err := client.EthSubscribe(ctx, txs, "newPendingTransactions", true)
+	if err != nil {
+		return err
+	}
+	defer sub.Unsubscribe()
+
+	for {
+		select {
+		case <-close:
+			return nil
+		case err := <-sub.Err():
+			if err == nil {
+				err = errMempoolSubscriptionClosed
+			}
+			return err
+		case tx := <-txs:
+			err := prx.ingestMempoolTransaction(tx)
+			if err != nil {
+				prx.Log.Debug("Mempool transaction was not accepted", slog.Any("error", err))
+				mempoolTransactionErrors.Inc()
+			}
+		}
+	}
+}
+
+// ingestMempoolTransaction validates transaction and sends it to the local builder
+// transactions already seen in the orderflow are dropped by the unique key
+func (prx *ReceiverProxy) ingestMempoolTransaction(tx *types.Transaction) error {
+	rawTx, err := tx.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	ethSendRawTransaction := rpctypes.EthSendRawTransactionArgs(rawTx)
+	err = ValidateEthSendRawTransaction(&ethSendRawTransaction, &prx.TxValidation)
+	if err != nil {
+		return err
+	}
+	uniqueKey := ethSendRawTransaction.UniqueKey()
+	mempoolTransactions.Inc()
+	return prx.HandleParsedRequest(context.Background(), ParsedRequest{
+		ethSendRawTransaction: &ethSendRawTransaction,
+		method:                EthSendRawTransactionMethod,
+		peerName:              MempoolPeerName,
+		requestArgUniqueKey:   &uniqueKey,
+		mempool:               true,
+	})
+}
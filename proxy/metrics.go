@@ -2,8 +2,10 @@ package proxy
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/VictoriaMetrics/metrics"
+	"github.com/ethereum/go-ethereum/common"
 )
 
 var (
@@ -13,21 +15,197 @@ var (
 	archiveEventsRPCSentCounter = metrics.NewCounter("orderflow_proxy_archive_events_sent_ok")
 	archiveEventsRPCDuration    = metrics.NewSummary("orderflow_proxy_archive_rpc_duration_milliseconds")
 	archiveEventsRPCErrors      = metrics.NewCounter("orderflow_proxy_archive_rpc_errors")
+	// events written to disk when archive is down or workers are stalling, drained when archive recovers
+	archiveSpilledEvents      = metrics.NewCounter("orderflow_proxy_archive_spill_events_written")
+	archiveSpillDrainedEvents = metrics.NewCounter("orderflow_proxy_archive_spill_events_drained")
+	archiveSpillDroppedEvents = metrics.NewCounter("orderflow_proxy_archive_spill_events_dropped")
+	archiveSpillBytes         = metrics.NewGauge("orderflow_proxy_archive_spill_bytes", nil)
+	archiveParquetRowsWritten = metrics.NewCounter("orderflow_proxy_archive_parquet_rows_written")
+	// events dropped because they could not be encrypted, they are never archived in plaintext
+	archiveEncryptionErrors = metrics.NewCounter("orderflow_proxy_archive_encryption_errors")
 
 	confighubErrorsCounter = metrics.NewCounter("orderflow_proxy_confighub_errors")
+	// time since the peer list was last fetched successfully
+	confighubLastSuccessAge = metrics.NewGauge("orderflow_proxy_confighub_last_success_age_seconds", confighubLastSuccessAgeSeconds)
+	// number of failed peer list fetches since the last successful one
+	confighubFailuresInARow = metrics.NewGauge("orderflow_proxy_confighub_consecutive_failures", func() float64 {
+		return float64(confighubConsecutiveFailures.Load())
+	})
+	peerListWebhookErrors = metrics.NewCounter("orderflow_proxy_peer_list_webhook_errors")
+
+	signerRotations = metrics.NewCounter("orderflow_proxy_signer_rotations")
+
+	certRenewals      = metrics.NewCounter("orderflow_proxy_cert_renewals")
+	certRenewalErrors = metrics.NewCounter("orderflow_proxy_cert_renewal_errors")
+	// unix time when the served certificate expires
+	certExpiry = metrics.NewGauge("orderflow_proxy_cert_expiry_timestamp_seconds", nil)
+
+	requestRetries    = metrics.NewCounter("orderflow_proxy_request_retries")
+	deadLetterRecords = metrics.NewCounter("orderflow_proxy_dead_letter_records")
+	deadLetterErrors  = metrics.NewCounter("orderflow_proxy_dead_letter_errors")
+
+	builderTimeouts = metrics.NewCounter("orderflow_proxy_builder_timeouts")
+	// raw transaction was sent to the builder as is because it could not be converted to bundle
+	rawTxToBundleErrors = metrics.NewCounter("orderflow_proxy_raw_tx_to_bundle_errors")
+
+	attestationVerificationErrors = metrics.NewCounter("orderflow_proxy_attestation_verification_errors")
+
+	// peer update reuses verified certificate and its connections on cache hit
+	peerCertCacheHits   = metrics.NewCounter("orderflow_proxy_peer_cert_cache_hits")
+	peerCertCacheMisses = metrics.NewCounter("orderflow_proxy_peer_cert_cache_misses")
+	// peer rejected connection because its certificate does not match the one from the config hub
+	peerCertErrors = metrics.NewCounter("orderflow_proxy_peer_cert_errors")
+	// peer certificate does not match fingerprints registered on the config hub, it can be a MITM attempt
+	peerCertFingerprintMismatches = metrics.NewCounter("orderflow_proxy_peer_cert_fingerprint_mismatches")
+
+	// connection to the public listener did not start with valid PROXY protocol header
+	proxyProtocolErrors = metrics.NewCounter("orderflow_proxy_proxy_protocol_errors")
+
+	blockNumberSubscriptionErrors = metrics.NewCounter("orderflow_proxy_block_number_subscription_errors")
+	// block number could not be fetched from any of the endpoints
+	blockNumberUpdateErrors = metrics.NewCounter("orderflow_proxy_block_number_update_errors")
+
+	mempoolTransactions       = metrics.NewCounter("orderflow_proxy_mempool_transactions")
+	mempoolTransactionErrors  = metrics.NewCounter("orderflow_proxy_mempool_transaction_errors")
+	mempoolSubscriptionErrors = metrics.NewCounter("orderflow_proxy_mempool_subscription_errors")
+
+	eventStreamDropped     = metrics.NewCounter("orderflow_proxy_event_stream_dropped")
+	eventStreamSubscribers = metrics.NewGauge("orderflow_proxy_event_stream_subscribers", func() float64 {
+		return float64(activeEventSubscribers.Load())
+	})
+
+	// size of the peer request bodies before and after compression
+	peerCompressionBytesIn  = metrics.NewCounter("orderflow_proxy_peer_compression_bytes_in")
+	peerCompressionBytesOut = metrics.NewCounter("orderflow_proxy_peer_compression_bytes_out")
+
+	// request was handled without claiming it because the shared store failed
+	sharedStoreErrors = metrics.NewCounter("orderflow_proxy_shared_store_errors")
+
+	leaderElectionErrors = metrics.NewCounter("orderflow_proxy_leader_election_errors")
+	senderLeader         = metrics.NewGauge("orderflow_proxy_sender_leader", func() float64 {
+		if isSenderLeader.Load() {
+			return 1
+		}
+		return 0
+	})
+	// requests rejected because the sender proxy is on standby
+	senderStandbyRejections = metrics.NewCounter("orderflow_proxy_sender_standby_rejections")
+	// requests waiting for a share worker of the sender proxy
+	senderQueueDepth = metrics.NewGauge("orderflow_proxy_sender_queue_depth", nil)
+	// requests that the fallback relay failed to accept after all receivers failed
+	senderFallbackRelayErrors = metrics.NewCounter("orderflow_proxy_sender_fallback_relay_errors")
 
 	shareQueueInternalErrors = metrics.NewCounter("orderflow_proxy_share_queue_internal_errors")
 
 	apiLocalRateLimits = metrics.NewCounter("orderflow_proxy_api_local_rate_limits")
+	// requests of the public signers rejected by the rate limit scaled by their reputation
+	apiSignerRateLimits = metrics.NewCounter("orderflow_proxy_api_signer_rate_limits")
+	// cancellations forwarded although the same cancellation was already received
+	apiDuplicateCancellations = metrics.NewCounter("orderflow_proxy_api_duplicate_cancellations")
+	// public requests rejected by the client IP rate limit before the signature was verified
+	apiIPRateLimits = metrics.NewCounter("orderflow_proxy_api_ip_rate_limits")
+	// large mev_sendBundle requests decoded from the body directly
+	apiStreamedBundles = metrics.NewCounter("orderflow_proxy_api_streamed_bundles")
+	// requests rejected because their params have unknown fields or values of wrong type
+	apiStrictDecodingRejections = metrics.NewCounter("orderflow_proxy_api_strict_decoding_rejections")
+	reputationStoreErrors       = metrics.NewCounter("orderflow_proxy_reputation_store_errors")
+	// panics of the JSON-RPC handlers recovered by RecoveryMiddleware
+	httpHandlerPanics = metrics.NewCounter("orderflow_proxy_http_handler_panics")
+	// time of evaluating one WASM policy module for one request
+	wasmPolicyDuration = metrics.NewSummary("orderflow_proxy_wasm_policy_duration_microseconds")
+
+	metricsAuthFailures = metrics.NewCounter("orderflow_proxy_metrics_auth_failures")
+	pprofAuthFailures   = metrics.NewCounter("orderflow_proxy_pprof_auth_failures")
+
+	auditLogEntries = metrics.NewCounter("orderflow_proxy_audit_log_entries")
+	auditLogErrors  = metrics.NewCounter("orderflow_proxy_audit_log_errors")
+	// sequence and first 6 bytes of the hash of the last audit log entry
+	auditLogHeadSequence = metrics.NewGauge("orderflow_proxy_audit_log_head_sequence", nil)
+	auditLogHeadPrefix   = metrics.NewGauge("orderflow_proxy_audit_log_head_prefix", nil)
+	// requests from peers rejected because the local builder is slow
+	apiLoadShedRequests   = metrics.NewCounter("orderflow_proxy_api_load_shed_requests")
+	builderLatencyAverage = metrics.NewGauge("orderflow_proxy_builder_latency_average_milliseconds", nil)
+
+	blocklistSize          = metrics.NewGauge("orderflow_proxy_blocklist_size", nil)
+	blocklistRefreshErrors = metrics.NewCounter("orderflow_proxy_blocklist_refresh_errors")
+
+	apiBlockNumberErrors = metrics.NewCounter("orderflow_proxy_api_block_number_errors")
+
+	apiUnsignedLocalRequests = metrics.NewCounter("orderflow_proxy_api_unsigned_local_requests")
+
+	apiPrivacyPolicyRejections = metrics.NewCounter("orderflow_proxy_api_privacy_policy_rejections")
+	// size of the blob sidecars received, accounted separately from the rest of the transactions
+	apiBlobTxSidecarBytes = metrics.NewCounter("orderflow_proxy_api_blob_tx_sidecar_bytes")
 )
 
 const (
 	apiIncomingRequestsByPeer  = `orderflow_proxy_api_incoming_requests_by_peer{peer="%s"}`
 	apiDuplicateRequestsByPeer = `orderflow_proxy_api_duplicate_requests_by_peer{peer="%s"}`
+	apiBatchSize               = `orderflow_proxy_api_batch_size`
+	apiRequestSizeLabel        = `orderflow_proxy_api_request_size_bytes{method="%s",endpoint="%s"}`
+	apiBundleTxsLabel          = `orderflow_proxy_api_bundle_txs{method="%s",endpoint="%s"}`
+	apiTxValidationRejections  = `orderflow_proxy_api_tx_validation_rejections{reason="%s"}`
+	apiBlockRangeRejections    = `orderflow_proxy_api_block_range_rejections{reason="%s"}`
+	apiSignerReputation        = `orderflow_proxy_api_signer_reputation{signer="%s"}`
+	// transactions of the requests that were not dropped as duplicate requests
+	apiTxsByPeer = `orderflow_proxy_api_txs_by_peer{peer="%s"}`
+	// decisions of the request filters, accept is counted once for requests accepted by all filters
+	apiRequestFilterDecisionsLabel = `orderflow_proxy_api_request_filter_decisions{decision="%s"}`
+	// errors of the WASM policy modules, requests are rejected when policy fails
+	wasmPolicyErrorsLabel = `orderflow_proxy_wasm_policy_errors{policy="%s"}`
+	// requests accepted but not forwarded or archived
+	apiDroppedRequestsLabel = `orderflow_proxy_api_dropped_requests{reason="%s"}`
+	// transactions that were already received in another request, kind is "request" for the same peer and "peer" for other peers
+	apiTxDuplicatesByPeer = `orderflow_proxy_api_tx_duplicates_by_peer{kind="%s",peer="%s"}`
+
+	apiReplayProtectionRejections = `orderflow_proxy_api_replay_protection_rejections{reason="%s"}`
+
+	blocklistMatchesLabel = `orderflow_proxy_blocklist_matches{action="%s"}`
+
+	destinationRateLimitedLabel = `orderflow_proxy_destination_rate_limited{destination="%s"}`
+	apiLocalTenantRequestsLabel = `orderflow_proxy_api_local_tenant_requests{tenant="%s"}`
+	queueOverflowLabel          = `orderflow_proxy_queue_overflow{queue="%s",policy="%s"}`
+	builderRPCErrorsLabel       = `orderflow_proxy_builder_rpc_errors{class="%s",code="%s"}`
+	blockNumberRPCErrorsLabel   = `orderflow_proxy_block_number_rpc_errors{endpoint="%s"}`
+	// time since the cached block number of the source with the primary endpoint last advanced
+	blockNumberHeadAgeLabel        = `orderflow_proxy_block_number_head_age_seconds{endpoint="%s"}`
+	senderRequestsLabel            = `orderflow_proxy_sender_requests{method="%s"}`
+	senderReceiverErrorsLabel      = `orderflow_proxy_sender_receiver_errors{receiver="%s"}`
+	senderReceiverSuccessLabel     = `orderflow_proxy_sender_receiver_success{receiver="%s"}`
+	senderReceiverLastSuccessLabel = `orderflow_proxy_sender_receiver_last_success_timestamp_seconds{receiver="%s"}`
+	senderReceiverRPCDurationLabel = `orderflow_proxy_sender_receiver_rpc_duration_milliseconds{receiver="%s"}`
+	senderFallbackRelayLabel       = `orderflow_proxy_sender_fallback_relay_requests{method="%s"}`
 
 	shareQueuePeerStallingErrorsLabel = `orderflow_proxy_share_queue_peer_stalling_errors{peer="%s"}`
 	shareQueuePeerRPCErrorsLabel      = `orderflow_proxy_share_queue_peer_rpc_errors{peer="%s"}`
-	shareQueuePeerRPCDurationLabel    = `orderflow_proxy_share_queue_peer_rpc_duration_milliseconds{peer="%s"}`
+	shareQueuePeerRPCSuccessLabel     = `orderflow_proxy_share_queue_peer_rpc_success{peer="%s"}`
+	shareQueuePeerQueueDepthLabel     = `orderflow_proxy_share_queue_peer_queue_depth{peer="%s"}`
+	shareQueuePeerStaleDroppedLabel   = `orderflow_proxy_share_queue_peer_stale_dropped{peer="%s"}`
+	// requests evicted from the full queue of the worker by drop-oldest backpressure policy
+	shareQueuePeerEvictedLabel = `orderflow_proxy_share_queue_peer_evicted{peer="%s"}`
+	// requests that were not sent because the deadline of the client request passed while they were queued
+	shareQueuePeerExpiredDroppedLabel = `orderflow_proxy_share_queue_peer_expired_dropped{peer="%s"}`
+	// versions of the bundles with replacement uuid that were replaced before they were sent
+	shareQueuePeerReplacedDroppedLabel = `orderflow_proxy_share_queue_peer_replaced_dropped{peer="%s"}`
+	shareQueuePeerRPCDurationLabel     = `orderflow_proxy_share_queue_peer_rpc_duration_milliseconds{peer="%s"}`
+	shareQueuePeerRPCLatencyLabel      = `orderflow_proxy_share_queue_peer_rpc_latency_milliseconds{peer="%s"}`
+	shareQueuePeerLastSuccessLabel     = `orderflow_proxy_share_queue_peer_last_success_timestamp_seconds{peer="%s"}`
+	shareQueuePeerBatchSizeLabel       = `orderflow_proxy_share_queue_peer_batch_size{peer="%s"}`
+	// time from receiving the request to delivering it, local requests are delivered before requests from peers
+	shareQueueLaneLatencyLabel = `orderflow_proxy_share_queue_lane_latency_milliseconds{lane="%s"}`
+
+	// average latency of the requests to the peer used to order the peers
+	shareQueuePeerLatencyLabel = `orderflow_proxy_share_queue_peer_latency_average_milliseconds{peer="%s"}`
+	// requests queued to the peer from the slow lane
+	shareQueuePeerSlowLaneRequestsLabel = `orderflow_proxy_share_queue_peer_slow_lane_requests{peer="%s"}`
+
+	// canary requests sent to the peers with peer-probe-interval
+	peerProbeRTTLabel          = `orderflow_proxy_peer_probe_rtt_milliseconds{peer="%s"}`
+	peerProbeTLSHandshakeLabel = `orderflow_proxy_peer_probe_tls_handshake_milliseconds{peer="%s"}`
+	peerProbeErrorsLabel       = `orderflow_proxy_peer_probe_errors{peer="%s"}`
+
+	// time from queueing the request to the share or archive queue until a worker takes it, without the RPC time
+	queueWaitLabel = `orderflow_proxy_queue_wait_milliseconds{queue="%s",method="%s"}`
 )
 
 func incAPIIncomingRequestsByPeer(peer string) {
@@ -40,10 +218,160 @@ func incAPIDuplicateRequestsByPeer(peer string) {
 	metrics.GetOrCreateCounter(l).Inc()
 }
 
+func incAPIRequestFilterDecisions(decision Decision) {
+	l := fmt.Sprintf(apiRequestFilterDecisionsLabel, decision)
+	metrics.GetOrCreateCounter(l).Inc()
+}
+
+func incWASMPolicyErrors(policy string) {
+	l := fmt.Sprintf(wasmPolicyErrorsLabel, policy)
+	metrics.GetOrCreateCounter(l).Inc()
+}
+
+func incAPIDroppedRequests(reason string) {
+	l := fmt.Sprintf(apiDroppedRequestsLabel, reason)
+	metrics.GetOrCreateCounter(l).Inc()
+}
+
+func incAPITxs(peer string) {
+	l := fmt.Sprintf(apiTxsByPeer, peer)
+	metrics.GetOrCreateCounter(l).Inc()
+}
+
+func incAPITxDuplicates(kind, peer string) {
+	l := fmt.Sprintf(apiTxDuplicatesByPeer, kind, peer)
+	metrics.GetOrCreateCounter(l).Inc()
+}
+
+func incAPITxValidationRejections(reason string) {
+	l := fmt.Sprintf(apiTxValidationRejections, reason)
+	metrics.GetOrCreateCounter(l).Inc()
+}
+
+func incAPIBlockRangeRejections(reason string) {
+	l := fmt.Sprintf(apiBlockRangeRejections, reason)
+	metrics.GetOrCreateCounter(l).Inc()
+}
+
+func setAPISignerReputation(signer common.Address, score float64) {
+	l := fmt.Sprintf(apiSignerReputation, signer.Hex())
+	metrics.GetOrCreateGauge(l, nil).Set(score)
+}
+
+func setAuditLogHead(head AuditHead) {
+	auditLogHeadSequence.Set(float64(head.Sequence))
+	prefix := uint64(0)
+	for _, b := range head.Hash[:6] {
+		prefix = prefix<<8 | uint64(b)
+	}
+	auditLogHeadPrefix.Set(float64(prefix))
+}
+
+func incAPIReplayProtectionRejections(reason string) {
+	l := fmt.Sprintf(apiReplayProtectionRejections, reason)
+	metrics.GetOrCreateCounter(l).Inc()
+}
+
+func updateAPIBatchSize(size int) {
+	metrics.GetOrCreateHistogram(apiBatchSize).Update(float64(size))
+}
+
+func updateAPIRequestSize(method, endpoint string, size int) {
+	l := fmt.Sprintf(apiRequestSizeLabel, method, endpoint)
+	metrics.GetOrCreateHistogram(l).Update(float64(size))
+}
+
+func updateAPIBundleTxs(method, endpoint string, txs int) {
+	l := fmt.Sprintf(apiBundleTxsLabel, method, endpoint)
+	metrics.GetOrCreateHistogram(l).Update(float64(txs))
+}
+
+func addAPIBlobTxSidecarBytes(size uint64) {
+	apiBlobTxSidecarBytes.Add(int(size))
+}
+
+func incAPIUnsignedLocalRequests() {
+	apiUnsignedLocalRequests.Inc()
+}
+
+func incAPIPrivacyPolicyRejections() {
+	apiPrivacyPolicyRejections.Inc()
+}
+
+func incAPIBlockNumberErrors() {
+	apiBlockNumberErrors.Inc()
+}
+
 func incAPILocalRateLimits() {
 	apiLocalRateLimits.Inc()
 }
 
+func incBlocklistMatches(action string) {
+	l := fmt.Sprintf(blocklistMatchesLabel, action)
+	metrics.GetOrCreateCounter(l).Inc()
+}
+
+func incRetries() {
+	requestRetries.Inc()
+}
+
+func incDestinationRateLimited(destination string) {
+	l := fmt.Sprintf(destinationRateLimitedLabel, destination)
+	metrics.GetOrCreateCounter(l).Inc()
+}
+
+func incAPILocalTenantRequests(tenant string) {
+	l := fmt.Sprintf(apiLocalTenantRequestsLabel, tenant)
+	metrics.GetOrCreateCounter(l).Inc()
+}
+
+func incQueueOverflow(queue, policy string) {
+	l := fmt.Sprintf(queueOverflowLabel, queue, policy)
+	metrics.GetOrCreateCounter(l).Inc()
+}
+
+func incBuilderRPCErrors(class, code string) {
+	l := fmt.Sprintf(builderRPCErrorsLabel, class, code)
+	metrics.GetOrCreateCounter(l).Inc()
+}
+
+func incBlockNumberRPCErrors(endpoint string) {
+	l := fmt.Sprintf(blockNumberRPCErrorsLabel, endpoint)
+	metrics.GetOrCreateCounter(l).Inc()
+}
+
+func registerBlockNumberHeadAge(endpoint string, age func() float64) {
+	l := fmt.Sprintf(blockNumberHeadAgeLabel, endpoint)
+	metrics.GetOrCreateGauge(l, age)
+}
+
+func incSenderRequests(method string) {
+	l := fmt.Sprintf(senderRequestsLabel, method)
+	metrics.GetOrCreateCounter(l).Inc()
+}
+
+func incSenderReceiverSuccess(receiver string) {
+	l := fmt.Sprintf(senderReceiverSuccessLabel, receiver)
+	metrics.GetOrCreateCounter(l).Inc()
+	l = fmt.Sprintf(senderReceiverLastSuccessLabel, receiver)
+	metrics.GetOrCreateGauge(l, nil).Set(float64(time.Now().Unix()))
+}
+
+func timeSenderReceiverRPCDuration(receiver string, duration int64) {
+	l := fmt.Sprintf(senderReceiverRPCDurationLabel, receiver)
+	metrics.GetOrCreateHistogram(l).Update(float64(duration))
+}
+
+func incSenderReceiverErrors(receiver string) {
+	l := fmt.Sprintf(senderReceiverErrorsLabel, receiver)
+	metrics.GetOrCreateCounter(l).Inc()
+}
+
+func incSenderFallbackRelayRequests(method string) {
+	l := fmt.Sprintf(senderFallbackRelayLabel, method)
+	metrics.GetOrCreateCounter(l).Inc()
+}
+
 func incShareQueuePeerStallingErrors(peer string) {
 	l := fmt.Sprintf(shareQueuePeerStallingErrorsLabel, peer)
 	metrics.GetOrCreateCounter(l).Inc()
@@ -54,7 +382,89 @@ func incShareQueuePeerRPCErrors(peer string) {
 	metrics.GetOrCreateCounter(l).Inc()
 }
 
+func incShareQueuePeerRPCSuccess(peer string) {
+	l := fmt.Sprintf(shareQueuePeerRPCSuccessLabel, peer)
+	metrics.GetOrCreateCounter(l).Inc()
+	l = fmt.Sprintf(shareQueuePeerLastSuccessLabel, peer)
+	metrics.GetOrCreateGauge(l, nil).Set(float64(time.Now().Unix()))
+}
+
+func setShareQueuePeerQueueDepth(peer string, depth int) {
+	l := fmt.Sprintf(shareQueuePeerQueueDepthLabel, peer)
+	metrics.GetOrCreateGauge(l, nil).Set(float64(depth))
+}
+
+func incShareQueuePeerStaleDropped(peer string) {
+	l := fmt.Sprintf(shareQueuePeerStaleDroppedLabel, peer)
+	metrics.GetOrCreateCounter(l).Inc()
+}
+
+func incShareQueuePeerEvicted(peer string) {
+	l := fmt.Sprintf(shareQueuePeerEvictedLabel, peer)
+	metrics.GetOrCreateCounter(l).Inc()
+}
+
+func incShareQueuePeerExpiredDropped(peer string) {
+	l := fmt.Sprintf(shareQueuePeerExpiredDroppedLabel, peer)
+	metrics.GetOrCreateCounter(l).Inc()
+}
+
+func incShareQueuePeerReplacedDropped(peer string) {
+	l := fmt.Sprintf(shareQueuePeerReplacedDroppedLabel, peer)
+	metrics.GetOrCreateCounter(l).Inc()
+}
+
 func timeShareQueuePeerRPCDuration(peer string, duration int64) {
 	l := fmt.Sprintf(shareQueuePeerRPCDurationLabel, peer)
 	metrics.GetOrCreateSummary(l).Update(float64(duration))
+	l = fmt.Sprintf(shareQueuePeerRPCLatencyLabel, peer)
+	metrics.GetOrCreateHistogram(l).Update(float64(duration))
+}
+
+func timeShareQueueLaneLatency(lane string, duration int64) {
+	l := fmt.Sprintf(shareQueueLaneLatencyLabel, lane)
+	metrics.GetOrCreateSummary(l).Update(float64(duration))
+}
+
+func setShareQueuePeerLatency(peer string, latency int64) {
+	l := fmt.Sprintf(shareQueuePeerLatencyLabel, peer)
+	metrics.GetOrCreateGauge(l, nil).Set(float64(latency))
+}
+
+func incShareQueuePeerSlowLaneRequests(peer string) {
+	l := fmt.Sprintf(shareQueuePeerSlowLaneRequestsLabel, peer)
+	metrics.GetOrCreateCounter(l).Inc()
+}
+
+func timePeerProbeRTT(peer string, duration int64) {
+	l := fmt.Sprintf(peerProbeRTTLabel, peer)
+	metrics.GetOrCreateHistogram(l).Update(float64(duration))
+}
+
+func timePeerProbeTLSHandshake(peer string, duration int64) {
+	l := fmt.Sprintf(peerProbeTLSHandshakeLabel, peer)
+	metrics.GetOrCreateHistogram(l).Update(float64(duration))
+}
+
+func incPeerProbeErrors(peer string) {
+	l := fmt.Sprintf(peerProbeErrorsLabel, peer)
+	metrics.GetOrCreateCounter(l).Inc()
+}
+
+// timeQueueWait records wait of the request in the queue, requests that were not queued with the time are skipped
+func timeQueueWait(queue, method string, queuedAt time.Time) {
+	if queuedAt.IsZero() {
+		return
+	}
+	l := fmt.Sprintf(queueWaitLabel, queue, method)
+	metrics.GetOrCreateHistogram(l).Update(float64(time.Since(queuedAt).Milliseconds()))
+}
+
+func updateShareQueuePeerBatchSize(peer string, size int) {
+	l := fmt.Sprintf(shareQueuePeerBatchSizeLabel, peer)
+	metrics.GetOrCreateHistogram(l).Update(float64(size))
+}
+
+func setCertExpiry(notAfter time.Time) {
+	certExpiry.Set(float64(notAfter.Unix()))
 }
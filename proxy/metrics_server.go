@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+)
+
+var (
+	errMetricsBasicAuth   = errors.New("metrics basic auth must be in user:password format")
+	errMetricsClientCA    = errors.New("metrics client CA requires metrics TLS certificate and key")
+	errMetricsClientCAPEM = errors.New("no certificates found in metrics client CA file")
+)
+
+// MetricsServerConfig protects the metrics and pprof endpoints when they are reachable beyond localhost
+type MetricsServerConfig struct {
+	// TLSCertFile and TLSKeyFile are optional, if set metrics are served over TLS
+	TLSCertFile string
+	TLSKeyFile  string
+	// ClientCAFile is optional, if set clients must present certificate signed by one of the CAs in the file
+	ClientCAFile string
+	// BasicAuth is optional user:password required from the clients
+	BasicAuth string
+}
+
+func (c *MetricsServerConfig) Validate() error {
+	if c.BasicAuth != "" && !strings.Contains(c.BasicAuth, ":") {
+		return errMetricsBasicAuth
+	}
+	if c.ClientCAFile != "" && (c.TLSCertFile == "" || c.TLSKeyFile == "") {
+		return errMetricsClientCA
+	}
+	return nil
+}
+
+// MTLS returns true if clients must present certificate
+func (c *MetricsServerConfig) MTLS() bool {
+	return c.ClientCAFile != ""
+}
+
+// Handler requires basic auth for the next handler if it is configured
+func (c *MetricsServerConfig) Handler(next http.Handler) http.Handler {
+	if c.BasicAuth == "" {
+		return next
+	}
+	expectedUser, expectedPassword, _ := strings.Cut(c.BasicAuth, ":")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		userMatch := subtle.ConstantTimeCompare([]byte(user), []byte(expectedUser)) == 1
+		passwordMatch := subtle.ConstantTimeCompare([]byte(password), []byte(expectedPassword)) == 1
+		if !ok || !userMatch || !passwordMatch {
+			metricsAuthFailures.Inc()
+			w.Header().Set("WWW-Authenticate", `Basic realm="metrics"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// TLSConfig returns config of the metrics server, nil if TLS is not configured
+func (c *MetricsServerConfig) TLSConfig() (*tls.Config, error) {
+	if c.TLSCertFile == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.TLSCertFile, c.TLSKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if c.ClientCAFile != "" {
+		caPEM, err := os.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		clientCAs := x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(caPEM) {
+			return nil, errMetricsClientCAPEM
+		}
+		tlsConfig.ClientCAs = clientCAs
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
+// ListenAndServe serves the handler of the server with configured auth and TLS
+func (c *MetricsServerConfig) ListenAndServe(server *http.Server) error {
+	err := c.Validate()
+	if err != nil {
+		return err
+	}
+	server.Handler = c.Handler(server.Handler)
+	server.TLSConfig, err = c.TLSConfig()
+	if err != nil {
+		return err
+	}
+	if server.TLSConfig != nil {
+		return server.ListenAndServeTLS("", "")
+	}
+	return server.ListenAndServe()
+}
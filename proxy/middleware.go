@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
+)
+
+// Middleware wraps HTTP handler of the JSON-RPC server, e.g. to add authentication, logging or rate limiting
+type Middleware func(next http.Handler) http.Handler
+
+// chainMiddlewares wraps handler with the middlewares, the first one is the outermost and sees the request first
+func chainMiddlewares(handler http.Handler, middlewares ...Middleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// RecoveryMiddleware returns JSON-RPC error instead of dropping the connection when the handler panics
+func RecoveryMiddleware(log *slog.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				recovered := recover()
+				if recovered == nil {
+					return
+				}
+				// http.ErrAbortHandler is used to abort the response on purpose
+				if recovered == http.ErrAbortHandler { //nolint:errorlint
+					panic(recovered)
+				}
+				httpHandlerPanics.Inc()
+				log.Error("HTTP handler panicked", slog.Any("panic", recovered), slog.String("stack", string(debug.Stack())))
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusInternalServerError)
+				writeJSONRPCError(w, "internal error")
+			}()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// AccessLogMiddleware logs every request with the response status and the time it took
+func AccessLogMiddleware(log *slog.Logger, server string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			sw := &statusResponseWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(sw, r)
+			log.Info("HTTP request",
+				slog.String("server", server),
+				slog.String("remoteAddr", r.RemoteAddr),
+				slog.String("path", r.URL.Path),
+				slog.Int("status", sw.status),
+				slog.Int64("durationMs", time.Since(start).Milliseconds()),
+			)
+		})
+	}
+}
+
+// statusResponseWriter records the status of the response
+type statusResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusResponseWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusResponseWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the original writer
+func (w *statusResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,195 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// DefaultPeerCertCacheTTL is the time after which the peer certificate is verified again
+	DefaultPeerCertCacheTTL = time.Hour
+	// PeerCertRefreshInterval is the minimal time between peer list fetches caused by certificate errors
+	PeerCertRefreshInterval = time.Second * 5
+
+	errPeerCertFingerprint = errors.New("peer certificate does not match fingerprints registered on the config hub")
+)
+
+// peerCertKey identifies certificate of the peer, peer gets a new key when it registers a new certificate
+type peerCertKey struct {
+	peer        string
+	fingerprint string
+}
+
+// newPeerCertKey uses hash of the whole PEM because it has two certificates while the peer renews its certificate
+func newPeerCertKey(peer string, credentials ConfighubOrderflowProxyCredentials) peerCertKey {
+	hash := sha256.New()
+	hash.Write([]byte(credentials.TLSCert))
+	for _, fingerprint := range credentials.TLSCertFingerprints {
+		hash.Write([]byte(fingerprint))
+	}
+	return peerCertKey{peer: peer, fingerprint: hex.EncodeToString(hash.Sum(nil))}
+}
+
+type peerCertEntry struct {
+	transport *http.Transport
+	expiresAt time.Time
+}
+
+// peerCertCache keeps transports pinned to the verified peer certificates, peers that are still present after
+// the peer list update keep their transport and its open connections
+type peerCertCache struct {
+	ttl time.Duration
+
+	mu          sync.Mutex
+	entries     map[peerCertKey]*peerCertEntry
+	lastRefresh time.Time
+}
+
+func newPeerCertCache(ttl time.Duration) *peerCertCache {
+	if ttl <= 0 {
+		ttl = DefaultPeerCertCacheTTL
+	}
+	return &peerCertCache{
+		ttl:     ttl,
+		entries: make(map[peerCertKey]*peerCertEntry),
+	}
+}
+
+// get returns transport of the certificate if it was verified within ttl
+func (c *peerCertCache) get(key peerCertKey) (*http.Transport, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.expiresAt) {
+		peerCertCacheMisses.Inc()
+		return nil, false
+	}
+	peerCertCacheHits.Inc()
+	return entry.transport, true
+}
+
+// put stores transport of the verified certificate and removes expired entries
+func (c *peerCertCache) put(key peerCertKey, transport *http.Transport) {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, entry := range c.entries {
+		if now.After(entry.expiresAt) {
+			delete(c.entries, key)
+		}
+	}
+	c.entries[key] = &peerCertEntry{transport: transport, expiresAt: now.Add(c.ttl)}
+}
+
+// invalidate removes certificates of the peer so they are verified again on the next peer list update
+func (c *peerCertCache) invalidate(peer string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, entry := range c.entries {
+		if key.peer == peer {
+			entry.transport.CloseIdleConnections()
+			delete(c.entries, key)
+		}
+	}
+}
+
+// shouldRefresh returns true at most once per PeerCertRefreshInterval
+func (c *peerCertCache) shouldRefresh() bool {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now.Sub(c.lastRefresh) < PeerCertRefreshInterval {
+		return false
+	}
+	c.lastRefresh = now
+	return true
+}
+
+// isCertificateError is true if TLS connection failed because certificate of the peer does not match the pinned one
+// or its registered fingerprints
+func isCertificateError(err error) bool {
+	var (
+		verificationErr     *tls.CertificateVerificationError
+		unknownAuthorityErr x509.UnknownAuthorityError
+		hostnameErr         x509.HostnameError
+		invalidErr          x509.CertificateInvalidError
+	)
+	return errors.As(err, &verificationErr) || errors.As(err, &unknownAuthorityErr) ||
+		errors.As(err, &hostnameErr) || errors.As(err, &invalidErr) || errors.Is(err, errPeerCertFingerprint)
+}
+
+func normalizeCertFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.ReplaceAll(fingerprint, ":", ""))
+}
+
+func certFingerprintSet(fingerprints []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(fingerprints))
+	for _, fingerprint := range fingerprints {
+		set[normalizeCertFingerprint(fingerprint)] = struct{}{}
+	}
+	return set
+}
+
+// verifyCertFingerprints checks that all certificates of TLSCert have registered fingerprints,
+// there is nothing to check if the config hub does not publish fingerprints
+func (c *ConfighubOrderflowProxyCredentials) verifyCertFingerprints() error {
+	if len(c.TLSCertFingerprints) == 0 {
+		return nil
+	}
+	fingerprints := certFingerprintSet(c.TLSCertFingerprints)
+	rest := []byte(c.TLSCert)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return nil
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return err
+		}
+		if _, ok := fingerprints[CertFingerprint(cert)]; !ok {
+			return errPeerCertFingerprint
+		}
+	}
+}
+
+// peerTransport returns transport that trusts only the peer certificate from the config hub,
+// certificate presented by the peer must also have one of the registered fingerprints if they are published
+func peerTransport(info ConfighubBuilder, maxOpenConnections int) (*http.Transport, error) {
+	err := info.OrderflowProxy.verifyCertFingerprints()
+	if err != nil {
+		peerCertFingerprintMismatches.Inc()
+		return nil, err
+	}
+	transport, err := transportWithCert([]byte(info.OrderflowProxy.TLSCert), maxOpenConnections)
+	if err != nil {
+		return nil, err
+	}
+	if len(info.OrderflowProxy.TLSCertFingerprints) == 0 {
+		return transport, nil
+	}
+	fingerprints := certFingerprintSet(info.OrderflowProxy.TLSCertFingerprints)
+	transport.TLSClientConfig.VerifyConnection = func(state tls.ConnectionState) error {
+		if len(state.PeerCertificates) > 0 {
+			if _, ok := fingerprints[CertFingerprint(state.PeerCertificates[0])]; ok {
+				return nil
+			}
+		}
+		peerCertFingerprintMismatches.Inc()
+		return fmt.Errorf("%w: %s", errPeerCertFingerprint, info.Name)
+	}
+	return transport, nil
+}
@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"time"
+)
+
+var (
+	// peers are sorted by their average latency with this interval
+	peerOrderInterval = time.Second
+	// max number of requests waiting for the slow peers before they are delivered even if fast peers have more requests
+	slowLaneMaxPending = 256
+)
+
+// peerLatency keeps moving average of the latency of the requests to the peer, weighted as builder latency
+type peerLatency struct {
+	mu         sync.Mutex
+	average    time.Duration
+	lastSample time.Time
+}
+
+func (l *peerLatency) record(latency time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.lastSample.IsZero() || time.Since(l.lastSample) > builderLatencyWindow {
+		l.average = latency
+	} else {
+		l.average += time.Duration(builderLatencyEWMAWeight * float64(latency-l.average))
+	}
+	l.lastSample = time.Now()
+}
+
+// get returns average latency, it is 0 if there were no requests to the peer for builderLatencyWindow
+func (l *peerLatency) get() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if time.Since(l.lastSample) > builderLatencyWindow {
+		return 0
+	}
+	return l.average
+}
+
+// orderPeers sorts peers so requests are queued to the fastest peers first
+// and moves peers with latency above the threshold to the slow lane
+func (sq *ShareQueue) orderPeers(peers []*shareQueuePeer) {
+	latencies := make(map[*shareQueuePeer]time.Duration, len(peers))
+	for _, peer := range peers {
+		latency := peer.latency.get()
+		latencies[peer] = latency
+		setShareQueuePeerLatency(peer.name, latency.Milliseconds())
+		peer.slow = sq.slowPeerLatency > 0 && latency > sq.slowPeerLatency
+	}
+	slices.SortStableFunc(peers, func(a, b *shareQueuePeer) int {
+		return cmp.Compare(latencies[a], latencies[b])
+	})
+}
+
+// slowDelivery is a request waiting in the slow lane for the peers that were slow when it was dispatched
+type slowDelivery struct {
+	req   *ParsedRequest
+	peers []*shareQueuePeer
+}
+
+// deliverSlowLane queues pending requests to the slow peers, it must be called before the peers are closed
+func (sq *ShareQueue) deliverSlowLane(pending []slowDelivery) []slowDelivery {
+	for _, delivery := range pending {
+		for _, peer := range delivery.peers {
+			peer.SendRequest(sq.log, delivery.req)
+			incShareQueuePeerSlowLaneRequests(peer.name)
+		}
+	}
+	clear(pending)
+	return pending[:0]
+}
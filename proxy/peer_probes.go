@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"net/http"
+	"net/http/httptrace"
+	"sync/atomic"
+	"time"
+
+	"github.com/flashbots/go-utils/rpcclient"
+)
+
+// peerProbeClient returns client for the canary probes of the peer, it doesn't reuse connections
+// so every probe measures the TLS handshake as a new connection to the peer would
+func (sq *ShareQueue) peerProbeClient(url string, transport *http.Transport) rpcclient.RPCClient {
+	probeTransport := transport.Clone()
+	probeTransport.DisableKeepAlives = true
+	return rpcClientWithTransportAndSigner(url, probeTransport, sq.peerSigner, nil)
+}
+
+// probePeers sends canary request to every peer that is not probed already
+func (sq *ShareQueue) probePeers(peers []*shareQueuePeer) {
+	for _, peer := range peers {
+		if peer.probeClient == nil || !peer.probing.CompareAndSwap(false, true) {
+			continue
+		}
+		go func() {
+			defer peer.probing.Store(false)
+			sq.probePeer(peer)
+		}()
+	}
+}
+
+// probePeer calls proxy_version of the peer and records round trip and TLS handshake time
+func (sq *ShareQueue) probePeer(peer *shareQueuePeer) {
+	var (
+		handshakeStart time.Time
+		handshake      atomic.Int64
+	)
+	trace := &httptrace.ClientTrace{
+		TLSHandshakeStart: func() {
+			handshakeStart = time.Now()
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			if err == nil {
+				handshake.Store(int64(time.Since(handshakeStart)))
+			}
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), peer.timeout)
+	defer cancel()
+	start := time.Now()
+	resp, err := peer.probeClient.Call(httptrace.WithClientTrace(ctx, trace), ProxyVersionMethod)
+	rtt := time.Since(start)
+	if err == nil && resp != nil && resp.Error != nil {
+		err = resp.Error
+	}
+	if err != nil {
+		sq.log.Warn("Peer probe failed", slog.String("peer", peer.name), slog.Any("error", err))
+		incPeerProbeErrors(peer.name)
+		return
+	}
+	timePeerProbeRTT(peer.name, rtt.Milliseconds())
+	peer.latency.record(rtt)
+	if handshake := time.Duration(handshake.Load()); handshake > 0 {
+		timePeerProbeTLSHandshake(peer.name, handshake.Milliseconds())
+	}
+}
@@ -0,0 +1,51 @@
+package proxy
+
+import (
+	"errors"
+	"hash/fnv"
+	"strconv"
+)
+
+var errInvalidPeerShard = errors.New("peer shard index must be less than shard count")
+
+// PeerShard selects the subset of peers this instance replicates orderflow to when fan-out
+// is split between several receiver instances that all receive the same local orderflow
+// peers are assigned to shards with rendezvous hashing of the peer name so changing the number
+// of shards moves only the peers of the added or removed shard
+type PeerShard struct {
+	Index int
+	// Count is the number of instances the peers are split between, sharding is disabled if <= 1
+	Count int
+}
+
+func (s PeerShard) Validate() error {
+	if s.Count > 1 && (s.Index < 0 || s.Index >= s.Count) {
+		return errInvalidPeerShard
+	}
+	return nil
+}
+
+// Owns returns true if the peer is replicated to by this shard
+func (s PeerShard) Owns(peerName string) bool {
+	if s.Count <= 1 {
+		return true
+	}
+	return peerShardIndex(peerName, s.Count) == s.Index
+}
+
+func peerShardIndex(peerName string, count int) int {
+	var (
+		owner     int
+		maxWeight uint64
+	)
+	for shard := range count {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(strconv.Itoa(shard)))
+		_, _ = h.Write([]byte{0})
+		_, _ = h.Write([]byte(peerName))
+		if weight := h.Sum64(); shard == 0 || weight > maxWeight {
+			owner, maxWeight = shard, weight
+		}
+	}
+	return owner
+}
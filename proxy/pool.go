@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// MaxPooledBufferBytes is the capacity of the largest buffer returned to the pool,
+// buffers of the rare huge requests are left to GC so the pool doesn't pin their memory
+var MaxPooledBufferBytes = 1024 * 1024
+
+var bufferPool = sync.Pool{
+	New: func() any {
+		return new(bytes.Buffer)
+	},
+}
+
+func getBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer) //nolint:forcetypeassert
+}
+
+// putBuffer returns buffer to the pool, its bytes must not be used after that
+func putBuffer(buf *bytes.Buffer) {
+	if buf == nil || buf.Cap() > MaxPooledBufferBytes {
+		return
+	}
+	buf.Reset()
+	bufferPool.Put(buf)
+}
+
+// readPooled reads body to the pooled buffer, sizeHint is used to allocate the buffer once when the size is known
+// caller must return the buffer with putBuffer when the body is no longer used
+func readPooled(body io.Reader, sizeHint int64) (*bytes.Buffer, error) {
+	buf := getBuffer()
+	if sizeHint > 0 && sizeHint <= int64(MaxPooledBufferBytes) {
+		// one extra byte so ReadFrom sees EOF without growing the buffer
+		buf.Grow(int(sizeHint) + 1)
+	}
+	_, err := buf.ReadFrom(body)
+	if err != nil {
+		putBuffer(buf)
+		return nil, err
+	}
+	return buf, nil
+}
+
+// marshalPooled marshals v to the pooled buffer in the same way as json.Marshal but with trailing newline
+func marshalPooled(v any) (*bytes.Buffer, error) {
+	buf := getBuffer()
+	err := json.NewEncoder(buf).Encode(v)
+	if err != nil {
+		putBuffer(buf)
+		return nil, err
+	}
+	return buf, nil
+}
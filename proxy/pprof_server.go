@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"strconv"
+	"sync/atomic"
+)
+
+// PprofAdminPath is the path of the endpoint that enables and disables profiling at runtime
+const PprofAdminPath = "/admin/pprof"
+
+// PprofServer serves pprof on a dedicated listener so it is never exposed together with the metrics
+// requests must have the bearer token, all requests are rejected if it is empty,
+// profiling can be enabled and disabled at runtime
+type PprofServer struct {
+	token   string
+	enabled atomic.Bool
+	mux     *http.ServeMux
+}
+
+func NewPprofServer(token string, enabled bool) *PprofServer {
+	s := &PprofServer{
+		token: token,
+		mux:   http.NewServeMux(),
+	}
+	s.enabled.Store(enabled)
+	s.mux.Handle("/debug/pprof/", http.HandlerFunc(pprof.Index))
+	s.mux.Handle("/debug/pprof/cmdline", http.HandlerFunc(pprof.Cmdline))
+	s.mux.Handle("/debug/pprof/profile", http.HandlerFunc(pprof.Profile))
+	s.mux.Handle("/debug/pprof/symbol", http.HandlerFunc(pprof.Symbol))
+	s.mux.Handle("/debug/pprof/trace", http.HandlerFunc(pprof.Trace))
+	return s
+}
+
+func (s *PprofServer) Enabled() bool {
+	return s.enabled.Load()
+}
+
+func (s *PprofServer) authorized(r *http.Request) bool {
+	if s.token == "" {
+		return false
+	}
+	expected := []byte("Bearer " + s.token)
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) == 1
+}
+
+// Handler serves pprof endpoints, they return not found while profiling is disabled
+func (s *PprofServer) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.authorized(r) {
+			pprofAuthFailures.Inc()
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !s.Enabled() {
+			http.NotFound(w, r)
+			return
+		}
+		s.mux.ServeHTTP(w, r)
+	})
+}
+
+// AdminHandler returns profiling state on GET and sets it on POST with enabled=true|false query parameter
+func (s *PprofServer) AdminHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.authorized(r) {
+			pprofAuthFailures.Inc()
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			enabled, err := strconv.ParseBool(r.URL.Query().Get("enabled"))
+			if err != nil {
+				http.Error(w, "enabled must be true or false", http.StatusBadRequest)
+				return
+			}
+			s.enabled.Store(enabled)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]bool{"enabled": s.Enabled()})
+	})
+}
@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"sync"
+	"time"
+)
+
+var (
+	// ProxyProtocolHeaderTimeout is the time to receive PROXY protocol header after the connection is accepted
+	ProxyProtocolHeaderTimeout = time.Second * 5
+
+	proxyProtocolV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+	errProxyProtocolSignature = errors.New("connection does not start with PROXY protocol v2 header")
+	errProxyProtocolHeader    = errors.New("invalid PROXY protocol v2 header")
+)
+
+const (
+	proxyProtocolCommandLocal = 0x0
+	proxyProtocolCommandProxy = 0x1
+
+	proxyProtocolFamilyUnspec = 0x00
+	proxyProtocolFamilyTCP4   = 0x11
+	proxyProtocolFamilyTCP6   = 0x21
+)
+
+// ProxyProtocolListener accepts connections from TCP load balancer that sends PROXY protocol v2 header,
+// remote address of the connections is the address of the client from the header
+// header is read by the first Read or RemoteAddr call so slow clients don't block Accept
+type ProxyProtocolListener struct {
+	net.Listener
+}
+
+func (l *ProxyProtocolListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyProtocolConn{Conn: conn}, nil
+}
+
+type proxyProtocolConn struct {
+	net.Conn
+
+	once       sync.Once
+	remoteAddr net.Addr
+	err        error
+}
+
+func (c *proxyProtocolConn) readHeader() {
+	c.once.Do(func() {
+		_ = c.Conn.SetReadDeadline(time.Now().Add(ProxyProtocolHeaderTimeout))
+		c.remoteAddr, c.err = readProxyProtocolHeader(c.Conn)
+		_ = c.Conn.SetReadDeadline(time.Time{})
+		if c.err != nil {
+			proxyProtocolErrors.Inc()
+		}
+	})
+}
+
+func (c *proxyProtocolConn) Read(b []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.Conn.Read(b)
+}
+
+// RemoteAddr returns address of the client, address of the load balancer is returned for its own connections
+func (c *proxyProtocolConn) RemoteAddr() net.Addr {
+	c.readHeader()
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// readProxyProtocolHeader returns source address from the header, nil if the load balancer doesn't know it
+func readProxyProtocolHeader(r io.Reader) (net.Addr, error) {
+	var header [16]byte
+	_, err := io.ReadFull(r, header[:])
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header[:12], proxyProtocolV2Signature) {
+		return nil, errProxyProtocolSignature
+	}
+	versionCommand, family := header[12], header[13]
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	_, err = io.ReadFull(r, payload)
+	if err != nil {
+		return nil, err
+	}
+	if versionCommand>>4 != 2 {
+		return nil, errProxyProtocolHeader
+	}
+	switch versionCommand & 0xf {
+	case proxyProtocolCommandLocal:
+		// health checks of the load balancer
+		return nil, nil
+	case proxyProtocolCommandProxy:
+	default:
+		return nil, errProxyProtocolHeader
+	}
+	// TLVs after the addresses are ignored
+	switch family {
+	case proxyProtocolFamilyTCP4:
+		if len(payload) < 12 {
+			return nil, errProxyProtocolHeader
+		}
+		return &net.TCPAddr{IP: net.IP(slices.Clone(payload[0:4])), Port: int(binary.BigEndian.Uint16(payload[8:10]))}, nil
+	case proxyProtocolFamilyTCP6:
+		if len(payload) < 36 {
+			return nil, errProxyProtocolHeader
+		}
+		return &net.TCPAddr{IP: net.IP(slices.Clone(payload[0:16])), Port: int(binary.BigEndian.Uint16(payload[32:34]))}, nil
+	case proxyProtocolFamilyUnspec:
+		return nil, nil
+	}
+	return nil, errProxyProtocolHeader
+}
@@ -2,8 +2,12 @@ package proxy
 
 import (
 	"context"
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"log/slog"
+	"net/http"
+	"strings"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -22,29 +26,85 @@ const (
 	EthCancelBundleMethod       = "eth_cancelBundle"
 	EthSendRawTransactionMethod = "eth_sendRawTransaction"
 	BidSubsidiseBlockMethod     = "bid_subsidiseBlock"
+	ProxyVersionMethod          = "proxy_version"
+	GetAuditHeadMethod          = "orderflow_getAuditHead"
+	GetStatsMethod              = "orderflow_getStats"
 )
 
 var (
 	errUnknownPeer          = errors.New("unknown peers can't send to the public address")
 	errSubsidyWrongEndpoint = errors.New("subsidy can only be called on public method")
-	errSubsidyWrongCaller   = errors.New("subsidy can only be called by Flashbots")
+	errSubsidyWrongCaller   = errors.New("subsidy can only be called by privileged signers")
 	errRateLimiting         = errors.New("requests to local API are rate limited")
 
 	errUUIDParse = errors.New("failed to parse UUID")
 
+	errUnknownMethod = errors.New("unknown method")
+
 	apiNow = time.Now
 
 	handleParsedRequestTimeout = time.Second * 1
 )
 
-func (prx *ReceiverProxy) PublicJSONRPCHandler(maxRequestBodySizeBytes int64) (*rpcserver.JSONRPCHandler, error) {
-	handler, err := rpcserver.NewJSONRPCHandler(rpcserver.Methods{
+func (prx *ReceiverProxy) publicMethods() rpcserver.Methods {
+	return streamedBundles(recordRejections(recordRequestSizes(configureMethods(rpcserver.Methods{
 		EthSendBundleMethod:         prx.EthSendBundlePublic,
 		MevSendBundleMethod:         prx.MevSendBundlePublic,
 		EthCancelBundleMethod:       prx.EthCancelBundlePublic,
 		EthSendRawTransactionMethod: prx.EthSendRawTransactionPublic,
 		BidSubsidiseBlockMethod:     prx.BidSubsidiseBlockPublic,
-	},
+		ProxyVersionMethod:          prx.ProxyVersion,
+	}, prx.DisabledPublicMethods, prx.MethodAliases), endpointName(true)), prx.rejections, endpointName(true)))
+}
+
+func (prx *ReceiverProxy) localMethods() rpcserver.Methods {
+	return recordRejections(recordRequestSizes(configureMethods(rpcserver.Methods{
+		EthSendBundleMethod:         prx.EthSendBundleLocal,
+		MevSendBundleMethod:         prx.MevSendBundleLocal,
+		EthCancelBundleMethod:       prx.EthCancelBundleLocal,
+		EthSendRawTransactionMethod: prx.EthSendRawTransactionLocal,
+		BidSubsidiseBlockMethod:     prx.BidSubsidiseBlockLocal,
+		ProxyVersionMethod:          prx.ProxyVersion,
+		GetAuditHeadMethod:          prx.GetAuditHead,
+		GetStatsMethod:              prx.GetStats,
+	}, prx.DisabledLocalMethods, prx.MethodAliases), endpointName(false)), prx.rejections, endpointName(false))
+}
+
+// configureMethods removes disabled methods so calls to them return method not found
+// and routes aliases to the handlers of the methods, aliases of disabled methods are not served
+func configureMethods(methods rpcserver.Methods, disabled []string, aliases map[string]string) rpcserver.Methods {
+	for _, method := range disabled {
+		delete(methods, method)
+	}
+	for alias, method := range aliases {
+		if handler, ok := methods[method]; ok {
+			methods[alias] = handler
+		}
+	}
+	return recordMethodErrors(methods)
+}
+
+// validateMethods returns error if any of the disabled or aliased methods is not served by the proxy
+// or if alias shadows one of the methods
+func validateMethods(methods rpcserver.Methods, disabled []string, aliases map[string]string) error {
+	for _, method := range disabled {
+		if _, ok := methods[method]; !ok {
+			return fmt.Errorf("%w: %s", errUnknownMethod, method)
+		}
+	}
+	for alias, method := range aliases {
+		if _, ok := methods[method]; !ok {
+			return fmt.Errorf("%w: %s", errUnknownMethod, method)
+		}
+		if _, ok := methods[alias]; ok {
+			return fmt.Errorf("%w: %s", errMethodAlias, alias)
+		}
+	}
+	return nil
+}
+
+func (prx *ReceiverProxy) PublicJSONRPCHandler(maxRequestBodySizeBytes int64) (*rpcserver.JSONRPCHandler, error) {
+	handler, err := rpcserver.NewJSONRPCHandler(prx.publicMethods(),
 		rpcserver.JSONRPCHandlerOpts{
 			ServerName:                       "public_server",
 			Log:                              prx.Log,
@@ -56,36 +116,65 @@ func (prx *ReceiverProxy) PublicJSONRPCHandler(maxRequestBodySizeBytes int64) (*
 	return handler, err
 }
 
-func (prx *ReceiverProxy) LocalJSONRPCHandler(maxRequestBodySizeBytes int64) (*rpcserver.JSONRPCHandler, error) {
-	handler, err := rpcserver.NewJSONRPCHandler(rpcserver.Methods{
-		EthSendBundleMethod:         prx.EthSendBundleLocal,
-		MevSendBundleMethod:         prx.MevSendBundleLocal,
-		EthCancelBundleMethod:       prx.EthCancelBundleLocal,
-		EthSendRawTransactionMethod: prx.EthSendRawTransactionLocal,
-		BidSubsidiseBlockMethod:     prx.BidSubsidiseBlockLocal,
-	},
+// publicBatchElementHandler handles elements of the batches received on the public endpoint
+// signature of the batch is verified before so signer is only extracted from the header
+func (prx *ReceiverProxy) publicBatchElementHandler(maxRequestBodySizeBytes int64) (*rpcserver.JSONRPCHandler, error) {
+	return rpcserver.NewJSONRPCHandler(prx.publicMethods(),
+		rpcserver.JSONRPCHandlerOpts{
+			ServerName:              "public_server",
+			Log:                     prx.Log,
+			MaxRequestBodySizeBytes: maxRequestBodySizeBytes,
+			ExtractUnverifiedRequestSignatureFromHeader: true,
+		},
+	)
+}
+
+func (prx *ReceiverProxy) LocalJSONRPCHandler(maxRequestBodySizeBytes int64) (http.Handler, error) {
+	// unsigned requests are allowed so signature is verified before the request reaches rpc handler
+	allowUnsigned := prx.SignaturePolicy.AllowUnsignedLocal
+	handler, err := rpcserver.NewJSONRPCHandler(prx.localMethods(),
 		rpcserver.JSONRPCHandlerOpts{
 			ServerName:                       "local_server",
 			Log:                              prx.Log,
 			MaxRequestBodySizeBytes:          maxRequestBodySizeBytes,
-			VerifyRequestSignatureFromHeader: true,
+			VerifyRequestSignatureFromHeader: !allowUnsigned,
+			ExtractUnverifiedRequestSignatureFromHeader: allowUnsigned,
 		},
 	)
+	if err != nil {
+		return nil, err
+	}
+	localHandler := rpcErrorHandler(requestSizeHandler(handler))
+	if prx.StrictJSONDecoding {
+		localHandler = strictParamsHandler(localHandler, prx.localMethods(), maxRequestBodySizeBytes)
+	}
+	if allowUnsigned {
+		return optionalSignatureHandler(localHandler, maxRequestBodySizeBytes), nil
+	}
+	return localHandler, nil
+}
 
-	return handler, err
+// privilegedSignerName returns name of the privileged signer, Flashbots signer is always privileged
+func (prx *ReceiverProxy) privilegedSignerName(signer common.Address) (string, bool) {
+	if signer == prx.FlashbotsSignerAddress {
+		return FlashbotsPeerName, true
+	}
+	name, ok := prx.PrivilegedSigners[signer]
+	return name, ok
 }
 
 func (prx *ReceiverProxy) ValidateSigner(ctx context.Context, req *ParsedRequest, publicEndpoint bool) error {
 	req.signer = rpcserver.GetSigner(ctx)
 	if !publicEndpoint {
 		req.peerName = "local-request"
+		req.tenant = tenantFromContext(ctx)
 		return nil
 	}
 
 	prx.Log.Debug("Received signed request on a public endpoint", slog.Any("signer", req.signer))
 
-	if req.signer == prx.FlashbotsSignerAddress {
-		req.peerName = FlashbotsPeerName
+	if name, ok := prx.privilegedSignerName(req.signer); ok {
+		req.peerName = name
 		return nil
 	}
 
@@ -101,13 +190,44 @@ func (prx *ReceiverProxy) ValidateSigner(ctx context.Context, req *ParsedRequest
 		}
 	}
 	if !found {
-		return errUnknownPeer
+		previous, ok := prx.previousPeerAddresses[req.signer]
+		if !ok || apiNow().After(previous.expiresAt) {
+			return errUnknownPeer
+		}
+		peerName = previous.name
 	}
 	req.peerName = peerName
 	return nil
 }
 
-func (prx *ReceiverProxy) EthSendBundle(ctx context.Context, ethSendBundle rpctypes.EthSendBundleArgs, publicEndpoint bool) error {
+// validateBlockRange checks target blocks of the bundle against the current head
+// if the head is not available we let the request through
+func (prx *ReceiverProxy) validateBlockRange(blockNumber, maxBlock uint64) error {
+	err := ValidateBlockSpan(blockNumber, maxBlock, prx.BlockRange)
+	if err != nil {
+		incAPIBlockRangeRejections("span")
+		return err
+	}
+	if !prx.BlockRange.Enabled() {
+		return nil
+	}
+	// cached head is used so a slow RPC node doesn't delay the requests, stale cache is refreshed in the background
+	head, ok := prx.blockNumberSource.CachedBlockNumber()
+	if !ok {
+		prx.Log.Warn("Block number is not available for block range validation")
+		incAPIBlockNumberErrors()
+		return nil
+	}
+	err = ValidateBlockRange(blockNumber, maxBlock, head, prx.BlockRange)
+	if errors.Is(err, errBlockRangePast) {
+		incAPIBlockRangeRejections("past")
+	} else if errors.Is(err, errBlockRangeFuture) {
+		incAPIBlockRangeRejections("future")
+	}
+	return err
+}
+
+func (prx *ReceiverProxy) EthSendBundle(ctx context.Context, ethSendBundle rpctypes.EthSendBundleArgs, publicEndpoint bool) (*EthSendBundleResponse, error) {
 	parsedRequest := ParsedRequest{
 		publicEndpoint: publicEndpoint,
 		ethSendBundle:  &ethSendBundle,
@@ -116,33 +236,68 @@ func (prx *ReceiverProxy) EthSendBundle(ctx context.Context, ethSendBundle rpcty
 
 	err := prx.ValidateSigner(ctx, &parsedRequest, publicEndpoint)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	err = ValidateEthSendBundle(&ethSendBundle, publicEndpoint)
+	err = ValidateEthSendBundle(&ethSendBundle, publicEndpoint, &prx.TxValidation)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	if ethSendBundle.BlockNumber > 0 {
+		err = prx.validateBlockRange(uint64(ethSendBundle.BlockNumber), 0)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	if !publicEndpoint {
 		ethSendBundle.SigningAddress = &parsedRequest.signer
 	}
 
-	uniqueKey := ethSendBundle.UniqueKey()
+	uniqueKey := ethSendBundleUniqueKey(&ethSendBundle)
 	parsedRequest.requestArgUniqueKey = &uniqueKey
 
-	return prx.HandleParsedRequest(ctx, parsedRequest)
+	err = prx.HandleParsedRequest(ctx, parsedRequest)
+	if err != nil {
+		return nil, err
+	}
+	return newEthSendBundleResponse(&ethSendBundle), nil
 }
 
-func (prx *ReceiverProxy) EthSendBundlePublic(ctx context.Context, ethSendBundle rpctypes.EthSendBundleArgs) error {
-	return prx.EthSendBundle(ctx, ethSendBundle, true)
+// ethSendBundleUniqueKey adds refund fields to the unique key of the bundle,
+// bundles that differ only in refunds are different requests for the builder
+func ethSendBundleUniqueKey(args *rpctypes.EthSendBundleArgs) uuid.UUID {
+	key := args.UniqueKey()
+	if args.RefundPercent == nil && args.RefundRecipient == nil && len(args.RefundTxHashes) == 0 {
+		return key
+	}
+	var refund []byte
+	if args.RefundPercent != nil {
+		refund = binary.BigEndian.AppendUint64(append(refund, 1), *args.RefundPercent)
+	} else {
+		refund = append(refund, 0)
+	}
+	if args.RefundRecipient != nil {
+		refund = append(refund, args.RefundRecipient.Bytes()...)
+	}
+	for _, txHash := range args.RefundTxHashes {
+		refund = append(refund, strings.ToLower(txHash)...)
+	}
+	return uuid.NewSHA1(key, refund)
+}
+
+func (prx *ReceiverProxy) EthSendBundlePublic(ctx context.Context, ethSendBundle rpctypes.EthSendBundleArgs) (*EthSendBundleResponse, error) {
+	resp, err := prx.EthSendBundle(ctx, ethSendBundle, true)
+	prx.reputation.RecordResult(rpcserver.GetSigner(ctx), err)
+	return resp, err
 }
 
-func (prx *ReceiverProxy) EthSendBundleLocal(ctx context.Context, ethSendBundle rpctypes.EthSendBundleArgs) error {
+func (prx *ReceiverProxy) EthSendBundleLocal(ctx context.Context, ethSendBundle rpctypes.EthSendBundleArgs) (*EthSendBundleResponse, error) {
 	return prx.EthSendBundle(ctx, ethSendBundle, false)
 }
 
-func (prx *ReceiverProxy) MevSendBundle(ctx context.Context, mevSendBundle rpctypes.MevSendBundleArgs, publicEndpoint bool) error {
+func (prx *ReceiverProxy) MevSendBundle(ctx context.Context, mevSendBundle rpctypes.MevSendBundleArgs, publicEndpoint bool) (*MevSendBundleResponse, error) {
 	parsedRequest := ParsedRequest{
 		publicEndpoint: publicEndpoint,
 		mevSendBundle:  &mevSendBundle,
@@ -151,12 +306,28 @@ func (prx *ReceiverProxy) MevSendBundle(ctx context.Context, mevSendBundle rpcty
 
 	err := prx.ValidateSigner(ctx, &parsedRequest, publicEndpoint)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	err = ValidateMevSendBundle(&mevSendBundle, publicEndpoint)
+	err = ValidateMevSendBundle(&mevSendBundle, publicEndpoint, &prx.TxValidation)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	// cancellations don't have target blocks
+	if len(mevSendBundle.Body) > 0 {
+		err = prx.validateBlockRange(uint64(mevSendBundle.Inclusion.BlockNumber), uint64(mevSendBundle.Inclusion.MaxBlock))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !publicEndpoint && prx.PrivacyPolicy.Enabled() && len(mevSendBundle.Body) > 0 {
+		err = ValidateMevSendBundlePrivacy(&mevSendBundle, &prx.PrivacyPolicy)
+		if err != nil {
+			incAPIPrivacyPolicyRejections()
+			return nil, err
+		}
 	}
 
 	if !publicEndpoint {
@@ -166,7 +337,7 @@ func (prx *ReceiverProxy) MevSendBundle(ctx context.Context, mevSendBundle rpcty
 		if mevSendBundle.ReplacementUUID != "" {
 			replUUID, err := uuid.Parse(mevSendBundle.ReplacementUUID)
 			if err != nil {
-				return errors.Join(errUUIDParse, err)
+				return nil, errors.Join(errUUIDParse, err)
 			}
 			replacementKey := replacementNonceKey{
 				uuid:   replUUID,
@@ -193,14 +364,20 @@ func (prx *ReceiverProxy) MevSendBundle(ctx context.Context, mevSendBundle rpcty
 	uniqueKey := mevSendBundle.UniqueKey()
 	parsedRequest.requestArgUniqueKey = &uniqueKey
 
-	return prx.HandleParsedRequest(ctx, parsedRequest)
+	err = prx.HandleParsedRequest(ctx, parsedRequest)
+	if err != nil {
+		return nil, err
+	}
+	return newMevSendBundleResponse(&mevSendBundle), nil
 }
 
-func (prx *ReceiverProxy) MevSendBundlePublic(ctx context.Context, mevSendBundle rpctypes.MevSendBundleArgs) error {
-	return prx.MevSendBundle(ctx, mevSendBundle, true)
+func (prx *ReceiverProxy) MevSendBundlePublic(ctx context.Context, mevSendBundle rpctypes.MevSendBundleArgs) (*MevSendBundleResponse, error) {
+	resp, err := prx.MevSendBundle(ctx, mevSendBundle, true)
+	prx.reputation.RecordResult(rpcserver.GetSigner(ctx), err)
+	return resp, err
 }
 
-func (prx *ReceiverProxy) MevSendBundleLocal(ctx context.Context, mevSendBundle rpctypes.MevSendBundleArgs) error {
+func (prx *ReceiverProxy) MevSendBundleLocal(ctx context.Context, mevSendBundle rpctypes.MevSendBundleArgs) (*MevSendBundleResponse, error) {
 	return prx.MevSendBundle(ctx, mevSendBundle, false)
 }
 
@@ -224,11 +401,18 @@ func (prx *ReceiverProxy) EthCancelBundle(ctx context.Context, ethCancelBundle r
 	if !publicEndpoint {
 		ethCancelBundle.SigningAddress = &parsedRequest.signer
 	}
+	// key is not used for deduplication of cancellations, it is sent to the peers so they can recognize retries
+	if ethCancelBundle.SigningAddress != nil {
+		uniqueKey := ethCancelBundle.UniqueKey()
+		parsedRequest.requestArgUniqueKey = &uniqueKey
+	}
 	return prx.HandleParsedRequest(ctx, parsedRequest)
 }
 
 func (prx *ReceiverProxy) EthCancelBundlePublic(ctx context.Context, ethCancelBundle rpctypes.EthCancelBundleArgs) error {
-	return prx.EthCancelBundle(ctx, ethCancelBundle, true)
+	err := prx.EthCancelBundle(ctx, ethCancelBundle, true)
+	prx.reputation.RecordResult(rpcserver.GetSigner(ctx), err)
+	return err
 }
 
 func (prx *ReceiverProxy) EthCancelBundleLocal(ctx context.Context, ethCancelBundle rpctypes.EthCancelBundleArgs) error {
@@ -246,6 +430,11 @@ func (prx *ReceiverProxy) EthSendRawTransaction(ctx context.Context, ethSendRawT
 		return err
 	}
 
+	err = ValidateEthSendRawTransaction(&ethSendRawTransaction, &prx.TxValidation)
+	if err != nil {
+		return err
+	}
+
 	uniqueKey := ethSendRawTransaction.UniqueKey()
 	parsedRequest.requestArgUniqueKey = &uniqueKey
 
@@ -253,7 +442,9 @@ func (prx *ReceiverProxy) EthSendRawTransaction(ctx context.Context, ethSendRawT
 }
 
 func (prx *ReceiverProxy) EthSendRawTransactionPublic(ctx context.Context, ethSendRawTransaction rpctypes.EthSendRawTransactionArgs) error {
-	return prx.EthSendRawTransaction(ctx, ethSendRawTransaction, true)
+	err := prx.EthSendRawTransaction(ctx, ethSendRawTransaction, true)
+	prx.reputation.RecordResult(rpcserver.GetSigner(ctx), err)
+	return err
 }
 
 func (prx *ReceiverProxy) EthSendRawTransactionLocal(ctx context.Context, ethSendRawTransaction rpctypes.EthSendRawTransactionArgs) error {
@@ -276,7 +467,7 @@ func (prx *ReceiverProxy) BidSubsidiseBlock(ctx context.Context, bidSubsidiseBlo
 		return err
 	}
 
-	if parsedRequest.signer != prx.FlashbotsSignerAddress {
+	if _, ok := prx.privilegedSignerName(parsedRequest.signer); !ok {
 		return errSubsidyWrongCaller
 	}
 
@@ -295,53 +486,205 @@ func (prx *ReceiverProxy) BidSubsidiseBlockLocal(ctx context.Context, bidSubsidi
 }
 
 type ParsedRequest struct {
-	publicEndpoint        bool
-	signer                common.Address
-	method                string
-	peerName              string
-	receivedAt            time.Time
+	publicEndpoint bool
+	signer         common.Address
+	method         string
+	peerName       string
+	receivedAt     time.Time
+	// deadline of the client request, zero if the client didn't set one, request is not sent to the peers after it
+	deadline time.Time
+	// time the request was queued to the share and archive queue, used to measure the wait for the workers
+	shareQueuedAt         time.Time
+	archiveQueuedAt       time.Time
 	requestArgUniqueKey   *uuid.UUID
 	ethSendBundle         *rpctypes.EthSendBundleArgs
 	mevSendBundle         *rpctypes.MevSendBundleArgs
 	ethCancelBundle       *rpctypes.EthCancelBundleArgs
 	ethSendRawTransaction *rpctypes.EthSendRawTransactionArgs
 	bidSubsidiseBlock     *rpctypes.BidSubsisideBlockArgs
+	// set for requests received on the tenant local endpoint
+	tenant string
+	// set for transactions ingested from the node mempool, they are sent only to the local builder
+	mempool bool
+	// version of the bundle with replacement uuid set by the share queue, replaced versions are not sent
+	replacementVersion uint64
+}
+
+// lastTargetBlock returns the last block that request can be included in
+// ok is false if request does not target specific blocks
+func (r *ParsedRequest) lastTargetBlock() (block uint64, ok bool) {
+	if r.ethSendBundle != nil && r.ethSendBundle.BlockNumber > 0 {
+		return uint64(r.ethSendBundle.BlockNumber), true
+	}
+	if r.mevSendBundle != nil && len(r.mevSendBundle.Body) > 0 && r.mevSendBundle.Inclusion.BlockNumber > 0 {
+		return uint64(max(r.mevSendBundle.Inclusion.BlockNumber, r.mevSendBundle.Inclusion.MaxBlock)), true
+	}
+	return 0, false
+}
+
+// orderingKey returns the key of requests that must be delivered in the order they were received
+// requests are ordered per original signer, this keeps nonce dependent bundles, replacements
+// and cancellations in order. Requests forwarded by peers carry the original signer in the arguments.
+func (r *ParsedRequest) orderingKey() (key string, ok bool) {
+	signer := r.originalSigner()
+	// sender proxy requests are not signed, versions of the same bundle are still kept in order
+	if signer == (common.Address{}) {
+		return r.replacementKey()
+	}
+	return signer.Hex(), true
+}
+
+// forgetRequest removes unique key of the request that was not handled from this and the other replicas,
+// idempotency key sent by the peer is removed too, cancellations are never claimed in the shared store
+func (prx *ReceiverProxy) forgetRequest(ctx context.Context, req *ParsedRequest, cancellation bool) {
+	if idempotencyKey, ok := idempotencyKeyFromContext(ctx); ok && req.publicEndpoint {
+		prx.requestUniqueKeysRLU.Remove(idempotencyKey)
+	}
+	if req.requestArgUniqueKey == nil {
+		return
+	}
+	prx.requestUniqueKeysRLU.Remove(*req.requestArgUniqueKey)
+	if !cancellation {
+		prx.releaseRequest(ctx, req)
+	}
+}
+
+// originalSigner returns the signer of the request, for requests forwarded by peers it is the signer from the arguments
+func (r *ParsedRequest) originalSigner() common.Address {
+	signer := r.signer
+	switch {
+	case r.ethSendBundle != nil && r.ethSendBundle.SigningAddress != nil:
+		signer = *r.ethSendBundle.SigningAddress
+	case r.mevSendBundle != nil && r.mevSendBundle.Metadata != nil && r.mevSendBundle.Metadata.Signer != nil:
+		signer = *r.mevSendBundle.Metadata.Signer
+	case r.ethCancelBundle != nil && r.ethCancelBundle.SigningAddress != nil:
+		signer = *r.ethCancelBundle.SigningAddress
+	}
+	return signer
+}
+
+// requestDeadlineOf returns the earlier of the context deadline and the configured request deadline, zero if none is set
+func (prx *ReceiverProxy) requestDeadlineOf(ctx context.Context, receivedAt time.Time) time.Time {
+	var deadline time.Time
+	if prx.requestDeadline > 0 {
+		deadline = receivedAt.Add(prx.requestDeadline)
+	}
+	if ctxDeadline, ok := ctx.Deadline(); ok && (deadline.IsZero() || ctxDeadline.Before(deadline)) {
+		deadline = ctxDeadline
+	}
+	return deadline
 }
 
 func (prx *ReceiverProxy) HandleParsedRequest(ctx context.Context, parsedRequest ParsedRequest) error {
+	parsedRequest.receivedAt = apiNow()
+	parsedRequest.deadline = prx.requestDeadlineOf(ctx, parsedRequest.receivedAt)
 	ctx, cancel := context.WithTimeout(ctx, handleParsedRequestTimeout)
 	defer cancel()
 
-	parsedRequest.receivedAt = apiNow()
 	prx.Log.Debug("Received request", slog.Bool("isPublicEndpoint", parsedRequest.publicEndpoint), slog.String("method", parsedRequest.method))
+	prx.requestEvents.publish(ctx, requestEvent{kind: requestEventReceived, req: &parsedRequest})
 	if parsedRequest.publicEndpoint {
-		incAPIIncomingRequestsByPeer(parsedRequest.peerName)
+		if _, privileged := prx.privilegedSignerName(parsedRequest.signer); !privileged {
+			err := prx.reputation.Allow(parsedRequest.signer)
+			if err != nil {
+				apiSignerRateLimits.Inc()
+				return err
+			}
+		}
+	}
+	if prx.shedRequest(&parsedRequest) {
+		return errOverloaded
+	}
+	decision, err := applyRequestFilters(ctx, prx.RequestFilters, &parsedRequest)
+	if err != nil {
+		prx.Log.Debug("Request rejected by filter", slog.String("method", parsedRequest.method), slog.Any("error", err))
+		return err
 	}
-	if parsedRequest.requestArgUniqueKey != nil {
+	if decision == DecisionDrop {
+		prx.requestEvents.publish(ctx, requestEvent{kind: requestEventDropped, req: &parsedRequest, reason: RejectionReasonFiltered})
+		return nil
+	}
+	// peers send the unique key computed on their side so retried deliveries are deduplicated exactly
+	idempotencyKey, hasIdempotencyKey := idempotencyKeyFromContext(ctx)
+	// cancellations are never dropped as duplicates, dropped cancellation would leave the bundle alive
+	// while forwarding it again is harmless for the builders
+	cancellation := parsedRequest.isCancellation()
+	if cancellation {
+		seen := hasIdempotencyKey && parsedRequest.publicEndpoint && prx.requestUniqueKeysRLU.Contains(idempotencyKey)
+		if parsedRequest.requestArgUniqueKey != nil {
+			seen = seen || prx.requestUniqueKeysRLU.Contains(*parsedRequest.requestArgUniqueKey)
+			prx.requestUniqueKeysRLU.Add(*parsedRequest.requestArgUniqueKey, struct{}{})
+		}
+		if seen {
+			apiDuplicateCancellations.Inc()
+		}
+	}
+	if !cancellation && hasIdempotencyKey && parsedRequest.publicEndpoint && prx.requestUniqueKeysRLU.Contains(idempotencyKey) {
+		incAPIDuplicateRequestsByPeer(parsedRequest.peerName)
+		prx.stats.recordDuplicate(&parsedRequest)
+		return nil
+	}
+	if !cancellation && parsedRequest.requestArgUniqueKey != nil {
 		if prx.requestUniqueKeysRLU.Contains(*parsedRequest.requestArgUniqueKey) {
 			incAPIDuplicateRequestsByPeer(parsedRequest.peerName)
+			prx.stats.recordDuplicate(&parsedRequest)
+			if parsedRequest.publicEndpoint {
+				prx.reputation.RecordDuplicate(parsedRequest.signer)
+			} else {
+				// duplicates between peers are expected, only duplicates of the users are kept
+				prx.requestEvents.publish(ctx, requestEvent{kind: requestEventDropped, req: &parsedRequest, reason: RejectionReasonDuplicate})
+			}
 			return nil
 		}
 		prx.requestUniqueKeysRLU.Add(*parsedRequest.requestArgUniqueKey, struct{}{})
+		if !prx.claimRequest(ctx, &parsedRequest) {
+			incAPIDuplicateRequestsByPeer(parsedRequest.peerName)
+			prx.stats.recordDuplicate(&parsedRequest)
+			return nil
+		}
 	}
-	if !parsedRequest.publicEndpoint {
+	if hasIdempotencyKey && parsedRequest.publicEndpoint {
+		prx.requestUniqueKeysRLU.Add(idempotencyKey, struct{}{})
+	}
+	if !parsedRequest.publicEndpoint && !parsedRequest.mempool {
 		err := prx.localAPIRateLimiter.Wait(ctx)
 		if err != nil {
 			incAPILocalRateLimits()
-			return errors.Join(errRateLimiting, err)
+			// request was not handled so its retry must not be dropped as duplicate
+			prx.forgetRequest(ctx, &parsedRequest, cancellation)
+			// request would wait longer than its deadline, client should retry when the limiter has a token
+			reservation := prx.localAPIRateLimiter.Reserve()
+			retryAfter := reservation.Delay()
+			reservation.Cancel()
+			return &RateLimitError{RetryAfter: retryAfter}
 		}
 	}
-	select {
-	case <-ctx.Done():
-		prx.Log.Error("Shared queue is stalling")
-	case prx.shareQueue <- &parsedRequest:
+	if ctx.Err() != nil {
+		// client gave up before the request was queued, its retry must not be dropped as duplicate
+		prx.forgetRequest(ctx, &parsedRequest, cancellation)
+		return ctx.Err()
+	}
+	shareQueue, queueName := prx.shareQueue, queueNameShare
+	if parsedRequest.tenant != "" {
+		incAPILocalTenantRequests(parsedRequest.tenant)
+		shareQueue = prx.tenants[parsedRequest.tenant].queue
+	} else if parsedRequest.publicEndpoint {
+		shareQueue, queueName = prx.peerShareQueue, queueNamePeerShare
 	}
-	if !parsedRequest.publicEndpoint {
-		select {
-		case <-ctx.Done():
-			prx.Log.Error("Archive queue is stalling")
-		case prx.archiveQueue <- &parsedRequest:
+	parsedRequest.shareQueuedAt = time.Now()
+	err = enqueueRequest(ctx, shareQueue, &parsedRequest, prx.backpressurePolicy, queueName, prx.publishEvicted(ctx, RejectionReasonShareQueueEvicted))
+	if err != nil {
+		prx.Log.Error("Shared queue is stalling", slog.String("queue", queueName))
+		prx.stats.recordQueueStall(queueName)
+		prx.requestEvents.publish(ctx, requestEvent{kind: requestEventDropped, req: &parsedRequest, reason: RejectionReasonShareQueueFull})
+		// with block policy requests were always accepted even if they were not queued
+		if prx.backpressurePolicy == BackpressureReject {
+			// rejected request was not handled so its retry must not be dropped as duplicate
+			prx.forgetRequest(ctx, &parsedRequest, cancellation)
+			return err
 		}
+		return nil
 	}
+	prx.requestEvents.publish(ctx, requestEvent{kind: requestEventValidated, req: &parsedRequest})
 	return nil
 }
@@ -5,6 +5,7 @@ import (
 	"crypto/tls"
 	"log/slog"
 	"net/http"
+	"slices"
 	"sync"
 	"time"
 
@@ -23,12 +24,17 @@ var (
 
 	peerUpdateTime = time.Second * 30
 
+	DefaultBlocklistRefreshInterval = time.Minute * 10
+
 	replacementNonceSize = 4096
 	replacementNonceTTL  = time.Second * 5 * 12
 
 	ReceiverProxyWorkerQueueSize = 10000
 )
 
+// OrderflowSignerHeader is sent by the cert endpoint with the address of the orderflow signer of the proxy
+const OrderflowSignerHeader = "X-Orderflow-Proxy-Signer"
+
 type replacementNonceKey struct {
 	uuid   uuid.UUID
 	signer common.Address
@@ -39,32 +45,94 @@ type ReceiverProxy struct {
 
 	ConfigHub *BuilderConfigHub
 
-	OrderflowSigner *signature.Signer
-	PublicCertPEM   []byte
-	Certificate     tls.Certificate
+	OrderflowSigner RequestSigner
+	rotatingSigner  *RotatingSigner
+	// PublicCertPEM and Certificate are replaced when the certificate is renewed, they are guarded by certMu
+	PublicCertPEM []byte
+	Certificate   tls.Certificate
+	certMu        sync.RWMutex
+	// set during renewal to the new certificate followed by the current one
+	renewalCertPEM    []byte
+	certValidDuration time.Duration
+	certHosts         []string
 
 	localBuilder rpcclient.RPCClient
 
+	blockNumberSource *BlockNumberSource
+
 	PublicHandler http.Handler
 	LocalHandler  http.Handler
-	CertHandler   http.Handler // this endpoint just returns generated certificate
+	CertHandler   http.Handler // this endpoint returns generated certificate and the orderflow signer address
+
+	BuildInfoHandler http.Handler
+	features         BuildInfoFeatures
+
+	// EventsHandler streams events of the accepted requests, it should be served only to the operator
+	EventsHandler http.Handler
+	// RejectionsHandler returns recent rejected and dropped requests, it should be served only to the operator
+	RejectionsHandler http.Handler
+	rejections        *rejectionRing
+	events            *eventStream
+	// lifecycle events of the requests are published to this bus, see subscribeRequestEvents
+	requestEvents *requestEventBus
+
+	// if set, TDX quote bound to the public certificate is served on the public endpoint
+	quoteProvider      QuoteProvider
+	attestationQuoteMu sync.Mutex
+	attestationQuote   []byte
+	// certificate PEM the attestation quote is bound to
+	attestationQuotePEM []byte
 
 	updatePeers chan []ConfighubBuilder
-	shareQueue  chan *ParsedRequest
+	// peer list is fetched as soon as possible when something is sent to this channel
+	refreshPeers chan struct{}
+	shareQueue   chan *ParsedRequest
+	// requests received from the peers, separate queue so they can't fill the share queue
+	peerShareQueue chan *ParsedRequest
 
 	archiveQueue      chan *ParsedRequest
 	archiveFlushQueue chan struct{}
 
 	peersMu          sync.RWMutex
 	lastFetchedPeers []ConfighubBuilder
+	// peers that rotated their keys are accepted with previous address during the grace period
+	previousPeerAddresses     map[common.Address]previousPeerAddress
+	signerRotationGracePeriod time.Duration
 
 	requestUniqueKeysRLU *expirable.LRU[uuid.UUID, struct{}]
 
 	replacementNonceRLU *expirable.LRU[replacementNonceKey, int]
 
-	peerUpdaterClose chan struct{}
+	// if set, requests are deduplicated with other replicas of the proxy
+	sharedStore SharedStore
+
+	peerUpdaterClose    chan struct{}
+	blocklistClose      chan struct{}
+	signerRotationClose chan struct{}
+	certRenewalClose    chan struct{}
+	// stops the newHeads subscription and the refresh of the block number
+	blockNumberClose   chan struct{}
+	mempoolClose       chan struct{}
+	reputationSaveStop chan struct{}
+	reputationSaveDone chan struct{}
+
+	audit *AuditLog
 
 	localAPIRateLimiter *rate.Limiter
+
+	backpressurePolicy string
+	// if > 0 requests are not forwarded after this time since they were received
+	requestDeadline time.Duration
+	// if set, requests received from peers are archived together with the local requests
+	archivePublicRequests bool
+	// if set, requests are forwarded only to the local builder and never shared with the peers
+	disablePeerSharing bool
+	builderLatency     *builderLatencyTracker
+	reputation         *SignerReputation
+	stats              *OrderflowStats
+	txDuplicates       *txDuplicateTracker
+
+	tenants map[string]*localTenant
 }
 
 type ReceiverProxyConstantConfig struct {
@@ -72,34 +140,209 @@ type ReceiverProxyConstantConfig struct {
 	// Name is optional field and it used to distringuish multiple proxies when running in the same process in tests
 	Name                   string
 	FlashbotsSignerAddress common.Address
+	// PrivilegedSigners maps addresses of additional privileged orderflow sources to their names
+	// they can call bid_subsidiseBlock and are named in metrics like Flashbots
+	PrivilegedSigners map[common.Address]string
+	// TxValidation configures validation of the transactions inside of the bundles
+	TxValidation TxValidationOpts
+	// BlockRange configures validation of the bundle target blocks against the chain head
+	BlockRange BlockRangeOpts
+	// PrivacyPolicy is enforced on mev share bundles received on the local endpoint
+	PrivacyPolicy PrivacyPolicy
+	// SignaturePolicy configures verification of the request signatures
+	SignaturePolicy SignaturePolicy
+	// DisabledPublicMethods and DisabledLocalMethods are not served on the endpoints, calls to them return method not found
+	DisabledPublicMethods []string
+	DisabledLocalMethods  []string
+	// MethodAliases maps additional method names to the methods served on both endpoints
+	MethodAliases map[string]string
+	// PublicProxyProtocol makes the public listener require PROXY protocol v2 header from the TCP load balancer
+	PublicProxyProtocol bool
+	// StrictJSONDecoding rejects requests with params that have unknown fields or values of wrong type on both endpoints
+	StrictJSONDecoding bool
+	// RequestFilters are custom policies applied in order to every request before it is forwarded
+	RequestFilters []RequestFilter
+	// PublicMiddlewares and LocalMiddlewares wrap handlers of the public and local servers, the first one is the outermost
+	PublicMiddlewares []Middleware
+	LocalMiddlewares  []Middleware
 }
 
 type ReceiverProxyConfig struct {
 	ReceiverProxyConstantConfig
 	CertValidDuration time.Duration
 	CertHosts         []string
+	// CertRenewBefore is the time before expiry when the certificate is replaced with a new one, renewal is disabled if 0
+	CertRenewBefore time.Duration
 
 	BuilderConfigHubEndpoint string
-	ArchiveEndpoint          string
-	ArchiveConnections       int
-	LocalBuilderEndpoint     string
+	// PeerListStaleWebhook is optional, if set it is called when peers can't be fetched from the builder config hub
+	PeerListStaleWebhook *PeerListStaleWebhook
+	ArchiveEndpoint      string
+	ArchiveConnections   int
+	// ArchiveSpillDir is optional, if set batches that can't be sent to the archive are kept in this directory
+	// and sent when the archive recovers instead of being dropped
+	ArchiveSpillDir string
+	// ArchiveParquetDir is optional, if set archived orderflow is written to parquet files in this directory
+	// instead of being sent to ArchiveEndpoint
+	ArchiveParquetDir string
+	// ArchiveSpillMaxBytes is the max size of the spilled batches, DefaultArchiveSpillMaxBytes if 0
+	ArchiveSpillMaxBytes int64
+	// ArchivePublicRequests archives requests received from peers as well, they are marked with the peer name
+	ArchivePublicRequests bool
+	// MetricsMTLS is reported in the build info, it is true if the metrics server requires client certificates
+	MetricsMTLS bool
+	// DisablePeerSharing forwards requests only to the local builder and archive, for orderflow that must not be shared with other builders
+	DisablePeerSharing bool
+	// ReceiveOnly forwards requests only to the local builder and never shares them with the peers,
+	// for staging builders that shadow the orderflow of production, archiving is configured as usual
+	ReceiveOnly bool
+	// ArchiveRedactedSinks are the archive sinks (rpc, parquet) that get only metadata of the requests
+	// with hashes and sizes of the transactions instead of raw transactions
+	ArchiveRedactedSinks []string
+	// ArchiveEncryptor is optional, if set archived events are encrypted before they leave the proxy
+	ArchiveEncryptor     ArchiveEncryptor
+	LocalBuilderEndpoint string
+	// BuilderTimeout is the timeout of each request to the local builder, if 0 default is used
+	BuilderTimeout time.Duration
+	// PeerTimeout is the timeout of each request forwarded to other proxies, if 0 default is used
+	PeerTimeout time.Duration
+	// RequestDeadline is optional, if set requests that were not sent to a peer or the local builder
+	// within this time after they were received are dropped
+	RequestDeadline time.Duration
+	// PeerCertCacheTTL is the time verified peer certificates are reused across peer list updates, if 0 default is used
+	PeerCertCacheTTL time.Duration
+	// PeerCompression compresses requests forwarded to the peers that accept compressed requests
+	PeerCompression bool
+	// LocalTenants are additional builders served on separate paths of the local endpoint
+	LocalTenants []LocalTenant
+	// BuilderAuthToken is optional bearer token sent with requests to the local builder
+	BuilderAuthToken string
+	// SignBuilderRequests makes requests to the local builder signed by the orderflow signer
+	SignBuilderRequests bool
+	// RawTxToBundle makes raw transactions delivered to the local builder as eth_sendBundle for the next block
+	RawTxToBundle bool
 
 	// EthRPC should support eth_blockNumber API
 	EthRPC string
+	// EthRPCFallbacks are used in order when EthRPC fails
+	EthRPCFallbacks []string
+	// BlockNumberCacheTTL is the time block number fetched with polling is used without refreshing
+	BlockNumberCacheTTL time.Duration
+	// EthWSRPC is optional websocket endpoint, if set block number is updated from newHeads subscription
+	EthWSRPC string
+	// MempoolWSRPC is optional websocket endpoint, if set pending transactions of the node are sent to the local builder
+	MempoolWSRPC string
 
 	MaxRequestBodySizeBytes int64
 
 	ConnectionsPerPeer int
-	MaxLocalRPS        int
+	// BackpressurePolicy is applied when share or archive queue is full, BackpressureBlock is used by default
+	BackpressurePolicy string
+	// ShareWorkersPerPeer is the number of concurrent workers sending to each peer, if 0 ConnectionsPerPeer is used
+	ShareWorkersPerPeer int
+	// ShareQueueSize is the capacity of the share queues of the local and peer requests and of the local tenants,
+	// if 0 ReceiverProxyWorkerQueueSize is used
+	ShareQueueSize int
+	// ShareWorkerQueueSize is the capacity of the worker queues of each peer and the local builder,
+	// it is split between the workers of the local and peer requests, if 0 ShareWorkerQueueSize is used
+	ShareWorkerQueueSize int
+	// ArchiveQueueSize is the capacity of the archive queue, if 0 ReceiverProxyWorkerQueueSize is used
+	ArchiveQueueSize int
+	// ShareBatchSize is the max number of requests sent to a peer in one JSON-RPC batch, batching is disabled if <= 1
+	ShareBatchSize int
+	// ShareBatchMaxBytes is the size of the batch params after which no more requests are added, 0 means no limit
+	ShareBatchMaxBytes int
+	// ShareBatchLatency is the time worker waits for more requests to fill the batch
+	ShareBatchLatency time.Duration
+	// SlowPeerLatency moves peers with average latency above it to the slow lane, they get requests after
+	// the other peers got all queued requests, disabled if 0
+	SlowPeerLatency time.Duration
+	// PeerProbeInterval is the interval of the canary requests sent to every peer to measure
+	// round trip and TLS handshake time, probes are disabled if 0
+	PeerProbeInterval time.Duration
+	// PeerShard splits replication to the peers between instances that receive the same local orderflow
+	PeerShard   PeerShard
+	MaxLocalRPS int
+	// MaxPublicSignerRPS limits requests of each peer on the public endpoint, the limit is scaled down
+	// with the signer reputation so spammy signers get less throughput, disabled if 0
+	MaxPublicSignerRPS int
+	// MaxPublicIPRPS limits requests of each client IP on the public endpoint before their signature is verified, disabled if 0
+	MaxPublicIPRPS int
+	// ReputationStorePath is optional, if set signer reputation is saved to this file and restored on start
+	ReputationStorePath string
+	// AuditLogPath is optional, if set entries of all forwarded requests signed by the orderflow signer are appended to this file
+	AuditLogPath string
+	// StatsWindows are the windows of the rolling counts returned by orderflow_getStats, DefaultStatsWindows if empty
+	StatsWindows []time.Duration
+	// LoadShedBuilderLatency is the average latency of the local builder above which requests from peers
+	// are rejected with overloaded error, load shedding is disabled if 0
+	LoadShedBuilderLatency time.Duration
+
+	// BlocklistSource is a file path or URL of the address blocklist, if empty blocklist is disabled
+	BlocklistSource          string
+	BlocklistRefreshInterval time.Duration
+	// BlocklistFlagOnly makes blocklist only log and count matching transactions instead of rejecting them
+	BlocklistFlagOnly bool
+
+	// QuoteProvider is optional, if set TDX quote bound to the public certificate is served on AttestationPath
+	QuoteProvider QuoteProvider
+
+	// SharedStore is optional, if set replicas running behind the same public address claim each request
+	// in the store so only one of them sends it to the builder and the peers
+	SharedStore SharedStore
+
+	// OrderflowSigner is optional, if not set random in-memory key is generated
+	OrderflowSigner RequestSigner
+	// SignerRotationInterval is optional, if set orderflow signer is periodically replaced with a new random key
+	SignerRotationInterval time.Duration
+	// SignerRotationGracePeriod is the time between registering new key and using it,
+	// previous keys of the peers are accepted for the same time
+	SignerRotationGracePeriod time.Duration
 }
 
 func NewReceiverProxy(config ReceiverProxyConfig) (*ReceiverProxy, error) {
-	orderflowSigner, err := signature.NewRandomSigner()
+	err := validateBackpressurePolicy(config.BackpressurePolicy)
+	if err != nil {
+		return nil, err
+	}
+	err = config.PeerShard.Validate()
+	if err != nil {
+		return nil, err
+	}
+	err = config.PrivacyPolicy.Validate()
 	if err != nil {
 		return nil, err
 	}
+	err = validateArchiveRedactedSinks(config.ArchiveRedactedSinks)
+	if err != nil {
+		return nil, err
+	}
+	if config.CertRenewBefore != 0 && config.CertRenewBefore >= config.CertValidDuration {
+		return nil, errCertRenewBefore
+	}
+	err = validateMethods((&ReceiverProxy{}).publicMethods(), config.DisabledPublicMethods, config.MethodAliases)
+	if err != nil {
+		return nil, err
+	}
+	err = validateMethods((&ReceiverProxy{}).localMethods(), config.DisabledLocalMethods, config.MethodAliases)
+	if err != nil {
+		return nil, err
+	}
+	orderflowSigner := config.OrderflowSigner
+	if orderflowSigner == nil {
+		randomSigner, err := signature.NewRandomSigner()
+		if err != nil {
+			return nil, err
+		}
+		orderflowSigner = randomSigner
+	}
+	rotatingSigner := NewRotatingSigner(orderflowSigner)
+	signerRotationGracePeriod := DefaultSignerRotationGracePeriod
+	if config.SignerRotationGracePeriod != 0 {
+		signerRotationGracePeriod = config.SignerRotationGracePeriod
+	}
 
-	cert, key, err := utils_tls.GenerateTLS(config.CertValidDuration, config.CertHosts)
+	cert, key, err := utils_tls.GenerateTLS(config.CertValidDuration, CertHostsForSANs(config.CertHosts))
 	if err != nil {
 		return nil, err
 	}
@@ -109,7 +352,14 @@ func NewReceiverProxy(config ReceiverProxyConfig) (*ReceiverProxy, error) {
 		return nil, err
 	}
 
-	localBuilder := rpcclient.NewClient(config.LocalBuilderEndpoint)
+	localBuilderOpts := &rpcclient.RPCClientOpts{}
+	if config.BuilderAuthToken != "" {
+		localBuilderOpts.CustomHeaders = map[string]string{"Authorization": "Bearer " + config.BuilderAuthToken}
+	}
+	if config.SignBuilderRequests {
+		localBuilderOpts.HTTPClient = HTTPClientWithSigner(&http.Client{}, rotatingSigner)
+	}
+	localBuilder := rpcclient.NewClientWithOpts(config.LocalBuilderEndpoint, localBuilderOpts)
 
 	limit := rate.Limit(config.MaxLocalRPS)
 	if config.MaxLocalRPS == 0 {
@@ -119,14 +369,94 @@ func NewReceiverProxy(config ReceiverProxyConfig) (*ReceiverProxy, error) {
 	prx := &ReceiverProxy{
 		ReceiverProxyConstantConfig: config.ReceiverProxyConstantConfig,
 		ConfigHub:                   NewBuilderConfigHub(config.Log, config.BuilderConfigHubEndpoint),
-		OrderflowSigner:             orderflowSigner,
+		OrderflowSigner:             rotatingSigner,
+		rotatingSigner:              rotatingSigner,
+		signerRotationGracePeriod:   signerRotationGracePeriod,
 		PublicCertPEM:               cert,
 		Certificate:                 certificate,
+		certValidDuration:           config.CertValidDuration,
+		certHosts:                   config.CertHosts,
 		localBuilder:                localBuilder,
+		blockNumberSource:           NewBlockNumberSource(append([]string{config.EthRPC}, config.EthRPCFallbacks...)...),
 		requestUniqueKeysRLU:        expirable.NewLRU[uuid.UUID, struct{}](requestsRLUSize, nil, requestsRLUTTL),
 		replacementNonceRLU:         expirable.NewLRU[replacementNonceKey, int](replacementNonceSize, nil, replacementNonceTTL),
 		localAPIRateLimiter:         localAPIRateLimiter,
+		quoteProvider:               config.QuoteProvider,
+		backpressurePolicy:          config.BackpressurePolicy,
+		archivePublicRequests:       config.ArchivePublicRequests,
+		disablePeerSharing:          config.DisablePeerSharing || config.ReceiveOnly,
+		requestDeadline:             config.RequestDeadline,
+		sharedStore:                 config.SharedStore,
+		builderLatency:              newBuilderLatencyTracker(config.LoadShedBuilderLatency),
+		reputation:                  NewSignerReputation(config.MaxPublicSignerRPS),
+		stats:                       NewOrderflowStats(config.StatsWindows),
+		txDuplicates:                newTxDuplicateTracker(config.Log),
+		rejections:                  newRejectionRing(RejectionRingSize),
+		requestEvents:               newRequestEventBus(),
+	}
+	prx.ConfigHub.staleWebhook = config.PeerListStaleWebhook
+	if config.BlockNumberCacheTTL != 0 {
+		prx.blockNumberSource.cacheTTL = config.BlockNumberCacheTTL
+	}
+	prx.blockNumberClose = make(chan struct{})
+	prx.blockNumberSource.registerHeadAgeMetric()
+	if config.EthRPC != "" {
+		go prx.blockNumberSource.RunRefresh(prx.blockNumberClose)
+	}
+	if config.EthWSRPC != "" {
+		go prx.blockNumberSource.RunNewHeadsSubscription(prx.Log, config.EthWSRPC, prx.blockNumberClose)
+	}
+	if config.BlocklistSource != "" {
+		blocklist, err := NewAddressBlocklist(prx.Log, config.BlocklistSource, config.BlocklistFlagOnly)
+		if err != nil {
+			return nil, err
+		}
+		prx.TxValidation.Blocklist = blocklist
+		refreshInterval := DefaultBlocklistRefreshInterval
+		if config.BlocklistRefreshInterval != 0 {
+			refreshInterval = config.BlocklistRefreshInterval
+		}
+		prx.blocklistClose = make(chan struct{})
+		go blocklist.RunRefresh(refreshInterval, prx.blocklistClose)
+	}
+
+	if config.ReputationStorePath != "" {
+		store, err := OpenReputationStore(config.ReputationStorePath)
+		if err != nil {
+			return nil, err
+		}
+		err = store.Load(prx.reputation)
+		if err != nil {
+			_ = store.Close()
+			return nil, err
+		}
+		prx.reputationSaveStop = make(chan struct{})
+		prx.reputationSaveDone = make(chan struct{})
+		go prx.runReputationSaving(store, prx.reputationSaveStop, prx.reputationSaveDone)
+	}
+
+	if config.AuditLogPath != "" {
+		prx.audit, err = NewAuditLog(prx.Log, config.AuditLogPath, prx.OrderflowSigner)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var archiveParquet *ParquetArchiveWriter
+	if config.ArchiveParquetDir != "" {
+		archiveParquet, err = NewParquetArchiveWriter(config.ArchiveParquetDir)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var archiveSpill *ArchiveSpill
+	if config.ArchiveSpillDir != "" {
+		archiveSpill, err = NewArchiveSpill(prx.Log, config.ArchiveSpillDir, config.ArchiveSpillMaxBytes)
+		if err != nil {
+			return nil, err
+		}
 	}
+
 	maxRequestBodySizeBytes := DefaultMaxRequestBodySizeBytes
 	if config.MaxRequestBodySizeBytes != 0 {
 		maxRequestBodySizeBytes = config.MaxRequestBodySizeBytes
@@ -136,52 +466,140 @@ func NewReceiverProxy(config ReceiverProxyConfig) (*ReceiverProxy, error) {
 	if err != nil {
 		return nil, err
 	}
-	prx.PublicHandler = publicHandler
+	batchElementHandler, err := prx.publicBatchElementHandler(maxRequestBodySizeBytes)
+	if err != nil {
+		return nil, err
+	}
+	publicChain := chainMiddlewares(publicHandler, idempotencyKeyHandler, rpcErrorHandler, requestSizeHandler)
+	batchElementChain := chainMiddlewares(batchElementHandler, idempotencyKeyHandler, rpcErrorHandler, requestSizeHandler)
+	if config.StrictJSONDecoding {
+		publicChain = strictParamsHandler(publicChain, prx.publicMethods(), maxRequestBodySizeBytes)
+		batchElementChain = strictParamsHandler(batchElementChain, prx.publicMethods(), maxRequestBodySizeBytes)
+	}
+	prx.PublicHandler = NewReplayProtection(prx.SignaturePolicy, maxRequestBodySizeBytes).Handler(
+		batchHandler(
+			publicChain,
+			batchElementChain,
+			newBundleStreamer(config.MethodAliases, config.StrictJSONDecoding),
+			maxRequestBodySizeBytes,
+		),
+	)
+	if prx.quoteProvider != nil {
+		publicMux := http.NewServeMux()
+		publicMux.HandleFunc(AttestationPath, prx.serveAttestation)
+		publicMux.Handle("/", prx.PublicHandler)
+		prx.PublicHandler = publicMux
+	}
+	prx.PublicHandler = decompressingHandler(prx.PublicHandler, maxRequestBodySizeBytes)
+	if config.MaxPublicIPRPS > 0 {
+		prx.PublicHandler = NewIPRateLimiter(config.MaxPublicIPRPS).Handler(prx.PublicHandler)
+	}
+
+	shareQueueSize := ReceiverProxyWorkerQueueSize
+	if config.ShareQueueSize > 0 {
+		shareQueueSize = config.ShareQueueSize
+	}
 
 	localHandler, err := prx.LocalJSONRPCHandler(maxRequestBodySizeBytes)
 	if err != nil {
 		return nil, err
 	}
 	prx.LocalHandler = localHandler
+	if len(config.LocalTenants) > 0 {
+		prx.startLocalTenants(config.LocalTenants, localBuilderOpts, shareQueueSize, ShareQueue{
+			signer:             prx.OrderflowSigner,
+			workerQueueSize:    config.ShareWorkerQueueSize,
+			backpressurePolicy: config.BackpressurePolicy,
+			workersPerPeer:     shareWorkersPerPeer(config.ConnectionsPerPeer, config.ShareWorkersPerPeer),
+			builderTimeout:     config.BuilderTimeout,
+			blockNumberSource:  prx.blockNumberSource,
+			rawTxToBundle:      config.RawTxToBundle,
+			requestEvents:      prx.requestEvents,
+		})
+	}
+	prx.PublicHandler = chainMiddlewares(prx.PublicHandler, config.PublicMiddlewares...)
+	prx.LocalHandler = chainMiddlewares(prx.LocalHandler, config.LocalMiddlewares...)
+
+	prx.features = BuildInfoFeatures{
+		ArchiveSink:         ArchiveSinkNone,
+		Attestation:         prx.quoteProvider != nil,
+		ArchiveEncrypted:    config.ArchiveEncryptor != nil,
+		MTLS:                config.MetricsMTLS,
+		PeerSharingDisabled: config.DisablePeerSharing || config.ReceiveOnly,
+		ReceiveOnly:         config.ReceiveOnly,
+	}
+	if config.ArchiveParquetDir != "" {
+		prx.features.ArchiveSink = ArchiveSinkParquet
+	} else if config.ArchiveEndpoint != "" {
+		prx.features.ArchiveSink = ArchiveSinkRPC
+	}
+	prx.features.ArchiveRedacted = slices.Contains(config.ArchiveRedactedSinks, prx.features.ArchiveSink)
+	prx.BuildInfoHandler = http.HandlerFunc(prx.serveBuildInfo)
+	prx.events = newEventStream()
+	prx.subscribeRequestEvents()
+	prx.EventsHandler = http.HandlerFunc(prx.serveEvents)
+	prx.RejectionsHandler = http.HandlerFunc(prx.serveRejections)
 
-	prx.CertHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Add("Content-Type", "application/octet-stream")
-		_, err := w.Write(prx.PublicCertPEM)
-		if err != nil {
-			prx.Log.Warn("Failed to serve certificate", slog.Any("error", err))
-		}
-	})
+	prx.CertHandler = http.HandlerFunc(prx.serveCert)
 
-	shareQeueuCh := make(chan *ParsedRequest, ReceiverProxyWorkerQueueSize)
+	shareQeueuCh := make(chan *ParsedRequest, shareQueueSize)
 	updatePeersCh := make(chan []ConfighubBuilder)
 	prx.shareQueue = shareQeueuCh
+	prx.peerShareQueue = make(chan *ParsedRequest, shareQueueSize)
 	prx.updatePeers = updatePeersCh
+	prx.refreshPeers = make(chan struct{}, 1)
 	queue := ShareQueue{
-		name:           prx.Name,
-		log:            prx.Log,
-		queue:          shareQeueuCh,
-		updatePeers:    updatePeersCh,
-		localBuilder:   prx.localBuilder,
-		signer:         prx.OrderflowSigner,
-		workersPerPeer: config.ConnectionsPerPeer,
+		name:                 prx.Name,
+		log:                  prx.Log,
+		queue:                shareQeueuCh,
+		peerQueue:            prx.peerShareQueue,
+		updatePeers:          updatePeersCh,
+		localBuilder:         prx.localBuilder,
+		signer:               prx.OrderflowSigner,
+		workersPerPeer:       shareWorkersPerPeer(config.ConnectionsPerPeer, config.ShareWorkersPerPeer),
+		workerQueueSize:      config.ShareWorkerQueueSize,
+		backpressurePolicy:   config.BackpressurePolicy,
+		builderTimeout:       config.BuilderTimeout,
+		peerTimeout:          config.PeerTimeout,
+		peerCertTTL:          config.PeerCertCacheTTL,
+		refreshPeers:         prx.refreshPeers,
+		blockNumberSource:    prx.blockNumberSource,
+		rawTxToBundle:        config.RawTxToBundle,
+		compressPeerRequests: config.PeerCompression,
+		batchSize:            config.ShareBatchSize,
+		batchMaxBytes:        config.ShareBatchMaxBytes,
+		batchLatency:         config.ShareBatchLatency,
+		probeInterval:        config.PeerProbeInterval,
+		slowPeerLatency:      config.SlowPeerLatency,
+		peerShard:            config.PeerShard,
+		builderLatency:       prx.builderLatency,
+		requestEvents:        prx.requestEvents,
 	}
 	go queue.Run()
 
-	archiveQueueCh := make(chan *ParsedRequest, ReceiverProxyWorkerQueueSize)
+	archiveQueueSize := ReceiverProxyWorkerQueueSize
+	if config.ArchiveQueueSize > 0 {
+		archiveQueueSize = config.ArchiveQueueSize
+	}
+	archiveQueueCh := make(chan *ParsedRequest, archiveQueueSize)
 	archiveFlushCh := make(chan struct{})
 	prx.archiveQueue = archiveQueueCh
 	prx.archiveFlushQueue = archiveFlushCh
 	archiveHTTPClient := HTTPClientWithMaxConnections(config.ArchiveConnections)
 	archiveClient := rpcclient.NewClientWithOpts(config.ArchiveEndpoint, &rpcclient.RPCClientOpts{
-		Signer:     orderflowSigner,
-		HTTPClient: archiveHTTPClient,
+		HTTPClient: HTTPClientWithSigner(archiveHTTPClient, rotatingSigner),
 	})
 	archiveQueue := ArchiveQueue{
 		log:               prx.Log,
 		queue:             archiveQueueCh,
 		flushQueue:        archiveFlushCh,
 		archiveClient:     archiveClient,
-		blockNumberSource: NewBlockNumberSource(config.EthRPC),
+		parquet:           archiveParquet,
+		blockNumberSource: prx.blockNumberSource,
+		spill:             archiveSpill,
+		publicRequests:    config.ArchivePublicRequests,
+		redact:            prx.features.ArchiveRedacted,
+		encryptor:         config.ArchiveEncryptor,
 	}
 	go archiveQueue.Run()
 
@@ -193,15 +611,32 @@ func NewReceiverProxy(config ReceiverProxyConfig) (*ReceiverProxy, error) {
 				if !more {
 					return
 				}
+			case <-prx.refreshPeers:
 			case <-time.After(peerUpdateTime):
-				err := prx.RequestNewPeers()
-				if err != nil {
-					prx.Log.Error("Failed to update peers", slog.Any("error", err))
-				}
+			}
+			err := prx.RequestNewPeers()
+			if err != nil {
+				prx.Log.Error("Failed to update peers", slog.Any("error", err))
 			}
 		}
 	}()
 
+	if config.SignerRotationInterval != 0 {
+		prx.signerRotationClose = make(chan struct{})
+		go prx.RunSignerRotation(config.SignerRotationInterval, signerRotationGracePeriod, prx.signerRotationClose)
+	}
+
+	if config.CertRenewBefore != 0 {
+		prx.certRenewalClose = make(chan struct{})
+		// peers need the same time to fetch the new certificate as they need to fetch the new signer address
+		go prx.RunCertRenewal(config.CertRenewBefore, signerRotationGracePeriod, prx.certRenewalClose)
+	}
+
+	if config.MempoolWSRPC != "" {
+		prx.mempoolClose = make(chan struct{})
+		go prx.RunMempoolIngestion(config.MempoolWSRPC, prx.mempoolClose)
+	}
+
 	// request peers on the first start
 	_ = prx.RequestNewPeers()
 
@@ -209,21 +644,75 @@ func NewReceiverProxy(config ReceiverProxyConfig) (*ReceiverProxy, error) {
 }
 
 func (prx *ReceiverProxy) Stop() {
+	if prx.mempoolClose != nil {
+		close(prx.mempoolClose)
+	}
 	close(prx.shareQueue)
+	close(prx.peerShareQueue)
 	close(prx.updatePeers)
 	close(prx.archiveQueue)
 	close(prx.archiveFlushQueue)
 	close(prx.peerUpdaterClose)
+	if prx.blocklistClose != nil {
+		close(prx.blocklistClose)
+	}
+	if prx.signerRotationClose != nil {
+		close(prx.signerRotationClose)
+	}
+	if prx.certRenewalClose != nil {
+		close(prx.certRenewalClose)
+	}
+	if prx.blockNumberClose != nil {
+		close(prx.blockNumberClose)
+	}
+	prx.stopLocalTenants()
+	if prx.reputationSaveStop != nil {
+		close(prx.reputationSaveStop)
+		<-prx.reputationSaveDone
+	}
+	if prx.audit != nil {
+		err := prx.audit.Close()
+		if err != nil {
+			prx.Log.Error("Failed to close audit log", slog.Any("error", err))
+		}
+	}
 }
 
 func (prx *ReceiverProxy) TLSConfig() *tls.Config {
 	return &tls.Config{
-		Certificates: []tls.Certificate{prx.Certificate},
-		MinVersion:   tls.VersionTLS13,
+		GetCertificate: prx.getCertificate,
+		MinVersion:     tls.VersionTLS13,
+	}
+}
+
+// serveCert returns the certificate with the address of the orderflow signer
+// certificate is signed by the orderflow signer so peers can check that both belong to the same instance
+// JSON variant with the parsed certificate is returned with format=json query or application/json in Accept header
+func (prx *ReceiverProxy) serveCert(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(OrderflowSignerHeader, prx.OrderflowSigner.Address().Hex())
+	if wantsJSON(r) {
+		prx.serveCertInfo(w)
+		return
+	}
+	w.Header().Add("Content-Type", "application/octet-stream")
+	certPEM := prx.certPEM()
+	sig, err := prx.OrderflowSigner.Create(certPEM)
+	if err != nil {
+		prx.Log.Warn("Failed to sign certificate", slog.Any("error", err))
+	} else {
+		w.Header().Set(signature.HTTPHeader, sig)
+	}
+	_, err = w.Write(certPEM)
+	if err != nil {
+		prx.Log.Warn("Failed to serve certificate", slog.Any("error", err))
 	}
 }
 
 func (prx *ReceiverProxy) RegisterSecrets(ctx context.Context) error {
+	return prx.registerCredentials(ctx, prx.OrderflowSigner.Address())
+}
+
+func (prx *ReceiverProxy) registerCredentials(ctx context.Context, signerAddress common.Address) error {
 	const maxRetries = 10
 	const timeBetweenRetries = time.Second * 10
 
@@ -233,8 +722,8 @@ func (prx *ReceiverProxy) RegisterSecrets(ctx context.Context) error {
 			return ctx.Err()
 		}
 		err := prx.ConfigHub.RegisterCredentials(ctx, ConfighubOrderflowProxyCredentials{
-			TLSCert:            string(prx.PublicCertPEM),
-			EcdsaPubkeyAddress: prx.OrderflowSigner.Address(),
+			TLSCert:            string(prx.registeredCertPEM()),
+			EcdsaPubkeyAddress: signerAddress,
 		})
 		if err == nil {
 			prx.Log.Info("Credentials registered on config hub")
@@ -262,9 +751,14 @@ func (prx *ReceiverProxy) RequestNewPeers() error {
 	}
 
 	prx.peersMu.Lock()
+	prx.updatePreviousPeerAddresses(builders, time.Now())
 	prx.lastFetchedPeers = builders
 	prx.peersMu.Unlock()
 
+	// peers are still fetched to authenticate requests on the public endpoint
+	if prx.disablePeerSharing {
+		return nil
+	}
 	select {
 	case prx.updatePeers <- builders:
 	default:
@@ -1,8 +1,21 @@
 package proxy
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -10,16 +23,31 @@ import (
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/flashbots/go-utils/rpcclient"
+	"github.com/flashbots/go-utils/rpcserver"
 	"github.com/flashbots/go-utils/rpctypes"
 	"github.com/flashbots/go-utils/signature"
+	utils_tls "github.com/flashbots/go-utils/tls"
+	"github.com/google/uuid"
+	"github.com/parquet-go/parquet-go"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -184,9 +212,10 @@ func TestMain(m *testing.M) {
 	os.Exit(m.Run())
 }
 
-func createProxy(localBuilder, name string) *ReceiverProxy {
+// createProxy creates proxy with the test config, options can change the config before the proxy is created
+func createProxy(localBuilder, name string, options ...func(config *ReceiverProxyConfig)) *ReceiverProxy {
 	log := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
-	proxy, err := NewReceiverProxy(ReceiverProxyConfig{
+	config := ReceiverProxyConfig{
 		ReceiverProxyConstantConfig: ReceiverProxyConstantConfig{
 			Log:                    log,
 			Name:                   name,
@@ -199,7 +228,11 @@ func createProxy(localBuilder, name string) *ReceiverProxy {
 		LocalBuilderEndpoint:     localBuilder,
 		EthRPC:                   "eth-rpc-not-set",
 		MaxLocalRPS:              10,
-	})
+	}
+	for _, option := range options {
+		option(&config)
+	}
+	proxy, err := NewReceiverProxy(config)
 	if err != nil {
 		panic(err)
 	}
@@ -220,6 +253,26 @@ func TestServeCert(t *testing.T) {
 	require.NoError(t, err)
 
 	require.Equal(t, string(proxies[0].proxy.PublicCertPEM), string(body))
+
+	signerAddress := proxies[0].proxy.OrderflowSigner.Address()
+	require.Equal(t, signerAddress.Hex(), resp.Header.Get(OrderflowSignerHeader))
+	signer, err := signature.Verify(resp.Header.Get(signature.HTTPHeader), body)
+	require.NoError(t, err)
+	require.Equal(t, signerAddress, signer)
+
+	resp, err = http.Get(proxies[0].certServer.URL + "?format=json")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	var info CertInfo
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&info))
+	require.Equal(t, string(proxies[0].proxy.PublicCertPEM), info.PEM)
+	require.Equal(t, CertFingerprint(proxies[0].proxy.Certificate.Leaf), info.FingerprintSHA256)
+	require.Len(t, info.FingerprintSHA256, 64)
+	require.Equal(t, "ECDSA P-256", info.KeyType)
+	require.Contains(t, info.DNSNames, "localhost")
+	require.True(t, info.NotAfter.After(info.NotBefore))
+	require.Equal(t, signerAddress, info.SignerAddress)
 }
 
 func expectRequest(t *testing.T, ch chan *RequestData) *RequestData {
@@ -375,6 +428,127 @@ func TestProxySendToArchive(t *testing.T) {
 	require.Equal(t, expectedArchiveRequest, archiveRequest.body)
 }
 
+func TestArchivePublicRequests(t *testing.T) {
+	builder := ServeHTTPRequestToChan(make(chan *RequestData, 10))
+	defer builder.Close()
+	archiveRequests := make(chan *RequestData, 10)
+	archive := ServeHTTPRequestToChan(archiveRequests)
+	defer archive.Close()
+
+	prx := createProxy(builder.URL, "", func(config *ReceiverProxyConfig) {
+		config.ArchiveEndpoint = archive.URL
+		config.ArchivePublicRequests = true
+	})
+	defer prx.Stop()
+	publicServer := httptest.NewServer(prx.PublicHandler)
+	defer publicServer.Close()
+
+	client := rpcclient.NewClientWithOpts(publicServer.URL, &rpcclient.RPCClientOpts{
+		HTTPClient: HTTPClientWithSigner(http.DefaultClient, flashbotsSigner),
+	})
+	signingAddress := flashbotsSigner.Address()
+	resp, err := client.Call(context.Background(), EthSendBundleMethod, &rpctypes.EthSendBundleArgs{BlockNumber: 1500, SigningAddress: &signingAddress})
+	require.NoError(t, err)
+	require.Nil(t, resp.Error)
+
+	prx.FlushArchiveQueue()
+	archiveRequest := expectRequest(t, archiveRequests)
+	require.Contains(t, archiveRequest.body, `"blockNumber":"0x5dc"`)
+	require.Contains(t, archiveRequest.body, `"peer":"flashbots"`)
+}
+
+func TestArchiveEncryption(t *testing.T) {
+	privateKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	encryptor, err := NewX25519ArchiveEncryptor(hex.EncodeToString(privateKey.PublicKey().Bytes()))
+	require.NoError(t, err)
+
+	tx := createTestTx(0)
+	req := &ParsedRequest{
+		method:     EthSendBundleMethod,
+		receivedAt: time.UnixMilli(1700000000000),
+		tenant:     "tenant",
+		ethSendBundle: &rpctypes.EthSendBundleArgs{
+			Txs:         []hexutil.Bytes{*tx},
+			BlockNumber: 1500,
+		},
+	}
+	args := archiveEventsArgs(slog.Default(), false, encryptor, []*ParsedRequest{req})
+	require.Len(t, args.OrderEvents, 1)
+	event := args.OrderEvents[0]
+	require.Nil(t, event.EthSendBundle)
+	require.NotNil(t, event.Encrypted)
+	require.Equal(t, EthSendBundleMethod, event.Encrypted.Method)
+	require.Equal(t, int64(1500), event.Encrypted.BlockNumber)
+
+	encoded, err := json.Marshal(args)
+	require.NoError(t, err)
+	require.NotContains(t, string(encoded), tx.String()[2:])
+
+	row, err := archiveParquetRowOf(event)
+	require.NoError(t, err)
+	require.Equal(t, int64(1700000000000), row.ReceivedAt)
+	require.Equal(t, "tenant", row.Tenant)
+	require.Empty(t, row.Txs)
+	require.NotContains(t, string(row.Params), tx.String()[2:])
+
+	plaintext, err := DecryptX25519ArchivePayload(event.Encrypted.Payload, privateKey)
+	require.NoError(t, err)
+	var decrypted ArchiveEvent
+	require.NoError(t, json.Unmarshal(plaintext, &decrypted))
+	require.NotNil(t, decrypted.EthSendBundle)
+	require.Equal(t, []hexutil.Bytes{*tx}, decrypted.EthSendBundle.Params.Txs)
+	require.Equal(t, "tenant", decrypted.EthSendBundle.Metadata.Tenant)
+
+	otherKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	_, err = DecryptX25519ArchivePayload(event.Encrypted.Payload, otherKey)
+	require.Error(t, err)
+}
+
+func TestArchiveRedaction(t *testing.T) {
+	require.NoError(t, validateArchiveRedactedSinks([]string{ArchiveSinkRPC, ArchiveSinkParquet}))
+	require.ErrorIs(t, validateArchiveRedactedSinks([]string{"spill"}), errUnknownArchiveSink)
+
+	tx := createTestTx(0)
+	var decoded types.Transaction
+	require.NoError(t, decoded.UnmarshalBinary(*tx))
+	signer := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	req := &ParsedRequest{
+		method:     MevSendBundleMethod,
+		receivedAt: time.UnixMilli(1700000000000),
+		mevSendBundle: &rpctypes.MevSendBundleArgs{
+			Inclusion: rpctypes.MevBundleInclusion{BlockNumber: 1500, MaxBlock: 1502},
+			Body:      []rpctypes.MevBundleBody{{Tx: tx}},
+			Metadata:  &rpctypes.MevBundleMetadata{Signer: &signer},
+		},
+	}
+	args := archiveEventsArgs(slog.Default(), true, nil, []*ParsedRequest{req})
+	require.Len(t, args.OrderEvents, 1)
+	event := args.OrderEvents[0]
+	require.Nil(t, event.MevSendBundle)
+	require.Equal(t, &ArchiveEventRedacted{
+		Method:         MevSendBundleMethod,
+		Signer:         signer.Hex(),
+		BlockNumber:    1500,
+		MaxBlockNumber: 1502,
+		Txs:            []ArchiveRedactedTx{{Hash: decoded.Hash(), Size: len(*tx)}},
+		Metadata:       &ArchiveEventMetadata{ReceivedAt: 1700000000000},
+	}, event.Redacted)
+
+	encoded, err := json.Marshal(args)
+	require.NoError(t, err)
+	require.NotContains(t, string(encoded), tx.String()[2:])
+
+	row, err := archiveParquetRowOf(event)
+	require.NoError(t, err)
+	require.Equal(t, MevSendBundleMethod, row.Method)
+	require.Equal(t, int64(1500), row.BlockNumber)
+	require.Equal(t, signer.Hex(), row.Signer)
+	require.Empty(t, row.Txs)
+	require.Contains(t, string(row.Params), decoded.Hash().Hex())
+}
+
 func createTestTx(i int) *hexutil.Bytes {
 	privateKey, err := crypto.HexToECDSA("c7589782d55a642c8ced7794ddcb24b62d4ebefbb81001034cb46545ff80e39e")
 	if err != nil {
@@ -521,3 +695,3325 @@ func TestProxyBidSubsidiseBlockCall(t *testing.T) {
 	expectNoRequest(t, proxies[1].localBuilderRequests)
 	expectNoRequest(t, proxies[2].localBuilderRequests)
 }
+
+func TestProxyVersion(t *testing.T) {
+	signer, err := signature.NewRandomSigner()
+	require.NoError(t, err)
+	client, err := RPCClientWithCertAndSigner(proxies[0].localServerEndpoint, proxies[0].proxy.PublicCertPEM, signer, 1)
+	require.NoError(t, err)
+
+	var buildInfo BuildInfo
+	err = client.CallFor(context.Background(), &buildInfo, ProxyVersionMethod)
+	require.NoError(t, err)
+	require.Equal(t, ArchiveSinkRPC, buildInfo.Features.ArchiveSink)
+	require.Contains(t, buildInfo.Methods.Local, EthSendBundleMethod)
+	require.Contains(t, buildInfo.Methods.Public, ProxyVersionMethod)
+	require.False(t, buildInfo.Features.MTLS)
+
+	metricsConfig := MetricsServerConfig{ClientCAFile: "ca.pem"}
+	prx := createProxy("", "", func(config *ReceiverProxyConfig) {
+		config.ArchiveEndpoint = "archive-not-set"
+		config.MetricsMTLS = metricsConfig.MTLS()
+	})
+	defer prx.Stop()
+	require.True(t, prx.BuildInfo().Features.MTLS)
+}
+
+func TestShareQueuePeerOrdering(t *testing.T) {
+	peer := newShareQueuePeer("test", nil, 8, 0)
+	signer := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	peerSigner := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	replacementUUID := "550e8400-e29b-41d4-a716-446655440000"
+
+	bundle := &ParsedRequest{signer: signer, ethSendBundle: &rpctypes.EthSendBundleArgs{ReplacementUUID: &replacementUUID}}
+	rawTx := &ParsedRequest{signer: signer, ethSendRawTransaction: &rpctypes.EthSendRawTransactionArgs{}}
+	// cancellation forwarded by a peer is ordered by its original signer
+	cancel := &ParsedRequest{signer: peerSigner, ethCancelBundle: &rpctypes.EthCancelBundleArgs{ReplacementUUID: replacementUUID, SigningAddress: &signer}}
+	worker := peer.worker(bundle)
+	for range 10 {
+		require.Equal(t, worker, peer.worker(cancel))
+		require.Equal(t, worker, peer.worker(rawTx))
+		// unsigned requests do not affect the choice
+		peer.worker(&ParsedRequest{ethSendRawTransaction: &rpctypes.EthSendRawTransactionArgs{}})
+	}
+}
+
+func TestShareQueuePeerLanes(t *testing.T) {
+	peer := newShareQueuePeer("test", nil, 1, 0)
+	log := slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	peerRequest := &ParsedRequest{publicEndpoint: true, ethSendRawTransaction: &rpctypes.EthSendRawTransactionArgs{}}
+	localRequest := &ParsedRequest{publicEndpoint: false, ethSendRawTransaction: &rpctypes.EthSendRawTransactionArgs{}}
+	peer.SendRequest(log, peerRequest)
+	peer.SendRequest(log, localRequest)
+
+	// workers of each lane only take requests of their lane
+	req, more := peer.nextRequest(shareQueueLaneLocal, 0)
+	require.True(t, more)
+	require.Same(t, localRequest, req)
+	_, ok, _ := peer.nextRequestBefore(shareQueueLaneLocal, 0, nil)
+	require.False(t, ok)
+	req, more = peer.nextRequest(shareQueueLanePeer, 0)
+	require.True(t, more)
+	require.Same(t, peerRequest, req)
+
+	peer.Close()
+	_, more = peer.nextRequest(shareQueueLaneLocal, 0)
+	require.False(t, more)
+	_, more = peer.nextRequest(shareQueueLanePeer, 0)
+	require.False(t, more)
+}
+
+func TestShareQueuePeerPriority(t *testing.T) {
+	peer := newShareQueuePeer("test", nil, 1, 0)
+	defer peer.Close()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	bundle := &ParsedRequest{ethSendBundle: &rpctypes.EthSendBundleArgs{BlockNumber: 1}}
+	rawTx := &ParsedRequest{ethSendRawTransaction: &rpctypes.EthSendRawTransactionArgs{}}
+	cancel := &ParsedRequest{ethCancelBundle: &rpctypes.EthCancelBundleArgs{ReplacementUUID: uuid.NewString()}}
+	subsidy := &ParsedRequest{bidSubsidiseBlock: new(rpctypes.BidSubsisideBlockArgs)}
+	for _, req := range []*ParsedRequest{bundle, rawTx, cancel, subsidy} {
+		peer.SendRequest(log, req)
+	}
+	depth := metrics.GetOrCreateGauge(fmt.Sprintf(shareQueuePeerQueueDepthLabel, peer.name), nil)
+	require.Equal(t, float64(4), depth.Get())
+	// cancellations and subsidies are taken first, other requests keep their order
+	for _, expected := range []*ParsedRequest{cancel, subsidy, bundle, rawTx} {
+		req, more := peer.nextRequest(shareQueueLaneLocal, 0)
+		require.True(t, more)
+		require.Same(t, expected, req)
+	}
+	// depth goes down when the workers take the requests
+	require.Equal(t, float64(0), depth.Get())
+}
+
+func TestShareQueuePeerQueueCapacity(t *testing.T) {
+	// capacity is split between the workers of both lanes
+	peer := newShareQueuePeer("test", nil, 2, 100)
+	peer.Close()
+	for worker := range 2 {
+		require.Equal(t, 25, cap(peer.localChs[worker]))
+		require.Equal(t, 25, cap(peer.peerChs[worker]))
+		require.Equal(t, 25, cap(peer.localPriorityChs[worker]))
+		require.Equal(t, 25, cap(peer.peerPriorityChs[worker]))
+	}
+	peer = newShareQueuePeer("test", nil, 1, 0)
+	peer.Close()
+	require.Equal(t, ShareWorkerQueueSize/2, cap(peer.localChs[0]))
+	require.Equal(t, shareWorkerPriorityQueueSize, cap(peer.localPriorityChs[0]))
+
+	// cancellations that don't fit in the priority queue are queued with the other requests
+	peer = newShareQueuePeer("test", nil, 1, 4)
+	defer peer.Close()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	bundle := &ParsedRequest{ethSendBundle: &rpctypes.EthSendBundleArgs{BlockNumber: 1}}
+	peer.SendRequest(log, bundle)
+	cancels := make([]*ParsedRequest, 3)
+	for i := range cancels {
+		cancels[i] = &ParsedRequest{ethCancelBundle: &rpctypes.EthCancelBundleArgs{ReplacementUUID: uuid.NewString()}}
+		peer.SendRequest(log, cancels[i])
+	}
+	for _, expected := range []*ParsedRequest{cancels[0], cancels[1], bundle, cancels[2]} {
+		req, more := peer.nextRequest(shareQueueLaneLocal, 0)
+		require.True(t, more)
+		require.Same(t, expected, req)
+	}
+}
+
+func TestShareQueueCancellationBehindEvictedReplacement(t *testing.T) {
+	peer := newShareQueuePeer("test", nil, 1, 0)
+	defer peer.Close()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	tracker := newReplacementTracker()
+
+	replacementUUID := uuid.NewString()
+	bundle := &ParsedRequest{ethSendBundle: &rpctypes.EthSendBundleArgs{BlockNumber: 1, ReplacementUUID: &replacementUUID}}
+	tracker.next(bundle)
+	tracker.record(bundle, []string{peer.name})
+	peer.SendRequest(log, bundle)
+
+	// key of the queued bundle is evicted by the bundles of other signers
+	for i := range replacementTrackerSize {
+		other := uuid.NewString()
+		req := &ParsedRequest{signer: common.BigToAddress(big.NewInt(int64(i + 1))), ethSendBundle: &rpctypes.EthSendBundleArgs{ReplacementUUID: &other}}
+		tracker.next(req)
+		tracker.record(req, nil)
+	}
+	cancel := &ParsedRequest{ethCancelBundle: &rpctypes.EthCancelBundleArgs{ReplacementUUID: replacementUUID}}
+	tracker.next(cancel)
+	require.Equal(t, uint64(1), cancel.replacementVersion)
+	require.False(t, tracker.superseded(bundle))
+	peer.SendRequest(log, cancel)
+
+	// cancellation is not sent before the bundle it cancels
+	for _, expected := range []*ParsedRequest{bundle, cancel} {
+		req, more := peer.nextRequest(shareQueueLaneLocal, 0)
+		require.True(t, more)
+		require.Same(t, expected, req)
+	}
+	require.Empty(t, peer.pendingReplacements)
+
+	// without queued versions cancellation is prioritized again
+	rawTx := &ParsedRequest{ethSendRawTransaction: &rpctypes.EthSendRawTransactionArgs{}}
+	peer.SendRequest(log, rawTx)
+	peer.SendRequest(log, cancel)
+	req, _ := peer.nextRequest(shareQueueLaneLocal, 0)
+	require.Same(t, cancel, req)
+}
+
+func TestShareQueueLocalRequestsNotStarvedByPeers(t *testing.T) {
+	release := make(chan struct{})
+	builderRequests := make(chan string, 10)
+	builder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Method string `json:"method"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		builderRequests <- req.Method
+		// requests from the peers are stuck at the builder
+		if req.Method == EthSendBundleMethod {
+			<-release
+		}
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":0,"result":null}`))
+	}))
+	defer builder.Close()
+	defer close(release)
+
+	queue := make(chan *ParsedRequest, 10)
+	peerQueue := make(chan *ParsedRequest, 10)
+	sq := &ShareQueue{
+		log:          slog.New(slog.NewTextHandler(io.Discard, nil)),
+		queue:        queue,
+		peerQueue:    peerQueue,
+		updatePeers:  make(chan []ConfighubBuilder),
+		localBuilder: rpcclient.NewClient(builder.URL),
+	}
+	go sq.Run()
+	defer close(queue)
+
+	peerQueue <- &ParsedRequest{publicEndpoint: true, method: EthSendBundleMethod, ethSendBundle: &rpctypes.EthSendBundleArgs{BlockNumber: 1}}
+	require.Equal(t, EthSendBundleMethod, <-builderRequests)
+	queue <- &ParsedRequest{method: EthSendRawTransactionMethod, ethSendRawTransaction: &rpctypes.EthSendRawTransactionArgs{1}}
+	select {
+	case method := <-builderRequests:
+		require.Equal(t, EthSendRawTransactionMethod, method)
+	case <-time.After(time.Second):
+		t.Fatal("local request was blocked by the peer request")
+	}
+}
+
+func TestReplayProtection(t *testing.T) {
+	signer, err := signature.NewRandomSigner()
+	require.NoError(t, err)
+	now := time.Unix(1700000000, 0)
+	protection := NewReplayProtection(SignaturePolicy{MaxClockSkew: time.Minute}, DefaultMaxRequestBodySizeBytes)
+	protection.now = func() time.Time { return now }
+
+	body := []byte(`{"jsonrpc":"2.0","id":1,"method":"eth_sendRawTransaction","params":[]}`)
+	signatureHeader, err := signer.Create(body)
+	require.NoError(t, err)
+
+	header, err := createReplayProtectionHeader(signer, body, now)
+	require.NoError(t, err)
+	require.NoError(t, protection.Verify(header, signatureHeader, body))
+	require.ErrorIs(t, protection.Verify(header, signatureHeader, body), errReplayProtectionNonce)
+	require.ErrorIs(t, protection.Verify(header, signatureHeader, []byte("{}")), errReplayProtectionHeader)
+
+	staleHeader, err := createReplayProtectionHeader(signer, body, now.Add(-2*time.Minute))
+	require.NoError(t, err)
+	require.ErrorIs(t, protection.Verify(staleHeader, signatureHeader, body), errReplayProtectionStale)
+
+	otherSigner, err := signature.NewRandomSigner()
+	require.NoError(t, err)
+	otherHeader, err := createReplayProtectionHeader(otherSigner, body, now)
+	require.NoError(t, err)
+	require.ErrorIs(t, protection.Verify(otherHeader, signatureHeader, body), errReplayProtectionHeader)
+}
+
+func TestSignaturePolicy(t *testing.T) {
+	signer, err := signature.NewRandomSigner()
+	require.NoError(t, err)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	body := []byte(`{"jsonrpc":"2.0","id":1,"method":"eth_sendRawTransaction","params":[]}`)
+	serve := func(h http.Handler, headers map[string]string) int {
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	signatureHeader, err := signer.Create(body)
+	require.NoError(t, err)
+	replayHeader, err := createReplayProtectionHeader(signer, body, time.Now())
+	require.NoError(t, err)
+
+	required := NewReplayProtection(SignaturePolicy{RequireReplayProtection: true}, DefaultMaxRequestBodySizeBytes).Handler(handler)
+	require.Equal(t, http.StatusOK, serve(required, map[string]string{signature.HTTPHeader: signatureHeader}))
+	require.Equal(t, http.StatusNoContent, serve(required, map[string]string{signature.HTTPHeader: signatureHeader, ReplayProtectionHeader: replayHeader}))
+
+	optional := optionalSignatureHandler(handler, DefaultMaxRequestBodySizeBytes)
+	require.Equal(t, http.StatusNoContent, serve(optional, nil))
+	require.Equal(t, http.StatusNoContent, serve(optional, map[string]string{signature.HTTPHeader: signatureHeader}))
+	// invalid signature is still rejected
+	otherSignatureHeader, err := signer.Create([]byte("{}"))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, serve(optional, map[string]string{signature.HTTPHeader: otherSignatureHeader}))
+}
+
+func TestPrivilegedSigners(t *testing.T) {
+	partner := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	signers, err := ParsePrivilegedSigners([]string{"partner=" + partner.Hex()})
+	require.NoError(t, err)
+	require.Equal(t, map[common.Address]string{partner: "partner"}, signers)
+
+	_, err = ParsePrivilegedSigners([]string{partner.Hex()})
+	require.ErrorIs(t, err, errPrivilegedSigner)
+
+	prx := &ReceiverProxy{ReceiverProxyConstantConfig: ReceiverProxyConstantConfig{
+		FlashbotsSignerAddress: flashbotsSigner.Address(),
+		PrivilegedSigners:      signers,
+	}}
+	name, ok := prx.privilegedSignerName(partner)
+	require.True(t, ok)
+	require.Equal(t, "partner", name)
+	name, ok = prx.privilegedSignerName(flashbotsSigner.Address())
+	require.True(t, ok)
+	require.Equal(t, FlashbotsPeerName, name)
+	_, ok = prx.privilegedSignerName(common.Address{})
+	require.False(t, ok)
+}
+
+type fakeKMS struct {
+	key   *ecdsa.PrivateKey
+	signs atomic.Int32
+}
+
+func (f *fakeKMS) GetPublicKey(ctx context.Context, params *kms.GetPublicKeyInput, optFns ...func(*kms.Options)) (*kms.GetPublicKeyOutput, error) {
+	spki := struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}},
+		PublicKey: asn1.BitString{Bytes: crypto.FromECDSAPub(&f.key.PublicKey), BitLength: 65 * 8},
+	}
+	der, err := asn1.Marshal(spki)
+	return &kms.GetPublicKeyOutput{PublicKey: der}, err
+}
+
+func (f *fakeKMS) Sign(ctx context.Context, params *kms.SignInput, optFns ...func(*kms.Options)) (*kms.SignOutput, error) {
+	f.signs.Add(1)
+	sig, err := crypto.Sign(params.Message, f.key)
+	if err != nil {
+		return nil, err
+	}
+	// KMS can return high s values
+	s := new(big.Int).SetBytes(sig[32:64])
+	der, err := asn1.Marshal(struct{ R, S *big.Int }{
+		R: new(big.Int).SetBytes(sig[0:32]),
+		S: new(big.Int).Sub(secp256k1N, s),
+	})
+	return &kms.SignOutput{Signature: der}, err
+}
+
+func TestAWSKMSSigner(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	signer, err := newAWSKMSSigner(&fakeKMS{key: key}, "test-key")
+	require.NoError(t, err)
+	require.Equal(t, crypto.PubkeyToAddress(key.PublicKey), signer.Address())
+
+	body := []byte(`{"jsonrpc":"2.0","id":1,"method":"eth_sendRawTransaction","params":[]}`)
+	header, err := signer.Create(body)
+	require.NoError(t, err)
+	address, err := signature.Verify(header, body)
+	require.NoError(t, err)
+	require.Equal(t, signer.Address(), address)
+
+	// same signature format as the in-memory signer
+	keySigner := signature.NewSigner(key)
+	expected, err := keySigner.Create(body)
+	require.NoError(t, err)
+	require.Equal(t, expected, header)
+}
+
+func TestSharedSignatures(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	kmsClient := &fakeKMS{key: key}
+	signer, err := newAWSKMSSigner(kmsClient, "test-key")
+	require.NoError(t, err)
+
+	headers := make(chan http.Header, 3)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header.Clone()
+	})
+	peer1 := httptest.NewServer(handler)
+	defer peer1.Close()
+	peer2 := httptest.NewServer(handler)
+	defer peer2.Close()
+
+	client := HTTPClientWithSigner(http.DefaultClient, newSharedSignatures(signer))
+	body := []byte(`{"jsonrpc":"2.0","id":0,"method":"eth_sendRawTransaction","params":[]}`)
+	send := func(url string) http.Header {
+		resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		return <-headers
+	}
+
+	// body shared with all peers is signed once
+	first := send(peer1.URL)
+	second := send(peer2.URL)
+	require.Equal(t, int32(2), kmsClient.signs.Load())
+	require.Equal(t, first.Get(signature.HTTPHeader), second.Get(signature.HTTPHeader))
+	require.Equal(t, first.Get(ReplayProtectionHeader), second.Get(ReplayProtectionHeader))
+	address, err := signature.Verify(second.Get(signature.HTTPHeader), body)
+	require.NoError(t, err)
+	require.Equal(t, signer.Address(), address)
+
+	// retry to the same peer gets a new replay protection nonce
+	retry := send(peer1.URL)
+	require.Equal(t, int32(4), kmsClient.signs.Load())
+	require.NotEqual(t, first.Get(ReplayProtectionHeader), retry.Get(ReplayProtectionHeader))
+}
+
+func TestRotatingSigner(t *testing.T) {
+	oldSigner, err := signature.NewRandomSigner()
+	require.NoError(t, err)
+	newSigner, err := signature.NewRandomSigner()
+	require.NoError(t, err)
+
+	signer := NewRotatingSigner(oldSigner)
+	signer.setPending(newSigner)
+	// pending signer is not used for signing but is recognized as our own
+	require.Equal(t, oldSigner.Address(), signer.Address())
+	require.True(t, isOwnAddress(signer, newSigner.Address()))
+
+	signer.activatePending()
+	require.Equal(t, newSigner.Address(), signer.Address())
+	require.False(t, isOwnAddress(signer, oldSigner.Address()))
+	header, err := signer.Create([]byte("{}"))
+	require.NoError(t, err)
+	address, err := signature.Verify(header, []byte("{}"))
+	require.NoError(t, err)
+	require.Equal(t, newSigner.Address(), address)
+}
+
+func TestCertRenewal(t *testing.T) {
+	var (
+		registeredMu sync.Mutex
+		registered   []string
+	)
+	hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/register_credentials/orderflow_proxy") {
+			_, _ = w.Write([]byte("[]"))
+			return
+		}
+		var req ConfighubOrderflowProxyCredentials
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		registeredMu.Lock()
+		registered = append(registered, req.TLSCert)
+		registeredMu.Unlock()
+	}))
+	defer hub.Close()
+	config := ReceiverProxyConfig{
+		ReceiverProxyConstantConfig: ReceiverProxyConstantConfig{
+			Log:                    slog.New(slog.NewTextHandler(os.Stdout, nil)),
+			FlashbotsSignerAddress: flashbotsSigner.Address(),
+		},
+		CertValidDuration:        time.Hour,
+		CertHosts:                []string{"localhost", "127.0.0.1"},
+		BuilderConfigHubEndpoint: hub.URL,
+		ArchiveEndpoint:          archiveServer.URL,
+		EthRPC:                   "eth-rpc-not-set",
+		CertRenewBefore:          time.Hour,
+	}
+	_, err := NewReceiverProxy(config)
+	require.ErrorIs(t, err, errCertRenewBefore)
+	config.CertRenewBefore = 0
+	prx, err := NewReceiverProxy(config)
+	require.NoError(t, err)
+	defer prx.Stop()
+
+	server := &http.Server{ //nolint:gosec
+		Handler:   http.NotFoundHandler(),
+		TLSConfig: prx.TLSConfig(),
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go server.ServeTLS(listener, "", "") //nolint:errcheck
+	defer server.Close()
+	handshake := func(certPEM string) error {
+		transport, err := createTransportForSelfSignedCert([]byte(certPEM))
+		require.NoError(t, err)
+		resp, err := (&http.Client{Transport: transport}).Get("https://" + listener.Addr().String())
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	oldPEM := string(prx.PublicCertPEM)
+	served, err := prx.getCertificate(nil)
+	require.NoError(t, err)
+	oldLeaf := served.Leaf
+	require.NoError(t, prx.RenewCertificate(context.Background(), 0))
+	newPEM := string(prx.PublicCertPEM)
+	require.NotEqual(t, oldPEM, newPEM)
+	// certificate returned to a handshake is not changed by the renewal
+	require.Same(t, oldLeaf, served.Leaf)
+	// both certificates are registered before the new one is served, then only the new one
+	registeredMu.Lock()
+	require.Equal(t, []string{newPEM + oldPEM, newPEM}, registered)
+	registeredMu.Unlock()
+	require.NoError(t, handshake(newPEM))
+	require.Error(t, handshake(oldPEM))
+	notAfter, err := prx.certNotAfter()
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now().Add(time.Hour), notAfter, time.Minute)
+}
+
+func TestPeerCertCache(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":0,"result":null}`))
+	}))
+	defer server.Close()
+	serverCertPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+	otherCertPEM, _, err := utils_tls.GenerateTLS(time.Hour, []string{"127.0.0.1"})
+	require.NoError(t, err)
+	builder := func(certPEM string) ConfighubBuilder {
+		return ConfighubBuilder{
+			Name:           "peer",
+			IP:             server.Listener.Addr().String(),
+			OrderflowProxy: ConfighubOrderflowProxyCredentials{TLSCert: certPEM},
+		}
+	}
+
+	log := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	refreshPeers := make(chan struct{}, 1)
+	sq := &ShareQueue{
+		log:          log,
+		signer:       flashbotsSigner,
+		refreshPeers: refreshPeers,
+		certs:        newPeerCertCache(time.Hour),
+		peerSigner:   newSharedSignatures(flashbotsSigner),
+	}
+	verified := make(chan verifiedPeer)
+	done := make(chan struct{})
+	defer close(done)
+	call := shareCall{method: EthSendRawTransactionMethod, data: hexutil.Bytes{1}}
+
+	peers, _ := sq.updatePeerList(nil, []ConfighubBuilder{builder(serverCertPEM)}, 1, verified, done)
+	require.Len(t, peers, 1)
+	require.NoError(t, sq.sendCall(log, peers[0], call))
+	// peer with the same certificate keeps its client and connections
+	updated, _ := sq.updatePeerList(peers, []ConfighubBuilder{builder(serverCertPEM)}, 1, verified, done)
+	require.Len(t, updated, 1)
+	require.Same(t, peers[0], updated[0])
+
+	// certificate rejected by the peer is dropped and peers are fetched again
+	peers, _ = sq.updatePeerList(updated, []ConfighubBuilder{builder(string(otherCertPEM))}, 1, verified, done)
+	require.Len(t, peers, 1)
+	require.NotSame(t, updated[0], peers[0])
+	err = sq.sendCall(log, peers[0], call)
+	require.True(t, isCertificateError(err))
+	_, ok := sq.certs.get(newPeerCertKey("peer", builder(string(otherCertPEM)).OrderflowProxy))
+	require.False(t, ok)
+	require.Len(t, refreshPeers, 1)
+	peers[0].Close()
+}
+
+func TestPeerCertFingerprints(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":0,"result":null}`))
+	}))
+	defer server.Close()
+	serverCertPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+	builder := func(fingerprints ...string) ConfighubBuilder {
+		return ConfighubBuilder{
+			Name: "peer",
+			IP:   server.Listener.Addr().String(),
+			OrderflowProxy: ConfighubOrderflowProxyCredentials{
+				TLSCert:             serverCertPEM,
+				TLSCertFingerprints: fingerprints,
+			},
+		}
+	}
+	log := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	sq := &ShareQueue{log: log, signer: flashbotsSigner, certs: newPeerCertCache(time.Hour), peerSigner: newSharedSignatures(flashbotsSigner)}
+	verified := make(chan verifiedPeer)
+	done := make(chan struct{})
+	defer close(done)
+	call := shareCall{method: EthSendRawTransactionMethod, data: hexutil.Bytes{1}}
+
+	// fingerprint can be formatted with colons and upper case
+	fingerprint := CertFingerprint(server.Certificate())
+	var formatted []string
+	for i := 0; i < len(fingerprint); i += 2 {
+		formatted = append(formatted, strings.ToUpper(fingerprint[i:i+2]))
+	}
+	peers, _ := sq.updatePeerList(nil, []ConfighubBuilder{builder(strings.Join(formatted, ":"))}, 1, verified, done)
+	require.Len(t, peers, 1)
+	require.NoError(t, sq.sendCall(log, peers[0], call))
+
+	// peer with certificate that is not registered is not used
+	mismatches := peerCertFingerprintMismatches.Get()
+	peers, _ = sq.updatePeerList(peers, []ConfighubBuilder{builder(hex.EncodeToString(make([]byte, 32)))}, 1, verified, done)
+	require.Empty(t, peers)
+	require.Equal(t, mismatches+1, peerCertFingerprintMismatches.Get())
+
+	// certificate served by the peer is checked too
+	transport, err := peerTransport(builder(fingerprint), 1)
+	require.NoError(t, err)
+	require.NoError(t, transport.TLSClientConfig.VerifyConnection(tls.ConnectionState{PeerCertificates: []*x509.Certificate{server.Certificate()}}))
+	otherCertPEM, _, err := utils_tls.GenerateTLS(time.Hour, []string{"127.0.0.1"})
+	require.NoError(t, err)
+	block, _ := pem.Decode(otherCertPEM)
+	otherCert, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err)
+	err = transport.TLSClientConfig.VerifyConnection(tls.ConnectionState{PeerCertificates: []*x509.Certificate{otherCert}})
+	require.ErrorIs(t, err, errPeerCertFingerprint)
+	require.True(t, isCertificateError(err))
+}
+
+func TestPreviousPeerAddresses(t *testing.T) {
+	oldAddress := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	newAddress := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	peer := func(address common.Address) ConfighubBuilder {
+		return ConfighubBuilder{Name: "peer", OrderflowProxy: ConfighubOrderflowProxyCredentials{EcdsaPubkeyAddress: address}}
+	}
+	prx := &ReceiverProxy{
+		ReceiverProxyConstantConfig: ReceiverProxyConstantConfig{Log: slog.New(slog.NewTextHandler(os.Stdout, nil))},
+		lastFetchedPeers:            []ConfighubBuilder{peer(oldAddress)},
+		signerRotationGracePeriod:   time.Minute,
+	}
+	now := time.Now()
+	prx.updatePreviousPeerAddresses([]ConfighubBuilder{peer(newAddress)}, now)
+	prx.lastFetchedPeers = []ConfighubBuilder{peer(newAddress)}
+
+	previous, ok := prx.previousPeerAddresses[oldAddress]
+	require.True(t, ok)
+	require.Equal(t, "peer", previous.name)
+	require.Equal(t, now.Add(time.Minute), previous.expiresAt)
+
+	// expired addresses are removed on the next update
+	prx.updatePreviousPeerAddresses([]ConfighubBuilder{peer(newAddress)}, now.Add(2*time.Minute))
+	require.Empty(t, prx.previousPeerAddresses)
+}
+
+func TestReceiverPoolFailover(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	received := make(chan *RequestData, 10)
+	working := ServeHTTPRequestToChan(received)
+	defer working.Close()
+
+	signer, err := signature.NewRandomSigner()
+	require.NoError(t, err)
+	log := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	pool := newReceiverPool(log, []string{failing.URL, working.URL}, signer, 1, 0)
+
+	for range 4 {
+		args := rpctypes.EthSendRawTransactionArgs(*createTestTx(0))
+		require.NoError(t, pool.Send(EthSendRawTransactionMethod, &args))
+		expectRequest(t, received)
+	}
+	require.False(t, pool.receivers[0].healthy.Load())
+	require.True(t, pool.receivers[1].healthy.Load())
+
+	working.Close()
+	args := rpctypes.EthSendRawTransactionArgs(*createTestTx(0))
+	require.ErrorIs(t, pool.Send(EthSendRawTransactionMethod, &args), errNoReceiverAvailable)
+}
+
+func TestReceiverPoolDiscovery(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	pool := newReceiverPool(log, nil, flashbotsSigner, 1, 0)
+	args := rpctypes.EthSendRawTransactionArgs(*createTestTx(0))
+	require.ErrorIs(t, pool.Send(EthSendRawTransactionMethod, &args), errNoReceiverAvailable)
+
+	builder := ConfighubBuilder{
+		Name: "receiver",
+		IP:   proxies[0].ip,
+		OrderflowProxy: ConfighubOrderflowProxyCredentials{
+			TLSCert: string(proxies[0].proxy.PublicCertPEM),
+		},
+	}
+	pool.UpdateFromBuilders([]ConfighubBuilder{builder})
+	receiver := pool.currentReceivers()[0]
+	pool.UpdateFromBuilders([]ConfighubBuilder{builder})
+	require.Same(t, receiver, pool.currentReceivers()[0])
+
+	require.NoError(t, pool.Send(EthSendRawTransactionMethod, &args))
+	expectRequest(t, proxies[0].localBuilderRequests)
+}
+
+func TestReceiverPoolFallbackRelay(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	relayRequests := make(chan *RequestData, 10)
+	relay := ServeHTTPRequestToChan(relayRequests)
+	defer relay.Close()
+
+	log := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	pool := newReceiverPool(log, []string{failing.URL}, flashbotsSigner, 1, 0)
+	pool.setFallbackRelay(relay.URL)
+
+	args := rpctypes.EthSendRawTransactionArgs(*createTestTx(0))
+	require.NoError(t, pool.Send(EthSendRawTransactionMethod, &args))
+	request := expectRequest(t, relayRequests)
+	signer, err := signature.Verify(request.request.Header.Get(signature.HTTPHeader), []byte(request.body))
+	require.NoError(t, err)
+	require.Equal(t, flashbotsSigner.Address(), signer)
+
+	relay.Close()
+	require.ErrorIs(t, pool.Send(EthSendRawTransactionMethod, &args), errNoReceiverAvailable)
+
+	_, err = NewSenderProxy(SenderProxyConfig{
+		SenderProxyConstantConfig: SenderProxyConstantConfig{Log: log, OrderflowSigner: flashbotsSigner},
+		BuilderConfigHubEndpoint:  builderHub.URL,
+		FallbackRelayEndpoint:     relay.URL,
+	})
+	require.ErrorIs(t, err, errFallbackRelayMode)
+}
+
+func TestRetriesWithDeadLetter(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	path := filepath.Join(t.TempDir(), "dead-letter.jsonl")
+	log := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	deadLetter, err := NewDeadLetterQueue(log, path)
+	require.NoError(t, err)
+
+	signer, err := signature.NewRandomSigner()
+	require.NoError(t, err)
+	pool := newReceiverPool(log, []string{failing.URL}, signer, 1, 0)
+	pool.retry = RetryPolicy{MaxRetries: 2, Backoff: time.Millisecond}
+
+	calls := 0
+	err = pool.retry.Do(func() error {
+		calls++
+		return pool.send(EthSendRawTransactionMethod, "0x01")
+	})
+	require.ErrorIs(t, err, errNoReceiverAvailable)
+	require.Equal(t, 3, calls)
+
+	deadLetter.Record(failing.URL, EthSendRawTransactionMethod, "0x01", err)
+	require.NoError(t, deadLetter.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var record DeadLetter
+	require.NoError(t, json.Unmarshal(data, &record))
+	require.Equal(t, EthSendRawTransactionMethod, record.Method)
+	require.Equal(t, "0x01", record.Params)
+	require.Equal(t, errNoReceiverAvailable.Error(), record.Error)
+}
+
+func TestReceiverPoolRateLimit(t *testing.T) {
+	received := make(chan *RequestData, 10)
+	receiver := ServeHTTPRequestToChan(received)
+	defer receiver.Close()
+
+	signer, err := signature.NewRandomSigner()
+	require.NoError(t, err)
+	log := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	pool := newReceiverPool(log, []string{receiver.URL}, signer, 1, 5)
+
+	start := time.Now()
+	for range 7 {
+		args := rpctypes.EthSendRawTransactionArgs(*createTestTx(0))
+		require.NoError(t, pool.Send(EthSendRawTransactionMethod, &args))
+		expectRequest(t, received)
+	}
+	// burst of 5 requests is allowed, 2 more requests have to wait for 200ms each
+	require.GreaterOrEqual(t, time.Since(start), 350*time.Millisecond)
+}
+
+type fakeQuoteProvider struct {
+	mrtd byte
+}
+
+func (p *fakeQuoteProvider) Quote(reportData [64]byte) ([]byte, error) {
+	quote := make([]byte, tdxQuoteMinSizeBytes+64)
+	binary.LittleEndian.PutUint16(quote[0:2], tdxQuoteVersion)
+	binary.LittleEndian.PutUint32(quote[4:8], tdxQuoteTeeType)
+	copy(quote[tdxReportMRTDOffset:], bytes.Repeat([]byte{p.mrtd}, tdxMeasurementSize))
+	copy(quote[tdxReportDataOffset:], reportData[:])
+	return quote, nil
+}
+
+type fakeQuoteVerifier struct {
+	calls int
+}
+
+func (v *fakeQuoteVerifier) VerifyQuote(ctx context.Context, quote []byte) error {
+	v.calls++
+	return nil
+}
+
+func TestAttestationVerifier(t *testing.T) {
+	prx := &ReceiverProxy{
+		ReceiverProxyConstantConfig: ReceiverProxyConstantConfig{Log: slog.New(slog.NewTextHandler(os.Stdout, nil))},
+		quoteProvider:               &fakeQuoteProvider{mrtd: 1},
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(prx.serveAttestation))
+	server.StartTLS()
+	defer server.Close()
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	prx.PublicCertPEM = certPEM
+
+	allowed := TDXMeasurements{MRTD: hex.EncodeToString(bytes.Repeat([]byte{1}, tdxMeasurementSize))}
+	quoteVerifier := &fakeQuoteVerifier{}
+	verifier, err := NewAttestationVerifier(quoteVerifier, []TDXMeasurements{allowed})
+	require.NoError(t, err)
+	require.NoError(t, verifier.Verify(server.URL, certPEM))
+	require.Equal(t, 1, quoteVerifier.calls)
+
+	// verified certificates are cached
+	require.NoError(t, verifier.Verify(server.URL, certPEM))
+	require.Equal(t, 1, quoteVerifier.calls)
+
+	// measurements are not in the allowlist
+	other := TDXMeasurements{MRTD: hex.EncodeToString(bytes.Repeat([]byte{2}, tdxMeasurementSize))}
+	verifier, err = NewAttestationVerifier(quoteVerifier, []TDXMeasurements{other})
+	require.NoError(t, err)
+	require.ErrorIs(t, verifier.Verify(server.URL, certPEM), errQuoteMeasurements)
+
+	// quote is bound to another certificate
+	prx.attestationQuote, err = (&fakeQuoteProvider{mrtd: 1}).Quote(certReportData([]byte("other cert")))
+	require.NoError(t, err)
+	verifier, err = NewAttestationVerifier(quoteVerifier, []TDXMeasurements{allowed})
+	require.NoError(t, err)
+	require.ErrorIs(t, verifier.Verify(server.URL, certPEM), errQuoteReportData)
+}
+
+type fakeNewHeadsService struct{}
+
+func (s *fakeNewHeadsService) NewHeads(ctx context.Context) (*rpc.Subscription, error) {
+	notifier, _ := rpc.NotifierFromContext(ctx)
+	sub := notifier.CreateSubscription()
+	_ = notifier.Notify(sub.ID, newHead{Number: 100})
+	return sub, nil
+}
+
+func TestBlockNumberNewHeadsSubscription(t *testing.T) {
+	rpcServer := rpc.NewServer()
+	require.NoError(t, rpcServer.RegisterName("eth", &fakeNewHeadsService{}))
+	defer rpcServer.Stop()
+	wsServer := httptest.NewServer(rpcServer.WebsocketHandler([]string{"*"}))
+	defer wsServer.Close()
+
+	bs := NewBlockNumberSource("http://127.0.0.1:1")
+	closeCh := make(chan struct{})
+	defer close(closeCh)
+	go bs.RunNewHeadsSubscription(slog.New(slog.NewTextHandler(os.Stdout, nil)), "ws"+strings.TrimPrefix(wsServer.URL, "http"), closeCh)
+
+	require.Eventually(t, bs.subscribed.Load, time.Second*5, time.Millisecond*10)
+	// polling endpoint is not available so number can only come from the subscription
+	number, err := bs.BlockNumber()
+	require.NoError(t, err)
+	require.Equal(t, uint64(100), number)
+}
+
+func TestBlockNumberFallback(t *testing.T) {
+	primaryDown := atomic.Bool{}
+	primaryDown.Store(true)
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if primaryDown.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x2"}`))
+	}))
+	defer primary.Close()
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	defer fallback.Close()
+
+	bs := NewBlockNumberSource(primary.URL, fallback.URL)
+	require.NoError(t, bs.UpdateCachedBlockNumber())
+	number, _ := bs.CachedBlockNumber()
+	require.Equal(t, uint64(1), number)
+	require.False(t, bs.endpoints[0].healthy.Load())
+
+	// unhealthy primary is tried after the healthy fallback
+	primaryDown.Store(false)
+	require.NoError(t, bs.UpdateCachedBlockNumber())
+	number, _ = bs.CachedBlockNumber()
+	require.Equal(t, uint64(1), number)
+
+	fallback.Close()
+	require.NoError(t, bs.UpdateCachedBlockNumber())
+	number, _ = bs.CachedBlockNumber()
+	require.Equal(t, uint64(2), number)
+	require.True(t, bs.endpoints[0].healthy.Load())
+	require.False(t, bs.endpoints[1].healthy.Load())
+
+	// primary is used again after it recovers and retry interval passes
+	retryInterval := blockNumberEndpointRetryInterval
+	blockNumberEndpointRetryInterval = 0
+	defer func() { blockNumberEndpointRetryInterval = retryInterval }()
+	bs.endpoints[0].healthy.Store(false)
+	require.NoError(t, bs.UpdateCachedBlockNumber())
+	number, _ = bs.CachedBlockNumber()
+	require.Equal(t, uint64(2), number)
+}
+
+func TestBlockNumberHeadAge(t *testing.T) {
+	var head atomic.Uint64
+	head.Store(1)
+	ethRPC := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, `{"jsonrpc":"2.0","id":1,"result":"0x%x"}`, head.Load())
+	}))
+	defer ethRPC.Close()
+
+	fresh := NewBlockNumberSource(ethRPC.URL)
+	fresh.cacheTTL = time.Millisecond * 10
+	stale := NewBlockNumberSource("http://127.0.0.1:1")
+	require.Zero(t, fresh.headAgeSeconds())
+
+	// cache is refreshed without requests, head age of each source is tracked separately
+	closeCh := make(chan struct{})
+	defer close(closeCh)
+	go fresh.RunRefresh(closeCh)
+	require.Eventually(t, func() bool {
+		number, ok := fresh.CachedBlockNumber()
+		return ok && number == 1
+	}, time.Second, time.Millisecond*10)
+	stale.setCachedBlockNumber(1)
+	stale.headUpdatedAt.Store(time.Now().Add(-time.Minute).UnixNano())
+	require.GreaterOrEqual(t, stale.headAgeSeconds(), float64(60))
+	require.Less(t, fresh.headAgeSeconds(), float64(1))
+
+	// head age grows while the node returns the same block
+	updatedAt := fresh.headUpdatedAt.Load()
+	time.Sleep(time.Millisecond * 50)
+	require.Equal(t, updatedAt, fresh.headUpdatedAt.Load())
+	head.Store(2)
+	require.Eventually(t, func() bool {
+		return fresh.headUpdatedAt.Load() > updatedAt
+	}, time.Second, time.Millisecond*10)
+
+	// failed update is counted
+	updateErrors := blockNumberUpdateErrors.Get()
+	require.Error(t, stale.UpdateCachedBlockNumber())
+	require.Greater(t, blockNumberUpdateErrors.Get(), updateErrors)
+}
+
+func TestBlockNumberCacheTTLConfig(t *testing.T) {
+	prx := createProxy("", "", func(config *ReceiverProxyConfig) {
+		config.ArchiveEndpoint = "archive-not-set"
+		config.BlockNumberCacheTTL = time.Second * 7
+	})
+	defer prx.Stop()
+	require.Equal(t, time.Second*7, prx.blockNumberSource.cacheTTL)
+}
+
+func TestBlockNumberHungEndpoint(t *testing.T) {
+	requestTimeout := blockNumberRequestTimeout
+	blockNumberRequestTimeout = time.Millisecond * 50
+	defer func() { blockNumberRequestTimeout = requestTimeout }()
+
+	release := make(chan struct{})
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer hung.Close()
+	defer close(release)
+	var fallbackCalls atomic.Int32
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fallbackCalls.Add(1)
+		time.Sleep(time.Millisecond * 20)
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	defer fallback.Close()
+
+	bs := NewBlockNumberSource(hung.URL, fallback.URL)
+	// concurrent callers with stale cache share one refresh
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			number, err := bs.BlockNumber()
+			assert.NoError(t, err)
+			assert.Equal(t, uint64(1), number)
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, int32(1), fallbackCalls.Load())
+	require.False(t, bs.endpoints[0].healthy.Load())
+}
+
+func TestClassifyRPCError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/unavailable":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case "/slow":
+			time.Sleep(100 * time.Millisecond)
+		default:
+			_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"bundle rejected"}}`))
+		}
+	}))
+	defer server.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	call := func(url string, timeout time.Duration) error {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		resp, err := rpcclient.NewClient(url).Call(ctx, EthSendBundleMethod)
+		if err != nil {
+			return err
+		}
+		return resp.Error
+	}
+
+	for _, tc := range []struct {
+		url   string
+		class string
+		code  string
+	}{
+		{server.URL + "/rejected", rpcErrorClassJSONRPC, "-32000"},
+		{server.URL + "/unavailable", rpcErrorClassHTTP5xx, "503"},
+		{server.URL + "/slow", rpcErrorClassTimeout, ""},
+		{closed.URL, rpcErrorClassConnectionRefused, ""},
+	} {
+		class, code := classifyRPCError(call(tc.url, 50*time.Millisecond))
+		require.Equal(t, tc.class, class, tc.url)
+		require.Equal(t, tc.code, code, tc.url)
+	}
+}
+
+func TestEnqueueRequestBackpressure(t *testing.T) {
+	first, second := &ParsedRequest{method: "first"}, &ParsedRequest{method: "second"}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	queue := make(chan *ParsedRequest, 1)
+	require.NoError(t, enqueueRequest(ctx, queue, first, BackpressureBlock, queueNameShare, nil))
+	require.ErrorIs(t, enqueueRequest(ctx, queue, second, BackpressureBlock, queueNameShare, nil), errOverloaded)
+	require.ErrorIs(t, enqueueRequest(ctx, queue, second, BackpressureReject, queueNameShare, nil), errOverloaded)
+	require.Same(t, first, <-queue)
+
+	require.NoError(t, enqueueRequest(ctx, queue, first, BackpressureDropOldest, queueNameShare, nil))
+	require.NoError(t, enqueueRequest(ctx, queue, second, BackpressureDropOldest, queueNameShare, nil))
+	require.Same(t, second, <-queue)
+
+	// evicted requests are reported
+	var evicted []*ParsedRequest
+	require.NoError(t, enqueueRequest(ctx, queue, second, BackpressureDropOldest, queueNameShare, nil))
+	require.NoError(t, enqueueRequest(ctx, queue, first, BackpressureDropOldest, queueNameShare, func(req *ParsedRequest) {
+		evicted = append(evicted, req)
+	}))
+	require.Equal(t, []*ParsedRequest{second}, evicted)
+	require.Same(t, first, <-queue)
+
+	// full queue of the share worker evicts the oldest request too
+	peer := newShareQueuePeer("test", nil, 1, 1)
+	defer peer.Close()
+	peer.dropOldest = true
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	peer.SendRequest(log, first)
+	peer.SendRequest(log, second)
+	req, _ := peer.nextRequest(shareQueueLaneLocal, 0)
+	require.Same(t, second, req)
+	require.Equal(t, uint64(1), metrics.GetOrCreateCounter(fmt.Sprintf(shareQueuePeerEvictedLabel, "test")).Get())
+
+	require.ErrorIs(t, validateBackpressurePolicy("unknown"), errUnknownBackpressurePolicy)
+}
+
+func TestReceiverPoolTimeout(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+	}))
+	defer slow.Close()
+
+	signer, err := signature.NewRandomSigner()
+	require.NoError(t, err)
+	log := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	pool := newReceiverPool(log, []string{slow.URL}, signer, 1, 0)
+	pool.timeout = 50 * time.Millisecond
+
+	start := time.Now()
+	args := rpctypes.EthSendRawTransactionArgs(*createTestTx(0))
+	require.ErrorIs(t, pool.Send(EthSendRawTransactionMethod, &args), errNoReceiverAvailable)
+	require.Less(t, time.Since(start), 400*time.Millisecond)
+}
+
+func TestAuthenticatedBuilderRequests(t *testing.T) {
+	builderRequests := make(chan *RequestData, 1)
+	builder := ServeHTTPRequestToChan(builderRequests)
+	defer builder.Close()
+
+	prx := createProxy(builder.URL, "", func(config *ReceiverProxyConfig) {
+		config.BuilderAuthToken = "secret"
+		config.SignBuilderRequests = true
+	})
+	defer prx.Stop()
+
+	_, err := prx.localBuilder.Call(context.Background(), EthSendBundleMethod)
+	require.NoError(t, err)
+	req := expectRequest(t, builderRequests)
+	require.Equal(t, "Bearer secret", req.request.Header.Get("Authorization"))
+	signer, err := signature.Verify(req.request.Header.Get(signature.HTTPHeader), []byte(req.body))
+	require.NoError(t, err)
+	require.Equal(t, prx.OrderflowSigner.Address(), signer)
+}
+
+func TestOrderflowProxyURLFromIP(t *testing.T) {
+	for ip, expected := range map[string]string{
+		"1.2.3.4":                  "https://1.2.3.4:5544",
+		"1.2.3.4:8080":             "https://1.2.3.4:8080",
+		"::1":                      "https://[::1]:5544",
+		"[2001:db8::1]":            "https://[2001:db8::1]:5544",
+		"[2001:db8::1]:8080":       "https://[2001:db8::1]:8080",
+		"builder.example.com":      "https://builder.example.com:5544",
+		"builder.example.com:8080": "https://builder.example.com:8080",
+		"https://builder.example":  "https://builder.example",
+	} {
+		require.Equal(t, expected, OrderflowProxyURLFromIP(ip), ip)
+	}
+
+	require.Equal(t,
+		[]string{"1.2.3.4", "2001:db8::1", "2001:db8::1", "builder.example.com", "builder.example.com"},
+		CertHostsForSANs([]string{"1.2.3.4", "[2001:db8::1]", "[2001:db8::1]:5544", "builder.example.com", "https://builder.example.com:5544"}),
+	)
+}
+
+func TestConfighubBuilderOrderflowProxyURL(t *testing.T) {
+	builder := ConfighubBuilder{IP: "1.2.3.4", PublicPort: 8080}
+	require.Equal(t, "https://1.2.3.4:8080", builder.OrderflowProxyURL())
+	builder.IP = "1.2.3.4:9090"
+	require.Equal(t, "https://1.2.3.4:9090", builder.OrderflowProxyURL())
+	builder = ConfighubBuilder{IP: "2001:db8::1"}
+	require.Equal(t, "https://[2001:db8::1]:5544", builder.OrderflowProxyURL())
+}
+
+func TestLocalTenants(t *testing.T) {
+	tenants, err := ParseLocalTenants([]string{"holesky=http://127.0.0.1:1"})
+	require.NoError(t, err)
+	require.Equal(t, []LocalTenant{{Name: "holesky", BuilderEndpoint: "http://127.0.0.1:1"}}, tenants)
+	_, err = ParseLocalTenants([]string{"a/b=http://127.0.0.1:1"})
+	require.ErrorIs(t, err, errLocalTenant)
+
+	builderRequests := make(chan *RequestData, 1)
+	builder := ServeHTTPRequestToChan(builderRequests)
+	defer builder.Close()
+	tenantRequests := make(chan *RequestData, 1)
+	tenantBuilder := ServeHTTPRequestToChan(tenantRequests)
+	defer tenantBuilder.Close()
+	archive := ServeHTTPRequestToChan(make(chan *RequestData, 10))
+	defer archive.Close()
+
+	prx := createProxy(builder.URL, "", func(config *ReceiverProxyConfig) {
+		config.ArchiveEndpoint = archive.URL
+		config.LocalTenants = []LocalTenant{{Name: "tenant", BuilderEndpoint: tenantBuilder.URL}}
+	})
+	defer prx.Stop()
+	localServer := httptest.NewServer(prx.LocalHandler)
+	defer localServer.Close()
+
+	signer, err := signature.NewRandomSigner()
+	require.NoError(t, err)
+	send := func(path string, blockNumber uint64) {
+		client := rpcclient.NewClientWithOpts(localServer.URL+path, &rpcclient.RPCClientOpts{
+			HTTPClient: HTTPClientWithSigner(&http.Client{}, signer),
+		})
+		resp, err := client.Call(context.Background(), EthSendBundleMethod, &rpctypes.EthSendBundleArgs{BlockNumber: rpc.BlockNumber(blockNumber)})
+		require.NoError(t, err)
+		require.Nil(t, resp.Error)
+	}
+
+	send("/tenant", 1000)
+	expectRequest(t, tenantRequests)
+	expectNoRequest(t, builderRequests)
+
+	send("/", 1001)
+	expectRequest(t, builderRequests)
+	expectNoRequest(t, tenantRequests)
+}
+
+func TestBlockRangeDoesNotWaitForRPC(t *testing.T) {
+	var hung atomic.Bool
+	hung.Store(true)
+	release := make(chan struct{})
+	ethRPC := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hung.Load() {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+			return
+		}
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x64"}`))
+	}))
+	defer ethRPC.Close()
+	defer close(release)
+	prx := &ReceiverProxy{
+		ReceiverProxyConstantConfig: ReceiverProxyConstantConfig{
+			Log:        slog.New(slog.NewTextHandler(io.Discard, nil)),
+			BlockRange: BlockRangeOpts{MaxPastBlocks: 10},
+		},
+		blockNumberSource: NewBlockNumberSource(ethRPC.URL),
+	}
+
+	// request is accepted without waiting for the hung node
+	start := time.Now()
+	require.NoError(t, prx.validateBlockRange(1, 0))
+	require.Less(t, time.Since(start), time.Millisecond*100)
+
+	hung.Store(false)
+	require.NoError(t, prx.blockNumberSource.UpdateCachedBlockNumber())
+	require.ErrorIs(t, prx.validateBlockRange(1, 0), errBlockRangePast)
+	require.NoError(t, prx.validateBlockRange(100, 0))
+}
+
+func TestRawTxToBundle(t *testing.T) {
+	ethRPC := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x10"}`))
+	}))
+	defer ethRPC.Close()
+	builderRequests := make(chan *RequestData, 1)
+	builder := ServeHTTPRequestToChan(builderRequests)
+	defer builder.Close()
+
+	queue := make(chan *ParsedRequest)
+	updatePeers := make(chan []ConfighubBuilder)
+	sq := ShareQueue{
+		log:               slog.New(slog.NewTextHandler(os.Stdout, nil)),
+		queue:             queue,
+		updatePeers:       updatePeers,
+		localBuilder:      rpcclient.NewClient(builder.URL),
+		blockNumberSource: NewBlockNumberSource(ethRPC.URL),
+		rawTxToBundle:     true,
+	}
+	go sq.Run()
+	defer close(queue)
+
+	// raw transaction is sent as is until the head is cached instead of waiting for the RPC node
+	tx := createTestTx(0)
+	rawTx := rpctypes.EthSendRawTransactionArgs(*tx)
+	queue <- &ParsedRequest{ethSendRawTransaction: &rawTx, receivedAt: time.Now()}
+	req := expectRequest(t, builderRequests)
+	require.Contains(t, req.body, EthSendRawTransactionMethod)
+
+	require.NoError(t, sq.blockNumberSource.UpdateCachedBlockNumber())
+	queue <- &ParsedRequest{ethSendRawTransaction: &rawTx, receivedAt: time.Now()}
+	req = expectRequest(t, builderRequests)
+	var decoded types.Transaction
+	require.NoError(t, decoded.UnmarshalBinary(*tx))
+	expected := fmt.Sprintf(`{"method":"eth_sendBundle","params":[{"txs":["%s"],"blockNumber":"0x11","revertingTxHashes":["%s"]}],"id":0,"jsonrpc":"2.0"}`, tx.String(), decoded.Hash().Hex())
+	require.Equal(t, expected, req.body)
+}
+
+func TestShareQueueDropsStaleRequests(t *testing.T) {
+	builderRequests := make(chan *RequestData, 1)
+	builder := ServeHTTPRequestToChan(builderRequests)
+	defer builder.Close()
+
+	queue := make(chan *ParsedRequest)
+	sq := ShareQueue{
+		log:               slog.New(slog.NewTextHandler(io.Discard, nil)),
+		queue:             queue,
+		updatePeers:       make(chan []ConfighubBuilder),
+		localBuilder:      rpcclient.NewClient(builder.URL),
+		blockNumberSource: NewBlockNumberSource(),
+	}
+	sq.blockNumberSource.setCachedBlockNumber(100)
+	go sq.Run()
+	defer close(queue)
+
+	// bundle for the block that is already built is not delivered
+	staleDropped := metrics.GetOrCreateCounter(fmt.Sprintf(shareQueuePeerStaleDroppedLabel, localBuilderPeerName))
+	staleDroppedBefore := staleDropped.Get()
+	queue <- &ParsedRequest{ethSendBundle: &rpctypes.EthSendBundleArgs{BlockNumber: 100}, receivedAt: time.Now()}
+	expectNoRequest(t, builderRequests)
+	require.Equal(t, staleDroppedBefore+1, staleDropped.Get())
+
+	queue <- &ParsedRequest{ethSendBundle: &rpctypes.EthSendBundleArgs{BlockNumber: 101}, receivedAt: time.Now()}
+	req := expectRequest(t, builderRequests)
+	require.Contains(t, req.body, `"blockNumber":"0x65"`)
+	require.Equal(t, staleDroppedBefore+1, staleDropped.Get())
+}
+
+type fakePendingTransactionsService struct {
+	tx *types.Transaction
+}
+
+func (s *fakePendingTransactionsService) NewPendingTransactions(ctx context.Context, fullTx *bool) (*rpc.Subscription, error) {
+	notifier, _ := rpc.NotifierFromContext(ctx)
+	sub := notifier.CreateSubscription()
+	_ = notifier.Notify(sub.ID, s.tx)
+	return sub, nil
+}
+
+func TestMempoolIngestion(t *testing.T) {
+	tx := createTestTx(1)
+	var decoded types.Transaction
+	require.NoError(t, decoded.UnmarshalBinary(*tx))
+
+	rpcServer := rpc.NewServer()
+	require.NoError(t, rpcServer.RegisterName("eth", &fakePendingTransactionsService{tx: &decoded}))
+	defer rpcServer.Stop()
+	wsServer := httptest.NewServer(rpcServer.WebsocketHandler([]string{"*"}))
+	defer wsServer.Close()
+
+	builderRequests := make(chan *RequestData, 1)
+	builder := ServeHTTPRequestToChan(builderRequests)
+	defer builder.Close()
+
+	prx := createProxy(builder.URL, "", func(config *ReceiverProxyConfig) {
+		config.MempoolWSRPC = "ws" + strings.TrimPrefix(wsServer.URL, "http")
+	})
+	defer prx.Stop()
+
+	req := expectRequest(t, builderRequests)
+	require.Equal(t, fmt.Sprintf(`{"method":"eth_sendRawTransaction","params":["%s"],"id":0,"jsonrpc":"2.0"}`, tx.String()), req.body)
+
+	// transaction that was already seen is not sent again
+	rawTx := rpctypes.EthSendRawTransactionArgs(*tx)
+	uniqueKey := rawTx.UniqueKey()
+	require.True(t, prx.requestUniqueKeysRLU.Contains(uniqueKey))
+	require.NoError(t, prx.ingestMempoolTransaction(&decoded))
+	select {
+	case <-builderRequests:
+		t.Fatal("duplicate mempool transaction was sent to the builder")
+	case <-time.After(time.Millisecond * 100):
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	builder := ServeHTTPRequestToChan(make(chan *RequestData, 10))
+	defer builder.Close()
+	archive := ServeHTTPRequestToChan(make(chan *RequestData, 10))
+	defer archive.Close()
+
+	prx := createProxy(builder.URL, "", func(config *ReceiverProxyConfig) {
+		config.ArchiveEndpoint = archive.URL
+	})
+	defer prx.Stop()
+	eventsServer := httptest.NewServer(prx.EventsHandler)
+	defer eventsServer.Close()
+
+	resp, err := http.Get(eventsServer.URL) //nolint:noctx
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	require.Eventually(t, func() bool { return activeEventSubscribers.Load() > 0 }, time.Second, time.Millisecond*10)
+
+	signer, err := signature.NewRandomSigner()
+	require.NoError(t, err)
+	localServer := httptest.NewServer(prx.LocalHandler)
+	defer localServer.Close()
+	client := rpcclient.NewClientWithOpts(localServer.URL, &rpcclient.RPCClientOpts{
+		HTTPClient: HTTPClientWithSigner(&http.Client{}, signer),
+	})
+	tx := createTestTx(2)
+	rpcResp, err := client.Call(context.Background(), EthSendBundleMethod, &rpctypes.EthSendBundleArgs{Txs: []hexutil.Bytes{*tx}, BlockNumber: 1100})
+	require.NoError(t, err)
+	require.Nil(t, rpcResp.Error)
+
+	reader := bufio.NewReader(resp.Body)
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(line, "data: "))
+	var event OrderflowEvent
+	require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event))
+
+	var decoded types.Transaction
+	require.NoError(t, decoded.UnmarshalBinary(*tx))
+	require.Equal(t, EthSendBundleMethod, event.Method)
+	require.Equal(t, signer.Address(), event.Signer)
+	require.Equal(t, uint64(1100), event.BlockNumber)
+	require.Equal(t, []common.Hash{decoded.Hash()}, event.TxHashes)
+}
+
+func TestEventFilter(t *testing.T) {
+	signer := common.HexToAddress("0x9349365494be4f6205e5d44bdc7ec7dcd134becf")
+	filter, err := ParseEventFilter(url.Values{
+		"method":    {EthSendBundleMethod, MevSendBundleMethod},
+		"signer":    {signer.Hex()},
+		"fromBlock": {"100"},
+		"toBlock":   {"110"},
+	})
+	require.NoError(t, err)
+
+	event := &OrderflowEvent{Method: EthSendBundleMethod, Signer: signer, PeerName: "peer", BlockNumber: 105}
+	require.True(t, filter.Matches(event))
+	// bundle targeting range that overlaps the filter
+	require.True(t, filter.Matches(&OrderflowEvent{Method: MevSendBundleMethod, Signer: signer, BlockNumber: 90, MaxBlock: 100}))
+	require.False(t, filter.Matches(&OrderflowEvent{Method: EthSendBundleMethod, Signer: signer, BlockNumber: 111}))
+	require.False(t, filter.Matches(&OrderflowEvent{Method: EthSendRawTransactionMethod, Signer: signer}))
+	require.False(t, filter.Matches(&OrderflowEvent{Method: EthSendBundleMethod, BlockNumber: 105}))
+
+	peerFilter, err := ParseEventFilter(url.Values{"peer": {"other"}})
+	require.NoError(t, err)
+	require.False(t, peerFilter.Matches(event))
+	emptyFilter, err := ParseEventFilter(url.Values{})
+	require.NoError(t, err)
+	require.True(t, emptyFilter.Matches(event))
+
+	for _, query := range []url.Values{
+		{"signer": {"not-an-address"}},
+		{"fromBlock": {"abc"}},
+		{"fromBlock": {"10"}, "toBlock": {"5"}},
+	} {
+		_, err = ParseEventFilter(query)
+		require.ErrorIs(t, err, errEventFilter)
+	}
+}
+
+func TestPeerCompression(t *testing.T) {
+	encodings := make(chan string, 2)
+	requests := make(chan *RequestData, 2)
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encodings <- r.Header.Get("Content-Encoding")
+		decompressingHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			requests <- &RequestData{body: string(body), request: r}
+			_, _ = w.Write([]byte("{}"))
+		}), DefaultMaxRequestBodySizeBytes).ServeHTTP(w, r)
+	}))
+	defer peer.Close()
+
+	client := rpcclient.NewClientWithOpts(peer.URL, &rpcclient.RPCClientOpts{
+		HTTPClient: &http.Client{Transport: &compressingTransport{base: http.DefaultTransport}},
+	})
+	bundle := &rpctypes.EthSendBundleArgs{BlockNumber: 1}
+	for i := range 20 {
+		bundle.Txs = append(bundle.Txs, *createTestTx(i))
+	}
+	params, err := json.Marshal(bundle)
+	require.NoError(t, err)
+	expectedBody := `{"method":"eth_sendBundle","params":[` + string(params) + `],"id":0,"jsonrpc":"2.0"}`
+
+	// first request is sent uncompressed and learns accepted encodings
+	for _, expectedEncoding := range []string{"", ContentEncodingZstd} {
+		_, err := client.Call(context.Background(), EthSendBundleMethod, bundle)
+		require.NoError(t, err)
+		require.Equal(t, expectedEncoding, <-encodings)
+		require.Equal(t, expectedBody, expectRequest(t, requests).body)
+	}
+
+	encoding, ok := selectContentEncoding("gzip;q=1.0, br")
+	require.True(t, ok)
+	require.Equal(t, ContentEncodingGzip, encoding)
+	_, ok = selectContentEncoding("")
+	require.False(t, ok)
+}
+
+func TestBatchedForwarding(t *testing.T) {
+	builderRequests := make(chan *RequestData, 10)
+	builder := ServeHTTPRequestToChan(builderRequests)
+	defer builder.Close()
+	archive := ServeHTTPRequestToChan(make(chan *RequestData, 10))
+	defer archive.Close()
+
+	prx := createProxy(builder.URL, "", func(config *ReceiverProxyConfig) {
+		config.ArchiveEndpoint = archive.URL
+	})
+	defer prx.Stop()
+
+	// records whether each request received by the peer is a batch
+	batches := make(chan bool, 10)
+	peerServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		batches <- bytes.HasPrefix(body, []byte("["))
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		prx.PublicHandler.ServeHTTP(w, r)
+	}))
+	defer peerServer.Close()
+
+	peer := newShareQueuePeer("peer", nil, 1, 0)
+	defer peer.Close()
+	peer.client = rpcclient.NewClientWithOpts(peerServer.URL, &rpcclient.RPCClientOpts{
+		HTTPClient: HTTPClientWithSigner(&http.Client{
+			Transport: &batchCapabilityTransport{base: http.DefaultTransport, maxBatch: &peer.maxBatch},
+		}, flashbotsSigner),
+	})
+
+	// peer advertises batch support in all responses
+	resp, err := peer.client.Call(context.Background(), ProxyVersionMethod)
+	require.NoError(t, err)
+	require.Nil(t, resp.Error)
+	require.False(t, <-batches)
+	require.Equal(t, int64(MaxBatchRequests), peer.maxBatch.Load())
+
+	signingAddress := flashbotsSigner.Address()
+	for i := range 3 {
+		peer.SendRequest(prx.Log, &ParsedRequest{
+			ethSendBundle: &rpctypes.EthSendBundleArgs{BlockNumber: rpc.BlockNumber(1200 + i), SigningAddress: &signingAddress},
+			method:        EthSendBundleMethod,
+			receivedAt:    time.Now(),
+		})
+	}
+	sq := &ShareQueue{log: prx.Log, batchSize: 10}
+	go sq.proxyRequests(peer, shareQueueLaneLocal, 0)
+
+	require.True(t, <-batches)
+	for range 3 {
+		expectRequest(t, builderRequests)
+	}
+	expectNoRequest(t, builderRequests)
+}
+
+func TestIdempotencyKeys(t *testing.T) {
+	builderRequests := make(chan *RequestData, 10)
+	builder := ServeHTTPRequestToChan(builderRequests)
+	defer builder.Close()
+	archive := ServeHTTPRequestToChan(make(chan *RequestData, 10))
+	defer archive.Close()
+
+	prx := createProxy(builder.URL, "", func(config *ReceiverProxyConfig) {
+		config.ArchiveEndpoint = archive.URL
+		config.BackpressurePolicy = BackpressureReject
+	})
+	defer prx.Stop()
+	publicServer := httptest.NewServer(prx.PublicHandler)
+	defer publicServer.Close()
+
+	client := rpcclient.NewClientWithOpts(publicServer.URL, &rpcclient.RPCClientOpts{
+		HTTPClient: HTTPClientWithSigner(&http.Client{Transport: &idempotencyKeyTransport{base: http.DefaultTransport}}, flashbotsSigner),
+	})
+	signingAddress := flashbotsSigner.Address()
+	bundle := func(block int64) *rpctypes.EthSendBundleArgs {
+		return &rpctypes.EthSendBundleArgs{BlockNumber: rpc.BlockNumber(block), SigningAddress: &signingAddress}
+	}
+	key := uuid.NewString()
+
+	ctx := withIdempotencyKeys(context.Background(), []string{key})
+	resp, err := client.Call(ctx, EthSendBundleMethod, bundle(1300))
+	require.NoError(t, err)
+	require.Nil(t, resp.Error)
+	expectRequest(t, builderRequests)
+
+	// delivery with the same key is dropped even though its content hash is different
+	resp, err = client.Call(ctx, EthSendBundleMethod, bundle(1301))
+	require.NoError(t, err)
+	require.Nil(t, resp.Error)
+	expectNoRequest(t, builderRequests)
+
+	// keys of the batch elements are matched by position
+	ctx = withIdempotencyKeys(context.Background(), []string{key, "", uuid.NewString()})
+	responses, err := client.CallBatch(ctx, rpcclient.RPCRequests{
+		rpcclient.NewRequest(EthSendBundleMethod, bundle(1302)),
+		rpcclient.NewRequest(EthSendBundleMethod, bundle(1303)),
+		rpcclient.NewRequest(EthSendBundleMethod, bundle(1304)),
+	})
+	require.NoError(t, err)
+	require.False(t, responses.HasError())
+	expectRequest(t, builderRequests)
+	expectRequest(t, builderRequests)
+	expectNoRequest(t, builderRequests)
+
+	// cancellations are forwarded even when they were already received
+	duplicateCancellations := apiDuplicateCancellations.Get()
+	cancel := &rpctypes.EthCancelBundleArgs{ReplacementUUID: uuid.NewString(), SigningAddress: &signingAddress}
+	ctx = withIdempotencyKeys(context.Background(), []string{key})
+	for range 2 {
+		resp, err = client.Call(ctx, EthCancelBundleMethod, cancel)
+		require.NoError(t, err)
+		require.Nil(t, resp.Error)
+		expectRequest(t, builderRequests)
+	}
+	require.Equal(t, duplicateCancellations+2, apiDuplicateCancellations.Get())
+
+	// retry with the key of the delivery that was rejected is not dropped
+	peerShareQueue := prx.peerShareQueue
+	prx.peerShareQueue = make(chan *ParsedRequest, 1)
+	prx.peerShareQueue <- &ParsedRequest{}
+	ctx = withIdempotencyKeys(context.Background(), []string{uuid.NewString()})
+	resp, err = client.Call(ctx, EthSendBundleMethod, bundle(1305))
+	require.NoError(t, err)
+	require.NotNil(t, resp.Error)
+	require.Equal(t, ErrorCodeOverloaded, resp.Error.Code)
+	prx.peerShareQueue = peerShareQueue
+	resp, err = client.Call(ctx, EthSendBundleMethod, bundle(1306))
+	require.NoError(t, err)
+	require.Nil(t, resp.Error)
+	expectRequest(t, builderRequests)
+}
+
+func TestStreamedMevSendBundle(t *testing.T) {
+	defer func(threshold int64) { StreamingBundleThresholdBytes = threshold }(StreamingBundleThresholdBytes)
+	StreamingBundleThresholdBytes = 0
+	streamed := apiStreamedBundles.Get()
+
+	builderRequests := make(chan *RequestData, 10)
+	builder := ServeHTTPRequestToChan(builderRequests)
+	defer builder.Close()
+	archive := ServeHTTPRequestToChan(make(chan *RequestData, 10))
+	defer archive.Close()
+
+	prx := createProxy(builder.URL, "", func(config *ReceiverProxyConfig) {
+		config.ArchiveEndpoint = archive.URL
+	})
+	defer prx.Stop()
+	publicServer := httptest.NewServer(prx.PublicHandler)
+	defer publicServer.Close()
+
+	client := rpcclient.NewClientWithOpts(publicServer.URL, &rpcclient.RPCClientOpts{
+		HTTPClient: HTTPClientWithSigner(&http.Client{}, flashbotsSigner),
+	})
+	signer := flashbotsSigner.Address()
+	bundle := func(block uint64, txs int) *rpctypes.MevSendBundleArgs {
+		args := &rpctypes.MevSendBundleArgs{
+			Version:   "v0.1",
+			Inclusion: rpctypes.MevBundleInclusion{BlockNumber: hexutil.Uint64(block)},
+			Metadata:  &rpctypes.MevBundleMetadata{Signer: &signer},
+		}
+		for i := range txs {
+			args.Body = append(args.Body, rpctypes.MevBundleBody{Tx: createTestTx(i)})
+		}
+		return args
+	}
+
+	resp, err := client.Call(context.Background(), MevSendBundleMethod, bundle(1400, 2))
+	require.NoError(t, err)
+	require.Nil(t, resp.Error)
+	builderRequest := expectRequest(t, builderRequests)
+	require.Contains(t, builderRequest.body, createTestTx(1).String())
+	require.Contains(t, builderRequest.body, `"block":"0x578"`)
+
+	responses, err := client.CallBatch(context.Background(), rpcclient.RPCRequests{
+		rpcclient.NewRequest(MevSendBundleMethod, bundle(1401, 1)),
+	})
+	require.NoError(t, err)
+	require.False(t, responses.HasError())
+	builderRequest = expectRequest(t, builderRequests)
+	require.Contains(t, builderRequest.body, `"block":"0x579"`)
+
+	// decoding stops at the first element over the limit
+	resp, err = client.Call(context.Background(), MevSendBundleMethod, bundle(1402, MaxMevBundleBodyElements+1))
+	require.NoError(t, err)
+	require.NotNil(t, resp.Error)
+	require.Contains(t, resp.Error.Message, errMevBundleTooManyElements.Error())
+	expectNoRequest(t, builderRequests)
+	require.Equal(t, streamed+3, apiStreamedBundles.Get())
+}
+
+func TestSharedStoreReplicas(t *testing.T) {
+	builderRequests := make(chan *RequestData, 10)
+	builder := ServeHTTPRequestToChan(builderRequests)
+	defer builder.Close()
+	archive := ServeHTTPRequestToChan(make(chan *RequestData, 10))
+	defer archive.Close()
+
+	sharedStore := NewMemorySharedStore()
+	var clients []rpcclient.RPCClient
+	for i := 0; i < 2; i++ {
+		prx := createProxy(builder.URL, fmt.Sprintf("replica-%d", i), func(config *ReceiverProxyConfig) {
+			config.ArchiveEndpoint = archive.URL
+			config.SharedStore = sharedStore
+		})
+		defer prx.Stop()
+		publicServer := httptest.NewServer(prx.PublicHandler)
+		defer publicServer.Close()
+		clients = append(clients, rpcclient.NewClientWithOpts(publicServer.URL, &rpcclient.RPCClientOpts{
+			HTTPClient: HTTPClientWithSigner(&http.Client{}, flashbotsSigner),
+		}))
+	}
+
+	signingAddress := flashbotsSigner.Address()
+	bundle := &rpctypes.EthSendBundleArgs{BlockNumber: rpc.BlockNumber(1400), SigningAddress: &signingAddress}
+
+	// the same request delivered to both replicas is sent to the builder once
+	for _, client := range clients {
+		resp, err := client.Call(context.Background(), EthSendBundleMethod, bundle)
+		require.NoError(t, err)
+		require.Nil(t, resp.Error)
+	}
+	expectRequest(t, builderRequests)
+	expectNoRequest(t, builderRequests)
+}
+
+func TestSharedStoreReleaseUnhandledRequest(t *testing.T) {
+	sharedStore := NewMemorySharedStore()
+	prx := createProxy("", "", func(config *ReceiverProxyConfig) {
+		config.ArchiveEndpoint = "archive-not-set"
+		config.SharedStore = sharedStore
+	})
+	defer prx.Stop()
+
+	// client gave up before the request was queued, the local rate limiter rejects it
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	uniqueKey := uuid.New()
+	request := ParsedRequest{
+		method:              EthSendBundleMethod,
+		peerName:            "local-request",
+		ethSendBundle:       &rpctypes.EthSendBundleArgs{BlockNumber: 1},
+		requestArgUniqueKey: &uniqueKey,
+	}
+	require.ErrorAs(t, prx.HandleParsedRequest(ctx, request), new(*RateLimitError))
+
+	// retry can be claimed by any replica
+	claimed, err := sharedStore.Claim(context.Background(), requestClaimKey(&request), time.Minute)
+	require.NoError(t, err)
+	require.True(t, claimed)
+	require.NoError(t, sharedStore.Release(context.Background(), requestClaimKey(&request)))
+	require.NoError(t, prx.HandleParsedRequest(context.Background(), request))
+}
+
+func TestSenderQueueDepth(t *testing.T) {
+	release := make(chan struct{})
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":null}`))
+	}))
+	defer receiver.Close()
+	prx, err := NewSenderProxy(SenderProxyConfig{
+		SenderProxyConstantConfig: SenderProxyConstantConfig{
+			Log:             slog.New(slog.NewTextHandler(io.Discard, nil)),
+			OrderflowSigner: flashbotsSigner,
+		},
+		BuilderConfigHubEndpoint: builderHub.URL,
+		ReceiverEndpoints:        []string{receiver.URL},
+		ConnectionsPerPeer:       1,
+	})
+	require.NoError(t, err)
+	defer prx.Stop()
+
+	// the only worker is busy with the first request, the second one waits for it
+	before := senderQueueDepth.Get()
+	var wg sync.WaitGroup
+	for nonce := range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			args := rpctypes.EthSendRawTransactionArgs(*createTestTx(nonce))
+			assert.NoError(t, prx.EthSendRawTransaction(context.Background(), args))
+		}()
+	}
+	require.Eventually(t, func() bool {
+		return senderQueueDepth.Get() == before+1
+	}, time.Second, time.Millisecond*10)
+	close(release)
+	wg.Wait()
+	require.Equal(t, before, senderQueueDepth.Get())
+}
+
+func TestSenderLeaderElection(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leader.lock")
+	ctx := context.Background()
+	lock := NewFileLeaderLock(path)
+	acquired, err := lock.Acquire(ctx, time.Second)
+	require.NoError(t, err)
+	require.True(t, acquired)
+
+	receiverRequests := make(chan *RequestData, 10)
+	receiver := ServeHTTPRequestToChan(receiverRequests)
+	defer receiver.Close()
+	prx, err := NewSenderProxy(SenderProxyConfig{
+		SenderProxyConstantConfig: SenderProxyConstantConfig{
+			Log:             slog.New(slog.NewTextHandler(os.Stdout, nil)),
+			OrderflowSigner: flashbotsSigner,
+		},
+		BuilderConfigHubEndpoint: builderHub.URL,
+		ReceiverEndpoints:        []string{receiver.URL},
+		LeaderLock:               NewFileLeaderLock(path),
+		LeaderLockTTL:            time.Millisecond * 30,
+	})
+	require.NoError(t, err)
+	defer prx.Stop()
+
+	args := rpctypes.EthSendRawTransactionArgs(*createTestTx(0))
+	require.ErrorIs(t, prx.EthSendRawTransaction(ctx, args), errNotLeader)
+
+	// standby takes over when the leader releases the lock
+	require.NoError(t, lock.Release(ctx))
+	require.Eventually(t, prx.leader.Load, time.Second, time.Millisecond*10)
+	require.NoError(t, prx.EthSendRawTransaction(ctx, args))
+	expectRequest(t, receiverRequests)
+
+	acquired, err = lock.Acquire(ctx, time.Second)
+	require.NoError(t, err)
+	require.False(t, acquired)
+}
+
+func TestPeerShard(t *testing.T) {
+	require.ErrorIs(t, PeerShard{Index: 3, Count: 3}.Validate(), errInvalidPeerShard)
+	require.NoError(t, PeerShard{}.Validate())
+	require.True(t, PeerShard{}.Owns("builder"))
+
+	moved := 0
+	for i := range 300 {
+		peer := fmt.Sprintf("builder-%d", i)
+		owners := 0
+		for index := range 3 {
+			if (PeerShard{Index: index, Count: 3}).Owns(peer) {
+				owners++
+			}
+		}
+		require.Equal(t, 1, owners)
+		// adding a shard only moves peers to the new shard
+		if newIndex := peerShardIndex(peer, 4); newIndex != peerShardIndex(peer, 3) {
+			require.Equal(t, 3, newIndex)
+			moved++
+		}
+	}
+	require.Greater(t, moved, 0)
+	require.Less(t, moved, 150)
+}
+
+func proxyProtocolV2Header(command, family byte, addresses []byte) []byte {
+	header := append([]byte{}, proxyProtocolV2Signature...)
+	header = append(header, 0x20|command, family)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(addresses)))
+	return append(header, addresses...)
+}
+
+func TestProxyProtocol(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &http.Server{ //nolint:gosec
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(r.RemoteAddr))
+		}),
+	}
+	go server.Serve(&ProxyProtocolListener{Listener: listener}) //nolint:errcheck
+	defer server.Close()
+
+	request := func(header []byte) (string, error) {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		_, err = conn.Write(append(header, "GET / HTTP/1.1\r\nHost: proxy\r\nConnection: close\r\n\r\n"...))
+		require.NoError(t, err)
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	// source 203.0.113.7:4242, destination 10.0.0.1:5544, TLV is ignored
+	addresses := []byte{203, 0, 113, 7, 10, 0, 0, 1, 0x10, 0x92, 0x15, 0xa8, 0x04, 0x00, 0x01, 0x00}
+	remoteAddr, err := request(proxyProtocolV2Header(proxyProtocolCommandProxy, proxyProtocolFamilyTCP4, addresses))
+	require.NoError(t, err)
+	require.Equal(t, "203.0.113.7:4242", remoteAddr)
+
+	// health check of the load balancer has its own address
+	remoteAddr, err = request(proxyProtocolV2Header(proxyProtocolCommandLocal, proxyProtocolFamilyUnspec, nil))
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(remoteAddr, "127.0.0.1:"))
+
+	// connections without header are closed
+	errorsBefore := proxyProtocolErrors.Get()
+	_, err = request(nil)
+	require.Error(t, err)
+	require.Equal(t, errorsBefore+1, proxyProtocolErrors.Get())
+
+	ipv6 := append(net.ParseIP("2001:db8::1").To16(), net.ParseIP("2001:db8::2").To16()...)
+	ipv6 = append(ipv6, 0x10, 0x92, 0x15, 0xa8)
+	addr, err := readProxyProtocolHeader(bytes.NewReader(proxyProtocolV2Header(proxyProtocolCommandProxy, proxyProtocolFamilyTCP6, ipv6)))
+	require.NoError(t, err)
+	require.Equal(t, "[2001:db8::1]:4242", addr.String())
+	_, err = readProxyProtocolHeader(bytes.NewReader(proxyProtocolV2Header(proxyProtocolCommandProxy, proxyProtocolFamilyTCP6, ipv6[:20])))
+	require.ErrorIs(t, err, errProxyProtocolHeader)
+}
+
+func TestIPRateLimiter(t *testing.T) {
+	var served int
+	handler := NewIPRateLimiter(2).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served++
+	}))
+	request := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{}`))
+		req.RemoteAddr = remoteAddr
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp
+	}
+
+	require.Equal(t, http.StatusOK, request("203.0.113.7:1000").Code)
+	require.Equal(t, http.StatusOK, request("203.0.113.7:1001").Code)
+	resp := request("203.0.113.7:1002")
+	require.Equal(t, http.StatusTooManyRequests, resp.Code)
+	require.Equal(t, "1", resp.Header().Get("Retry-After"))
+	var body struct {
+		Error struct {
+			Code int          `json:"code"`
+			Data RPCErrorData `json:"data"`
+		} `json:"error"`
+	}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+	require.Equal(t, ErrorCodeRateLimited, body.Error.Code)
+	require.Equal(t, ErrorReasonRateLimited, body.Error.Data.Reason)
+	require.Positive(t, body.Error.Data.RetryAfterMs)
+	require.Equal(t, 2, served)
+
+	// other clients are not affected, IPv6 clients share the limit of their /64
+	require.Equal(t, http.StatusOK, request("203.0.113.8:1000").Code)
+	require.Equal(t, http.StatusOK, request("[2001:db8::1]:1000").Code)
+	require.Equal(t, http.StatusOK, request("[2001:db8::2]:1000").Code)
+	require.Equal(t, http.StatusTooManyRequests, request("[2001:db8::3]:1000").Code)
+	require.Equal(t, http.StatusOK, request("[2001:db8:0:1::1]:1000").Code)
+}
+
+func TestRequestSizeMetrics(t *testing.T) {
+	handler, err := rpcserver.NewJSONRPCHandler(recordRequestSizes(rpcserver.Methods{
+		EthSendBundleMethod: func(ctx context.Context, args rpctypes.EthSendBundleArgs) error {
+			return nil
+		},
+	}, "test"), rpcserver.JSONRPCHandlerOpts{})
+	require.NoError(t, err)
+	histogramCount := func(label string) (count uint64) {
+		metrics.GetOrCreateHistogram(label).VisitNonZeroBuckets(func(_ string, c uint64) {
+			count += c
+		})
+		return count
+	}
+	sizeLabel := fmt.Sprintf(apiRequestSizeLabel, EthSendBundleMethod, "test")
+	txsLabel := fmt.Sprintf(apiBundleTxsLabel, EthSendBundleMethod, "test")
+
+	body := `{"jsonrpc":"2.0","id":1,"method":"eth_sendBundle","params":[{"txs":["0x01","0x02"],"blockNumber":"0x1"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+	requestSizeHandler(handler).ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Equal(t, uint64(1), histogramCount(sizeLabel))
+	require.Equal(t, uint64(1), histogramCount(txsLabel))
+}
+
+func TestPeerListStaleWebhook(t *testing.T) {
+	var hubDown atomic.Bool
+	hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hubDown.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("[]"))
+	}))
+	defer hub.Close()
+	alerts := make(chan *RequestData, 10)
+	webhook := ServeHTTPRequestToChan(alerts)
+	defer webhook.Close()
+
+	configHub := NewBuilderConfigHub(slog.New(slog.NewTextHandler(os.Stdout, nil)), hub.URL)
+	configHub.staleWebhook = &PeerListStaleWebhook{URL: webhook.URL, StaleAfter: time.Millisecond * 50}
+	_, err := configHub.Builders(false)
+	require.NoError(t, err)
+
+	hubDown.Store(true)
+	_, err = configHub.Builders(false)
+	require.Error(t, err)
+	expectNoRequest(t, alerts)
+	require.Equal(t, int64(1), confighubConsecutiveFailures.Load())
+
+	time.Sleep(time.Millisecond * 50)
+	_, err = configHub.Builders(false)
+	require.Error(t, err)
+	alert := expectRequest(t, alerts)
+	var staleAlert PeerListStaleAlert
+	require.NoError(t, json.Unmarshal([]byte(alert.body), &staleAlert))
+	require.Equal(t, 2, staleAlert.ConsecutiveFailures)
+
+	// webhook is called once until the peer list is fetched again
+	_, err = configHub.Builders(false)
+	require.Error(t, err)
+	expectNoRequest(t, alerts)
+
+	hubDown.Store(false)
+	_, err = configHub.Builders(false)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), confighubConsecutiveFailures.Load())
+	require.Less(t, confighubLastSuccessAgeSeconds(), 1.0)
+}
+
+func TestDisabledMethods(t *testing.T) {
+	archive := ServeHTTPRequestToChan(make(chan *RequestData, 10))
+	defer archive.Close()
+	config := ReceiverProxyConfig{
+		ReceiverProxyConstantConfig: ReceiverProxyConstantConfig{
+			Log:                    slog.New(slog.NewTextHandler(os.Stdout, nil)),
+			FlashbotsSignerAddress: flashbotsSigner.Address(),
+			DisabledPublicMethods:  []string{EthSendRawTransactionMethod},
+			DisabledLocalMethods:   []string{BidSubsidiseBlockMethod},
+		},
+		CertValidDuration:        time.Hour,
+		CertHosts:                []string{"localhost"},
+		BuilderConfigHubEndpoint: builderHub.URL,
+		ArchiveEndpoint:          archive.URL,
+		LocalBuilderEndpoint:     archive.URL,
+		EthRPC:                   "eth-rpc-not-set",
+	}
+	prx, err := NewReceiverProxy(config)
+	require.NoError(t, err)
+	defer prx.Stop()
+	publicServer := httptest.NewServer(prx.PublicHandler)
+	defer publicServer.Close()
+
+	client := rpcclient.NewClientWithOpts(publicServer.URL, &rpcclient.RPCClientOpts{
+		HTTPClient: HTTPClientWithSigner(&http.Client{}, flashbotsSigner),
+	})
+	resp, err := client.Call(context.Background(), EthSendRawTransactionMethod, hexutil.Bytes{})
+	require.NoError(t, err)
+	require.NotNil(t, resp.Error)
+	require.Equal(t, -32601, resp.Error.Code)
+
+	buildInfo := prx.BuildInfo()
+	require.NotContains(t, buildInfo.Methods.Public, EthSendRawTransactionMethod)
+	require.Contains(t, buildInfo.Methods.Local, EthSendRawTransactionMethod)
+	require.NotContains(t, buildInfo.Methods.Local, BidSubsidiseBlockMethod)
+
+	config.DisabledLocalMethods = []string{"eth_unknown"}
+	_, err = NewReceiverProxy(config)
+	require.ErrorIs(t, err, errUnknownMethod)
+}
+
+func TestMethodAliases(t *testing.T) {
+	_, err := ParseMethodAliases([]string{"eth_sendPrivateRawTransaction"})
+	require.ErrorIs(t, err, errMethodAlias)
+	aliases, err := ParseMethodAliases([]string{"eth_sendPrivateRawTransaction=" + EthSendRawTransactionMethod})
+	require.NoError(t, err)
+
+	receiverRequests := make(chan *RequestData, 10)
+	receiver := ServeHTTPRequestToChan(receiverRequests)
+	defer receiver.Close()
+	config := SenderProxyConfig{
+		SenderProxyConstantConfig: SenderProxyConstantConfig{
+			Log:             slog.New(slog.NewTextHandler(os.Stdout, nil)),
+			OrderflowSigner: flashbotsSigner,
+		},
+		BuilderConfigHubEndpoint: builderHub.URL,
+		ReceiverEndpoints:        []string{receiver.URL},
+		MethodAliases:            aliases,
+	}
+	prx, err := NewSenderProxy(config)
+	require.NoError(t, err)
+	defer prx.Stop()
+	server := httptest.NewServer(prx.Handler)
+	defer server.Close()
+
+	resp, err := rpcclient.NewClient(server.URL).Call(context.Background(), "eth_sendPrivateRawTransaction", createTestTx(0))
+	require.NoError(t, err)
+	require.Nil(t, resp.Error)
+	req := expectRequest(t, receiverRequests)
+	require.Contains(t, req.body, `"method":"eth_sendRawTransaction"`)
+
+	// alias can't shadow served method
+	config.MethodAliases = map[string]string{EthSendBundleMethod: EthSendRawTransactionMethod}
+	_, err = NewSenderProxy(config)
+	require.ErrorIs(t, err, errMethodAlias)
+}
+
+func TestStructuredRPCErrors(t *testing.T) {
+	archive := ServeHTTPRequestToChan(make(chan *RequestData, 10))
+	defer archive.Close()
+	prx := createProxy(archive.URL, "", func(config *ReceiverProxyConfig) {
+		config.ArchiveEndpoint = archive.URL
+	})
+	defer prx.Stop()
+	publicServer := httptest.NewServer(prx.PublicHandler)
+	defer publicServer.Close()
+	localServer := httptest.NewServer(prx.LocalHandler)
+	defer localServer.Close()
+
+	expectError := func(resp *rpcclient.RPCResponse, code int, data RPCErrorData) {
+		t.Helper()
+		require.NotNil(t, resp.Error)
+		require.Equal(t, code, resp.Error.Code)
+		rawData, err := json.Marshal(resp.Error.Data)
+		require.NoError(t, err)
+		var errData RPCErrorData
+		require.NoError(t, json.Unmarshal(rawData, &errData))
+		require.Equal(t, data, errData)
+	}
+
+	unknownSigner, err := signature.NewRandomSigner()
+	require.NoError(t, err)
+	publicClient := rpcclient.NewClientWithOpts(publicServer.URL, &rpcclient.RPCClientOpts{
+		HTTPClient: HTTPClientWithSigner(&http.Client{}, unknownSigner),
+	})
+	resp, err := publicClient.Call(context.Background(), EthSendRawTransactionMethod, createTestTx(0))
+	require.NoError(t, err)
+	expectError(resp, ErrorCodeUnauthorized, RPCErrorData{Reason: ErrorReasonUnknownSigner})
+
+	localClient := rpcclient.NewClientWithOpts(localServer.URL, &rpcclient.RPCClientOpts{
+		HTTPClient: HTTPClientWithSigner(&http.Client{}, flashbotsSigner),
+	})
+	signingAddress := flashbotsSigner.Address()
+	resp, err = localClient.Call(context.Background(), EthSendBundleMethod, &rpctypes.EthSendBundleArgs{BlockNumber: 1, SigningAddress: &signingAddress})
+	require.NoError(t, err)
+	expectError(resp, ErrorCodeInvalidParams, RPCErrorData{Reason: ErrorReasonValidation, Field: "signingAddress"})
+}
+
+func TestEthSendBundleHash(t *testing.T) {
+	tx := createTestTx(0)
+	var decoded types.Transaction
+	require.NoError(t, decoded.UnmarshalBinary(*tx))
+
+	builderRequests := make(chan *RequestData, 10)
+	builder := ServeHTTPRequestToChan(builderRequests)
+	defer builder.Close()
+	archive := ServeHTTPRequestToChan(make(chan *RequestData, 10))
+	defer archive.Close()
+	prx := createProxy(builder.URL, "", func(config *ReceiverProxyConfig) {
+		config.ArchiveEndpoint = archive.URL
+	})
+	defer prx.Stop()
+	localServer := httptest.NewServer(prx.LocalHandler)
+	defer localServer.Close()
+
+	client := rpcclient.NewClientWithOpts(localServer.URL, &rpcclient.RPCClientOpts{
+		HTTPClient: HTTPClientWithSigner(&http.Client{}, flashbotsSigner),
+	})
+	var resp EthSendBundleResponse
+	err := client.CallFor(context.Background(), &resp, EthSendBundleMethod, &rpctypes.EthSendBundleArgs{
+		Txs:         []hexutil.Bytes{*tx},
+		BlockNumber: rpc.BlockNumber(1500),
+	})
+	require.NoError(t, err)
+	require.Equal(t, crypto.Keccak256Hash(decoded.Hash().Bytes()), resp.BundleHash)
+	expectRequest(t, builderRequests)
+
+	// bundles without transactions don't have the hash
+	_, ok := ethBundleHash(&rpctypes.EthSendBundleArgs{})
+	require.False(t, ok)
+}
+
+func TestMevSendBundleHash(t *testing.T) {
+	tx := hexutil.Bytes(*createTestTx(0))
+	inner := &rpctypes.MevSendBundleArgs{Body: []rpctypes.MevBundleBody{{Tx: &tx}}}
+	bundle := &rpctypes.MevSendBundleArgs{Body: []rpctypes.MevBundleBody{{Tx: &tx}, {Bundle: inner}}}
+
+	// hash matches mev-share hashing of bundles without backrun targets
+	expected, err := bundle.Validate()
+	require.NoError(t, err)
+	hash, ok := mevBundleHash(bundle)
+	require.True(t, ok)
+	require.Equal(t, expected, hash)
+
+	target := common.HexToHash("0x01")
+	backrun := &rpctypes.MevSendBundleArgs{Body: []rpctypes.MevBundleBody{{Hash: &target}, {Tx: &tx}}}
+	_, ok = mevBundleHash(backrun)
+	require.True(t, ok)
+
+	_, ok = mevBundleHash(&rpctypes.MevSendBundleArgs{ReplacementUUID: uuid.NewString()})
+	require.False(t, ok)
+}
+
+func TestRateLimitRetryAfter(t *testing.T) {
+	archive := ServeHTTPRequestToChan(make(chan *RequestData, 10))
+	defer archive.Close()
+	builderRequests := make(chan *RequestData, 10)
+	builder := ServeHTTPRequestToChan(builderRequests)
+	defer builder.Close()
+	prx := createProxy(builder.URL, "", func(config *ReceiverProxyConfig) {
+		config.ArchiveEndpoint = archive.URL
+		config.MaxLocalRPS = 1
+	})
+	defer prx.Stop()
+	localServer := httptest.NewServer(prx.LocalHandler)
+	defer localServer.Close()
+
+	client := HTTPClientWithSigner(&http.Client{}, flashbotsSigner)
+	send := func(nonce int) (*http.Response, error) {
+		body, err := json.Marshal(map[string]any{
+			"jsonrpc": "2.0",
+			"id":      1,
+			"method":  EthSendRawTransactionMethod,
+			"params":  []any{createTestTx(nonce)},
+		})
+		if err != nil {
+			return nil, err
+		}
+		return client.Post(localServer.URL, "application/json", bytes.NewReader(body))
+	}
+
+	resp, err := send(0)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// one of the concurrent requests waits for the next token, the other can't get it before the deadline
+	responses := make(chan *http.Response, 2)
+	for nonce := 1; nonce <= 2; nonce++ {
+		go func() {
+			resp, err := send(nonce)
+			assert.NoError(t, err)
+			responses <- resp
+		}()
+	}
+	resp = <-responses
+	require.NotNil(t, resp)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	retryAfter, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	require.NoError(t, err)
+	require.Positive(t, retryAfter)
+	var rpcResp rpcclient.RPCResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&rpcResp))
+	require.NotNil(t, rpcResp.Error)
+	require.Equal(t, ErrorCodeRateLimited, rpcResp.Error.Code)
+	rateLimitErr, ok := rateLimitErrorFromResponse(rpcResp.Error)
+	require.True(t, ok)
+	require.Positive(t, rateLimitErr.RetryAfter)
+	require.LessOrEqual(t, rateLimitErr.RetryAfter, time.Duration(retryAfter)*time.Second)
+	resp = <-responses
+	require.NotNil(t, resp)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// rate limited request is not deduplicated when it is retried
+	time.Sleep(rateLimitErr.RetryAfter)
+	for len(builderRequests) > 0 {
+		<-builderRequests
+	}
+	for nonce := 1; nonce <= 2; nonce++ {
+		resp, err = send(nonce)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	expectRequest(t, builderRequests)
+}
+
+func TestBackpressureRejectRetry(t *testing.T) {
+	builderRequests := make(chan *RequestData, 10)
+	builder := ServeHTTPRequestToChan(builderRequests)
+	defer builder.Close()
+	archive := ServeHTTPRequestToChan(make(chan *RequestData, 10))
+	defer archive.Close()
+	prx := createProxy(builder.URL, "", func(config *ReceiverProxyConfig) {
+		config.ArchiveEndpoint = archive.URL
+		config.BackpressurePolicy = BackpressureReject
+	})
+	defer prx.Stop()
+	localServer := httptest.NewServer(prx.LocalHandler)
+	defer localServer.Close()
+
+	client := rpcclient.NewClientWithOpts(localServer.URL, &rpcclient.RPCClientOpts{
+		HTTPClient: HTTPClientWithSigner(&http.Client{}, flashbotsSigner),
+	})
+	tx := createTestTx(0)
+
+	// share queue that is not drained by the workers is full
+	shareQueue := prx.shareQueue
+	prx.shareQueue = make(chan *ParsedRequest, 1)
+	prx.shareQueue <- &ParsedRequest{}
+	resp, err := client.Call(context.Background(), EthSendRawTransactionMethod, tx)
+	require.NoError(t, err)
+	require.NotNil(t, resp.Error)
+	require.Equal(t, ErrorCodeOverloaded, resp.Error.Code)
+	expectNoRequest(t, builderRequests)
+
+	// retry of the rejected request is not dropped as duplicate
+	prx.shareQueue = shareQueue
+	resp, err = client.Call(context.Background(), EthSendRawTransactionMethod, tx)
+	require.NoError(t, err)
+	require.Nil(t, resp.Error)
+	expectRequest(t, builderRequests)
+}
+
+func TestLoadSheddingSlowBuilder(t *testing.T) {
+	builderRequests := make(chan *RequestData, 10)
+	builder := ServeHTTPRequestToChan(builderRequests)
+	defer builder.Close()
+	archive := ServeHTTPRequestToChan(make(chan *RequestData, 10))
+	defer archive.Close()
+	prx := createProxy(builder.URL, "", func(config *ReceiverProxyConfig) {
+		config.ArchiveEndpoint = archive.URL
+		config.LoadShedBuilderLatency = time.Millisecond * 100
+	})
+	defer prx.Stop()
+	publicServer := httptest.NewServer(prx.PublicHandler)
+	defer publicServer.Close()
+
+	peerSigner, err := signature.NewRandomSigner()
+	require.NoError(t, err)
+	prx.peersMu.Lock()
+	prx.lastFetchedPeers = []ConfighubBuilder{{Name: "peer", OrderflowProxy: ConfighubOrderflowProxyCredentials{EcdsaPubkeyAddress: peerSigner.Address()}}}
+	prx.peersMu.Unlock()
+	peerClient := rpcclient.NewClientWithOpts(publicServer.URL, &rpcclient.RPCClientOpts{
+		HTTPClient: HTTPClientWithSigner(&http.Client{}, peerSigner),
+	})
+	flashbotsClient := rpcclient.NewClientWithOpts(publicServer.URL, &rpcclient.RPCClientOpts{
+		HTTPClient: HTTPClientWithSigner(&http.Client{}, flashbotsSigner),
+	})
+
+	resp, err := peerClient.Call(context.Background(), EthSendRawTransactionMethod, createTestTx(0))
+	require.NoError(t, err)
+	require.Nil(t, resp.Error)
+	expectRequest(t, builderRequests)
+
+	prx.builderLatency.record(time.Second)
+	resp, err = peerClient.Call(context.Background(), EthSendRawTransactionMethod, createTestTx(1))
+	require.NoError(t, err)
+	require.NotNil(t, resp.Error)
+	require.Equal(t, ErrorCodeOverloaded, resp.Error.Code)
+	expectNoRequest(t, builderRequests)
+
+	// privileged orderflow is not shed
+	resp, err = flashbotsClient.Call(context.Background(), EthSendRawTransactionMethod, createTestTx(2))
+	require.NoError(t, err)
+	require.Nil(t, resp.Error)
+	expectRequest(t, builderRequests)
+}
+
+func TestSignerReputation(t *testing.T) {
+	reputation := NewSignerReputation(10)
+	good := common.HexToAddress("0x1")
+	spammer := common.HexToAddress("0x2")
+	for range 20 {
+		reputation.RecordResult(good, nil)
+		reputation.RecordResult(good, errUnknownPeer)
+		reputation.RecordResult(spammer, errTxSignature)
+	}
+	require.InDelta(t, 1.0, reputation.Score(good), 0.001)
+	require.InDelta(t, 0.0, reputation.Score(spammer), 0.001)
+
+	// good signer keeps the full limit, spammer is limited to the min scale of it
+	for range 10 {
+		require.NoError(t, reputation.Allow(good))
+	}
+	require.NoError(t, reputation.Allow(spammer))
+	var rateLimitErr *RateLimitError
+	require.ErrorAs(t, reputation.Allow(spammer), &rateLimitErr)
+	require.Positive(t, rateLimitErr.RetryAfter)
+
+	// requests of low reputation signers are shed first
+	latency := newBuilderLatencyTracker(time.Millisecond * 100)
+	latency.record(time.Millisecond * 50)
+	require.False(t, latency.overloaded(reputation.Score(good)))
+	require.True(t, latency.overloaded(reputation.Score(spammer)))
+}
+
+func TestReputationStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reputation.db")
+	spammer := common.HexToAddress("0x2")
+
+	store, err := OpenReputationStore(path)
+	require.NoError(t, err)
+	reputation := NewSignerReputation(10)
+	for range 20 {
+		reputation.RecordResult(spammer, errTxSignature)
+	}
+	require.NoError(t, store.Save(reputation))
+	require.NoError(t, store.Close())
+
+	// spammer keeps its score after restart
+	store, err = OpenReputationStore(path)
+	require.NoError(t, err)
+	defer store.Close()
+	restored := NewSignerReputation(10)
+	require.NoError(t, store.Load(restored))
+	require.InDelta(t, 0.0, restored.Score(spammer), 0.001)
+	require.NoError(t, restored.Allow(spammer))
+	require.ErrorIs(t, restored.Allow(spammer), errRateLimiting)
+}
+
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	builderRequests := make(chan *RequestData, 10)
+	builder := ServeHTTPRequestToChan(builderRequests)
+	defer builder.Close()
+	archive := ServeHTTPRequestToChan(make(chan *RequestData, 10))
+	defer archive.Close()
+	prx := createProxy(builder.URL, "", func(config *ReceiverProxyConfig) {
+		config.ArchiveEndpoint = archive.URL
+		config.AuditLogPath = path
+	})
+	localServer := httptest.NewServer(prx.LocalHandler)
+	defer localServer.Close()
+
+	client := rpcclient.NewClientWithOpts(localServer.URL, &rpcclient.RPCClientOpts{
+		HTTPClient: HTTPClientWithSigner(&http.Client{}, flashbotsSigner),
+	})
+	resp, err := client.Call(context.Background(), EthSendRawTransactionMethod, createTestTx(0))
+	require.NoError(t, err)
+	require.Nil(t, resp.Error)
+	expectRequest(t, builderRequests)
+	require.Eventually(t, func() bool { return prx.audit.Head().Sequence == 1 }, time.Second, time.Millisecond*10)
+	var head AuditHead
+	require.NoError(t, client.CallFor(context.Background(), &head, GetAuditHeadMethod))
+	require.Equal(t, prx.audit.Head(), head)
+	prx.Stop()
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 1)
+	entry, signer, err := VerifyAuditEntry([]byte(lines[0]))
+	require.NoError(t, err)
+	require.Equal(t, prx.OrderflowSigner.Address(), signer)
+	require.Equal(t, EthSendRawTransactionMethod, entry.Method)
+	require.Equal(t, flashbotsSigner.Address(), entry.Signer)
+	require.Contains(t, entry.Destinations, localBuilderPeerName)
+	require.NotNil(t, entry.UniqueKey)
+
+	// modified entry does not verify
+	tampered := strings.Replace(lines[0], localBuilderPeerName, "other-builder", 1)
+	_, signer, err = VerifyAuditEntry([]byte(tampered))
+	if err == nil {
+		require.NotEqual(t, prx.OrderflowSigner.Address(), signer)
+	}
+}
+
+func TestAuditLogChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	log := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	record := func(count int) AuditHead {
+		auditLog, err := NewAuditLog(log, path, flashbotsSigner)
+		require.NoError(t, err)
+		for range count {
+			auditLog.Record(&ParsedRequest{method: EthSendRawTransactionMethod, receivedAt: time.Now()}, []string{localBuilderPeerName})
+		}
+		require.NoError(t, auditLog.Close())
+		return auditLog.Head()
+	}
+	record(3)
+	// chain is continued after restart
+	head := record(2)
+	require.Equal(t, uint64(5), head.Sequence)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	verified, err := VerifyAuditChain(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, head, verified)
+
+	// removed entry breaks the chain
+	lines := strings.SplitAfter(string(data), "\n")
+	_, err = VerifyAuditChain(strings.NewReader(lines[0] + strings.Join(lines[2:], "")))
+	require.ErrorIs(t, err, errAuditChainBroken)
+
+	// partial entry written before a crash is truncated and the chain is continued from the last complete entry
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	require.NoError(t, err)
+	_, err = file.WriteString(lines[0][:len(lines[0])/2])
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+	head = record(1)
+	require.Equal(t, uint64(6), head.Sequence)
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+	verified, err = VerifyAuditChain(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, head, verified)
+}
+
+func TestOrderflowStats(t *testing.T) {
+	builderRequests := make(chan *RequestData, 10)
+	builder := ServeHTTPRequestToChan(builderRequests)
+	defer builder.Close()
+	archive := ServeHTTPRequestToChan(make(chan *RequestData, 10))
+	defer archive.Close()
+	prx := createProxy(builder.URL, "", func(config *ReceiverProxyConfig) {
+		config.ArchiveEndpoint = archive.URL
+		config.StatsWindows = []time.Duration{time.Minute, time.Hour}
+		config.ArchiveQueueSize = 200
+	})
+	defer prx.Stop()
+	localServer := httptest.NewServer(prx.LocalHandler)
+	defer localServer.Close()
+
+	client := rpcclient.NewClientWithOpts(localServer.URL, &rpcclient.RPCClientOpts{
+		HTTPClient: HTTPClientWithSigner(&http.Client{}, flashbotsSigner),
+	})
+	for range 2 {
+		resp, err := client.Call(context.Background(), EthSendRawTransactionMethod, createTestTx(0))
+		require.NoError(t, err)
+		require.Nil(t, resp.Error)
+	}
+	expectRequest(t, builderRequests)
+
+	var stats OrderflowStatsResponse
+	require.NoError(t, client.CallFor(context.Background(), &stats, GetStatsMethod))
+	require.Len(t, stats.Windows, 2)
+	for i, window := range []string{"1m0s", "1h0m0s"} {
+		require.Equal(t, window, stats.Windows[i].Window)
+		require.Equal(t, uint64(2), stats.Windows[i].Requests)
+		require.Equal(t, uint64(1), stats.Windows[i].Duplicates)
+		require.InDelta(t, 0.5, stats.Windows[i].DuplicateRate, 0.001)
+		require.Equal(t, map[string]uint64{EthSendRawTransactionMethod: 2}, stats.Windows[i].Methods)
+		require.Equal(t, map[string]uint64{flashbotsSigner.Address().Hex(): 2}, stats.Windows[i].Signers)
+	}
+	require.Equal(t, ReceiverProxyWorkerQueueSize, stats.Queues[queueNameShare].Capacity)
+	require.Equal(t, ReceiverProxyWorkerQueueSize, stats.Queues[queueNamePeerShare].Capacity)
+	require.Equal(t, 200, stats.Queues[queueNameArchive].Capacity)
+}
+
+func TestStrictJSONDecoding(t *testing.T) {
+	builderRequests := make(chan *RequestData, 10)
+	builder := ServeHTTPRequestToChan(builderRequests)
+	defer builder.Close()
+	archive := ServeHTTPRequestToChan(make(chan *RequestData, 10))
+	defer archive.Close()
+	prx := createProxy(builder.URL, "", func(config *ReceiverProxyConfig) {
+		config.StrictJSONDecoding = true
+		config.ArchiveEndpoint = archive.URL
+	})
+	defer prx.Stop()
+	localServer := httptest.NewServer(prx.LocalHandler)
+	defer localServer.Close()
+
+	client := rpcclient.NewClientWithOpts(localServer.URL, &rpcclient.RPCClientOpts{
+		HTTPClient: HTTPClientWithSigner(&http.Client{}, flashbotsSigner),
+	})
+	errorData := func(resp *rpcclient.RPCResponse) RPCErrorData {
+		t.Helper()
+		require.NotNil(t, resp.Error)
+		require.Equal(t, ErrorCodeInvalidParams, resp.Error.Code)
+		rawData, err := json.Marshal(resp.Error.Data)
+		require.NoError(t, err)
+		var data RPCErrorData
+		require.NoError(t, json.Unmarshal(rawData, &data))
+		require.Equal(t, ErrorReasonDecoding, data.Reason)
+		return data
+	}
+
+	resp, err := client.Call(context.Background(), EthSendBundleMethod, map[string]any{"blockNumber": "0x1", "blockNumbr": "0x2"})
+	require.NoError(t, err)
+	require.Equal(t, "blockNumbr", errorData(resp).Field)
+	require.Equal(t, `unknown field "blockNumbr"`, resp.Error.Message)
+
+	resp, err = client.Call(context.Background(), MevSendBundleMethod, map[string]any{"version": "v0.1", "inclusion": map[string]any{"block": 1}})
+	require.NoError(t, err)
+	require.Equal(t, "inclusion.block", errorData(resp).Field)
+	require.Contains(t, resp.Error.Message, "field inclusion.block: expected hexutil.Uint64")
+	expectNoRequest(t, builderRequests)
+
+	resp, err = client.Call(context.Background(), EthSendBundleMethod, map[string]any{"blockNumber": "0x1"})
+	require.NoError(t, err)
+	require.Nil(t, resp.Error)
+	expectRequest(t, builderRequests)
+}
+
+func TestRejectionRing(t *testing.T) {
+	builderRequests := make(chan *RequestData, 10)
+	builder := ServeHTTPRequestToChan(builderRequests)
+	defer builder.Close()
+	archive := ServeHTTPRequestToChan(make(chan *RequestData, 10))
+	defer archive.Close()
+	prx := createProxy(builder.URL, "", func(config *ReceiverProxyConfig) {
+		config.ArchiveEndpoint = archive.URL
+	})
+	defer prx.Stop()
+	localServer := httptest.NewServer(prx.LocalHandler)
+	defer localServer.Close()
+
+	client := rpcclient.NewClientWithOpts(localServer.URL, &rpcclient.RPCClientOpts{
+		HTTPClient: HTTPClientWithSigner(&http.Client{}, flashbotsSigner),
+	})
+	resp, err := client.Call(context.Background(), EthSendRawTransactionMethod, hexutil.Bytes{0x1})
+	require.NoError(t, err)
+	require.NotNil(t, resp.Error)
+	for range 2 {
+		resp, err = client.Call(context.Background(), EthSendRawTransactionMethod, createTestTx(0))
+		require.NoError(t, err)
+		require.Nil(t, resp.Error)
+	}
+	expectRequest(t, builderRequests)
+
+	get := func(query string) []Rejection {
+		rec := httptest.NewRecorder()
+		prx.RejectionsHandler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, RejectionsPath+query, nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+		var rejections []Rejection
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &rejections))
+		return rejections
+	}
+	rejections := get("?signer=" + flashbotsSigner.Address().Hex())
+	require.Len(t, rejections, 2)
+	require.Equal(t, RejectionReasonDuplicate, rejections[0].Reason)
+	require.Equal(t, ErrorReasonValidation, rejections[1].Reason)
+	require.Equal(t, EthSendRawTransactionMethod, rejections[1].Method)
+	require.Equal(t, "local", rejections[1].Endpoint)
+	require.Len(t, rejections[1].PayloadHash, 2+2*rejectionPayloadHashSize)
+	require.Len(t, get("?limit=1"), 1)
+	require.Empty(t, get("?signer=0x0000000000000000000000000000000000000001"))
+}
+
+func TestArchiveSpill(t *testing.T) {
+	dir := t.TempDir()
+	log := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	batch := func(n int) *FlashbotsNewOrderEventsArgs {
+		args := &FlashbotsNewOrderEventsArgs{}
+		for range n {
+			args.OrderEvents = append(args.OrderEvents, ArchiveEvent{EthCancelBundle: &ArchiveEventEthCancelBundle{
+				Params:   &rpctypes.EthCancelBundleArgs{ReplacementUUID: uuid.NewString()},
+				Metadata: &ArchiveEventMetadata{ReceivedAt: time.Now().UnixMilli()},
+			}})
+		}
+		return args
+	}
+
+	spill, err := NewArchiveSpill(log, dir, 0)
+	require.NoError(t, err)
+	require.NoError(t, spill.Write(batch(1)))
+	require.NoError(t, spill.Write(batch(2)))
+	require.NoError(t, spill.Close())
+
+	// batches are kept after restart and sent in order, batches not sent are kept
+	spill, err = NewArchiveSpill(log, dir, 0)
+	require.NoError(t, err)
+	var sent []int
+	err = spill.Drain(func(args *FlashbotsNewOrderEventsArgs) error {
+		if len(sent) == 1 {
+			return errArchiveReturnedError
+		}
+		sent = append(sent, len(args.OrderEvents))
+		return nil
+	})
+	require.ErrorIs(t, err, errArchiveReturnedError)
+	require.NoError(t, spill.Write(batch(3)))
+	require.NoError(t, spill.Drain(func(args *FlashbotsNewOrderEventsArgs) error {
+		sent = append(sent, len(args.OrderEvents))
+		return nil
+	}))
+	require.Equal(t, []int{1, 2, 3}, sent)
+	segments, err := spill.segments()
+	require.NoError(t, err)
+	require.Empty(t, segments)
+
+	// batches are dropped when spill is full
+	spill, err = NewArchiveSpill(log, t.TempDir(), 1000)
+	require.NoError(t, err)
+	require.NoError(t, spill.Write(batch(1)))
+	require.Error(t, spill.Write(batch(10)))
+	require.NoError(t, spill.Close())
+}
+
+func TestParquetArchive(t *testing.T) {
+	dir := t.TempDir()
+	writer, err := NewParquetArchiveWriter(dir)
+	require.NoError(t, err)
+	signer := common.HexToAddress("0x1")
+	receivedAt := time.Date(2024, 10, 27, 12, 0, 0, 0, time.UTC).UnixMilli()
+	metadata := &ArchiveEventMetadata{ReceivedAt: receivedAt}
+	args := &FlashbotsNewOrderEventsArgs{OrderEvents: []ArchiveEvent{
+		{EthSendBundle: &ArchiveEventEthSendBundle{
+			Params:   &rpctypes.EthSendBundleArgs{Txs: []hexutil.Bytes{*createTestTx(0)}, BlockNumber: 123, SigningAddress: &signer},
+			Metadata: metadata,
+		}},
+		{EthSendBundle: &ArchiveEventEthSendBundle{
+			Params:   &rpctypes.EthSendBundleArgs{BlockNumber: 124, SigningAddress: &signer},
+			Metadata: metadata,
+		}},
+		{EthCancelBundle: &ArchiveEventEthCancelBundle{
+			Params:   &rpctypes.EthCancelBundleArgs{ReplacementUUID: "uuid", SigningAddress: &signer},
+			Metadata: metadata,
+		}},
+	}}
+	require.NoError(t, writer.Write(args))
+
+	partition := filepath.Join(dir, "schema=v1", "date=2024-10-27")
+	for _, block := range []string{"block=0", "block=123", "block=124"} {
+		files, err := filepath.Glob(filepath.Join(partition, block, "*.parquet"))
+		require.NoError(t, err)
+		require.Len(t, files, 1)
+	}
+
+	files, err := filepath.Glob(filepath.Join(partition, "block=123", "*.parquet"))
+	require.NoError(t, err)
+	rows, err := parquet.ReadFile[ArchiveParquetRow](files[0])
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+	require.Equal(t, int32(ArchiveParquetSchemaVersion), rows[0].SchemaVersion)
+	require.Equal(t, receivedAt, rows[0].ReceivedAt)
+	require.Equal(t, EthSendBundleMethod, rows[0].Method)
+	require.Equal(t, signer.Hex(), rows[0].Signer)
+	require.Equal(t, [][]byte{*createTestTx(0)}, rows[0].Txs)
+	require.JSONEq(t, `{"txs":["`+createTestTx(0).String()+`"],"blockNumber":"0x7b","signingAddress":"`+strings.ToLower(signer.Hex())+`"}`, string(rows[0].Params))
+}
+
+func TestMetricsServerAuth(t *testing.T) {
+	dir := t.TempDir()
+	cert, key, err := utils_tls.GenerateTLS(time.Hour, []string{"127.0.0.1"})
+	require.NoError(t, err)
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, cert, 0o600))
+	require.NoError(t, os.WriteFile(keyFile, key, 0o600))
+
+	config := MetricsServerConfig{ClientCAFile: certFile}
+	require.ErrorIs(t, config.Validate(), errMetricsClientCA)
+	config = MetricsServerConfig{BasicAuth: "user"}
+	require.ErrorIs(t, config.Validate(), errMetricsBasicAuth)
+
+	config = MetricsServerConfig{TLSCertFile: certFile, TLSKeyFile: keyFile, BasicAuth: "user:password"}
+	require.NoError(t, config.Validate())
+	tlsConfig, err := config.TLSConfig()
+	require.NoError(t, err)
+	server := httptest.NewUnstartedServer(config.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("metrics"))
+	})))
+	server.TLS = tlsConfig
+	server.StartTLS()
+	defer server.Close()
+
+	certPool := x509.NewCertPool()
+	require.True(t, certPool.AppendCertsFromPEM(cert))
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: certPool, MinVersion: tls.VersionTLS12}}}
+	get := func(user, password string) int {
+		req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+		require.NoError(t, err)
+		if user != "" {
+			req.SetBasicAuth(user, password)
+		}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	require.Equal(t, http.StatusUnauthorized, get("", ""))
+	require.Equal(t, http.StatusUnauthorized, get("user", "wrong"))
+	require.Equal(t, http.StatusOK, get("user", "password"))
+
+	// client without certificate is rejected when client CA is set
+	config.ClientCAFile = certFile
+	tlsConfig, err = config.TLSConfig()
+	require.NoError(t, err)
+	mtlsServer := httptest.NewUnstartedServer(http.NotFoundHandler())
+	mtlsServer.TLS = tlsConfig
+	mtlsServer.StartTLS()
+	defer mtlsServer.Close()
+	resp, err := client.Get(mtlsServer.URL)
+	if err == nil {
+		resp.Body.Close()
+	}
+	require.Error(t, err)
+}
+
+func TestPprofServer(t *testing.T) {
+	pprofServer := NewPprofServer("secret", false)
+	server := httptest.NewServer(pprofServer.Handler())
+	defer server.Close()
+	admin := httptest.NewServer(pprofServer.AdminHandler())
+	defer admin.Close()
+
+	do := func(method, url, token string) int {
+		req, err := http.NewRequest(method, url, nil)
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	require.Equal(t, http.StatusUnauthorized, do(http.MethodGet, server.URL+"/debug/pprof/", ""))
+	require.Equal(t, http.StatusNotFound, do(http.MethodGet, server.URL+"/debug/pprof/", "secret"))
+
+	// profiling is enabled at runtime
+	require.Equal(t, http.StatusUnauthorized, do(http.MethodPost, admin.URL+"?enabled=true", "wrong"))
+	require.Equal(t, http.StatusOK, do(http.MethodPost, admin.URL+"?enabled=true", "secret"))
+	require.True(t, pprofServer.Enabled())
+	require.Equal(t, http.StatusOK, do(http.MethodGet, server.URL+"/debug/pprof/", "secret"))
+
+	require.Equal(t, http.StatusOK, do(http.MethodPost, admin.URL+"?enabled=false", "secret"))
+	require.Equal(t, http.StatusNotFound, do(http.MethodGet, server.URL+"/debug/pprof/", "secret"))
+
+	// server without token is never open to anonymous requests
+	anonymous := httptest.NewServer(NewPprofServer("", true).Handler())
+	defer anonymous.Close()
+	require.Equal(t, http.StatusUnauthorized, do(http.MethodGet, anonymous.URL+"/debug/pprof/", ""))
+}
+
+func TestShareQueueReplacements(t *testing.T) {
+	sq := &ShareQueue{replacements: newReplacementTracker()}
+	peer := newShareQueuePeer("peer", nil, 1, 0)
+	defer peer.Close()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	signer := common.Address{1}
+	replacementUUID := uuid.NewString()
+	version := func(blockNumber rpc.BlockNumber) *ParsedRequest {
+		return &ParsedRequest{ethSendBundle: &rpctypes.EthSendBundleArgs{
+			BlockNumber:     blockNumber,
+			ReplacementUUID: &replacementUUID,
+			SigningAddress:  &signer,
+		}}
+	}
+
+	first := version(1)
+	require.Empty(t, sq.replacements.next(first))
+	sq.replacements.record(first, []string{peer.name})
+	second := version(2)
+	require.Equal(t, []string{peer.name}, sq.replacements.next(second))
+	sq.replacements.record(second, []string{localBuilderPeerName})
+
+	// first version was replaced before it was sent
+	_, ok := sq.prepareCall(logger, peer, first)
+	require.False(t, ok)
+	_, ok = sq.prepareCall(logger, peer, second)
+	require.True(t, ok)
+
+	// cancellation received from a peer is routed to everyone that was sent any version
+	cancel := &ParsedRequest{publicEndpoint: true, ethCancelBundle: &rpctypes.EthCancelBundleArgs{
+		ReplacementUUID: strings.ToUpper(replacementUUID),
+		SigningAddress:  &signer,
+	}}
+	require.ElementsMatch(t, []string{peer.name, localBuilderPeerName}, sq.replacements.next(cancel))
+	sq.replacements.record(cancel, []string{peer.name})
+	_, ok = sq.prepareCall(logger, peer, second)
+	require.False(t, ok)
+	_, ok = sq.prepareCall(logger, peer, cancel)
+	require.True(t, ok)
+
+	// unsigned versions are ordered by the replacement uuid
+	key, ok := (&ParsedRequest{ethCancelBundle: &rpctypes.EthCancelBundleArgs{ReplacementUUID: replacementUUID}}).orderingKey()
+	require.True(t, ok)
+	unsignedKey, _ := (&ParsedRequest{ethSendBundle: &rpctypes.EthSendBundleArgs{ReplacementUUID: &replacementUUID}}).orderingKey()
+	require.Equal(t, key, unsignedKey)
+}
+
+func TestTxDuplicateMetrics(t *testing.T) {
+	tracker := newTxDuplicateTracker(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	duplicates := func(kind, peer string) uint64 {
+		return metrics.GetOrCreateCounter(fmt.Sprintf(apiTxDuplicatesByPeer, kind, peer)).Get()
+	}
+	peerA, peerB := "tx-duplicates-a", "tx-duplicates-b"
+
+	tx := hexutil.Bytes(*createTestTx(0))
+	otherTx := hexutil.Bytes(*createTestTx(1))
+	bundle := func(peer string, txs ...hexutil.Bytes) *ParsedRequest {
+		return &ParsedRequest{peerName: peer, ethSendBundle: &rpctypes.EthSendBundleArgs{Txs: txs}}
+	}
+
+	// transaction repeated in one request is not a duplicate
+	tracker.record(bundle(peerA, tx, tx))
+	require.Equal(t, uint64(0), duplicates(txDuplicateKindRequest, peerA))
+
+	tracker.record(bundle(peerA, tx, otherTx))
+	require.Equal(t, uint64(1), duplicates(txDuplicateKindRequest, peerA))
+
+	rawTx := rpctypes.EthSendRawTransactionArgs(otherTx)
+	tracker.record(&ParsedRequest{peerName: peerB, ethSendRawTransaction: &rawTx})
+	require.Equal(t, uint64(1), duplicates(txDuplicateKindPeer, peerB))
+	require.Equal(t, uint64(0), duplicates(txDuplicateKindRequest, peerB))
+	require.Equal(t, uint64(3), metrics.GetOrCreateCounter(fmt.Sprintf(apiTxsByPeer, peerA)).Get())
+}
+
+func TestRequestEventBus(t *testing.T) {
+	builder := ServeHTTPRequestToChan(make(chan *RequestData, 10))
+	defer builder.Close()
+	prx := createProxy(builder.URL, "", func(config *ReceiverProxyConfig) {
+		config.ArchiveEndpoint = "archive-not-set"
+	})
+	defer prx.Stop()
+
+	events := make(chan requestEvent, 10)
+	for _, kind := range []string{requestEventReceived, requestEventValidated, requestEventForwarded, requestEventDropped} {
+		prx.requestEvents.subscribe(kind, func(_ context.Context, event requestEvent) {
+			events <- event
+		})
+	}
+	nextEvent := func() requestEvent {
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(time.Second):
+			t.Fatal("request event was not published")
+			return requestEvent{}
+		}
+	}
+
+	uniqueKey := uuid.New()
+	request := ParsedRequest{
+		method:              EthSendBundleMethod,
+		peerName:            "local-request",
+		ethSendBundle:       &rpctypes.EthSendBundleArgs{BlockNumber: 1},
+		requestArgUniqueKey: &uniqueKey,
+	}
+	require.NoError(t, prx.HandleParsedRequest(context.Background(), request))
+	require.Equal(t, requestEventReceived, nextEvent().kind)
+	// forwarded event is published by the share queue so it can come before validated
+	byKind := make(map[string]requestEvent)
+	for range 2 {
+		event := nextEvent()
+		byKind[event.kind] = event
+	}
+	require.Contains(t, byKind, requestEventValidated)
+	require.Contains(t, byKind[requestEventForwarded].destinations, localBuilderPeerName)
+
+	require.NoError(t, prx.HandleParsedRequest(context.Background(), request))
+	require.Equal(t, requestEventReceived, nextEvent().kind)
+	dropped := nextEvent()
+	require.Equal(t, requestEventDropped, dropped.kind)
+	require.Equal(t, RejectionReasonDuplicate, dropped.reason)
+	require.Equal(t, RejectionReasonDuplicate, prx.rejections.recent(common.Address{}, 1)[0].Reason)
+}
+
+func TestRequestFilters(t *testing.T) {
+	errPolicy := errors.New("signer is not allowed by the operator")
+	var applied []string
+	setBlock := RequestFilterFunc(func(_ context.Context, req *ParsedRequest) (Decision, error) {
+		applied = append(applied, "setBlock")
+		if bundle := req.EthSendBundle(); bundle != nil {
+			bundle.BlockNumber = 10
+		}
+		return DecisionAccept, nil
+	})
+	dropRawTxs := RequestFilterFunc(func(_ context.Context, req *ParsedRequest) (Decision, error) {
+		applied = append(applied, "dropRawTxs")
+		if req.Method() == EthSendRawTransactionMethod {
+			return DecisionDrop, nil
+		}
+		return DecisionAccept, nil
+	})
+	rejectPeers := RequestFilterFunc(func(_ context.Context, req *ParsedRequest) (Decision, error) {
+		applied = append(applied, "rejectPeers")
+		if req.PublicEndpoint() {
+			return DecisionReject, errPolicy
+		}
+		return DecisionAccept, nil
+	})
+	filters := []RequestFilter{setBlock, dropRawTxs, rejectPeers}
+
+	bundle := &ParsedRequest{method: EthSendBundleMethod, ethSendBundle: &rpctypes.EthSendBundleArgs{BlockNumber: 1}}
+	decision, err := applyRequestFilters(context.Background(), filters, bundle)
+	require.NoError(t, err)
+	require.Equal(t, DecisionAccept, decision)
+	require.Equal(t, rpc.BlockNumber(10), bundle.ethSendBundle.BlockNumber)
+	require.Equal(t, []string{"setBlock", "dropRawTxs", "rejectPeers"}, applied)
+
+	applied = nil
+	decision, err = applyRequestFilters(context.Background(), filters, &ParsedRequest{method: EthSendRawTransactionMethod})
+	require.NoError(t, err)
+	require.Equal(t, DecisionDrop, decision)
+	require.Equal(t, []string{"setBlock", "dropRawTxs"}, applied)
+
+	_, err = applyRequestFilters(context.Background(), filters, &ParsedRequest{method: EthSendBundleMethod, publicEndpoint: true})
+	require.ErrorIs(t, err, errPolicy)
+	require.ErrorIs(t, err, errRequestRejected)
+	code, data, ok := rpcErrorCodeAndData(err)
+	require.True(t, ok)
+	require.Equal(t, ErrorCodeUnauthorized, code)
+	require.Equal(t, ErrorReasonRejected, data.Reason)
+
+	rejectAll := RequestFilterFunc(func(context.Context, *ParsedRequest) (Decision, error) { return DecisionReject, nil })
+	_, err = applyRequestFilters(context.Background(), []RequestFilter{rejectAll}, bundle)
+	require.ErrorIs(t, err, errRequestRejected)
+}
+
+// testPolicyModule returns WASM module with alloc returning offset 1024 and filter with the body
+func testPolicyModule(memoryPages byte, filterBody ...byte) []byte {
+	section := func(id byte, content ...byte) []byte {
+		return append([]byte{id, byte(len(content))}, content...)
+	}
+	allocBody := []byte{0x00, 0x41, 0x80, 0x08, 0x0b} // i32.const 1024
+	filterBody = append([]byte{0x00}, filterBody...)
+	module := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+	module = append(module, section(0x01, 0x02, 0x60, 0x01, 0x7f, 0x01, 0x7f, 0x60, 0x02, 0x7f, 0x7f, 0x01, 0x7f)...)
+	module = append(module, section(0x03, 0x02, 0x00, 0x01)...)
+	module = append(module, section(0x05, 0x01, 0x00, memoryPages)...)
+	module = append(module, section(0x07, 0x03,
+		0x06, 'm', 'e', 'm', 'o', 'r', 'y', 0x02, 0x00,
+		0x05, 'a', 'l', 'l', 'o', 'c', 0x00, 0x00,
+		0x06, 'f', 'i', 'l', 't', 'e', 'r', 0x00, 0x01)...)
+	code := append([]byte{0x02, byte(len(allocBody))}, allocBody...)
+	code = append(code, byte(len(filterBody)))
+	code = append(code, filterBody...)
+	return append(module, section(0x0a, code...)...)
+}
+
+func TestWASMPolicyFilter(t *testing.T) {
+	request := &ParsedRequest{method: EthSendBundleMethod, ethSendBundle: &rpctypes.EthSendBundleArgs{BlockNumber: 1}}
+	apply := func(filterBody ...byte) (Decision, error) {
+		policy, err := NewWASMPolicyFilter("test", testPolicyModule(1, filterBody...), WASMPolicyOpts{Timeout: time.Millisecond * 100})
+		require.NoError(t, err)
+		defer policy.Close()
+		return policy.Apply(context.Background(), request)
+	}
+
+	// returns first byte of the input minus '{', so the input must be written to the memory to accept the request
+	decision, err := apply(0x20, 0x00, 0x2d, 0x00, 0x00, 0x41, 0xfb, 0x00, 0x6b, 0x0b)
+	require.NoError(t, err)
+	require.Equal(t, DecisionAccept, decision)
+
+	decision, err = apply(0x41, 0x02, 0x0b)
+	require.NoError(t, err)
+	require.Equal(t, DecisionDrop, decision)
+
+	_, err = apply(0x41, 0x01, 0x0b)
+	require.ErrorIs(t, err, errRequestRejected)
+
+	_, err = apply(0x41, 0x07, 0x0b)
+	require.ErrorIs(t, err, errWASMPolicyDecision)
+
+	// infinite loop is stopped by the timeout
+	start := time.Now()
+	_, err = apply(0x03, 0x40, 0x0c, 0x00, 0x0b, 0x41, 0x00, 0x0b)
+	require.Error(t, err)
+	require.Less(t, time.Since(start), time.Second)
+
+	// module needs more memory than allowed
+	_, err = NewWASMPolicyFilter("test", testPolicyModule(2, 0x41, 0x00, 0x0b), WASMPolicyOpts{MaxMemoryBytes: 64 * 1024})
+	require.Error(t, err)
+	_, err = NewWASMPolicyFilter("test", []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}, WASMPolicyOpts{})
+	require.ErrorIs(t, err, errWASMPolicyExports)
+}
+
+func TestMiddlewares(t *testing.T) {
+	var order []string
+	named := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	var logs bytes.Buffer
+	log := slog.New(slog.NewTextHandler(&logs, nil))
+	handler := chainMiddlewares(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		order = append(order, "handler")
+		panic("handler failed")
+	}), named("first"), AccessLogMiddleware(log, "public"), RecoveryMiddleware(log), named("last"))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/", nil))
+	require.Equal(t, []string{"first", "last", "handler"}, order)
+	require.Equal(t, http.StatusInternalServerError, rr.Code)
+	require.Contains(t, rr.Body.String(), "internal error")
+	require.Contains(t, logs.String(), "HTTP handler panicked")
+	require.Contains(t, logs.String(), "status=500")
+}
+
+func TestRequestDeadlineThroughHandler(t *testing.T) {
+	builderRequests := make(chan *RequestData, 10)
+	builder := ServeHTTPRequestToChan(builderRequests)
+	defer builder.Close()
+	var slow atomic.Bool
+	deadlines := make(chan time.Duration, 10)
+	prx := createProxy(builder.URL, "", func(config *ReceiverProxyConfig) {
+		config.RequestFilters = []RequestFilter{RequestFilterFunc(func(_ context.Context, req *ParsedRequest) (Decision, error) {
+			deadlines <- req.deadline.Sub(req.receivedAt)
+			if slow.Load() {
+				time.Sleep(time.Millisecond * 150)
+			}
+			return DecisionAccept, nil
+		})}
+		config.ArchiveEndpoint = "archive-not-set"
+		config.RequestDeadline = time.Millisecond * 100
+	})
+	defer prx.Stop()
+	localServer := httptest.NewServer(prx.LocalHandler)
+	defer localServer.Close()
+	client := rpcclient.NewClientWithOpts(localServer.URL, &rpcclient.RPCClientOpts{
+		HTTPClient: HTTPClientWithSigner(&http.Client{}, flashbotsSigner),
+	})
+	send := func(nonce int) {
+		args := rpctypes.EthSendRawTransactionArgs(*createTestTx(nonce))
+		resp, err := client.Call(context.Background(), EthSendRawTransactionMethod, &args)
+		require.NoError(t, err)
+		require.Nil(t, resp.Error)
+	}
+
+	// deadline is measured from the time the request was received
+	send(0)
+	require.Equal(t, time.Millisecond*100, <-deadlines)
+	expectRequest(t, builderRequests)
+
+	// request that is delayed past its deadline before it is queued is not sent to the builder
+	expired := metrics.GetOrCreateCounter(fmt.Sprintf(shareQueuePeerExpiredDroppedLabel, localBuilderPeerName))
+	expiredBefore := expired.Get()
+	slow.Store(true)
+	send(1)
+	<-deadlines
+	expectNoRequest(t, builderRequests)
+	require.Equal(t, expiredBefore+1, expired.Get())
+}
+
+func TestRequestDeadlines(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	sq := &ShareQueue{log: logger}
+	peer := newShareQueuePeer("peer", rpcclient.NewClient(server.URL), 1, 0)
+	defer peer.Close()
+
+	// request past its deadline is not sent
+	expired := &ParsedRequest{ethSendRawTransaction: &rpctypes.EthSendRawTransactionArgs{1}, deadline: time.Now().Add(-time.Millisecond)}
+	_, ok := sq.prepareCall(logger, peer, expired)
+	require.False(t, ok)
+
+	// call is cancelled at the deadline of the client request instead of the peer timeout
+	request := &ParsedRequest{ethSendRawTransaction: &rpctypes.EthSendRawTransactionArgs{1}, deadline: time.Now().Add(50 * time.Millisecond)}
+	call, ok := sq.prepareCall(logger, peer, request)
+	require.True(t, ok)
+	start := time.Now()
+	require.Error(t, sq.sendCall(logger, peer, call))
+	require.Less(t, time.Since(start), peer.timeout/2)
+
+	// batch waits for the latest deadline, requests without deadline use the peer timeout
+	require.Equal(t, request.deadline, batchDeadline([]shareCall{{deadline: request.deadline.Add(-time.Second)}, call}))
+	require.True(t, batchDeadline([]shareCall{call, {}}).IsZero())
+}
+
+func TestQueueWaitMetrics(t *testing.T) {
+	builderRequests := make(chan *RequestData, 10)
+	builder := ServeHTTPRequestToChan(builderRequests)
+	defer builder.Close()
+	waitCount := func(queue, method string) (count uint64) {
+		metrics.GetOrCreateHistogram(fmt.Sprintf(queueWaitLabel, queue, method)).VisitNonZeroBuckets(func(_ string, c uint64) {
+			count += c
+		})
+		return count
+	}
+
+	peer := newShareQueuePeer("test", rpcclient.NewClient(builder.URL), 1, 0)
+	defer peer.Close()
+	sq := &ShareQueue{log: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	go sq.proxyRequests(peer, shareQueueLanePeer, 0)
+
+	// requests that were not queued with the time are not measured
+	peer.SendRequest(sq.log, &ParsedRequest{publicEndpoint: true, method: "test_notQueued", ethSendRawTransaction: &rpctypes.EthSendRawTransactionArgs{1}})
+	expectRequest(t, builderRequests)
+	require.Zero(t, waitCount(queueNamePeerShare, "test_notQueued"))
+
+	peer.SendRequest(sq.log, &ParsedRequest{
+		publicEndpoint:        true,
+		method:                "test_queued",
+		shareQueuedAt:         time.Now().Add(-time.Second),
+		ethSendRawTransaction: &rpctypes.EthSendRawTransactionArgs{1},
+	})
+	expectRequest(t, builderRequests)
+	require.Equal(t, uint64(1), waitCount(queueNamePeerShare, "test_queued"))
+}
+
+func TestPeerProbes(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":0,"result":"test"}`))
+	}))
+	serverCertPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	sq := &ShareQueue{log: log, signer: flashbotsSigner, certs: newPeerCertCache(time.Hour), peerSigner: newSharedSignatures(flashbotsSigner), probeInterval: time.Hour}
+	done := make(chan struct{})
+	defer close(done)
+	peers, _ := sq.updatePeerList(nil, []ConfighubBuilder{{
+		Name:           "probed-peer",
+		IP:             server.Listener.Addr().String(),
+		OrderflowProxy: ConfighubOrderflowProxyCredentials{TLSCert: serverCertPEM},
+	}}, 1, make(chan verifiedPeer), done)
+	require.Len(t, peers, 1)
+	defer peers[0].Close()
+	histogramCount := func(label string) (count uint64) {
+		metrics.GetOrCreateHistogram(fmt.Sprintf(label, "probed-peer")).VisitNonZeroBuckets(func(_ string, c uint64) {
+			count += c
+		})
+		return count
+	}
+
+	// every probe opens a new connection so TLS handshake is measured every time
+	for range 2 {
+		sq.probePeer(peers[0])
+	}
+	require.Equal(t, uint64(2), histogramCount(peerProbeRTTLabel))
+	require.Equal(t, uint64(2), histogramCount(peerProbeTLSHandshakeLabel))
+
+	server.Close()
+	sq.probePeer(peers[0])
+	require.Equal(t, uint64(2), histogramCount(peerProbeRTTLabel))
+	require.Equal(t, uint64(1), metrics.GetOrCreateCounter(fmt.Sprintf(peerProbeErrorsLabel, "probed-peer")).Get())
+}
+
+func TestPeerLatencyOrdering(t *testing.T) {
+	sq := &ShareQueue{
+		log:             slog.New(slog.NewTextHandler(io.Discard, nil)),
+		slowPeerLatency: 100 * time.Millisecond,
+		replacements:    newReplacementTracker(),
+	}
+	slowPeer := newShareQueuePeer("slow", nil, 1, 0)
+	defer slowPeer.Close()
+	fastPeer := newShareQueuePeer("fast", nil, 1, 0)
+	defer fastPeer.Close()
+	slowPeer.latency.record(time.Second)
+	fastPeer.latency.record(time.Millisecond)
+
+	peers := []*shareQueuePeer{slowPeer, fastPeer}
+	sq.orderPeers(peers)
+	require.Equal(t, []*shareQueuePeer{fastPeer, slowPeer}, peers)
+	require.True(t, slowPeer.slow)
+	require.False(t, fastPeer.slow)
+
+	// slow peer gets the request only when the slow lane is delivered
+	req := &ParsedRequest{ethSendRawTransaction: &rpctypes.EthSendRawTransactionArgs{1}}
+	slow := sq.dispatch(req, nil, peers, nil)
+	require.Len(t, slow, 1)
+	_, ok, _ := fastPeer.nextRequestBefore(shareQueueLaneLocal, 0, nil)
+	require.True(t, ok)
+	_, ok, _ = slowPeer.nextRequestBefore(shareQueueLaneLocal, 0, nil)
+	require.False(t, ok)
+
+	require.Empty(t, sq.deliverSlowLane(slow))
+	queued, ok, _ := slowPeer.nextRequestBefore(shareQueueLaneLocal, 0, nil)
+	require.True(t, ok)
+	require.Same(t, req, queued)
+}
+
+func TestDisablePeerSharing(t *testing.T) {
+	builder := ServeHTTPRequestToChan(make(chan *RequestData, 10))
+	defer builder.Close()
+	prx := createProxy(builder.URL, "", func(config *ReceiverProxyConfig) {
+		config.ArchiveEndpoint = "archive-not-set"
+		config.DisablePeerSharing = true
+	})
+	defer prx.Stop()
+	require.True(t, prx.features.PeerSharingDisabled)
+
+	forwarded := make(chan requestEvent, 10)
+	prx.requestEvents.subscribe(requestEventForwarded, func(_ context.Context, event requestEvent) {
+		forwarded <- event
+	})
+
+	require.NoError(t, prx.RequestNewPeers())
+	// peers are still known so they can send requests to the public endpoint
+	prx.peersMu.RLock()
+	require.NotEmpty(t, prx.lastFetchedPeers)
+	prx.peersMu.RUnlock()
+
+	uniqueKey := uuid.New()
+	require.NoError(t, prx.HandleParsedRequest(context.Background(), ParsedRequest{
+		method:              EthSendBundleMethod,
+		peerName:            "local-request",
+		ethSendBundle:       &rpctypes.EthSendBundleArgs{BlockNumber: 1},
+		requestArgUniqueKey: &uniqueKey,
+	}))
+	select {
+	case event := <-forwarded:
+		require.Equal(t, []string{localBuilderPeerName}, event.destinations)
+	case <-time.After(time.Second):
+		t.Fatal("request was not forwarded")
+	}
+}
+
+func TestReceiveOnly(t *testing.T) {
+	builder := ServeHTTPRequestToChan(make(chan *RequestData, 10))
+	defer builder.Close()
+	archiveCh := make(chan *RequestData, 10)
+	archive := ServeHTTPRequestToChan(archiveCh)
+	defer archive.Close()
+	prx := createProxy(builder.URL, "", func(config *ReceiverProxyConfig) {
+		config.ArchiveEndpoint = archive.URL
+		config.ReceiveOnly = true
+	})
+	defer prx.Stop()
+	require.True(t, prx.features.ReceiveOnly)
+	require.True(t, prx.features.PeerSharingDisabled)
+	require.Equal(t, ArchiveSinkRPC, prx.features.ArchiveSink)
+
+	forwarded := make(chan requestEvent, 10)
+	prx.requestEvents.subscribe(requestEventForwarded, func(_ context.Context, event requestEvent) {
+		forwarded <- event
+	})
+	require.NoError(t, prx.RequestNewPeers())
+
+	uniqueKey := uuid.New()
+	require.NoError(t, prx.HandleParsedRequest(context.Background(), ParsedRequest{
+		method:              EthSendBundleMethod,
+		peerName:            "local-request",
+		ethSendBundle:       &rpctypes.EthSendBundleArgs{BlockNumber: 1},
+		requestArgUniqueKey: &uniqueKey,
+	}))
+	select {
+	case event := <-forwarded:
+		require.Equal(t, []string{localBuilderPeerName}, event.destinations)
+	case <-time.After(time.Second):
+		t.Fatal("request was not forwarded")
+	}
+
+	// archiving is governed by the archive flags
+	prx.FlushArchiveQueue()
+	expectRequest(t, archiveCh)
+}
+
+type benchmarkTransport struct{}
+
+func (benchmarkTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	_, _ = io.Copy(io.Discard, req.Body)
+	_ = req.Body.Close()
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+}
+
+func benchmarkBundleBody(b *testing.B) []byte {
+	b.Helper()
+	args := rpctypes.EthSendBundleArgs{BlockNumber: 1}
+	for i := range 20 {
+		args.Txs = append(args.Txs, hexutil.Bytes(*createTestTx(i)))
+	}
+	body, err := json.Marshal(rpcclient.NewRequest(EthSendBundleMethod, args))
+	require.NoError(b, err)
+	return body
+}
+
+func BenchmarkPublicRequestBody(b *testing.B) {
+	body := benchmarkBundleBody(b)
+	header, err := flashbotsSigner.Create(body)
+	require.NoError(b, err)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+	})
+	handler := batchHandler(next, next, nil, DefaultMaxRequestBodySizeBytes)
+
+	b.ReportAllocs()
+	for range b.N {
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		req.Header.Set(signature.HTTPHeader, header)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+}
+
+func BenchmarkSigningTransport(b *testing.B) {
+	body := benchmarkBundleBody(b)
+	client := HTTPClientWithSigner(&http.Client{Transport: benchmarkTransport{}}, flashbotsSigner)
+
+	b.ReportAllocs()
+	for range b.N {
+		resp, err := client.Post("http://localhost", "application/json", bytes.NewReader(body))
+		require.NoError(b, err)
+		_ = resp.Body.Close()
+	}
+}
+
+func BenchmarkShareQueueCollectBatch(b *testing.B) {
+	bundle := rpctypes.EthSendBundleArgs{BlockNumber: 1}
+	for i := range 20 {
+		bundle.Txs = append(bundle.Txs, hexutil.Bytes(*createTestTx(i)))
+	}
+	sq := &ShareQueue{}
+	peer := newShareQueuePeer("benchmark", nil, 1, 0)
+	defer peer.Close()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	b.ReportAllocs()
+	for range b.N {
+		_, calls, _ := sq.collectBatch(logger, peer, shareQueueLaneLocal, 0, nil, []shareCall{{method: EthSendBundleMethod, data: &bundle}}, 0)
+		for _, call := range calls {
+			putBuffer(call.params)
+		}
+	}
+}
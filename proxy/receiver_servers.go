@@ -3,6 +3,7 @@ package proxy
 import (
 	"errors"
 	"log/slog"
+	"net"
 	"net/http"
 	"time"
 )
@@ -17,9 +18,12 @@ type ReceiverProxyServers struct {
 	publicServer *http.Server
 	localServer  *http.Server
 	certServer   *http.Server
+	// optional, nil if events listen address is not set
+	eventsServer *http.Server
 }
 
-func StartReceiverServers(proxy *ReceiverProxy, publicListenAddress, localListenAddress, certListenAddress string) (*ReceiverProxyServers, error) {
+// StartReceiverServers starts all servers of the receiver, events server is started only if eventsListenAddress is not empty
+func StartReceiverServers(proxy *ReceiverProxy, publicListenAddress, localListenAddress, certListenAddress, eventsListenAddress string) (*ReceiverProxyServers, error) {
 	publicServer := &http.Server{
 		Addr:         publicListenAddress,
 		Handler:      proxy.PublicHandler,
@@ -34,17 +38,41 @@ func StartReceiverServers(proxy *ReceiverProxy, publicListenAddress, localListen
 		ReadTimeout:  HTTPDefaultReadTimeout,
 		WriteTimeout: HTTPDefaultWriteTimeout,
 	}
+	certMux := http.NewServeMux()
+	certMux.Handle("/buildinfo", proxy.BuildInfoHandler)
+	certMux.Handle("/", proxy.CertHandler)
 	certServer := &http.Server{
 		Addr:         certListenAddress,
-		Handler:      proxy.CertHandler,
+		Handler:      certMux,
 		ReadTimeout:  HTTPDefaultReadTimeout,
 		WriteTimeout: HTTPDefaultWriteTimeout,
 	}
 
+	var eventsServer *http.Server
+	if eventsListenAddress != "" {
+		eventsMux := http.NewServeMux()
+		eventsMux.Handle(EventsPath, proxy.EventsHandler)
+		eventsMux.Handle(RejectionsPath, proxy.RejectionsHandler)
+		// write timeout is not set because events are streamed
+		eventsServer = &http.Server{
+			Addr:              eventsListenAddress,
+			Handler:           eventsMux,
+			ReadHeaderTimeout: HTTPDefaultReadTimeout,
+		}
+	}
+
+	publicListener, err := net.Listen("tcp", publicListenAddress)
+	if err != nil {
+		return nil, errors.Join(errors.New("public HTTP server failed"), err)
+	}
+	if proxy.PublicProxyProtocol {
+		publicListener = &ProxyProtocolListener{Listener: publicListener}
+	}
+
 	errCh := make(chan error)
 
 	go func() {
-		if err := publicServer.ListenAndServeTLS("", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		if err := publicServer.ServeTLS(publicListener, "", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			err = errors.Join(errors.New("public HTTP server failed"), err)
 			errCh <- err
 		}
@@ -61,6 +89,14 @@ func StartReceiverServers(proxy *ReceiverProxy, publicListenAddress, localListen
 			errCh <- err
 		}
 	}()
+	if eventsServer != nil {
+		go func() {
+			if err := eventsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				err = errors.Join(errors.New("events HTTP server failed"), err)
+				errCh <- err
+			}
+		}()
+	}
 
 	select {
 	case err := <-errCh:
@@ -83,6 +119,7 @@ func StartReceiverServers(proxy *ReceiverProxy, publicListenAddress, localListen
 		publicServer: publicServer,
 		localServer:  localServer,
 		certServer:   certServer,
+		eventsServer: eventsServer,
 	}, nil
 }
 
@@ -90,6 +127,9 @@ func (s *ReceiverProxyServers) Stop() {
 	_ = s.publicServer.Close()
 	_ = s.localServer.Close()
 	_ = s.certServer.Close()
+	if s.eventsServer != nil {
+		_ = s.eventsServer.Close()
+	}
 	s.proxy.Stop()
 }
 
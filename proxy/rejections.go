@@ -0,0 +1,196 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/flashbots/go-utils/rpcserver"
+)
+
+var (
+	// RejectionRingSize is the number of the most recent rejections kept in memory
+	RejectionRingSize = 1000
+	// payload hash is truncated to this many bytes, it is only used to find the request
+	rejectionPayloadHashSize = 8
+)
+
+// RejectionsPath is the path of the rejections endpoint on the events listener
+const RejectionsPath = "/admin/rejections"
+
+// reasons of the dropped requests, rejected requests use reason of RPCErrorData or "error"
+const (
+	RejectionReasonDuplicate        = "duplicate"
+	RejectionReasonShareQueueFull   = "share_queue_full"
+	RejectionReasonArchiveQueueFull = "archive_queue_full"
+	// queued requests evicted to make space for the new ones by BackpressureDropOldest
+	RejectionReasonShareQueueEvicted   = "share_queue_evicted"
+	RejectionReasonArchiveQueueEvicted = "archive_queue_evicted"
+	rejectionReasonError               = "error"
+)
+
+// Rejection is a request that was rejected with error or accepted but not forwarded
+type Rejection struct {
+	Time     time.Time      `json:"time"`
+	Endpoint string         `json:"endpoint"`
+	Method   string         `json:"method"`
+	Signer   common.Address `json:"signer"`
+	Reason   string         `json:"reason"`
+	Error    string         `json:"error,omitempty"`
+	// PayloadHash is truncated keccak256 of the JSON params
+	PayloadHash string `json:"payloadHash"`
+}
+
+// rejectionRing keeps the most recent rejections, the oldest ones are overwritten
+type rejectionRing struct {
+	mu      sync.Mutex
+	entries []Rejection
+	next    int
+	full    bool
+}
+
+func newRejectionRing(size int) *rejectionRing {
+	return &rejectionRing{entries: make([]Rejection, max(size, 1))}
+}
+
+func (r *rejectionRing) add(rejection Rejection) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries[r.next] = rejection
+	r.next = (r.next + 1) % len(r.entries)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// recent returns up to limit rejections, newest first, filtered by signer if it is not zero
+func (r *rejectionRing) recent(signer common.Address, limit int) []Rejection {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	count := r.next
+	if r.full {
+		count = len(r.entries)
+	}
+	result := make([]Rejection, 0, min(count, limit))
+	for i := 1; i <= count && len(result) < limit; i++ {
+		rejection := r.entries[(r.next-i+len(r.entries))%len(r.entries)]
+		if signer != (common.Address{}) && rejection.Signer != signer {
+			continue
+		}
+		result = append(result, rejection)
+	}
+	return result
+}
+
+func rejectionPayloadHash(payload any) string {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return ""
+	}
+	return hexutil.Encode(crypto.Keccak256(data)[:rejectionPayloadHashSize])
+}
+
+func endpointName(publicEndpoint bool) string {
+	if publicEndpoint {
+		return "public"
+	}
+	return "local"
+}
+
+// recordRejections wraps methods so requests they reject are added to the ring
+func recordRejections(methods rpcserver.Methods, ring *rejectionRing, endpoint string) rpcserver.Methods {
+	if ring == nil {
+		return methods
+	}
+	for name, method := range methods {
+		fn := reflect.ValueOf(method)
+		methods[name] = reflect.MakeFunc(fn.Type(), func(args []reflect.Value) []reflect.Value {
+			results := fn.Call(args)
+			errValue := results[len(results)-1]
+			if errValue.IsNil() {
+				return results
+			}
+			err, _ := errValue.Interface().(error)
+			ctx, _ := args[0].Interface().(context.Context)
+			params := make([]any, 0, len(args)-1)
+			for _, arg := range args[1:] {
+				params = append(params, arg.Interface())
+			}
+			reason := rejectionReasonError
+			if _, data, ok := rpcErrorCodeAndData(err); ok {
+				reason = data.Reason
+			}
+			ring.add(Rejection{
+				Time:        time.Now().UTC(),
+				Endpoint:    endpoint,
+				Method:      name,
+				Signer:      rpcserver.GetSigner(ctx),
+				Reason:      reason,
+				Error:       err.Error(),
+				PayloadHash: rejectionPayloadHash(params),
+			})
+			return results
+		}).Interface()
+	}
+	return methods
+}
+
+// recordDropped adds request that was accepted but not forwarded to the ring
+func (prx *ReceiverProxy) recordDropped(_ context.Context, event requestEvent) {
+	req := event.req
+	var params any
+	switch {
+	case req.ethSendBundle != nil:
+		params = req.ethSendBundle
+	case req.mevSendBundle != nil:
+		params = req.mevSendBundle
+	case req.ethCancelBundle != nil:
+		params = req.ethCancelBundle
+	case req.ethSendRawTransaction != nil:
+		params = req.ethSendRawTransaction
+	case req.bidSubsidiseBlock != nil:
+		params = req.bidSubsidiseBlock
+	}
+	prx.rejections.add(Rejection{
+		Time:        time.Now().UTC(),
+		Endpoint:    endpointName(req.publicEndpoint),
+		Method:      req.method,
+		Signer:      req.signer,
+		Reason:      event.reason,
+		PayloadHash: rejectionPayloadHash([]any{params}),
+	})
+}
+
+// serveRejections returns recent rejections as JSON, newest first
+// signer query parameter filters them by signer and limit limits their number
+func (prx *ReceiverProxy) serveRejections(w http.ResponseWriter, r *http.Request) {
+	var signer common.Address
+	if value := r.URL.Query().Get("signer"); value != "" {
+		if !common.IsHexAddress(value) {
+			http.Error(w, "invalid signer", http.StatusBadRequest)
+			return
+		}
+		signer = common.HexToAddress(value)
+	}
+	limit := RejectionRingSize
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(prx.rejections.recent(signer, limit))
+}
@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/golang-lru/v2/expirable"
+)
+
+// ReplacementTTL is the time versions of the bundle with replacement uuid are tracked after the last one was queued
+var ReplacementTTL = time.Second * 5 * 12
+
+const replacementTrackerSize = 4096
+
+// replacementKey returns the key shared by all versions and cancellations of the bundle with replacement uuid
+// eth_cancelBundle cancels eth_sendBundle while mev_sendBundle is cancelled with empty body
+func (r *ParsedRequest) replacementKey() (key string, ok bool) {
+	var kind, replacementUUID string
+	switch {
+	case r.ethSendBundle != nil && r.ethSendBundle.ReplacementUUID != nil:
+		kind, replacementUUID = EthSendBundleMethod, *r.ethSendBundle.ReplacementUUID
+	case r.ethCancelBundle != nil:
+		kind, replacementUUID = EthSendBundleMethod, r.ethCancelBundle.ReplacementUUID
+	case r.mevSendBundle != nil:
+		kind, replacementUUID = MevSendBundleMethod, r.mevSendBundle.ReplacementUUID
+	}
+	if replacementUUID == "" {
+		return "", false
+	}
+	return kind + ":" + r.originalSigner().Hex() + ":" + strings.ToLower(replacementUUID), true
+}
+
+// isCancellation returns true if request cancels all versions of the bundle
+func (r *ParsedRequest) isCancellation() bool {
+	return r.ethCancelBundle != nil || (r.mevSendBundle != nil && len(r.mevSendBundle.Body) == 0)
+}
+
+// highPriority returns true for the requests that are sent before the queued bundles and transactions,
+// delayed cancellation or subsidy costs much more than delayed submission
+func (r *ParsedRequest) highPriority() bool {
+	return r.isCancellation() || r.bidSubsidiseBlock != nil
+}
+
+type replacementVersions struct {
+	latest uint64
+	// peers that were sent any version of the bundle
+	destinations []string
+}
+
+// replacementTracker numbers versions of the bundles with replacement uuid so workers can drop versions
+// that were replaced before they were sent, it is updated by the share queue loop and read by the workers
+type replacementTracker struct {
+	versions *expirable.LRU[string, replacementVersions]
+}
+
+func newReplacementTracker() *replacementTracker {
+	return &replacementTracker{
+		versions: expirable.NewLRU[string, replacementVersions](replacementTrackerSize, nil, ReplacementTTL),
+	}
+}
+
+// next sets version of the request and returns peers that were sent its previous versions
+func (t *replacementTracker) next(req *ParsedRequest) []string {
+	key, ok := req.replacementKey()
+	if !ok {
+		return nil
+	}
+	versions, _ := t.versions.Peek(key)
+	req.replacementVersion = versions.latest + 1
+	return versions.destinations
+}
+
+// record adds peers the request was sent to, it must be called after next from the same goroutine
+func (t *replacementTracker) record(req *ParsedRequest, destinations []string) {
+	key, ok := req.replacementKey()
+	if !ok {
+		return
+	}
+	versions, _ := t.versions.Peek(key)
+	merged := slices.Clone(versions.destinations)
+	for _, destination := range destinations {
+		if !slices.Contains(merged, destination) {
+			merged = append(merged, destination)
+		}
+	}
+	t.versions.Add(key, replacementVersions{latest: req.replacementVersion, destinations: merged})
+}
+
+// superseded returns true if a newer version of the bundle was queued, cancellations are always sent
+func (t *replacementTracker) superseded(req *ParsedRequest) bool {
+	if t == nil || req.replacementVersion == 0 || req.isCancellation() {
+		return false
+	}
+	key, _ := req.replacementKey()
+	versions, ok := t.versions.Peek(key)
+	return ok && versions.latest > req.replacementVersion
+}
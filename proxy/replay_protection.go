@@ -0,0 +1,214 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/flashbots/go-utils/signature"
+	"github.com/hashicorp/golang-lru/v2/expirable"
+)
+
+// ReplayProtectionHeader is sent together with X-Flashbots-Signature, its value is "timestamp:nonce:signature"
+// where signature is created by the same key over "timestamp:nonce:" followed by the request body.
+// Old receivers ignore this header so it can be sent unconditionally.
+const ReplayProtectionHeader = "X-Flashbots-Replay-Protection"
+
+var (
+	DefaultSignatureFreshnessWindow = time.Minute
+
+	// nonces are kept for this many requests per second from all peers during the time they can be replayed
+	replayProtectionExpectedRPS = 2000
+
+	errReplayProtectionHeader   = errors.New("invalid replay protection header")
+	errReplayProtectionRequired = errors.New("replay protection header is required")
+	errReplayProtectionStale    = errors.New("request timestamp is outside of the freshness window")
+	errReplayProtectionNonce    = errors.New("request nonce was already used")
+)
+
+func replayProtectionPayload(timestamp, nonce string, body []byte) []byte {
+	payload := make([]byte, 0, len(timestamp)+len(nonce)+2+len(body))
+	payload = append(payload, timestamp...)
+	payload = append(payload, ':')
+	payload = append(payload, nonce...)
+	payload = append(payload, ':')
+	return append(payload, body...)
+}
+
+// createReplayProtectionHeader returns value of the ReplayProtectionHeader for the body
+func createReplayProtectionHeader(signer RequestSigner, body []byte, now time.Time) (string, error) {
+	var nonceBytes [16]byte
+	_, err := rand.Read(nonceBytes[:])
+	if err != nil {
+		return "", err
+	}
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	nonce := hexutil.Encode(nonceBytes[:])
+	signatureHeader, err := signer.Create(replayProtectionPayload(timestamp, nonce, body))
+	if err != nil {
+		return "", err
+	}
+	_, sig, _ := strings.Cut(signatureHeader, ":")
+	return timestamp + ":" + nonce + ":" + sig, nil
+}
+
+// SignaturePolicy configures how signatures of the incoming requests are verified
+type SignaturePolicy struct {
+	// MaxClockSkew is the max difference between local time and the timestamp of replay protected requests
+	MaxClockSkew time.Duration
+	// RequireReplayProtection rejects requests to the public endpoint without the replay protection header
+	RequireReplayProtection bool
+	// AllowUnsignedLocal accepts unsigned requests on the local endpoint, they are processed with zero address as a signer
+	AllowUnsignedLocal bool
+}
+
+// ReplayProtection verifies ReplayProtectionHeader of the incoming requests
+// requests without the header are passed through unchanged unless the header is required
+type ReplayProtection struct {
+	window                  time.Duration
+	required                bool
+	maxRequestBodySizeBytes int64
+	noncesMu                sync.Mutex
+	nonces                  *expirable.LRU[string, struct{}]
+	now                     func() time.Time
+}
+
+func NewReplayProtection(policy SignaturePolicy, maxRequestBodySizeBytes int64) *ReplayProtection {
+	window := DefaultSignatureFreshnessWindow
+	if policy.MaxClockSkew != 0 {
+		window = policy.MaxClockSkew
+	}
+	// timestamps are accepted within window in both directions so nonce can't be reused after 2 windows
+	nonceCacheSize := int((2*window)/time.Second+1) * replayProtectionExpectedRPS
+	return &ReplayProtection{
+		window:                  window,
+		required:                policy.RequireReplayProtection,
+		maxRequestBodySizeBytes: maxRequestBodySizeBytes,
+		nonces:                  expirable.NewLRU[string, struct{}](nonceCacheSize, nil, 2*window),
+		now:                     time.Now,
+	}
+}
+
+// Verify checks replay protection header value for the body signed by the signer from the X-Flashbots-Signature header
+func (p *ReplayProtection) Verify(header, signatureHeader string, body []byte) error {
+	parts := strings.Split(header, ":")
+	if len(parts) != 3 {
+		incAPIReplayProtectionRejections("header")
+		return errReplayProtectionHeader
+	}
+	timestamp, nonce, sig := parts[0], parts[1], parts[2]
+	unixTimestamp, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || nonce == "" {
+		incAPIReplayProtectionRejections("header")
+		return errReplayProtectionHeader
+	}
+
+	signerAddress, _, _ := strings.Cut(signatureHeader, ":")
+	signer, err := signature.Verify(signerAddress+":"+sig, replayProtectionPayload(timestamp, nonce, body))
+	if err != nil {
+		incAPIReplayProtectionRejections("signature")
+		return errors.Join(errReplayProtectionHeader, err)
+	}
+
+	diff := p.now().Sub(time.Unix(unixTimestamp, 0))
+	if diff > p.window || diff < -p.window {
+		incAPIReplayProtectionRejections("stale")
+		return errReplayProtectionStale
+	}
+
+	key := signer.Hex() + ":" + nonce
+	p.noncesMu.Lock()
+	defer p.noncesMu.Unlock()
+	if p.nonces.Contains(key) {
+		incAPIReplayProtectionRejections("nonce")
+		return errReplayProtectionNonce
+	}
+	p.nonces.Add(key, struct{}{})
+	return nil
+}
+
+// Handler verifies replay protection header before passing request to the next handler
+func (p *ReplayProtection) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get(ReplayProtectionHeader)
+		if header == "" && p.required {
+			incAPIReplayProtectionRejections("missing")
+			writeJSONRPCError(w, errReplayProtectionRequired.Error())
+			return
+		}
+		if header == "" || r.Body == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		buf, err := readPooled(http.MaxBytesReader(w, r.Body, p.maxRequestBodySizeBytes), r.ContentLength)
+		if err != nil {
+			writeJSONRPCError(w, fmt.Sprintf("request body is too big, max size: %d", p.maxRequestBodySizeBytes))
+			return
+		}
+		defer putBuffer(buf)
+		body := buf.Bytes()
+		err = p.Verify(header, r.Header.Get(signature.HTTPHeader), body)
+		if err != nil {
+			writeJSONRPCError(w, err.Error())
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// optionalSignatureHandler verifies X-Flashbots-Signature only if it is present
+// next handler is expected to extract signer from the header without verification
+func optionalSignatureHandler(next http.Handler, maxRequestBodySizeBytes int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get(signature.HTTPHeader)
+		if header == "" || r.Body == nil {
+			incAPIUnsignedLocalRequests()
+			r.Header.Del(signature.HTTPHeader)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		buf, err := readPooled(http.MaxBytesReader(w, r.Body, maxRequestBodySizeBytes), r.ContentLength)
+		if err != nil {
+			writeJSONRPCError(w, fmt.Sprintf("request body is too big, max size: %d", maxRequestBodySizeBytes))
+			return
+		}
+		defer putBuffer(buf)
+		body := buf.Bytes()
+		_, err = signature.Verify(header, body)
+		if err != nil {
+			writeJSONRPCError(w, err.Error())
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeJSONRPCError writes invalid request error in the same format as rpcserver
+func writeJSONRPCError(w http.ResponseWriter, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(jsonRPCError(msg))
+}
+
+func jsonRPCError(msg string) map[string]any {
+	return map[string]any{
+		"jsonrpc": "2.0",
+		"id":      nil,
+		"error": map[string]any{
+			"code":    -32600,
+			"message": msg,
+		},
+	}
+}
@@ -0,0 +1,200 @@
+package proxy
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"golang.org/x/time/rate"
+)
+
+var (
+	// stats of the signer are halved after each window so old behaviour is gradually forgotten
+	reputationWindow = time.Minute
+	// signers with fewer requests in the stats have the full score
+	reputationMinRequests = 20.0
+	// rate limit and shedding threshold of the signer are never scaled below this part
+	reputationMinScale = 0.1
+
+	// penalty of each rate in the score
+	reputationValidationWeight = 1.0
+	reputationStaleWeight      = 0.5
+	reputationDuplicateWeight  = 0.25
+)
+
+type signerStats struct {
+	requests           float64
+	validationFailures float64
+	staleBundles       float64
+	duplicates         float64
+	decayedAt          time.Time
+
+	// nil if signer is not rate limited
+	limiter *rate.Limiter
+}
+
+// decay halves the stats for each window passed since the last decay
+func (s *signerStats) decay(now time.Time) {
+	for now.Sub(s.decayedAt) >= reputationWindow {
+		s.requests /= 2
+		s.validationFailures /= 2
+		s.staleBundles /= 2
+		s.duplicates /= 2
+		s.decayedAt = s.decayedAt.Add(reputationWindow)
+		if s.requests < 1 {
+			s.requests, s.validationFailures, s.staleBundles, s.duplicates = 0, 0, 0, 0
+			s.decayedAt = now
+		}
+	}
+}
+
+// score is 1 for well behaved signers and goes down to 0 with the rate of rejected, stale and duplicate requests
+func (s *signerStats) score() float64 {
+	if s.requests < reputationMinRequests {
+		return 1
+	}
+	penalty := reputationValidationWeight*s.validationFailures/s.requests +
+		reputationStaleWeight*s.staleBundles/s.requests +
+		reputationDuplicateWeight*s.duplicates/s.requests
+	return min(max(1-penalty, 0), 1)
+}
+
+// SignerReputation scores signers of the requests received on the public endpoint
+type SignerReputation struct {
+	// if > 0 requests of each signer are limited to this rate scaled by the signer score
+	maxRPS int
+
+	mu      sync.Mutex
+	signers map[common.Address]*signerStats
+}
+
+func NewSignerReputation(maxRPS int) *SignerReputation {
+	return &SignerReputation{
+		maxRPS:  maxRPS,
+		signers: make(map[common.Address]*signerStats),
+	}
+}
+
+// stats must be called with mu locked
+func (r *SignerReputation) stats(signer common.Address) *signerStats {
+	now := time.Now()
+	stats, ok := r.signers[signer]
+	if !ok {
+		stats = &signerStats{decayedAt: now}
+		if r.maxRPS > 0 {
+			stats.limiter = rate.NewLimiter(rate.Limit(r.maxRPS), r.maxRPS)
+		}
+		r.signers[signer] = stats
+	}
+	stats.decay(now)
+	return stats
+}
+
+// update sets signer limit and metric to the current score, it must be called with mu locked
+func (r *SignerReputation) update(signer common.Address, stats *signerStats) {
+	score := stats.score()
+	setAPISignerReputation(signer, score)
+	if stats.limiter != nil {
+		scale := max(score, reputationMinScale)
+		stats.limiter.SetLimit(rate.Limit(float64(r.maxRPS) * scale))
+		stats.limiter.SetBurst(max(int(float64(r.maxRPS)*scale), 1))
+	}
+}
+
+// RecordResult records request of the signer and the error returned to it
+func (r *SignerReputation) RecordResult(signer common.Address, err error) {
+	// unknown signers are not tracked and the requests rejected by the proxy itself are not the signer fault
+	if errors.Is(err, errUnknownPeer) || errors.Is(err, errRateLimiting) || errors.Is(err, errOverloaded) {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := r.stats(signer)
+	stats.requests++
+	switch {
+	case err == nil:
+	case errors.Is(err, errBlockRangePast):
+		stats.staleBundles++
+	default:
+		stats.validationFailures++
+	}
+	r.update(signer, stats)
+}
+
+// RecordDuplicate records request of the signer that was already received before
+func (r *SignerReputation) RecordDuplicate(signer common.Address) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := r.stats(signer)
+	stats.duplicates++
+	r.update(signer, stats)
+}
+
+// Score returns reputation of the signer between 0 and 1
+func (r *SignerReputation) Score(signer common.Address) float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats, ok := r.signers[signer]
+	if !ok {
+		return 1
+	}
+	stats.decay(time.Now())
+	return stats.score()
+}
+
+// Allow returns RateLimitError if the signer exceeded its rate limit
+func (r *SignerReputation) Allow(signer common.Address) error {
+	if r.maxRPS <= 0 {
+		return nil
+	}
+	r.mu.Lock()
+	limiter := r.stats(signer).limiter
+	r.mu.Unlock()
+	reservation := limiter.Reserve()
+	delay := reservation.Delay()
+	if delay == 0 {
+		return nil
+	}
+	reservation.Cancel()
+	return &RateLimitError{RetryAfter: delay}
+}
+
+// signerStatsRecord is the persisted form of the signer stats
+type signerStatsRecord struct {
+	Requests           float64   `json:"requests"`
+	ValidationFailures float64   `json:"validationFailures"`
+	StaleBundles       float64   `json:"staleBundles"`
+	Duplicates         float64   `json:"duplicates"`
+	DecayedAt          time.Time `json:"decayedAt"`
+}
+
+func (r *SignerReputation) snapshot() map[common.Address]signerStatsRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	records := make(map[common.Address]signerStatsRecord, len(r.signers))
+	for signer, stats := range r.signers {
+		records[signer] = signerStatsRecord{
+			Requests:           stats.requests,
+			ValidationFailures: stats.validationFailures,
+			StaleBundles:       stats.staleBundles,
+			Duplicates:         stats.duplicates,
+			DecayedAt:          stats.decayedAt,
+		}
+	}
+	return records
+}
+
+func (r *SignerReputation) restore(signer common.Address, record signerStatsRecord) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := r.stats(signer)
+	stats.requests = record.Requests
+	stats.validationFailures = record.ValidationFailures
+	stats.staleBundles = record.StaleBundles
+	stats.duplicates = record.Duplicates
+	stats.decayedAt = record.DecayedAt
+	// time passed while the proxy was stopped decays the stats
+	stats.decay(time.Now())
+	r.update(signer, stats)
+}
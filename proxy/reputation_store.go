@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// reputation is saved to the store with this interval and when the proxy stops
+	ReputationSaveInterval = time.Second * 30
+
+	reputationBucket = []byte("signer-reputation")
+)
+
+// ReputationStore persists signer reputation in a bbolt database file
+// so proxy restart does not reset the limits of the signers
+type ReputationStore struct {
+	db *bolt.DB
+}
+
+func OpenReputationStore(path string) (*ReputationStore, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(reputationBucket)
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &ReputationStore{db: db}, nil
+}
+
+// Load restores stats of all stored signers
+func (s *ReputationStore) Load(reputation *SignerReputation) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(reputationBucket).ForEach(func(key, value []byte) error {
+			var record signerStatsRecord
+			err := json.Unmarshal(value, &record)
+			if err != nil {
+				return err
+			}
+			reputation.restore(common.BytesToAddress(key), record)
+			return nil
+		})
+	})
+}
+
+// Save stores current stats of all signers
+func (s *ReputationStore) Save(reputation *SignerReputation) error {
+	records := reputation.snapshot()
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(reputationBucket)
+		for signer, record := range records {
+			value, err := json.Marshal(record)
+			if err != nil {
+				return err
+			}
+			err = bucket.Put(signer.Bytes(), value)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *ReputationStore) Close() error {
+	return s.db.Close()
+}
+
+// runReputationSaving saves reputation periodically until stop is closed, then saves it last time,
+// closes the store and closes done
+func (prx *ReceiverProxy) runReputationSaving(store *ReputationStore, stop, done chan struct{}) {
+	defer close(done)
+	save := func() {
+		err := store.Save(prx.reputation)
+		if err != nil {
+			prx.Log.Error("Failed to save signer reputation", slog.Any("error", err))
+			reputationStoreErrors.Inc()
+		}
+	}
+	for {
+		select {
+		case <-stop:
+			save()
+			err := store.Close()
+			if err != nil {
+				prx.Log.Error("Failed to close reputation store", slog.Any("error", err))
+			}
+			return
+		case <-time.After(ReputationSaveInterval):
+			save()
+		}
+	}
+}
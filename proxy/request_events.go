@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// kinds of the request lifecycle events
+const (
+	// request reached HandleParsedRequest, it was not deduplicated or rate limited yet
+	requestEventReceived = "received"
+	// request passed deduplication and rate limits and was queued for sharing
+	requestEventValidated = "validated"
+	// share queue sent request to the local builder and the peers in destinations
+	requestEventForwarded = "forwarded"
+	// request was accepted but it was not forwarded or archived because of the reason
+	requestEventDropped = "dropped"
+)
+
+type requestEvent struct {
+	kind string
+	req  *ParsedRequest
+	// destinations are set for forwarded events
+	destinations []string
+	// reason is set for dropped events, one of RejectionReason constants
+	reason string
+}
+
+type requestEventHandler func(ctx context.Context, event requestEvent)
+
+// requestEventBus delivers lifecycle events of the requests to the subsystems that consume them
+// handlers are called by the publisher in the order they were subscribed so they must not block,
+// handlers that need to do slow work queue it to their own goroutine
+type requestEventBus struct {
+	mu       sync.RWMutex
+	handlers map[string][]requestEventHandler
+}
+
+func newRequestEventBus() *requestEventBus {
+	return &requestEventBus{handlers: make(map[string][]requestEventHandler)}
+}
+
+func (b *requestEventBus) subscribe(kind string, handler requestEventHandler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[kind] = append(b.handlers[kind], handler)
+}
+
+func (b *requestEventBus) publish(ctx context.Context, event requestEvent) {
+	if b == nil {
+		return
+	}
+	b.mu.RLock()
+	handlers := b.handlers[event.kind]
+	b.mu.RUnlock()
+	for _, handler := range handlers {
+		handler(ctx, event)
+	}
+}
+
+// subscribeRequestEvents connects metrics, stats, event stream, archive, audit log and rejections to the request events
+func (prx *ReceiverProxy) subscribeRequestEvents() {
+	bus := prx.requestEvents
+	bus.subscribe(requestEventReceived, func(_ context.Context, event requestEvent) {
+		if event.req.publicEndpoint {
+			incAPIIncomingRequestsByPeer(event.req.peerName)
+		}
+		prx.stats.recordRequest(event.req)
+	})
+
+	bus.subscribe(requestEventValidated, func(_ context.Context, event requestEvent) {
+		if !event.req.mempool {
+			prx.txDuplicates.record(event.req)
+		}
+	})
+	bus.subscribe(requestEventValidated, func(_ context.Context, event requestEvent) {
+		prx.events.publish(event.req)
+	})
+	bus.subscribe(requestEventValidated, prx.archiveRequest)
+
+	if prx.audit != nil {
+		bus.subscribe(requestEventForwarded, func(_ context.Context, event requestEvent) {
+			prx.audit.Record(event.req, event.destinations)
+		})
+	}
+
+	bus.subscribe(requestEventDropped, func(_ context.Context, event requestEvent) {
+		incAPIDroppedRequests(event.reason)
+	})
+	bus.subscribe(requestEventDropped, prx.recordDropped)
+}
+
+// archiveRequest queues request to the archive, request is already shared so it is accepted even if it can't be archived
+func (prx *ReceiverProxy) archiveRequest(ctx context.Context, event requestEvent) {
+	req := event.req
+	if (req.publicEndpoint && !prx.archivePublicRequests) || req.mempool {
+		return
+	}
+	req.archiveQueuedAt = time.Now()
+	err := enqueueRequest(ctx, prx.archiveQueue, req, prx.backpressurePolicy, queueNameArchive, prx.publishEvicted(ctx, RejectionReasonArchiveQueueEvicted))
+	if err != nil {
+		prx.Log.Error("Archive queue is stalling", slog.String("method", req.method))
+		prx.stats.recordQueueStall(queueNameArchive)
+		prx.requestEvents.publish(ctx, requestEvent{kind: requestEventDropped, req: req, reason: RejectionReasonArchiveQueueFull})
+	}
+}
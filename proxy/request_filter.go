@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/flashbots/go-utils/rpctypes"
+)
+
+// Decision is the result of RequestFilter
+type Decision int
+
+const (
+	// DecisionAccept passes request to the next filter, filter can modify the request before accepting it
+	DecisionAccept Decision = iota
+	// DecisionReject rejects request with the error returned by the filter
+	DecisionReject
+	// DecisionDrop accepts request without forwarding or archiving it, client gets successful response
+	DecisionDrop
+)
+
+func (d Decision) String() string {
+	switch d {
+	case DecisionAccept:
+		return "accept"
+	case DecisionReject:
+		return "reject"
+	case DecisionDrop:
+		return "drop"
+	}
+	return "unknown"
+}
+
+// RejectionReasonFiltered is the reason of the requests dropped by RequestFilter
+const RejectionReasonFiltered = "filtered"
+
+var errRequestRejected = errors.New("request rejected")
+
+// RequestFilter is a custom policy applied to every request after it was validated and before it is deduplicated and forwarded
+// filters are applied in the configured order until one of them rejects or drops the request,
+// request is rejected if filter returns error
+// params of the request can be changed through the getters, unique key of the request is not recomputed
+type RequestFilter interface {
+	Apply(ctx context.Context, req *ParsedRequest) (Decision, error)
+}
+
+// RequestFilterFunc is RequestFilter implemented by a function
+type RequestFilterFunc func(ctx context.Context, req *ParsedRequest) (Decision, error)
+
+func (f RequestFilterFunc) Apply(ctx context.Context, req *ParsedRequest) (Decision, error) {
+	return f(ctx, req)
+}
+
+// applyRequestFilters returns DecisionDrop or DecisionAccept, or error if the request is rejected
+func applyRequestFilters(ctx context.Context, filters []RequestFilter, req *ParsedRequest) (Decision, error) {
+	for _, filter := range filters {
+		decision, err := filter.Apply(ctx, req)
+		if err == nil && decision == DecisionReject {
+			err = errRequestRejected
+		}
+		if err != nil {
+			incAPIRequestFilterDecisions(DecisionReject)
+			if errors.Is(err, errRequestRejected) {
+				return DecisionReject, err
+			}
+			return DecisionReject, fmt.Errorf("%w: %w", errRequestRejected, err)
+		}
+		if decision == DecisionDrop {
+			incAPIRequestFilterDecisions(DecisionDrop)
+			return DecisionDrop, nil
+		}
+	}
+	if len(filters) > 0 {
+		incAPIRequestFilterDecisions(DecisionAccept)
+	}
+	return DecisionAccept, nil
+}
+
+// getters of the request for RequestFilter, params are returned as pointers so filters can change them
+
+func (r *ParsedRequest) Method() string {
+	return r.method
+}
+
+// PublicEndpoint returns true if request was received from a peer
+func (r *ParsedRequest) PublicEndpoint() bool {
+	return r.publicEndpoint
+}
+
+// PeerName returns name of the peer that sent the request or "local-request"
+func (r *ParsedRequest) PeerName() string {
+	return r.peerName
+}
+
+// Signer returns the signer of the request, for requests forwarded by peers it is the signer from the params
+func (r *ParsedRequest) Signer() common.Address {
+	return r.originalSigner()
+}
+
+// Tenant returns the name of the local tenant the request was received for, empty for other requests
+func (r *ParsedRequest) Tenant() string {
+	return r.tenant
+}
+
+func (r *ParsedRequest) ReceivedAt() time.Time {
+	return r.receivedAt
+}
+
+func (r *ParsedRequest) EthSendBundle() *rpctypes.EthSendBundleArgs {
+	return r.ethSendBundle
+}
+
+func (r *ParsedRequest) MevSendBundle() *rpctypes.MevSendBundleArgs {
+	return r.mevSendBundle
+}
+
+func (r *ParsedRequest) EthCancelBundle() *rpctypes.EthCancelBundleArgs {
+	return r.ethCancelBundle
+}
+
+func (r *ParsedRequest) EthSendRawTransaction() *rpctypes.EthSendRawTransactionArgs {
+	return r.ethSendRawTransaction
+}
+
+func (r *ParsedRequest) BidSubsidiseBlock() *rpctypes.BidSubsisideBlockArgs {
+	return r.bidSubsidiseBlock
+}
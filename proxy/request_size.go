@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"reflect"
+
+	"github.com/flashbots/go-utils/rpcserver"
+	"github.com/flashbots/go-utils/rpctypes"
+)
+
+type requestSizeContextKey struct{}
+
+// countingReader counts bytes of the request body read by rpcserver
+type countingReader struct {
+	io.ReadCloser
+	n int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += n
+	return n, err
+}
+
+// requestSizeHandler makes size of the request body available to the methods wrapped with recordRequestSizes
+// body of the compressed requests is counted after decompression, elements of the batches are counted separately
+// size of the streamed bundles is set by bundleStreamer
+func requestSizeHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Value(requestSizeContextKey{}).(*countingReader); ok || r.Body == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body := &countingReader{ReadCloser: r.Body}
+		r.Body = body
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestSizeContextKey{}, body)))
+	})
+}
+
+// recordRequestSizes wraps methods so size of the requests and number of transactions in the bundles are recorded,
+// requests are recorded before they are validated
+func recordRequestSizes(methods rpcserver.Methods, endpoint string) rpcserver.Methods {
+	for name, method := range methods {
+		fn := reflect.ValueOf(method)
+		methods[name] = reflect.MakeFunc(fn.Type(), func(args []reflect.Value) []reflect.Value {
+			ctx, _ := args[0].Interface().(context.Context)
+			if body, ok := ctx.Value(requestSizeContextKey{}).(*countingReader); ok {
+				updateAPIRequestSize(name, endpoint, body.n)
+			}
+			if len(args) > 1 {
+				switch params := args[1].Interface().(type) {
+				case rpctypes.EthSendBundleArgs:
+					updateAPIBundleTxs(name, endpoint, len(params.Txs))
+				case rpctypes.MevSendBundleArgs:
+					updateAPIBundleTxs(name, endpoint, len(params.Body))
+				}
+			}
+			return fn.Call(args)
+		}).Interface()
+	}
+	return methods
+}
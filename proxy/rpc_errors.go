@@ -0,0 +1,233 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/flashbots/go-utils/rpcclient"
+	"github.com/flashbots/go-utils/rpcserver"
+)
+
+// JSON-RPC error codes returned by the proxy, errors that are not listed here use the generic -32000 code
+// error data is RPCErrorData with the machine readable reason of the error
+const (
+	// ErrorCodeInvalidParams is returned when request fails validation, data has the invalid field if known
+	ErrorCodeInvalidParams = -32602
+	// ErrorCodeUnavailable is returned when proxy does not serve requests right now, e.g. sender on standby
+	ErrorCodeUnavailable = -32002
+	// ErrorCodeRateLimited is returned when request was rate limited, it can be retried later
+	ErrorCodeRateLimited = -32005
+	// ErrorCodeUnauthorized is returned when signer is not allowed to call the method
+	ErrorCodeUnauthorized = -32010
+	// ErrorCodeOverloaded is returned when request queues of the proxy are full, it can be retried later
+	ErrorCodeOverloaded = -32011
+)
+
+// reasons sent in RPCErrorData
+const (
+	ErrorReasonValidation    = "validation"
+	ErrorReasonBlocked       = "blocked_address"
+	ErrorReasonUnknownSigner = "unknown_signer"
+	ErrorReasonUnauthorized  = "unauthorized"
+	ErrorReasonRateLimited   = "rate_limited"
+	ErrorReasonOverloaded    = "overloaded"
+	ErrorReasonStandby       = "standby"
+	ErrorReasonRejected      = "rejected"
+	// ErrorReasonDecoding is sent when params have unknown field or value of wrong type in strict decoding mode
+	ErrorReasonDecoding = "decoding"
+)
+
+// RPCErrorData is sent in the data field of the JSON-RPC errors
+type RPCErrorData struct {
+	Reason string `json:"reason"`
+	// Field is the JSON name of the request field that failed validation
+	Field string `json:"field,omitempty"`
+	// RetryAfterMs is the time after which rate limited request can be retried
+	RetryAfterMs int64 `json:"retryAfterMs,omitempty"`
+}
+
+// RateLimitError is returned when request was rate limited, it is also sent with HTTP 429 and Retry-After header
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return errRateLimiting.Error() + ", retry after " + e.RetryAfter.String()
+}
+
+func (e *RateLimitError) Unwrap() error {
+	return errRateLimiting
+}
+
+// rateLimitErrorFromResponse returns RateLimitError if the JSON-RPC error is rate limit error with retry after
+func rateLimitErrorFromResponse(rpcErr *rpcclient.RPCError) (*RateLimitError, bool) {
+	if rpcErr.Code != ErrorCodeRateLimited || rpcErr.Data == nil {
+		return nil, false
+	}
+	rawData, err := json.Marshal(rpcErr.Data)
+	if err != nil {
+		return nil, false
+	}
+	var data RPCErrorData
+	if err := json.Unmarshal(rawData, &data); err != nil || data.RetryAfterMs <= 0 {
+		return nil, false
+	}
+	return &RateLimitError{RetryAfter: time.Duration(data.RetryAfterMs) * time.Millisecond}, true
+}
+
+type rpcErrorClass struct {
+	err  error
+	code int
+	data RPCErrorData
+}
+
+func validationError(err error, field string) rpcErrorClass {
+	return rpcErrorClass{err, ErrorCodeInvalidParams, RPCErrorData{Reason: ErrorReasonValidation, Field: field}}
+}
+
+var rpcErrorClasses = []rpcErrorClass{
+	{errUnknownPeer, ErrorCodeUnauthorized, RPCErrorData{Reason: ErrorReasonUnknownSigner}},
+	{errSubsidyWrongCaller, ErrorCodeUnauthorized, RPCErrorData{Reason: ErrorReasonUnauthorized}},
+	{errSubsidyWrongEndpoint, ErrorCodeUnauthorized, RPCErrorData{Reason: ErrorReasonUnauthorized}},
+	{errRateLimiting, ErrorCodeRateLimited, RPCErrorData{Reason: ErrorReasonRateLimited}},
+	{errOverloaded, ErrorCodeOverloaded, RPCErrorData{Reason: ErrorReasonOverloaded}},
+	{errNotLeader, ErrorCodeUnavailable, RPCErrorData{Reason: ErrorReasonStandby}},
+	{errRequestRejected, ErrorCodeUnauthorized, RPCErrorData{Reason: ErrorReasonRejected}},
+	{errBlockedAddress, ErrorCodeInvalidParams, RPCErrorData{Reason: ErrorReasonBlocked, Field: "txs"}},
+
+	validationError(errSigningAddress, "signingAddress"),
+	validationError(errReplacementNonce, "replacementNonce"),
+	validationError(errRevertingTxHashes, "revertingTxHashes"),
+	validationError(errDroppingTxHashes, "droppingTxHashes"),
+	validationError(errUUID, "replacementUuid"),
+	validationError(errUUIDParse, "replacementUuid"),
+	validationError(errRefundPercent, "refundPercent"),
+	validationError(errRefundRecipient, "refundRecipient"),
+	validationError(errRefundTxHashes, "refundTxHashes"),
+	validationError(errLocalEndpointSbundleMetadata, "metadata"),
+	validationError(errMevBundleTooManyElements, "body"),
+	validationError(errMevBundleTooDeep, "body"),
+	validationError(errPrivacyParse, "privacy"),
+	validationError(errPrivacyUnknownHint, "privacy"),
+	validationError(errPrivacyMissingHint, "privacy"),
+	validationError(errPrivacyBuilderNotAllow, "privacy"),
+	validationError(errBlockRangePast, "blockNumber"),
+	validationError(errBlockRangeFuture, "blockNumber"),
+	validationError(errBlockRangeSpan, "maxBlock"),
+	validationError(errTxDecode, "txs"),
+	validationError(errTxChainID, "txs"),
+	validationError(errTxSignature, "txs"),
+	validationError(errTxZeroGas, "txs"),
+	validationError(errTxGasLimit, "txs"),
+	validationError(errTxNonceConflict, "txs"),
+	validationError(errTxSize, "txs"),
+	validationError(errBlobTxDisabled, "txs"),
+	validationError(errBlobTxNoBlobs, "txs"),
+	validationError(errBlobTxTooManyBlobs, "txs"),
+	validationError(errBlobTxSidecar, "txs"),
+	validationError(errSetCodeTxEmptyAuthList, "txs"),
+	validationError(errSetCodeTxAuthChainID, "txs"),
+	validationError(errSetCodeTxAuthSignature, "txs"),
+	validationError(errSetCodeTxAuthNonce, "txs"),
+}
+
+// rpcErrorCodeAndData returns documented code and data of the error returned by the method
+func rpcErrorCodeAndData(err error) (int, *RPCErrorData, bool) {
+	for _, class := range rpcErrorClasses {
+		if errors.Is(err, class.err) {
+			data := class.data
+			var rateLimitErr *RateLimitError
+			if errors.As(err, &rateLimitErr) {
+				data.RetryAfterMs = rateLimitErr.RetryAfter.Milliseconds()
+			}
+			return class.code, &data, true
+		}
+	}
+	return 0, nil, false
+}
+
+type methodErrorContextKey struct{}
+
+// recordMethodErrors wraps methods so their errors are stored for rpcErrorHandler
+func recordMethodErrors(methods rpcserver.Methods) rpcserver.Methods {
+	for name, method := range methods {
+		fn := reflect.ValueOf(method)
+		methods[name] = reflect.MakeFunc(fn.Type(), func(args []reflect.Value) []reflect.Value {
+			results := fn.Call(args)
+			errValue := results[len(results)-1]
+			if errValue.IsNil() {
+				return results
+			}
+			ctx, _ := args[0].Interface().(context.Context)
+			if slot, ok := ctx.Value(methodErrorContextKey{}).(*error); ok {
+				*slot, _ = errValue.Interface().(error)
+			}
+			return results
+		}).Interface()
+	}
+	return methods
+}
+
+// rpcErrorHandler replaces generic error code of rpcserver with the documented code and data of the method error
+// methods of the next handler must be wrapped with recordMethodErrors
+func rpcErrorHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var methodErr error
+		r = r.WithContext(context.WithValue(r.Context(), methodErrorContextKey{}, &methodErr))
+		resp := &bufferedResponseWriter{header: w.Header()}
+		next.ServeHTTP(resp, r)
+
+		body := resp.body.Bytes()
+		if code, data, ok := rpcErrorCodeAndData(methodErr); ok {
+			var response map[string]any
+			decoder := json.NewDecoder(bytes.NewReader(body))
+			// keep numeric request id as is
+			decoder.UseNumber()
+			if err := decoder.Decode(&response); err == nil {
+				if responseErr, ok := response["error"].(map[string]any); ok {
+					responseErr["code"] = code
+					responseErr["data"] = data
+					if rewritten, err := json.Marshal(response); err == nil {
+						body = append(rewritten, '\n')
+					}
+				}
+			}
+		}
+		var rateLimitErr *RateLimitError
+		if errors.As(methodErr, &rateLimitErr) {
+			setRetryAfterHeader(w, rateLimitErr.RetryAfter)
+			resp.statusCode = http.StatusTooManyRequests
+		}
+		if resp.statusCode != 0 {
+			w.WriteHeader(resp.statusCode)
+		}
+		_, _ = w.Write(body)
+	})
+}
+
+func setRetryAfterHeader(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(max(seconds, 1)))
+}
+
+// writeRateLimitError writes error for the request rate limited before it reached rpcserver
+func writeRateLimitError(w http.ResponseWriter, err *RateLimitError) {
+	code, data, _ := rpcErrorCodeAndData(err)
+	response := jsonRPCError(err.Error())
+	response["error"] = map[string]any{
+		"code":    code,
+		"message": err.Error(),
+		"data":    data,
+	}
+	setRetryAfterHeader(w, err.RetryAfter)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusTooManyRequests)
+	_ = json.NewEncoder(w).Encode(response)
+}
@@ -4,23 +4,64 @@ import (
 	"context"
 	"log/slog"
 	"net/http"
+	"sync/atomic"
 	"time"
 
 	"github.com/flashbots/go-utils/rpcserver"
 	"github.com/flashbots/go-utils/rpctypes"
-	"github.com/flashbots/go-utils/signature"
 )
 
 type SenderProxyConstantConfig struct {
 	Log             *slog.Logger
-	OrderflowSigner *signature.Signer
+	OrderflowSigner RequestSigner
 }
 
 type SenderProxyConfig struct {
 	SenderProxyConstantConfig
 	BuilderConfigHubEndpoint string
-	MaxRequestBodySizeBytes  int64
-	ConnectionsPerPeer       int
+	// PeerListStaleWebhook is optional, if set it is called when peers can't be fetched from the builder config hub
+	PeerListStaleWebhook    *PeerListStaleWebhook
+	MaxRequestBodySizeBytes int64
+	ConnectionsPerPeer      int
+	// ShareWorkersPerPeer is the number of concurrent workers sending to each peer or receiver, if 0 ConnectionsPerPeer is used
+	ShareWorkersPerPeer int
+
+	// ReceiverEndpoints are local endpoints of the receiver proxies, if set each request is sent
+	// to one of them instead of all the peers from the builder config hub
+	ReceiverEndpoints []string
+	// ReceiverDiscovery makes sender proxy send each request to one of the receivers fetched from the builder config hub
+	ReceiverDiscovery           bool
+	ReceiverHealthCheckInterval time.Duration
+
+	// Retry configures retries of the requests that failed or were rejected
+	Retry RetryPolicy
+	// PeerTimeout is the timeout of each request to the receivers or peers, if 0 default is used
+	PeerTimeout time.Duration
+	// PeerCertCacheTTL is the time verified peer certificates are reused across peer list updates, if 0 default is used
+	PeerCertCacheTTL time.Duration
+	// MaxRPSPerReceiver limits the rate of requests forwarded to each receiver or peer, 0 means no limit
+	MaxRPSPerReceiver int
+
+	// Attestation is optional, if set receivers from the builder config hub are used only after
+	// their certificates are verified with TDX attestation
+	Attestation *AttestationVerifier
+
+	// DeadLetter is a file path or URL where requests are recorded when all retries fail, if empty they are dropped
+	DeadLetter string
+	// FallbackRelayEndpoint is optional, if set requests that failed on all receivers are sent to this relay
+	// before they are recorded to DeadLetter, it requires ReceiverEndpoints or ReceiverDiscovery
+	FallbackRelayEndpoint string
+
+	// DisabledMethods are not served, calls to them return method not found
+	DisabledMethods []string
+	// MethodAliases maps additional method names to the served methods
+	MethodAliases map[string]string
+
+	// LeaderLock is optional, if set only the instance holding the lock forwards requests
+	// and the others reject them until they take over the lock
+	LeaderLock LeaderLock
+	// LeaderLockTTL is the time the lock is held without extending it, if 0 default is used
+	LeaderLockTTL time.Duration
 }
 
 type SenderProxy struct {
@@ -32,6 +73,17 @@ type SenderProxy struct {
 	shareQueue  chan *ParsedRequest
 
 	PeerUpdateForce chan struct{}
+	// peer list is fetched as soon as possible when something is sent to this channel
+	refreshPeers chan struct{}
+
+	receiverHealthCheckClose chan struct{}
+	// set only if receivers are discovered from the builder config hub
+	discoveredReceivers *receiverPool
+
+	deadLetter *DeadLetterQueue
+
+	leader              atomic.Bool
+	leaderElectionClose chan struct{}
 }
 
 func NewSenderProxy(config SenderProxyConfig) (*SenderProxy, error) {
@@ -47,15 +99,22 @@ func NewSenderProxy(config SenderProxyConfig) (*SenderProxy, error) {
 		updatePeers:               make(chan []ConfighubBuilder),
 		shareQueue:                make(chan *ParsedRequest),
 		PeerUpdateForce:           make(chan struct{}),
+		refreshPeers:              make(chan struct{}, 1),
 	}
+	prx.ConfigHub.staleWebhook = config.PeerListStaleWebhook
 
-	handler, err := rpcserver.NewJSONRPCHandler(rpcserver.Methods{
+	methods := rpcserver.Methods{
 		EthSendBundleMethod:         prx.EthSendBundle,
 		MevSendBundleMethod:         prx.MevSendBundle,
 		EthCancelBundleMethod:       prx.EthCancelBundle,
 		EthSendRawTransactionMethod: prx.EthSendRawTransaction,
 		BidSubsidiseBlockMethod:     prx.BidSubsidiseBlock,
-	},
+	}
+	err := validateMethods(methods, config.DisabledMethods, config.MethodAliases)
+	if err != nil {
+		return nil, err
+	}
+	handler, err := rpcserver.NewJSONRPCHandler(configureMethods(methods, config.DisabledMethods, config.MethodAliases),
 		rpcserver.JSONRPCHandlerOpts{
 			Log:                     prx.Log,
 			MaxRequestBodySizeBytes: maxRequestBodySizeBytes,
@@ -64,17 +123,73 @@ func NewSenderProxy(config SenderProxyConfig) (*SenderProxy, error) {
 	if err != nil {
 		return nil, err
 	}
-	prx.Handler = handler
+	prx.Handler = rpcErrorHandler(handler)
 
-	queue := ShareQueue{
-		log:            prx.Log,
-		queue:          prx.shareQueue,
-		updatePeers:    prx.updatePeers,
-		localBuilder:   nil,
-		signer:         prx.OrderflowSigner,
-		workersPerPeer: config.ConnectionsPerPeer,
+	if config.FallbackRelayEndpoint != "" && len(config.ReceiverEndpoints) == 0 && !config.ReceiverDiscovery {
+		return nil, errFallbackRelayMode
+	}
+
+	if config.LeaderLock != nil {
+		leaderLockTTL := DefaultLeaderLockTTL
+		if config.LeaderLockTTL != 0 {
+			leaderLockTTL = config.LeaderLockTTL
+		}
+		prx.leaderElectionClose = make(chan struct{})
+		go prx.runLeaderElection(config.LeaderLock, leaderLockTTL, prx.leaderElectionClose)
+	} else {
+		prx.leader.Store(true)
+	}
+
+	if config.DeadLetter != "" {
+		deadLetter, err := NewDeadLetterQueue(prx.Log, config.DeadLetter)
+		if err != nil {
+			return nil, err
+		}
+		prx.deadLetter = deadLetter
+	}
+
+	if len(config.ReceiverEndpoints) > 0 || config.ReceiverDiscovery {
+		connections := max(config.ConnectionsPerPeer, 1)
+		pool := newReceiverPool(prx.Log, config.ReceiverEndpoints, prx.OrderflowSigner, connections, config.MaxRPSPerReceiver)
+		pool.retry = config.Retry
+		pool.attestation = config.Attestation
+		if config.FallbackRelayEndpoint != "" {
+			pool.setFallbackRelay(config.FallbackRelayEndpoint)
+		}
+		if config.PeerTimeout > 0 {
+			pool.timeout = config.PeerTimeout
+		}
+		for range shareWorkersPerPeer(connections, config.ShareWorkersPerPeer) {
+			go prx.sendToReceivers(pool)
+		}
+		healthCheckInterval := DefaultReceiverHealthCheckInterval
+		if config.ReceiverHealthCheckInterval != 0 {
+			healthCheckInterval = config.ReceiverHealthCheckInterval
+		}
+		prx.receiverHealthCheckClose = make(chan struct{})
+		go pool.RunHealthChecks(healthCheckInterval, prx.receiverHealthCheckClose)
+		if !config.ReceiverDiscovery {
+			return prx, nil
+		}
+		prx.discoveredReceivers = pool
+	} else {
+		queue := ShareQueue{
+			log:            prx.Log,
+			queue:          prx.shareQueue,
+			updatePeers:    prx.updatePeers,
+			localBuilder:   nil,
+			signer:         prx.OrderflowSigner,
+			workersPerPeer: shareWorkersPerPeer(config.ConnectionsPerPeer, config.ShareWorkersPerPeer),
+			retry:          config.Retry,
+			deadLetter:     prx.deadLetter,
+			maxRPSPerPeer:  config.MaxRPSPerReceiver,
+			attestation:    config.Attestation,
+			peerTimeout:    config.PeerTimeout,
+			peerCertTTL:    config.PeerCertCacheTTL,
+			refreshPeers:   prx.refreshPeers,
+		}
+		go queue.Run()
 	}
-	go queue.Run()
 
 	go func() {
 		for {
@@ -83,6 +198,7 @@ func NewSenderProxy(config SenderProxyConfig) (*SenderProxy, error) {
 				if !more {
 					return
 				}
+			case <-prx.refreshPeers:
 			case <-time.After(peerUpdateTime):
 			}
 			builders, err := prx.ConfigHub.Builders(true)
@@ -93,6 +209,10 @@ func NewSenderProxy(config SenderProxyConfig) (*SenderProxy, error) {
 
 			prx.Log.Info("Updated peers", slog.Int("peerCount", len(builders)))
 
+			if prx.discoveredReceivers != nil {
+				prx.discoveredReceivers.UpdateFromBuilders(builders)
+				continue
+			}
 			select {
 			case prx.updatePeers <- builders:
 			default:
@@ -106,9 +226,18 @@ func NewSenderProxy(config SenderProxyConfig) (*SenderProxy, error) {
 }
 
 func (prx *SenderProxy) Stop() {
+	if prx.leaderElectionClose != nil {
+		close(prx.leaderElectionClose)
+	}
 	close(prx.shareQueue)
 	close(prx.updatePeers)
 	close(prx.PeerUpdateForce)
+	if prx.receiverHealthCheckClose != nil {
+		close(prx.receiverHealthCheckClose)
+	}
+	if prx.deadLetter != nil {
+		_ = prx.deadLetter.Close()
+	}
 }
 
 func (prx *SenderProxy) EthSendBundle(ctx context.Context, ethSendBundle rpctypes.EthSendBundleArgs) error {
@@ -117,7 +246,7 @@ func (prx *SenderProxy) EthSendBundle(ctx context.Context, ethSendBundle rpctype
 		method:        EthSendBundleMethod,
 	}
 
-	err := ValidateEthSendBundle(&ethSendBundle, true)
+	err := ValidateEthSendBundle(&ethSendBundle, true, nil)
 	if err != nil {
 		return err
 	}
@@ -131,7 +260,7 @@ func (prx *SenderProxy) MevSendBundle(ctx context.Context, mevSendBundle rpctype
 		method:        MevSendBundleMethod,
 	}
 
-	err := ValidateMevSendBundle(&mevSendBundle, true)
+	err := ValidateMevSendBundle(&mevSendBundle, true, nil)
 	if err != nil {
 		return err
 	}
@@ -175,10 +304,18 @@ func (prx *SenderProxy) HandleParsedRequest(ctx context.Context, parsedRequest P
 	// we set it explicitly to note that we need to proxy all calls to all peers
 	parsedRequest.publicEndpoint = false
 	prx.Log.Debug("Received request", slog.String("method", parsedRequest.method))
+	incSenderRequests(parsedRequest.method)
+	if !prx.leader.Load() {
+		senderStandbyRejections.Inc()
+		return errNotLeader
+	}
 
+	parsedRequest.shareQueuedAt = time.Now()
+	senderQueueDepth.Inc()
 	select {
 	case <-ctx.Done():
 	case prx.shareQueue <- &parsedRequest:
 	}
+	senderQueueDepth.Dec()
 	return nil
 }
@@ -0,0 +1,244 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/flashbots/go-utils/rpcclient"
+	"golang.org/x/time/rate"
+)
+
+var (
+	DefaultReceiverHealthCheckInterval = time.Second * 5
+
+	errNoReceiverAvailable = errors.New("request failed on all receivers")
+	errFallbackRelayMode   = errors.New("fallback relay requires receiver endpoints or receiver discovery")
+)
+
+// receiverEndpoint is a local endpoint of the receiver proxy used as an entry point to the network
+type receiverEndpoint struct {
+	url     string
+	client  rpcclient.RPCClient
+	healthy atomic.Bool
+	limiter *rate.Limiter
+}
+
+func newReceiverEndpoint(url string, client rpcclient.RPCClient, maxRPS int) *receiverEndpoint {
+	receiver := &receiverEndpoint{
+		url:     url,
+		client:  client,
+		limiter: newDestinationRateLimiter(maxRPS),
+	}
+	receiver.healthy.Store(true)
+	return receiver
+}
+
+// receiverPool sends each request to one of the receivers, unhealthy receivers are used only when all of them fail
+type receiverPool struct {
+	log *slog.Logger
+	// the same request sent to the next receiver after a failure reuses the signature
+	signer             *sharedSignatures
+	maxOpenConnections int
+	retry              RetryPolicy
+	// if > 0 requests to each receiver are limited to this rate
+	maxRPSPerReceiver int
+	// if set, discovered receivers are used only after their certificate is verified with TDX attestation
+	attestation *AttestationVerifier
+	timeout     time.Duration
+	// if set, requests that failed on all receivers are sent to this relay
+	fallbackRelay *receiverEndpoint
+
+	mu        sync.RWMutex
+	receivers []*receiverEndpoint
+	next      atomic.Uint64
+}
+
+func newReceiverPool(log *slog.Logger, endpoints []string, signer RequestSigner, maxOpenConnections, maxRPSPerReceiver int) *receiverPool {
+	pool := &receiverPool{
+		log:                log,
+		signer:             newSharedSignatures(signer),
+		maxOpenConnections: maxOpenConnections,
+		maxRPSPerReceiver:  maxRPSPerReceiver,
+		timeout:            requestTimeout,
+	}
+	for _, url := range endpoints {
+		client := rpcclient.NewClientWithOpts(url, &rpcclient.RPCClientOpts{
+			HTTPClient: HTTPClientWithSigner(HTTPClientWithMaxConnections(maxOpenConnections), pool.signer),
+		})
+		pool.receivers = append(pool.receivers, newReceiverEndpoint(url, client, maxRPSPerReceiver))
+	}
+	return pool
+}
+
+// UpdateFromBuilders replaces receivers with the builders from the config hub
+// receivers that are still present keep their clients and health status
+func (p *receiverPool) UpdateFromBuilders(builders []ConfighubBuilder) {
+	p.mu.RLock()
+	existing := make(map[string]*receiverEndpoint, len(p.receivers))
+	for _, receiver := range p.receivers {
+		existing[receiver.url] = receiver
+	}
+	p.mu.RUnlock()
+
+	receivers := make([]*receiverEndpoint, 0, len(builders))
+	for _, builder := range builders {
+		url := builder.OrderflowProxyURL()
+		if receiver, ok := existing[url]; ok {
+			receivers = append(receivers, receiver)
+			continue
+		}
+		transport, err := peerTransport(builder, p.maxOpenConnections)
+		if errors.Is(err, errPeerCertFingerprint) {
+			p.log.Error("Receiver certificate does not match registered fingerprints", slog.String("receiver", builder.Name))
+			continue
+		}
+		if err != nil {
+			p.log.Error("Failed to create a receiver client", slog.String("receiver", builder.Name), slog.Any("error", err))
+			shareQueueInternalErrors.Inc()
+			continue
+		}
+		if p.attestation != nil {
+			err := p.attestation.Verify(url, []byte(builder.OrderflowProxy.TLSCert))
+			if err != nil {
+				p.log.Error("Failed to verify receiver attestation", slog.String("receiver", builder.Name), slog.Any("error", err))
+				continue
+			}
+		}
+		client := rpcClientWithTransportAndSigner(url, transport, p.signer, nil)
+		receivers = append(receivers, newReceiverEndpoint(url, client, p.maxRPSPerReceiver))
+	}
+
+	p.mu.Lock()
+	p.receivers = receivers
+	p.mu.Unlock()
+	p.log.Info("Updated receivers", slog.Int("receiverCount", len(receivers)))
+}
+
+func (p *receiverPool) currentReceivers() []*receiverEndpoint {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.receivers
+}
+
+// orderedReceivers returns receivers starting from the next one in round-robin order, healthy receivers first
+func (p *receiverPool) orderedReceivers() []*receiverEndpoint {
+	receivers := p.currentReceivers()
+	if len(receivers) == 0 {
+		return nil
+	}
+	start := int(p.next.Add(1) % uint64(len(receivers)))
+	healthy := make([]*receiverEndpoint, 0, len(receivers))
+	var unhealthy []*receiverEndpoint
+	for i := range receivers {
+		receiver := receivers[(start+i)%len(receivers)]
+		if receiver.healthy.Load() {
+			healthy = append(healthy, receiver)
+		} else {
+			unhealthy = append(unhealthy, receiver)
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
+func (p *receiverPool) call(receiver *receiverEndpoint, method string, params ...any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+	resp, err := receiver.client.Call(ctx, method, params...)
+	if resp != nil && resp.Error != nil {
+		if rateLimitErr, ok := rateLimitErrorFromResponse(resp.Error); ok {
+			return rateLimitErr
+		}
+		return resp.Error
+	}
+	return err
+}
+
+// Send delivers request to the first receiver that accepts it, or to the fallback relay if all of them failed
+func (p *receiverPool) Send(method string, data any) error {
+	err := p.retry.Do(func() error {
+		return p.send(method, data)
+	})
+	if err == nil || p.fallbackRelay == nil {
+		return err
+	}
+	incSenderFallbackRelayRequests(method)
+	relayErr := p.call(p.fallbackRelay, method, data)
+	if relayErr != nil {
+		senderFallbackRelayErrors.Inc()
+		return fmt.Errorf("%w, fallback relay failed: %w", err, relayErr)
+	}
+	p.log.Warn("Request was sent to the fallback relay", slog.String("method", method), slog.Any("error", err))
+	return nil
+}
+
+// setFallbackRelay configures relay that receives requests when all receivers failed, it is signed as requests to the receivers
+func (p *receiverPool) setFallbackRelay(url string) {
+	client := rpcclient.NewClientWithOpts(url, &rpcclient.RPCClientOpts{
+		HTTPClient: HTTPClientWithSigner(HTTPClientWithMaxConnections(p.maxOpenConnections), p.signer),
+	})
+	p.fallbackRelay = newReceiverEndpoint(url, client, 0)
+}
+
+func (p *receiverPool) send(method string, data any) error {
+	for _, receiver := range p.orderedReceivers() {
+		waitForDestinationRateLimiter(receiver.limiter, receiver.url)
+		start := time.Now()
+		err := p.call(receiver, method, data)
+		timeSenderReceiverRPCDuration(receiver.url, time.Since(start).Milliseconds())
+		if err == nil {
+			incSenderReceiverSuccess(receiver.url)
+			receiver.healthy.Store(true)
+			return nil
+		}
+		p.log.Warn("Failed to send request to receiver", slog.String("receiver", receiver.url), slog.Any("error", err))
+		incSenderReceiverErrors(receiver.url)
+		receiver.healthy.Store(false)
+	}
+	return errNoReceiverAvailable
+}
+
+// RunHealthChecks periodically checks all receivers until close is closed
+func (p *receiverPool) RunHealthChecks(interval time.Duration, close chan struct{}) {
+	for {
+		select {
+		case <-close:
+			return
+		case <-time.After(interval):
+			for _, receiver := range p.currentReceivers() {
+				err := p.call(receiver, ProxyVersionMethod)
+				if err != nil && receiver.healthy.Load() {
+					p.log.Warn("Receiver is unhealthy", slog.String("receiver", receiver.url), slog.Any("error", err))
+				}
+				receiver.healthy.Store(err == nil)
+			}
+		}
+	}
+}
+
+func (prx *SenderProxy) sendToReceivers(pool *receiverPool) {
+	for {
+		req, more := <-prx.shareQueue
+		if !more {
+			return
+		}
+		timeQueueWait(queueNameShare, req.method, req.shareQueuedAt)
+		method, data, ok := req.rpcMethodAndData()
+		if !ok {
+			prx.Log.Error("Unknown request type", slog.String("method", req.method))
+			shareQueueInternalErrors.Inc()
+			continue
+		}
+		err := pool.Send(method, data)
+		if err != nil {
+			prx.Log.Error("Failed to send request to any receiver", slog.String("method", method), slog.Any("error", err))
+			if prx.deadLetter != nil {
+				prx.deadLetter.Record("receivers", method, data, err)
+			}
+		}
+	}
+}
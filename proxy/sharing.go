@@ -1,57 +1,355 @@
 package proxy
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"hash/fnv"
 	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/flashbots/go-utils/rpcclient"
-	"github.com/flashbots/go-utils/signature"
+	"github.com/flashbots/go-utils/rpctypes"
+	"github.com/google/uuid"
+	"golang.org/x/time/rate"
 )
 
 var (
+	// ShareWorkerQueueSize is the capacity of the worker queues of each peer, it is split between the workers and lanes
 	ShareWorkerQueueSize = 10000
 	requestTimeout       = time.Second * 10
+
+	errBatchMissingResponse = errors.New("no response for the request in the batch")
 )
 
 type ShareQueue struct {
-	name         string
-	log          *slog.Logger
-	queue        chan *ParsedRequest
+	name  string
+	log   *slog.Logger
+	queue chan *ParsedRequest
+	// requests received on the public endpoint, if nil they are received on the queue together with the local requests
+	peerQueue    chan *ParsedRequest
 	updatePeers  chan []ConfighubBuilder
 	localBuilder rpcclient.RPCClient
-	signer       *signature.Signer
+	signer       RequestSigner
+	// signs requests to the peers, bodies shared with all peers are signed once
+	peerSigner *sharedSignatures
 	// if > 0 share queue will spawn multiple senders per peer
 	workersPerPeer int
+	// capacity of the worker queues of each peer, if 0 ShareWorkerQueueSize is used
+	workerQueueSize int
+	// if BackpressureDropOldest, oldest requests in the full queue of the worker are evicted for the new ones,
+	// otherwise new requests are dropped
+	backpressurePolicy string
+	// if set, requests that target only already built blocks are dropped before sending
+	blockNumberSource *BlockNumberSource
+	// failed requests are retried according to the policy and then recorded to the dead letter queue if set
+	retry      RetryPolicy
+	deadLetter *DeadLetterQueue
+	// if > 0 requests to each peer are limited to this rate, local builder is not limited
+	maxRPSPerPeer int
+	// if set, peers are used only after their certificate is verified with TDX attestation
+	attestation *AttestationVerifier
+	// timeout of the requests to the local builder, if 0 requestTimeout is used
+	builderTimeout time.Duration
+	// timeout of the requests to the peers, if 0 requestTimeout is used
+	peerTimeout time.Duration
+	// name of the local builder used in metrics, localBuilderPeerName if empty
+	localBuilderName string
+	// if set, raw transactions are sent to the local builder as single transaction bundles for the next block
+	rawTxToBundle bool
+	// if set, requests to peers are compressed with encoding accepted by the peer
+	compressPeerRequests bool
+	// if > 1 queued requests are sent to peers that accept batches in JSON-RPC batches of up to this size
+	batchSize int
+	// batch is closed when its requests reach this size, 0 means no limit
+	batchMaxBytes int
+	// time to wait for more requests before sending not full batch, if 0 only already queued requests are batched
+	batchLatency time.Duration
+	// requests are sent only to the peers of this shard
+	peerShard PeerShard
+	// if set, latency of the requests to the local builder is recorded for load shedding
+	builderLatency *builderLatencyTracker
+	// if set, forwarded event is published for every request
+	requestEvents *requestEventBus
+	// verified peer certificates are reused for this time, if 0 DefaultPeerCertCacheTTL is used
+	peerCertTTL time.Duration
+	// if > 0 peers with average latency above it are moved to the slow lane and get requests after the other peers
+	slowPeerLatency time.Duration
+	// if > 0 canary request is sent to every peer with this interval to measure latency to the peer
+	probeInterval time.Duration
+	// if set, peer list is fetched from the config hub in the background when peer certificate is rejected
+	refreshPeers chan<- struct{}
+	certs        *peerCertCache
+	replacements *replacementTracker
 }
 
+const localBuilderPeerName = "local-builder"
+
+// shareWorkerPriorityQueueSize is the capacity of the priority queue of each worker,
+// cancellations and subsidies that don't fit are queued with the other requests of the lane
+const shareWorkerPriorityQueueSize = 100
+
+const (
+	// requests received on the local endpoint
+	shareQueueLaneLocal = "local"
+	// requests forwarded to us by other peers
+	shareQueueLanePeer = "peer"
+)
+
 type shareQueuePeer struct {
-	ch     chan *ParsedRequest
-	name   string
-	client rpcclient.RPCClient
+	// one channel per worker and lane, requests from the same signer always go to the same worker of the lane
+	// each lane has its own workers so flood of the peer requests can't delay requests received on the local endpoint
+	localChs []chan *ParsedRequest
+	peerChs  []chan *ParsedRequest
+	// cancellations and subsidies of the lanes, workers take them before the other requests of the lane
+	// cancellation is queued as a normal request while versions of the bundle it cancels are queued,
+	// so it never depends on the replacement tracker to drop the bundles it overtook
+	localPriorityChs []chan *ParsedRequest
+	peerPriorityChs  []chan *ParsedRequest
+	// number of requests with replacement uuid in the normal channels by replacement key
+	pendingMu           sync.Mutex
+	pendingReplacements map[string]int
+	name                string
+	client              rpcclient.RPCClient
+	// url and certificate the client was created for, empty for the local builder
+	url     string
+	certKey peerCertKey
+	// used to spread requests without ordering key between workers
+	nextWorker int
+	// shared by all workers of the peer, nil if not limited
+	limiter *rate.Limiter
+	timeout time.Duration
+	// true for the local builder, its errors are classified in metrics
+	localBuilder bool
+	// max batch size advertised by the peer, batches are not sent if it is 0
+	maxBatch atomic.Int64
+	// evict oldest requests when the queue of the worker is full
+	dropOldest bool
+	// client of the canary probes, nil for the local builder
+	probeClient rpcclient.RPCClient
+	probing     atomic.Bool
+	latency     peerLatency
+	// true if the peer is in the slow lane, it is only used by the share queue loop
+	slow bool
 }
 
-func newShareQueuePeer(name string, client rpcclient.RPCClient) shareQueuePeer {
-	return shareQueuePeer{
-		ch:     make(chan *ParsedRequest, ShareWorkerQueueSize),
-		name:   name,
-		client: client,
+// newShareQueuePeer creates peer with the queues of the workers, queueSize is split between the workers of both lanes,
+// if queueSize is 0 ShareWorkerQueueSize is used
+func newShareQueuePeer(name string, client rpcclient.RPCClient, workers, queueSize int) *shareQueuePeer {
+	if queueSize <= 0 {
+		queueSize = ShareWorkerQueueSize
+	}
+	workerQueueSize := max(queueSize/(2*workers), 1)
+	priorityQueueSize := min(workerQueueSize, shareWorkerPriorityQueueSize)
+	localChs := make([]chan *ParsedRequest, workers)
+	peerChs := make([]chan *ParsedRequest, workers)
+	localPriorityChs := make([]chan *ParsedRequest, workers)
+	peerPriorityChs := make([]chan *ParsedRequest, workers)
+	for i := range workers {
+		localChs[i] = make(chan *ParsedRequest, workerQueueSize)
+		peerChs[i] = make(chan *ParsedRequest, workerQueueSize)
+		localPriorityChs[i] = make(chan *ParsedRequest, priorityQueueSize)
+		peerPriorityChs[i] = make(chan *ParsedRequest, priorityQueueSize)
+	}
+	return &shareQueuePeer{
+		localChs:            localChs,
+		peerChs:             peerChs,
+		localPriorityChs:    localPriorityChs,
+		peerPriorityChs:     peerPriorityChs,
+		pendingReplacements: make(map[string]int),
+		name:                name,
+		client:              client,
+		timeout:             requestTimeout,
 	}
 }
 
 func (p *shareQueuePeer) Close() {
-	close(p.ch)
+	for i := range p.localChs {
+		close(p.localChs[i])
+		close(p.peerChs[i])
+		close(p.localPriorityChs[i])
+		close(p.peerPriorityChs[i])
+	}
+}
+
+func (p *shareQueuePeer) worker(request *ParsedRequest) int {
+	if key, ok := request.orderingKey(); ok {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(key))
+		return int(h.Sum32() % uint32(len(p.localChs)))
+	}
+	p.nextWorker = (p.nextWorker + 1) % len(p.localChs)
+	return p.nextWorker
 }
 
 func (p *shareQueuePeer) SendRequest(log *slog.Logger, request *ParsedRequest) {
+	lane := shareQueueLaneLocal
+	if request.publicEndpoint {
+		lane = shareQueueLanePeer
+	}
+	priorityCh, ch := p.laneChs(lane, p.worker(request))
+	key, replaceable := request.replacementKey()
+	if request.highPriority() && !(replaceable && p.replacementPending(key)) {
+		select {
+		case priorityCh <- request:
+			setShareQueuePeerQueueDepth(p.name, p.queueDepth())
+			return
+		default:
+		}
+		// priority queue is full, request is queued after the other requests of the lane
+	}
+	select {
+	case ch <- request:
+		p.addPending(request, 1)
+		setShareQueuePeerQueueDepth(p.name, p.queueDepth())
+		return
+	default:
+	}
+	log.Error("Peer is stalling on requests", slog.String("peer", p.name))
+	incShareQueuePeerStallingErrors(p.name)
+	if p.dropOldest {
+		sendDropOldest(ch, request, func(evicted *ParsedRequest) {
+			p.addPending(evicted, -1)
+			incShareQueuePeerEvicted(p.name)
+		})
+		p.addPending(request, 1)
+		setShareQueuePeerQueueDepth(p.name, p.queueDepth())
+	}
+}
+
+// replacementPending returns true if a request with the replacement key is waiting in the normal channels
+func (p *shareQueuePeer) replacementPending(key string) bool {
+	p.pendingMu.Lock()
+	defer p.pendingMu.Unlock()
+	return p.pendingReplacements[key] > 0
+}
+
+// addPending updates number of the queued requests with the replacement key of the request
+func (p *shareQueuePeer) addPending(request *ParsedRequest, delta int) {
+	key, ok := request.replacementKey()
+	if !ok {
+		return
+	}
+	p.pendingMu.Lock()
+	defer p.pendingMu.Unlock()
+	if pending := p.pendingReplacements[key] + delta; pending > 0 {
+		p.pendingReplacements[key] = pending
+	} else {
+		delete(p.pendingReplacements, key)
+	}
+}
+
+// queueDepth returns number of requests waiting in all worker channels
+func (p *shareQueuePeer) queueDepth() int {
+	depth := 0
+	for i := range p.localChs {
+		depth += len(p.localChs[i]) + len(p.peerChs[i]) + len(p.localPriorityChs[i]) + len(p.peerPriorityChs[i])
+	}
+	return depth
+}
+
+// laneChs returns priority and normal channel of the worker of the lane
+func (p *shareQueuePeer) laneChs(lane string, worker int) (priority, normal chan *ParsedRequest) {
+	if lane == shareQueueLanePeer {
+		return p.peerPriorityChs[worker], p.peerChs[worker]
+	}
+	return p.localPriorityChs[worker], p.localChs[worker]
+}
+
+// nextRequestBefore returns next request for the worker of the lane if it is available before timeout,
+// if timeout is nil only already queued requests are returned
+func (p *shareQueuePeer) nextRequestBefore(lane string, worker int, timeout <-chan time.Time) (req *ParsedRequest, ok, more bool) {
+	priority, normal := p.laneChs(lane, worker)
 	select {
-	case p.ch <- request:
+	case req, more = <-priority:
+		p.dequeued(req, more, false)
+		return req, more, more
 	default:
-		log.Error("Peer is stalling on requests", slog.String("peer", p.name))
-		incShareQueuePeerStallingErrors(p.name)
+	}
+	if timeout == nil {
+		select {
+		case req, more = <-priority:
+			p.dequeued(req, more, false)
+		case req, more = <-normal:
+			p.dequeued(req, more, true)
+		default:
+			return nil, false, true
+		}
+		return req, more, more
+	}
+	select {
+	case req, more = <-priority:
+		p.dequeued(req, more, false)
+	case req, more = <-normal:
+		p.dequeued(req, more, true)
+	case <-timeout:
+		return nil, false, true
+	}
+	return req, more, more
+}
+
+// nextRequest returns next request for the worker of the lane, preferring high priority requests
+func (p *shareQueuePeer) nextRequest(lane string, worker int) (req *ParsedRequest, more bool) {
+	priority, normal := p.laneChs(lane, worker)
+	select {
+	case req, more = <-priority:
+		p.dequeued(req, more, false)
+		return req, more
+	default:
+	}
+	select {
+	case req, more = <-priority:
+		p.dequeued(req, more, false)
+	case req, more = <-normal:
+		p.dequeued(req, more, true)
+	}
+	return req, more
+}
+
+// dequeued is called when the worker takes request from the priority or normal channel
+func (p *shareQueuePeer) dequeued(req *ParsedRequest, more, normal bool) {
+	if !more {
+		return
+	}
+	if normal {
+		p.addPending(req, -1)
+	}
+	setShareQueuePeerQueueDepth(p.name, p.queueDepth())
+}
+
+// laneQueueName returns name of the receiver queue the requests of the lane are received from
+func laneQueueName(lane string) string {
+	if lane == shareQueueLanePeer {
+		return queueNamePeerShare
+	}
+	return queueNameShare
+}
+
+// startWorkers starts workers of both lanes of the peer
+func (sq *ShareQueue) startWorkers(peer *shareQueuePeer, workersPerPeer int) {
+	for worker := range workersPerPeer {
+		go sq.proxyRequests(peer, shareQueueLaneLocal, worker)
+		go sq.proxyRequests(peer, shareQueueLanePeer, worker)
 	}
 }
 
+// shareWorkersPerPeer returns configured number of workers, by default there is one worker per connection
+func shareWorkersPerPeer(connectionsPerPeer, workersPerPeer int) int {
+	if workersPerPeer > 0 {
+		return workersPerPeer
+	}
+	return connectionsPerPeer
+}
+
 func (sq *ShareQueue) Run() {
 	workersPerPeer := 1
 	if sq.workersPerPeer > 0 {
@@ -59,113 +357,616 @@ func (sq *ShareQueue) Run() {
 	}
 	var (
 		localBuilder *shareQueuePeer
-		peers        []shareQueuePeer
+		peers        []*shareQueuePeer
+		// certificates of the peers from the last update, peers verified with other certificates are discarded
+		wanted map[string]peerCertKey
 	)
+	sq.certs = newPeerCertCache(sq.peerCertTTL)
+	sq.peerSigner = newSharedSignatures(sq.signer)
+	sq.replacements = newReplacementTracker()
+	// peers are attested in the background so requests are not blocked by the network requests
+	verified := make(chan verifiedPeer)
+	done := make(chan struct{})
+	defer close(done)
+	var probes <-chan time.Time
+	if sq.probeInterval > 0 {
+		ticker := time.NewTicker(sq.probeInterval)
+		defer ticker.Stop()
+		probes = ticker.C
+	}
 	if sq.localBuilder != nil {
-		builderPeer := newShareQueuePeer("local-builder", sq.localBuilder)
-		localBuilder = &builderPeer
-		for worker := range workersPerPeer {
-			go sq.proxyRequests(localBuilder, worker)
+		localBuilderName := localBuilderPeerName
+		if sq.localBuilderName != "" {
+			localBuilderName = sq.localBuilderName
+		}
+		localBuilder = newShareQueuePeer(localBuilderName, sq.localBuilder, workersPerPeer, sq.workerQueueSize)
+		localBuilder.localBuilder = true
+		localBuilder.dropOldest = sq.backpressurePolicy == BackpressureDropOldest
+		if sq.builderTimeout > 0 {
+			localBuilder.timeout = sq.builderTimeout
 		}
+		sq.startWorkers(localBuilder, workersPerPeer)
 		defer localBuilder.Close()
 	}
+	orderTicker := time.NewTicker(peerOrderInterval)
+	defer orderTicker.Stop()
+	// requests for the slow peers, they are always delivered before the peers are updated
+	var slow []slowDelivery
 	for {
+		// local requests are dispatched first so they are not queued behind the peer requests
 		select {
 		case req, more := <-sq.queue:
-			sq.log.Debug("Share queue received a request", slog.String("name", sq.name), slog.String("method", req.method))
 			if !more {
 				sq.log.Info("Share queue closing, queue channel closed")
 				return
 			}
-			if localBuilder != nil {
-				localBuilder.SendRequest(sq.log, req)
+			slow = sq.dispatch(req, localBuilder, peers, slow)
+			if len(slow) < slowLaneMaxPending {
+				continue
 			}
-			if !req.publicEndpoint {
-				for _, peer := range peers {
-					peer.SendRequest(sq.log, req)
+		default:
+		}
+		if len(slow) > 0 {
+			// slow peers get the requests after all queued requests were queued to the fast peers
+			select {
+			case req, more := <-sq.peerQueue:
+				if !more {
+					sq.log.Info("Share queue closing, peer queue channel closed")
+					return
+				}
+				slow = sq.dispatch(req, localBuilder, peers, slow)
+				if len(slow) < slowLaneMaxPending {
+					continue
 				}
+			default:
 			}
+			slow = sq.deliverSlowLane(slow)
+		}
+		select {
+		case req, more := <-sq.queue:
+			if !more {
+				sq.log.Info("Share queue closing, queue channel closed")
+				return
+			}
+			slow = sq.dispatch(req, localBuilder, peers, slow)
+		case req, more := <-sq.peerQueue:
+			if !more {
+				sq.log.Info("Share queue closing, peer queue channel closed")
+				return
+			}
+			slow = sq.dispatch(req, localBuilder, peers, slow)
+		case <-probes:
+			sq.probePeers(peers)
+		case <-orderTicker.C:
+			sq.orderPeers(peers)
 		case newPeers, more := <-sq.updatePeers:
 			if !more {
 				sq.log.Info("Share queue closing, peer channel closed")
 				return
 			}
-			for _, peer := range peers {
-				peer.Close()
+			peers, wanted = sq.updatePeerList(peers, newPeers, workersPerPeer, verified, done)
+		case peer := <-verified:
+			if wanted[peer.info.Name] != peer.key {
+				continue
 			}
-			peers = nil
-			for _, info := range newPeers {
-				// don't send to yourself
-				if info.OrderflowProxy.EcdsaPubkeyAddress == sq.signer.Address() {
-					continue
-				}
-				client, err := RPCClientWithCertAndSigner(OrderflowProxyURLFromIP(info.IP), []byte(info.OrderflowProxy.TLSCert), sq.signer, workersPerPeer)
-				if err != nil {
-					sq.log.Error("Failed to create a peer client", slog.Any("error", err))
-					shareQueueInternalErrors.Inc()
-					continue
-				}
-				sq.log.Info("Created client for peer", slog.String("peer", info.Name), slog.String("name", sq.name))
-				newPeer := newShareQueuePeer(info.Name, client)
-				peers = append(peers, newPeer)
-				for worker := range workersPerPeer {
-					go sq.proxyRequests(&newPeer, worker)
-				}
+			if peer.err != nil {
+				sq.log.Error("Failed to verify peer attestation", slog.String("peer", peer.info.Name), slog.Any("error", peer.err))
+				peers = replacePeer(peers, peer.info.Name, nil)
+				continue
+			}
+			sq.certs.put(peer.key, peer.transport)
+			peers = replacePeer(peers, peer.info.Name, sq.startPeer(peer.info, peer.key, peer.transport, workersPerPeer))
+		}
+	}
+}
+
+// dispatch queues request to the workers of the local builder and the fast peers that should receive it,
+// request for the slow peers is added to the slow lane
+func (sq *ShareQueue) dispatch(req *ParsedRequest, localBuilder *shareQueuePeer, peers []*shareQueuePeer, slow []slowDelivery) []slowDelivery {
+	sq.log.Debug("Share queue received a request", slog.String("name", sq.name), slog.String("method", req.method))
+	previous := sq.replacements.next(req)
+	var destinations []string
+	if localBuilder != nil {
+		localBuilder.SendRequest(sq.log, req)
+		destinations = append(destinations, localBuilder.name)
+	}
+	// peers have their own mempool, only cancellation goes to every peer that was sent any version of the bundle
+	shared := !req.publicEndpoint && !req.mempool
+	cancellation := req.isCancellation()
+	var slowPeers []*shareQueuePeer
+	// peers are sorted by latency so the fastest peers get the request first
+	for _, peer := range peers {
+		if !shared && !(cancellation && slices.Contains(previous, peer.name)) {
+			continue
+		}
+		destinations = append(destinations, peer.name)
+		if peer.slow {
+			slowPeers = append(slowPeers, peer)
+			continue
+		}
+		peer.SendRequest(sq.log, req)
+	}
+	if len(slowPeers) > 0 {
+		slow = append(slow, slowDelivery{req: req, peers: slowPeers})
+	}
+	sq.replacements.record(req, destinations)
+	sq.requestEvents.publish(context.Background(), requestEvent{kind: requestEventForwarded, req: req, destinations: destinations})
+	return slow
+}
+
+// verifiedPeer is the result of the peer attestation
+type verifiedPeer struct {
+	info      ConfighubBuilder
+	key       peerCertKey
+	transport *http.Transport
+	err       error
+}
+
+// updatePeerList returns peers of the new list, peers with the same url and verified certificate keep running
+// while peers that need attestation keep the previous client until the new certificate is verified
+func (sq *ShareQueue) updatePeerList(peers []*shareQueuePeer, newPeers []ConfighubBuilder, workersPerPeer int, verified chan<- verifiedPeer, done <-chan struct{}) ([]*shareQueuePeer, map[string]peerCertKey) {
+	running := make(map[string]*shareQueuePeer, len(peers))
+	for _, peer := range peers {
+		running[peer.name] = peer
+	}
+	wanted := make(map[string]peerCertKey, len(newPeers))
+	updated := make([]*shareQueuePeer, 0, len(newPeers))
+	for _, info := range newPeers {
+		// don't send to yourself
+		if isOwnAddress(sq.signer, info.OrderflowProxy.EcdsaPubkeyAddress) {
+			continue
+		}
+		if !sq.peerShard.Owns(info.Name) {
+			sq.log.Debug("Skipping peer of another shard", slog.String("peer", info.Name), slog.String("name", sq.name))
+			continue
+		}
+		key := newPeerCertKey(info.Name, info.OrderflowProxy)
+		wanted[info.Name] = key
+		old := running[info.Name]
+		delete(running, info.Name)
+		transport, ok := sq.certs.get(key)
+		if ok && old != nil && old.certKey == key && old.url == info.OrderflowProxyURL() {
+			updated = append(updated, old)
+			continue
+		}
+		if !ok && sq.attestation != nil {
+			go sq.verifyPeer(info, key, workersPerPeer, verified, done)
+			if old != nil {
+				updated = append(updated, old)
 			}
+			continue
+		}
+		if old != nil {
+			old.Close()
+		}
+		if !ok {
+			var err error
+			transport, err = peerTransport(info, workersPerPeer)
+			if errors.Is(err, errPeerCertFingerprint) {
+				sq.log.Error("Peer certificate does not match registered fingerprints", slog.String("peer", info.Name))
+				continue
+			}
+			if err != nil {
+				sq.log.Error("Failed to create a peer client", slog.Any("error", err))
+				shareQueueInternalErrors.Inc()
+				continue
+			}
+			sq.certs.put(key, transport)
+		}
+		updated = append(updated, sq.startPeer(info, key, transport, workersPerPeer))
+	}
+	for _, peer := range running {
+		peer.Close()
+	}
+	return updated, wanted
+}
+
+// verifyPeer checks attestation of the peer certificate and returns the result to the queue
+func (sq *ShareQueue) verifyPeer(info ConfighubBuilder, key peerCertKey, workersPerPeer int, verified chan<- verifiedPeer, done <-chan struct{}) {
+	result := verifiedPeer{info: info, key: key}
+	result.transport, result.err = peerTransport(info, workersPerPeer)
+	if result.err == nil {
+		result.err = sq.attestation.Verify(info.OrderflowProxyURL(), []byte(info.OrderflowProxy.TLSCert))
+	}
+	select {
+	case verified <- result:
+	case <-done:
+	}
+}
+
+// startPeer creates client for the peer and starts its workers
+func (sq *ShareQueue) startPeer(info ConfighubBuilder, key peerCertKey, transport *http.Transport, workersPerPeer int) *shareQueuePeer {
+	peer := newShareQueuePeer(info.Name, nil, workersPerPeer, sq.workerQueueSize)
+	peer.url = info.OrderflowProxyURL()
+	peer.certKey = key
+	peer.client = rpcClientWithTransportAndSigner(peer.url, transport, sq.peerSigner, func(base http.RoundTripper) http.RoundTripper {
+		if sq.compressPeerRequests {
+			base = &compressingTransport{base: base}
 		}
+		return &batchCapabilityTransport{base: &idempotencyKeyTransport{base: base}, maxBatch: &peer.maxBatch}
+	})
+	peer.limiter = newDestinationRateLimiter(sq.maxRPSPerPeer)
+	if sq.probeInterval > 0 {
+		peer.probeClient = sq.peerProbeClient(peer.url, transport)
 	}
+	peer.dropOldest = sq.backpressurePolicy == BackpressureDropOldest
+	if sq.peerTimeout > 0 {
+		peer.timeout = sq.peerTimeout
+	}
+	sq.log.Info("Created client for peer", slog.String("peer", info.Name), slog.String("name", sq.name))
+	sq.startWorkers(peer, workersPerPeer)
+	return peer
 }
 
-func (sq *ShareQueue) proxyRequests(peer *shareQueuePeer, worker int) {
+// replacePeer closes peer with the name and puts the new one in its place, peer is removed if the new one is nil
+func replacePeer(peers []*shareQueuePeer, name string, peer *shareQueuePeer) []*shareQueuePeer {
+	for i, old := range peers {
+		if old.name != name {
+			continue
+		}
+		old.Close()
+		if peer == nil {
+			return slices.Delete(peers, i, i+1)
+		}
+		peers[i] = peer
+		return peers
+	}
+	if peer == nil {
+		return peers
+	}
+	return append(peers, peer)
+}
+
+// handlePeerError drops certificate rejected in the TLS handshake, the peer has probably renewed it
+// so the peer list is fetched from the config hub in the background
+func (sq *ShareQueue) handlePeerError(logger *slog.Logger, peer *shareQueuePeer, err error) {
+	if peer.localBuilder || sq.certs == nil || !isCertificateError(err) {
+		return
+	}
+	if errors.Is(err, errPeerCertFingerprint) {
+		logger.Error("Peer certificate does not match registered fingerprints", slog.Any("error", err))
+	} else {
+		logger.Warn("Peer certificate does not match the one from the config hub", slog.Any("error", err))
+	}
+	peerCertErrors.Inc()
+	sq.certs.invalidate(peer.name)
+	if sq.refreshPeers != nil && sq.certs.shouldRefresh() {
+		select {
+		case sq.refreshPeers <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (sq *ShareQueue) proxyRequests(peer *shareQueuePeer, lane string, worker int) {
 	proxiedRequestCount := 0
-	logger := sq.log.With(slog.String("peer", peer.name), slog.String("name", sq.name), slog.Int("worker", worker))
+	logger := sq.log.With(slog.String("peer", peer.name), slog.String("name", sq.name), slog.String("lane", lane), slog.Int("worker", worker))
 	logger.Info("Started proxying requests to peer")
 	defer func() {
 		logger.Info("Stopped proxying requets to peer", slog.Int("proxiedRequestCount", proxiedRequestCount))
 	}()
 	for {
-		req, more := <-peer.ch
+		req, more := peer.nextRequest(lane, worker)
+		if !more {
+			return
+		}
+		timeQueueWait(laneQueueName(lane), req.method, req.shareQueuedAt)
+		requests := []*ParsedRequest{req}
+		var calls []shareCall
+		if call, ok := sq.prepareCall(logger, peer, req); ok {
+			calls = append(calls, call)
+		}
+		if batchSize := sq.peerBatchSize(peer); batchSize > 1 {
+			requests, calls, more = sq.collectBatch(logger, peer, lane, worker, requests, calls, batchSize)
+		}
+		switch {
+		case len(calls) == 1:
+			err := sq.sendCall(logger, peer, calls[0])
+			if err != nil && sq.deadLetter != nil {
+				sq.deadLetter.Record(peer.name, calls[0].method, calls[0].data, err)
+			}
+		case len(calls) > 1:
+			sq.sendBatch(logger, peer, calls)
+		}
+		for _, call := range calls {
+			putBuffer(call.params)
+		}
+		for _, req := range requests {
+			timeShareQueueLaneLatency(lane, time.Since(req.receivedAt).Milliseconds())
+		}
+		proxiedRequestCount += len(requests)
+		logger.Debug("Message proxied", slog.Int("requests", len(requests)))
 		if !more {
 			return
 		}
-		var (
-			method string
-			data   any
-		)
-		if req.ethSendBundle != nil {
-			method = EthSendBundleMethod
-			data = req.ethSendBundle
-		} else if req.mevSendBundle != nil {
-			method = MevSendBundleMethod
-			data = req.mevSendBundle
-		} else if req.ethCancelBundle != nil {
-			method = EthCancelBundleMethod
-			data = req.ethCancelBundle
-		} else if req.ethSendRawTransaction != nil {
-			method = EthSendRawTransactionMethod
-			data = req.ethSendRawTransaction
-		} else if req.bidSubsidiseBlock != nil {
-			method = BidSubsidiseBlockMethod
-			data = req.bidSubsidiseBlock
+	}
+}
+
+// shareCall is a request prepared for sending to the peer
+type shareCall struct {
+	method string
+	data   any
+	// unique key of the request sent to peers in the IdempotencyKeyHeader
+	key *uuid.UUID
+	// pooled buffer of the params marshalled by collectBatch, data points to it until the call is sent
+	params *bytes.Buffer
+	// deadline of the client request, zero if it is not set
+	deadline time.Time
+}
+
+// prepareCall returns method and params of the request, ok is false if request should not be sent
+func (sq *ShareQueue) prepareCall(logger *slog.Logger, peer *shareQueuePeer, req *ParsedRequest) (call shareCall, ok bool) {
+	if sq.isStale(req) {
+		logger.Debug("Dropping stale request", slog.String("method", req.method))
+		incShareQueuePeerStaleDropped(peer.name)
+		return call, false
+	}
+	if req.expired() {
+		logger.Debug("Dropping expired request", slog.String("method", req.method))
+		incShareQueuePeerExpiredDropped(peer.name)
+		return call, false
+	}
+	if sq.replacements.superseded(req) {
+		logger.Debug("Dropping replaced request", slog.String("method", req.method))
+		incShareQueuePeerReplacedDropped(peer.name)
+		return call, false
+	}
+	method, data, ok := req.rpcMethodAndData()
+	if !ok {
+		logger.Error("Unknown request type", slog.String("method", req.method))
+		shareQueueInternalErrors.Inc()
+		return call, false
+	}
+	if peer.localBuilder && sq.rawTxToBundle && req.ethSendRawTransaction != nil {
+		bundle, err := sq.rawTxBundle(*req.ethSendRawTransaction)
+		if err != nil {
+			logger.Warn("Failed to convert raw transaction to bundle, sending it as is", slog.Any("error", err))
+			rawTxToBundleErrors.Inc()
 		} else {
-			logger.Error("Unknown request type", slog.String("method", req.method))
-			shareQueueInternalErrors.Inc()
-			continue
+			method, data = EthSendBundleMethod, bundle
+		}
+	}
+	return shareCall{method: method, data: data, key: req.requestArgUniqueKey, deadline: req.deadline}, true
+}
+
+// callContext returns context of the call to the peer that expires after the peer timeout
+// or at the deadline of the client request if it is earlier, zero deadline is ignored
+func callContext(peer *shareQueuePeer, deadline time.Time) (context.Context, context.CancelFunc) {
+	expiresAt := time.Now().Add(peer.timeout)
+	if !deadline.IsZero() && deadline.Before(expiresAt) {
+		expiresAt = deadline
+	}
+	return context.WithDeadline(context.Background(), expiresAt)
+}
+
+// batchDeadline returns the latest deadline of the calls, it is zero if any of the calls doesn't have a deadline
+func batchDeadline(calls []shareCall) time.Time {
+	var deadline time.Time
+	for _, call := range calls {
+		if call.deadline.IsZero() {
+			return time.Time{}
+		}
+		if call.deadline.After(deadline) {
+			deadline = call.deadline
+		}
+	}
+	return deadline
+}
+
+// peerBatchSize returns max number of requests sent to the peer in one batch, batches are not used for the local builder
+func (sq *ShareQueue) peerBatchSize(peer *shareQueuePeer) int {
+	if sq.batchSize <= 1 || peer.localBuilder {
+		return 0
+	}
+	return int(min(int64(sq.batchSize), peer.maxBatch.Load()))
+}
+
+// collectBatch adds queued requests to the batch until it is full, latency budget is exhausted or queue is empty
+// params are marshalled to account for the batch size, more is false if the peer was closed
+func (sq *ShareQueue) collectBatch(logger *slog.Logger, peer *shareQueuePeer, lane string, worker int, requests []*ParsedRequest, calls []shareCall, batchSize int) ([]*ParsedRequest, []shareCall, bool) {
+	batchBytes := 0
+	addCall := func(call shareCall) {
+		params, err := marshalPooled(call.data)
+		if err == nil {
+			call.data = json.RawMessage(params.Bytes())
+			call.params = params
+			batchBytes += params.Len()
+		}
+		calls = append(calls, call)
+	}
+	pending := calls
+	calls = nil
+	for _, call := range pending {
+		addCall(call)
+	}
+
+	var timeout <-chan time.Time
+	if sq.batchLatency > 0 {
+		timer := time.NewTimer(sq.batchLatency)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for len(requests) < batchSize && (sq.batchMaxBytes <= 0 || batchBytes < sq.batchMaxBytes) {
+		req, ok, more := peer.nextRequestBefore(lane, worker, timeout)
+		if !more {
+			return requests, calls, false
 		}
+		if !ok {
+			break
+		}
+		timeQueueWait(laneQueueName(lane), req.method, req.shareQueuedAt)
+		requests = append(requests, req)
+		if call, ok := sq.prepareCall(logger, peer, req); ok {
+			addCall(call)
+		}
+	}
+	return requests, calls, true
+}
+
+// sendCall sends single request to the peer with retries
+func (sq *ShareQueue) sendCall(logger *slog.Logger, peer *shareQueuePeer, call shareCall) error {
+	return sq.retry.Do(func() error {
+		waitForDestinationRateLimiter(peer.limiter, peer.name)
 		start := time.Now()
-		ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
-		resp, err := peer.client.Call(ctx, method, data)
+		ctx, cancel := callContext(peer, call.deadline)
+		if call.key != nil {
+			ctx = withIdempotencyKeys(ctx, []string{call.idempotencyKey()})
+		}
+		resp, err := peer.client.Call(ctx, call.method, call.data)
 		cancel()
 		timeShareQueuePeerRPCDuration(peer.name, time.Since(start).Milliseconds())
+		peer.latency.record(time.Since(start))
+		if peer.localBuilder {
+			sq.builderLatency.record(time.Since(start))
+		}
 		if err != nil {
 			logger.Warn("Error while proxying request", slog.Any("error", err))
 			incShareQueuePeerRPCErrors(peer.name)
+			sq.handlePeerError(logger, peer, err)
+			if peer.localBuilder {
+				class, code := classifyRPCError(err)
+				if class == rpcErrorClassTimeout {
+					builderTimeouts.Inc()
+				}
+				incBuilderRPCErrors(class, code)
+			}
+			return err
 		}
 		if resp != nil && resp.Error != nil {
 			logger.Warn("Error returned from target while proxying", slog.Any("error", resp.Error))
 			incShareQueuePeerRPCErrors(peer.name)
+			if peer.localBuilder {
+				incBuilderRPCErrors(classifyRPCError(resp.Error))
+			}
+			return resp.Error
+		}
+		incShareQueuePeerRPCSuccess(peer.name)
+		return nil
+	})
+}
+
+// sendBatch sends requests to the peer in one JSON-RPC batch, only failed requests are retried
+func (sq *ShareQueue) sendBatch(logger *slog.Logger, peer *shareQueuePeer, calls []shareCall) {
+	pending := calls
+	errs := make([]error, len(calls))
+	err := sq.retry.Do(func() error {
+		for range pending {
+			waitForDestinationRateLimiter(peer.limiter, peer.name)
+		}
+		requests := make(rpcclient.RPCRequests, len(pending))
+		keys := make([]string, len(pending))
+		for i, call := range pending {
+			requests[i] = rpcclient.NewRequest(call.method, call.data)
+			keys[i] = call.idempotencyKey()
+		}
+		updateShareQueuePeerBatchSize(peer.name, len(requests))
+		start := time.Now()
+		ctx, cancel := callContext(peer, batchDeadline(pending))
+		ctx = withIdempotencyKeys(ctx, keys)
+		responses, err := peer.client.CallBatch(ctx, requests)
+		cancel()
+		timeShareQueuePeerRPCDuration(peer.name, time.Since(start).Milliseconds())
+		peer.latency.record(time.Since(start))
+		if peer.localBuilder {
+			sq.builderLatency.record(time.Since(start))
+		}
+		if err != nil {
+			logger.Warn("Error while proxying batch", slog.Any("error", err), slog.Int("requests", len(pending)))
+			incShareQueuePeerRPCErrors(peer.name)
+			sq.handlePeerError(logger, peer, err)
+			errs = errs[:len(pending)]
+			for i := range errs {
+				errs[i] = err
+			}
+			return err
+		}
+		var failed []shareCall
+		errs = errs[:0]
+		for i, call := range pending {
+			resp := responses.GetByID(i)
+			if resp != nil && resp.Error == nil {
+				incShareQueuePeerRPCSuccess(peer.name)
+				continue
+			}
+			err := errBatchMissingResponse
+			if resp != nil {
+				err = resp.Error
+			}
+			logger.Warn("Error returned from target while proxying", slog.Any("error", err))
+			incShareQueuePeerRPCErrors(peer.name)
+			failed = append(failed, call)
+			errs = append(errs, err)
+		}
+		pending = failed
+		if len(failed) > 0 {
+			return errs[0]
 		}
-		proxiedRequestCount += 1
-		logger.Debug("Message proxied")
+		return nil
+	})
+	if err != nil && sq.deadLetter != nil {
+		for i, call := range pending {
+			sq.deadLetter.Record(peer.name, call.method, call.data, errs[i])
+		}
+	}
+}
+
+// rpcMethodAndData returns method and params used to forward the request
+func (r *ParsedRequest) rpcMethodAndData() (method string, data any, ok bool) {
+	switch {
+	case r.ethSendBundle != nil:
+		return EthSendBundleMethod, r.ethSendBundle, true
+	case r.mevSendBundle != nil:
+		return MevSendBundleMethod, r.mevSendBundle, true
+	case r.ethCancelBundle != nil:
+		return EthCancelBundleMethod, r.ethCancelBundle, true
+	case r.ethSendRawTransaction != nil:
+		return EthSendRawTransactionMethod, r.ethSendRawTransaction, true
+	case r.bidSubsidiseBlock != nil:
+		return BidSubsidiseBlockMethod, r.bidSubsidiseBlock, true
+	}
+	return "", nil, false
+}
+
+// rawTxBundle wraps raw transaction into a bundle targeting the next block
+// transaction is allowed to revert as it would be when included from the mempool
+// cached head is used so the worker is not blocked by a slow RPC node
+func (sq *ShareQueue) rawTxBundle(rawTx rpctypes.EthSendRawTransactionArgs) (*rpctypes.EthSendBundleArgs, error) {
+	if sq.blockNumberSource == nil {
+		return nil, errNoBlockNumberEndpoint
+	}
+	head, ok := sq.blockNumberSource.CachedBlockNumber()
+	if !ok {
+		return nil, errBlockNumberNotCached
+	}
+	var tx types.Transaction
+	err := tx.UnmarshalBinary(rawTx)
+	if err != nil {
+		return nil, err
+	}
+	return &rpctypes.EthSendBundleArgs{
+		Txs:               []hexutil.Bytes{hexutil.Bytes(rawTx)},
+		BlockNumber:       rpc.BlockNumber(head + 1),
+		RevertingTxHashes: []common.Hash{tx.Hash()},
+	}, nil
+}
+
+// expired returns true if the client request deadline has passed
+func (r *ParsedRequest) expired() bool {
+	return !r.deadline.IsZero() && !time.Now().Before(r.deadline)
+}
+
+// isStale returns true if all blocks targeted by the request are already built
+func (sq *ShareQueue) isStale(req *ParsedRequest) bool {
+	if sq.blockNumberSource == nil {
+		return false
+	}
+	lastBlock, ok := req.lastTargetBlock()
+	if !ok {
+		return false
+	}
+	head, ok := sq.blockNumberSource.CachedBlockNumber()
+	if !ok {
+		return false
 	}
+	return lastBlock <= head
 }
@@ -0,0 +1,276 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/flashbots/go-utils/signature"
+	"github.com/hashicorp/golang-lru/v2/expirable"
+)
+
+// RequestSigner signs outgoing requests with the orderflow key of the proxy
+// *signature.Signer keeps the key in memory, AWSKMSSigner uses key stored in AWS KMS
+// other key stores (GCP KMS, PKCS#11 HSMs) are not supported yet, they can be added as implementations of this interface
+type RequestSigner interface {
+	Address() common.Address
+	// Create returns X-Flashbots-Signature header value for the body
+	Create(body []byte) (string, error)
+}
+
+var (
+	KMSRequestTimeout = time.Second * 5
+
+	// signatures of the bodies shared with peers are reused for this long,
+	// it must stay well below the signature freshness window of the receivers
+	SharedSignatureTTL  = time.Second * 5
+	sharedSignatureSize = 10_000
+
+	errKMSPublicKey = errors.New("failed to parse KMS public key")
+	errKMSSignature = errors.New("failed to parse KMS signature")
+
+	secp256k1N     = crypto.S256().Params().N
+	secp256k1HalfN = new(big.Int).Rsh(secp256k1N, 1)
+)
+
+// signingTransport sets X-Flashbots-Signature and replay protection headers of all requests
+type signingTransport struct {
+	signer RequestSigner
+	base   http.RoundTripper
+}
+
+func (t *signingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.GetBody != nil {
+		bodyReader, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		// copy of the body is needed only for signing, request is sent with its own body
+		buf, err := readPooled(bodyReader, req.ContentLength)
+		if err != nil {
+			return nil, err
+		}
+		defer putBuffer(buf)
+		body = buf.Bytes()
+	} else if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	var (
+		signatureHeader, replayHeader string
+		err                           error
+	)
+	if shared, ok := t.signer.(*sharedSignatures); ok {
+		signatureHeader, replayHeader, err = shared.headers(body, req.URL.Host)
+	} else {
+		signatureHeader, replayHeader, err = requestHeaders(t.signer, body)
+	}
+	if err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set(signature.HTTPHeader, signatureHeader)
+	req.Header.Set(ReplayProtectionHeader, replayHeader)
+	return t.base.RoundTrip(req)
+}
+
+func requestHeaders(signer RequestSigner, body []byte) (signatureHeader, replayHeader string, err error) {
+	signatureHeader, err = signer.Create(body)
+	if err != nil {
+		return "", "", err
+	}
+	replayHeader, err = createReplayProtectionHeader(signer, body, time.Now())
+	if err != nil {
+		return "", "", err
+	}
+	return signatureHeader, replayHeader, nil
+}
+
+// sharedSignatures signs the body once for all peers instead of once per peer, signing with KMS is a network call
+// replay protection nonce can be used only once by each receiver so the body sent to the same host again
+// (e.g. retry) is signed again
+type sharedSignatures struct {
+	RequestSigner
+
+	mu     sync.Mutex
+	bodies *expirable.LRU[common.Hash, *sharedSignature]
+}
+
+type sharedSignature struct {
+	// closed when the headers are set
+	done            chan struct{}
+	signatureHeader string
+	replayHeader    string
+	err             error
+	// hosts that already got the headers, guarded by sharedSignatures.mu
+	hosts map[string]struct{}
+}
+
+func newSharedSignatures(signer RequestSigner) *sharedSignatures {
+	return &sharedSignatures{
+		RequestSigner: signer,
+		bodies:        expirable.NewLRU[common.Hash, *sharedSignature](sharedSignatureSize, nil, SharedSignatureTTL),
+	}
+}
+
+func (s *sharedSignatures) headers(body []byte, host string) (signatureHeader, replayHeader string, err error) {
+	key := crypto.Keccak256Hash(body)
+	s.mu.Lock()
+	entry, ok := s.bodies.Get(key)
+	if ok {
+		if _, sent := entry.hosts[host]; sent {
+			ok = false
+		}
+	}
+	if ok {
+		entry.hosts[host] = struct{}{}
+		s.mu.Unlock()
+		<-entry.done
+		if entry.err == nil {
+			return entry.signatureHeader, entry.replayHeader, nil
+		}
+		// error of the other request is not reused
+		return requestHeaders(s.RequestSigner, body)
+	}
+	entry = &sharedSignature{done: make(chan struct{}), hosts: map[string]struct{}{host: {}}}
+	s.bodies.Add(key, entry)
+	s.mu.Unlock()
+
+	entry.signatureHeader, entry.replayHeader, entry.err = requestHeaders(s.RequestSigner, body)
+	close(entry.done)
+	return entry.signatureHeader, entry.replayHeader, entry.err
+}
+
+// HTTPClientWithSigner returns client that signs all requests with the signer
+func HTTPClientWithSigner(client *http.Client, signer RequestSigner) *http.Client {
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &http.Client{
+		Transport: &signingTransport{signer: signer, base: base},
+		Timeout:   client.Timeout,
+	}
+}
+
+type kmsAPI interface {
+	GetPublicKey(ctx context.Context, params *kms.GetPublicKeyInput, optFns ...func(*kms.Options)) (*kms.GetPublicKeyOutput, error)
+	Sign(ctx context.Context, params *kms.SignInput, optFns ...func(*kms.Options)) (*kms.SignOutput, error)
+}
+
+// AWSKMSSigner signs requests with ECC_SECG_P256K1 key stored in AWS KMS
+type AWSKMSSigner struct {
+	client    kmsAPI
+	keyID     string
+	address   common.Address
+	publicKey []byte
+}
+
+// NewAWSKMSSigner creates signer for the KMS key, AWS credentials are loaded from the environment
+func NewAWSKMSSigner(keyID string) (*AWSKMSSigner, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), KMSRequestTimeout)
+	defer cancel()
+	cfg, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newAWSKMSSigner(kms.NewFromConfig(cfg), keyID)
+}
+
+func newAWSKMSSigner(client kmsAPI, keyID string) (*AWSKMSSigner, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), KMSRequestTimeout)
+	defer cancel()
+	resp, err := client.GetPublicKey(ctx, &kms.GetPublicKeyInput{KeyId: aws.String(keyID)})
+	if err != nil {
+		return nil, err
+	}
+
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	_, err = asn1.Unmarshal(resp.PublicKey, &spki)
+	if err != nil {
+		return nil, errors.Join(errKMSPublicKey, err)
+	}
+	publicKey, err := crypto.UnmarshalPubkey(spki.PublicKey.Bytes)
+	if err != nil {
+		return nil, errors.Join(errKMSPublicKey, err)
+	}
+
+	return &AWSKMSSigner{
+		client:    client,
+		keyID:     keyID,
+		address:   crypto.PubkeyToAddress(*publicKey),
+		publicKey: crypto.FromECDSAPub(publicKey),
+	}, nil
+}
+
+func (s *AWSKMSSigner) Address() common.Address {
+	return s.address
+}
+
+// Create signs the body in the same way as signature.Signer
+func (s *AWSKMSSigner) Create(body []byte) (string, error) {
+	digest := accounts.TextHash([]byte(hexutil.Encode(crypto.Keccak256(body))))
+
+	ctx, cancel := context.WithTimeout(context.Background(), KMSRequestTimeout)
+	defer cancel()
+	resp, err := s.client.Sign(ctx, &kms.SignInput{
+		KeyId:            aws.String(s.keyID),
+		Message:          digest,
+		MessageType:      kmstypes.MessageTypeDigest,
+		SigningAlgorithm: kmstypes.SigningAlgorithmSpecEcdsaSha256,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var sig struct {
+		R, S *big.Int
+	}
+	_, err = asn1.Unmarshal(resp.Signature, &sig)
+	if err != nil {
+		return "", errors.Join(errKMSSignature, err)
+	}
+	// ethereum accepts only signatures with low s
+	if sig.S.Cmp(secp256k1HalfN) > 0 {
+		sig.S.Sub(secp256k1N, sig.S)
+	}
+
+	signatureBytes := make([]byte, 65)
+	sig.R.FillBytes(signatureBytes[0:32])
+	sig.S.FillBytes(signatureBytes[32:64])
+	// KMS does not return recovery id so we find the one that recovers our key
+	for v := range byte(2) {
+		signatureBytes[64] = v
+		recovered, err := crypto.Ecrecover(digest, signatureBytes)
+		if err == nil && bytes.Equal(recovered, s.publicKey) {
+			signatureBytes[64] += 27
+			return fmt.Sprintf("%s:%s", s.address.Hex(), hexutil.Encode(signatureBytes)), nil
+		}
+	}
+	return "", errKMSSignature
+}
@@ -0,0 +1,198 @@
+package proxy
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+var (
+	// DefaultStatsWindows are the windows of orderflow_getStats if none are configured
+	DefaultStatsWindows = []time.Duration{time.Minute, 5 * time.Minute, 15 * time.Minute}
+	// StatsBucketDuration is the resolution of the rolling counts
+	StatsBucketDuration = 10 * time.Second
+	// StatsMaxSigners is the number of the most active signers returned for each window
+	StatsMaxSigners = 20
+)
+
+const (
+	statsKindMethod     = "method"
+	statsKindPeer       = "peer"
+	statsKindSigner     = "signer"
+	statsKindDuplicate  = "duplicate"
+	statsKindQueueStall = "queueStall"
+)
+
+type statsKey struct {
+	kind string
+	name string
+}
+
+type statsBucket struct {
+	start  time.Time
+	counts map[statsKey]uint64
+}
+
+// OrderflowStats keeps rolling counts of the received requests in fixed size buckets
+type OrderflowStats struct {
+	windows []time.Duration
+
+	mu      sync.Mutex
+	buckets []statsBucket
+}
+
+func NewOrderflowStats(windows []time.Duration) *OrderflowStats {
+	if len(windows) == 0 {
+		windows = DefaultStatsWindows
+	}
+	windows = slices.Clone(windows)
+	slices.Sort(windows)
+	bucketCount := int(windows[len(windows)-1]/StatsBucketDuration) + 1
+	return &OrderflowStats{
+		windows: windows,
+		buckets: make([]statsBucket, bucketCount),
+	}
+}
+
+func (s *OrderflowStats) add(keys ...statsKey) {
+	start := apiNow().Truncate(StatsBucketDuration)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bucket := &s.buckets[int(start.UnixNano()/int64(StatsBucketDuration))%len(s.buckets)]
+	if !bucket.start.Equal(start) {
+		bucket.start = start
+		bucket.counts = make(map[statsKey]uint64)
+	}
+	for _, key := range keys {
+		bucket.counts[key]++
+	}
+}
+
+// recordRequest counts request received by the proxy
+func (s *OrderflowStats) recordRequest(req *ParsedRequest) {
+	keys := []statsKey{{statsKindMethod, req.method}, {statsKindPeer, req.peerName}}
+	if signer := req.originalSigner(); signer != (common.Address{}) {
+		keys = append(keys, statsKey{statsKindSigner, signer.Hex()})
+	}
+	s.add(keys...)
+}
+
+// recordDuplicate counts request dropped as a duplicate of already received one
+func (s *OrderflowStats) recordDuplicate(req *ParsedRequest) {
+	s.add(statsKey{statsKindDuplicate, req.peerName})
+}
+
+// recordQueueStall counts request that could not be queued
+func (s *OrderflowStats) recordQueueStall(queueName string) {
+	s.add(statsKey{statsKindQueueStall, queueName})
+}
+
+type OrderflowStatsWindow struct {
+	Window        string  `json:"window"`
+	Requests      uint64  `json:"requests"`
+	Duplicates    uint64  `json:"duplicates"`
+	DuplicateRate float64 `json:"duplicateRate"`
+	// Methods, Peers and Signers are the number of requests by method, peer that sent them and original signer
+	Methods map[string]uint64 `json:"methods"`
+	Peers   map[string]uint64 `json:"peers"`
+	// Signers are only the StatsMaxSigners most active signers
+	Signers map[string]uint64 `json:"signers"`
+	// DuplicatesByPeer is the number of duplicates by peer that sent them
+	DuplicatesByPeer map[string]uint64 `json:"duplicatesByPeer"`
+	// QueueStalls is the number of requests that could not be queued by queue
+	QueueStalls map[string]uint64 `json:"queueStalls"`
+}
+
+type OrderflowQueueStats struct {
+	Length   int `json:"length"`
+	Capacity int `json:"capacity"`
+}
+
+type OrderflowStatsResponse struct {
+	Windows []OrderflowStatsWindow         `json:"windows"`
+	Queues  map[string]OrderflowQueueStats `json:"queues"`
+}
+
+func (s *OrderflowStats) snapshot() []OrderflowStatsWindow {
+	now := apiNow()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make([]OrderflowStatsWindow, 0, len(s.windows))
+	for _, window := range s.windows {
+		stats := OrderflowStatsWindow{
+			Window:           window.String(),
+			Methods:          make(map[string]uint64),
+			Peers:            make(map[string]uint64),
+			Signers:          make(map[string]uint64),
+			DuplicatesByPeer: make(map[string]uint64),
+			QueueStalls:      make(map[string]uint64),
+		}
+		// bucket is counted if it started within the window
+		from := now.Add(-window)
+		for _, bucket := range s.buckets {
+			if bucket.counts == nil || !bucket.start.After(from) || bucket.start.After(now) {
+				continue
+			}
+			for key, count := range bucket.counts {
+				switch key.kind {
+				case statsKindMethod:
+					stats.Methods[key.name] += count
+					stats.Requests += count
+				case statsKindPeer:
+					stats.Peers[key.name] += count
+				case statsKindSigner:
+					stats.Signers[key.name] += count
+				case statsKindDuplicate:
+					stats.DuplicatesByPeer[key.name] += count
+					stats.Duplicates += count
+				case statsKindQueueStall:
+					stats.QueueStalls[key.name] += count
+				}
+			}
+		}
+		if stats.Requests > 0 {
+			stats.DuplicateRate = float64(stats.Duplicates) / float64(stats.Requests)
+		}
+		stats.Signers = topCounts(stats.Signers, StatsMaxSigners)
+		result = append(result, stats)
+	}
+	return result
+}
+
+// topCounts returns n entries with the highest counts
+func topCounts(counts map[string]uint64, n int) map[string]uint64 {
+	if len(counts) <= n {
+		return counts
+	}
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if counts[names[i]] != counts[names[j]] {
+			return counts[names[i]] > counts[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	top := make(map[string]uint64, n)
+	for _, name := range names[:n] {
+		top[name] = counts[name]
+	}
+	return top
+}
+
+// GetStats returns rolling counts of the received requests and the state of the queues
+func (prx *ReceiverProxy) GetStats(ctx context.Context) (*OrderflowStatsResponse, error) {
+	return &OrderflowStatsResponse{
+		Windows: prx.stats.snapshot(),
+		Queues: map[string]OrderflowQueueStats{
+			queueNameShare:     {Length: len(prx.shareQueue), Capacity: cap(prx.shareQueue)},
+			queueNamePeerShare: {Length: len(prx.peerShareQueue), Capacity: cap(prx.peerShareQueue)},
+			queueNameArchive:   {Length: len(prx.archiveQueue), Capacity: cap(prx.archiveQueue)},
+		},
+	}, nil
+}
@@ -0,0 +1,161 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/flashbots/go-utils/rpcserver"
+)
+
+// strictParamsHandler rejects requests with params that have unknown fields or values of wrong type,
+// rpcserver ignores unknown fields so mistakes of the clients would be dropped silently
+// params are decoded only to be checked, next handler decodes them again
+func strictParamsHandler(next http.Handler, methods rpcserver.Methods, maxRequestBodySizeBytes int64) http.Handler {
+	paramTypes := make(map[string][]reflect.Type, len(methods))
+	for name, method := range methods {
+		fnType := reflect.TypeOf(method)
+		for i := 1; i < fnType.NumIn(); i++ {
+			paramTypes[name] = append(paramTypes[name], fnType.In(i))
+		}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		buf, err := readPooled(http.MaxBytesReader(w, r.Body, maxRequestBodySizeBytes), r.ContentLength)
+		if err != nil {
+			writeJSONRPCError(w, fmt.Sprintf("request body is too big, max size: %d", maxRequestBodySizeBytes))
+			return
+		}
+		defer putBuffer(buf)
+
+		// malformed requests and unknown methods are reported by rpcserver
+		var req struct {
+			ID     json.RawMessage   `json:"id"`
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		if json.Unmarshal(buf.Bytes(), &req) == nil {
+			for i, param := range req.Params {
+				if i >= len(paramTypes[req.Method]) {
+					break
+				}
+				paramType := paramTypes[req.Method][i]
+				err := decodeStrict(param, reflect.New(paramType).Interface())
+				if err != nil {
+					apiStrictDecodingRejections.Inc()
+					field, msg := strictDecodingError(err, param, paramType)
+					writeStrictDecodingError(w, req.ID, field, msg)
+					return
+				}
+			}
+		}
+		r.Body = io.NopCloser(bytes.NewReader(buf.Bytes()))
+		next.ServeHTTP(w, r)
+	})
+}
+
+func decodeStrict(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// strictDecodingError returns name of the field and the message describing what is wrong with it
+func strictDecodingError(err error, param json.RawMessage, paramType reflect.Type) (field, msg string) {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		field = typeErr.Field
+		if field == "" {
+			// errors of json.Unmarshaler types like hexutil.Uint64 don't have the field
+			field = typeErrorField(param, paramType, "")
+		}
+		if field == "" {
+			return "", fmt.Sprintf("params: expected %s, got %s", typeErr.Type, typeErr.Value)
+		}
+		return field, fmt.Sprintf("field %s: expected %s, got %s", field, typeErr.Type, typeErr.Value)
+	}
+	// encoding/json does not export error of unknown fields
+	if name, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		field = strings.Trim(name, `"`)
+		return field, fmt.Sprintf("unknown field %s", name)
+	}
+	return "", "params: " + err.Error()
+}
+
+// typeErrorField returns path of the first field of the value that can't be decoded to its type
+func typeErrorField(data json.RawMessage, t reflect.Type, path string) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if bytes.Equal(bytes.TrimSpace(data), []byte("null")) {
+		return ""
+	}
+	if reflect.PointerTo(t).Implements(reflect.TypeFor[json.Unmarshaler]()) || (t.Kind() != reflect.Struct && t.Kind() != reflect.Slice) {
+		if json.Unmarshal(data, reflect.New(t).Interface()) != nil {
+			return path
+		}
+		return ""
+	}
+	if t.Kind() == reflect.Slice {
+		var elements []json.RawMessage
+		if json.Unmarshal(data, &elements) != nil {
+			return path
+		}
+		for i, element := range elements {
+			if field := typeErrorField(element, t.Elem(), fmt.Sprintf("%s[%d]", path, i)); field != "" {
+				return field
+			}
+		}
+		return ""
+	}
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(data, &fields) != nil {
+		return path
+	}
+	for i := range t.NumField() {
+		structField := t.Field(i)
+		name, _, _ := strings.Cut(structField.Tag.Get("json"), ",")
+		if !structField.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = structField.Name
+		}
+		value, ok := fields[name]
+		if !ok {
+			continue
+		}
+		fieldPath := name
+		if path != "" {
+			fieldPath = path + "." + name
+		}
+		if field := typeErrorField(value, structField.Type, fieldPath); field != "" {
+			return field
+		}
+	}
+	return ""
+}
+
+func writeStrictDecodingError(w http.ResponseWriter, id json.RawMessage, field, msg string) {
+	if len(id) == 0 {
+		id = json.RawMessage("null")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"jsonrpc": "2.0",
+		"id":      id,
+		"error": map[string]any{
+			"code":    ErrorCodeInvalidParams,
+			"message": msg,
+			"data":    RPCErrorData{Reason: ErrorReasonDecoding, Field: field},
+		},
+	})
+}
@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/flashbots/go-utils/rpcclient"
+)
+
+var errLocalTenant = errors.New("invalid local tenant, expected name=builder_endpoint")
+
+// LocalTenant is a separate builder served on /<name> path of the local endpoint
+// requests of the tenant are sent only to its builder and are not shared with peers
+type LocalTenant struct {
+	Name            string
+	BuilderEndpoint string
+}
+
+// ParseLocalTenants parses list of "name=builder_endpoint" entries
+func ParseLocalTenants(entries []string) ([]LocalTenant, error) {
+	tenants := make([]LocalTenant, 0, len(entries))
+	names := make(map[string]struct{}, len(entries))
+	for _, entry := range entries {
+		name, endpoint, found := strings.Cut(entry, "=")
+		_, duplicate := names[name]
+		if !found || name == "" || endpoint == "" || strings.Contains(name, "/") || duplicate {
+			return nil, fmt.Errorf("%w: %s", errLocalTenant, entry)
+		}
+		names[name] = struct{}{}
+		tenants = append(tenants, LocalTenant{Name: name, BuilderEndpoint: endpoint})
+	}
+	return tenants, nil
+}
+
+type localTenant struct {
+	queue       chan *ParsedRequest
+	updatePeers chan []ConfighubBuilder
+}
+
+type tenantContextKey struct{}
+
+func tenantFromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantContextKey{}).(string)
+	return tenant
+}
+
+// tenantHandler marks requests with the tenant name before they reach the local handler
+func tenantHandler(tenant string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), tenantContextKey{}, tenant)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// startLocalTenants starts share queue delivering requests to the builder of each tenant
+// and serves tenants on their paths of the local handler
+// base share queue provides the delivery options shared by all tenants
+func (prx *ReceiverProxy) startLocalTenants(tenants []LocalTenant, builderOpts *rpcclient.RPCClientOpts, queueSize int, base ShareQueue) {
+	prx.tenants = make(map[string]*localTenant, len(tenants))
+	mux := http.NewServeMux()
+	mux.Handle("/", prx.LocalHandler)
+	for _, tenant := range tenants {
+		t := &localTenant{
+			queue:       make(chan *ParsedRequest, queueSize),
+			updatePeers: make(chan []ConfighubBuilder),
+		}
+		prx.tenants[tenant.Name] = t
+		queue := base
+		queue.name = tenant.Name
+		queue.log = prx.Log.With(slog.String("tenant", tenant.Name))
+		queue.queue = t.queue
+		queue.updatePeers = t.updatePeers
+		queue.localBuilder = rpcclient.NewClientWithOpts(tenant.BuilderEndpoint, builderOpts)
+		queue.localBuilderName = localBuilderPeerName + "-" + tenant.Name
+		go queue.Run()
+		mux.Handle("/"+tenant.Name, tenantHandler(tenant.Name, prx.LocalHandler))
+	}
+	prx.LocalHandler = mux
+}
+
+func (prx *ReceiverProxy) stopLocalTenants() {
+	for _, t := range prx.tenants {
+		close(t.queue)
+		close(t.updatePeers)
+	}
+}
@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/flashbots/go-utils/rpctypes"
+	"github.com/hashicorp/golang-lru/v2/expirable"
+)
+
+var (
+	// TxDuplicatesCacheSize is the number of recently received transactions checked for duplicates
+	TxDuplicatesCacheSize = 100_000
+	// TxDuplicatesTTL is the time transaction is remembered after it was first received
+	TxDuplicatesTTL = time.Minute
+)
+
+const (
+	// transaction was received in another request from the same source
+	txDuplicateKindRequest = "request"
+	// transaction was received from another peer or from local and public endpoint
+	txDuplicateKindPeer = "peer"
+)
+
+// txDuplicateTracker measures how often the same transactions arrive in different requests and from different peers
+// transactions are identified by the hash of the raw bytes, it is the transaction hash for all transactions except blob
+// transactions with sidecar, so they are not decoded again
+type txDuplicateTracker struct {
+	log *slog.Logger
+	// hash of the transaction to the peer it was first received from
+	seen *expirable.LRU[common.Hash, string]
+}
+
+func newTxDuplicateTracker(log *slog.Logger) *txDuplicateTracker {
+	return &txDuplicateTracker{
+		log:  log,
+		seen: expirable.NewLRU[common.Hash, string](TxDuplicatesCacheSize, nil, TxDuplicatesTTL),
+	}
+}
+
+// record counts transactions of the request that were already received in other requests
+// it must be called once per request after duplicate requests were dropped
+func (t *txDuplicateTracker) record(req *ParsedRequest) {
+	hashes := requestTxHashes(req)
+	for _, hash := range hashes {
+		incAPITxs(req.peerName)
+		first, ok := t.seen.Peek(hash)
+		if !ok {
+			t.seen.Add(hash, req.peerName)
+			continue
+		}
+		kind := txDuplicateKindRequest
+		if first != req.peerName {
+			kind = txDuplicateKindPeer
+		}
+		incAPITxDuplicates(kind, req.peerName)
+		t.log.Debug("Received duplicate transaction", slog.String("tx", hash.Hex()), slog.String("method", req.method),
+			slog.String("peer", req.peerName), slog.String("firstPeer", first), slog.String("kind", kind))
+	}
+}
+
+// requestTxHashes returns hashes of the raw transactions of the request, transactions repeated in the request are returned once
+func requestTxHashes(req *ParsedRequest) []common.Hash {
+	var hashes []common.Hash
+	add := func(rawTx []byte) {
+		hash := crypto.Keccak256Hash(rawTx)
+		for _, seen := range hashes {
+			if seen == hash {
+				return
+			}
+		}
+		hashes = append(hashes, hash)
+	}
+	switch {
+	case req.ethSendBundle != nil:
+		for _, tx := range req.ethSendBundle.Txs {
+			add(tx)
+		}
+	case req.mevSendBundle != nil:
+		var addBundle func(bundle *rpctypes.MevSendBundleArgs)
+		addBundle = func(bundle *rpctypes.MevSendBundleArgs) {
+			for _, body := range bundle.Body {
+				if body.Tx != nil {
+					add(*body.Tx)
+				}
+				if body.Bundle != nil {
+					addBundle(body.Bundle)
+				}
+			}
+		}
+		addBundle(req.mevSendBundle)
+	case req.ethSendRawTransaction != nil:
+		add(*req.ethSendRawTransaction)
+	}
+	return hashes
+}
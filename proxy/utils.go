@@ -5,20 +5,76 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
+	"fmt"
 	"net"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
 
-	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/flashbots/go-utils/rpcclient"
-	"github.com/flashbots/go-utils/signature"
+	"golang.org/x/time/rate"
 )
 
 var DefaultOrderflowProxyPublicPort = "5544"
 
-var errCertificate = errors.New("failed to add certificate to pool")
+var (
+	errCertificate      = errors.New("failed to add certificate to pool")
+	errPrivilegedSigner = errors.New("invalid privileged signer, expected name=address")
+	errMethodAlias      = errors.New("invalid method alias, expected alias=method")
+	errStatsWindow      = errors.New("invalid stats window, expected duration not shorter than the stats bucket")
+)
+
+// newDestinationRateLimiter returns limiter that allows maxRPS requests per second, nil means no limit
+func newDestinationRateLimiter(maxRPS int) *rate.Limiter {
+	if maxRPS <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(maxRPS), maxRPS)
+}
+
+// waitForDestinationRateLimiter blocks until request to the destination is allowed by the limiter
+func waitForDestinationRateLimiter(limiter *rate.Limiter, destination string) {
+	if limiter == nil || limiter.Allow() {
+		return
+	}
+	incDestinationRateLimited(destination)
+	_ = limiter.Wait(context.Background())
+}
+
+const (
+	rpcErrorClassTimeout           = "timeout"
+	rpcErrorClassConnectionRefused = "connection_refused"
+	rpcErrorClassHTTP4xx           = "http_4xx"
+	rpcErrorClassHTTP5xx           = "http_5xx"
+	rpcErrorClassJSONRPC           = "jsonrpc"
+	rpcErrorClassOther             = "other"
+)
+
+// classifyRPCError returns class of the error returned by rpcclient and JSON-RPC or HTTP code if available
+func classifyRPCError(err error) (class, code string) {
+	var (
+		rpcErr  *rpcclient.RPCError
+		httpErr *rpcclient.HTTPError
+		netErr  net.Error
+	)
+	switch {
+	case errors.As(err, &rpcErr):
+		return rpcErrorClassJSONRPC, strconv.Itoa(rpcErr.Code)
+	case errors.As(err, &httpErr) && httpErr.Code >= http.StatusInternalServerError:
+		return rpcErrorClassHTTP5xx, strconv.Itoa(httpErr.Code)
+	case errors.As(err, &httpErr):
+		return rpcErrorClassHTTP4xx, strconv.Itoa(httpErr.Code)
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return rpcErrorClassTimeout, ""
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return rpcErrorClassConnectionRefused, ""
+	}
+	return rpcErrorClassOther, ""
+}
 
 func createTransportForSelfSignedCert(certPEM []byte) (*http.Transport, error) {
 	certPool := x509.NewCertPool()
@@ -43,68 +99,111 @@ func HTTPClientWithMaxConnections(maxOpenConnections int) *http.Client {
 }
 
 //nolint:ireturn
-func RPCClientWithCertAndSigner(endpoint string, certPEM []byte, signer *signature.Signer, maxOpenConnections int) (rpcclient.RPCClient, error) {
+func RPCClientWithCertAndSigner(endpoint string, certPEM []byte, signer RequestSigner, maxOpenConnections int) (rpcclient.RPCClient, error) {
+	return rpcClientWithCertAndSigner(endpoint, certPEM, signer, maxOpenConnections, nil)
+}
+
+// rpcClientWithCertAndSigner wraps the transport with wrapTransport if set, wrapped transport gets already signed requests
+//
+//nolint:ireturn
+func rpcClientWithCertAndSigner(endpoint string, certPEM []byte, signer RequestSigner, maxOpenConnections int, wrapTransport func(http.RoundTripper) http.RoundTripper) (rpcclient.RPCClient, error) {
+	transport, err := transportWithCert(certPEM, maxOpenConnections)
+	if err != nil {
+		return nil, err
+	}
+	return rpcClientWithTransportAndSigner(endpoint, transport, signer, wrapTransport), nil
+}
+
+func transportWithCert(certPEM []byte, maxOpenConnections int) (*http.Transport, error) {
 	transport, err := createTransportForSelfSignedCert(certPEM)
 	if err != nil {
 		return nil, err
 	}
 	transport.MaxIdleConns = maxOpenConnections
 	transport.MaxIdleConnsPerHost = maxOpenConnections
+	return transport, nil
+}
+
+// rpcClientWithTransportAndSigner creates client that shares the transport and its connections with other clients
+//
+//nolint:ireturn
+func rpcClientWithTransportAndSigner(endpoint string, transport *http.Transport, signer RequestSigner, wrapTransport func(http.RoundTripper) http.RoundTripper) rpcclient.RPCClient {
+	var base http.RoundTripper = transport
+	if wrapTransport != nil {
+		base = wrapTransport(transport)
+	}
 	client := rpcclient.NewClientWithOpts(endpoint, &rpcclient.RPCClientOpts{
 		HTTPClient: &http.Client{
-			Transport: transport,
+			Transport: &signingTransport{signer: signer, base: base},
 		},
-		Signer: signer,
 	})
-	return client, nil
+	return client
 }
 
-func OrderflowProxyURLFromIP(ip string) string {
-	if strings.Contains(ip, ":") {
-		return "https://" + ip
-	} else {
-		return "https://" + net.JoinHostPort(ip, DefaultOrderflowProxyPublicPort)
+// ParsePrivilegedSigners parses list of "name=address" entries
+func ParsePrivilegedSigners(entries []string) (map[common.Address]string, error) {
+	signers := make(map[common.Address]string, len(entries))
+	for _, entry := range entries {
+		name, address, found := strings.Cut(entry, "=")
+		if !found || name == "" || !common.IsHexAddress(address) {
+			return nil, fmt.Errorf("%w: %s", errPrivilegedSigner, entry)
+		}
+		signers[common.HexToAddress(address)] = name
 	}
+	return signers, nil
 }
 
-type BlockNumberSource struct {
-	client         rpcclient.RPCClient
-	cacheMu        sync.RWMutex
-	cacheTimestamp time.Time
-	cachedNumber   uint64
+// ParseMethodAliases parses list of "alias=method" entries
+func ParseMethodAliases(entries []string) (map[string]string, error) {
+	aliases := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		alias, method, found := strings.Cut(entry, "=")
+		if !found || alias == "" || method == "" {
+			return nil, fmt.Errorf("%w: %s", errMethodAlias, entry)
+		}
+		aliases[alias] = method
+	}
+	return aliases, nil
 }
 
-func NewBlockNumberSource(endpoint string) *BlockNumberSource {
-	client := rpcclient.NewClient(endpoint)
-	return &BlockNumberSource{
-		client: client,
+// ParseStatsWindows parses list of durations of the stats windows
+func ParseStatsWindows(entries []string) ([]time.Duration, error) {
+	windows := make([]time.Duration, 0, len(entries))
+	for _, entry := range entries {
+		window, err := time.ParseDuration(entry)
+		if err != nil || window < StatsBucketDuration {
+			return nil, fmt.Errorf("%w: %s", errStatsWindow, entry)
+		}
+		windows = append(windows, window)
 	}
+	return windows, nil
 }
 
-func (bs *BlockNumberSource) UpdateCachedBlockNumber() error {
-	var numberHex hexutil.Uint64
-	err := bs.client.CallFor(context.Background(), &numberHex, "eth_blockNumber")
-	if err != nil {
-		return err
+// OrderflowProxyURLFromIP returns URL of the peer public endpoint from the config hub address
+// address can be a full URL, host:port or a host without port where host is IPv4, IPv6 (bracketed or not) or DNS name
+func OrderflowProxyURLFromIP(ip string) string {
+	if strings.Contains(ip, "://") {
+		return ip
+	}
+	if _, _, err := net.SplitHostPort(ip); err == nil {
+		return "https://" + ip
 	}
-	bs.cacheMu.Lock()
-	bs.cacheTimestamp = time.Now()
-	bs.cachedNumber = uint64(numberHex)
-	bs.cacheMu.Unlock()
-	return nil
+	host := strings.TrimSuffix(strings.TrimPrefix(ip, "["), "]")
+	return "https://" + net.JoinHostPort(host, DefaultOrderflowProxyPublicPort)
 }
 
-func (bs *BlockNumberSource) BlockNumber() (uint64, error) {
-	bs.cacheMu.RLock()
-	if time.Since(bs.cacheTimestamp) > time.Second*3 {
-		bs.cacheMu.RUnlock()
-		err := bs.UpdateCachedBlockNumber()
-		if err != nil {
-			return 0, err
+// CertHostsForSANs strips schemes, ports and IPv6 brackets so that hosts are added to the certificate
+// as IP addresses or DNS names
+func CertHostsForSANs(hosts []string) []string {
+	result := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		if parsed, err := url.Parse(host); err == nil && parsed.Host != "" {
+			host = parsed.Host
+		}
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
 		}
-		bs.cacheMu.RLock()
+		result = append(result, strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"))
 	}
-	res := bs.cachedNumber
-	bs.cacheMu.RUnlock()
-	return res, nil
+	return result
 }
@@ -0,0 +1,173 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+var (
+	// DefaultWASMPolicyTimeout is the time policy module can run for one request
+	DefaultWASMPolicyTimeout = time.Millisecond * 20
+	// DefaultWASMPolicyMaxMemoryBytes is the max memory of policy module
+	DefaultWASMPolicyMaxMemoryBytes = 16 * 1024 * 1024
+
+	errWASMPolicyExports  = errors.New("policy module must export memory, alloc(len i32) i32 and filter(ptr i32, len i32) i32")
+	errWASMPolicyDecision = errors.New("policy module returned unknown decision")
+	errWASMPolicyMemory   = errors.New("policy module returned input pointer out of its memory")
+)
+
+const wasmPageSize = 64 * 1024
+
+// WASMPolicyOpts are the limits of the policy module, defaults are used for zero values
+type WASMPolicyOpts struct {
+	Timeout        time.Duration
+	MaxMemoryBytes int
+}
+
+// WASMPolicyFilter is RequestFilter evaluated by a WASM module, so policies can be updated without rebuilding the proxy
+//
+// module must export:
+//   - memory
+//   - alloc(len i32) i32 that returns pointer to len bytes of memory for the input
+//   - filter(ptr i32, len i32) i32 that returns Decision for the request encoded as wasmPolicyInput JSON
+//
+// every request is evaluated by a new instance of the module so requests don't share state,
+// module that runs out of time or memory or returns unknown decision rejects the request
+type WASMPolicyFilter struct {
+	name    string
+	runtime wazero.Runtime
+	module  wazero.CompiledModule
+	timeout time.Duration
+}
+
+// wasmPolicyInput is the request passed to the policy module
+type wasmPolicyInput struct {
+	Method         string         `json:"method"`
+	PublicEndpoint bool           `json:"publicEndpoint"`
+	Peer           string         `json:"peer"`
+	Signer         common.Address `json:"signer"`
+	Tenant         string         `json:"tenant,omitempty"`
+	Params         any            `json:"params"`
+}
+
+// LoadWASMPolicyFilter loads policy module from the file, name of the file without extension is the name of the policy
+func LoadWASMPolicyFilter(path string, opts WASMPolicyOpts) (*WASMPolicyFilter, error) {
+	wasm, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return NewWASMPolicyFilter(name, wasm, opts)
+}
+
+func NewWASMPolicyFilter(name string, wasm []byte, opts WASMPolicyOpts) (*WASMPolicyFilter, error) {
+	timeout := DefaultWASMPolicyTimeout
+	if opts.Timeout != 0 {
+		timeout = opts.Timeout
+	}
+	maxMemoryBytes := DefaultWASMPolicyMaxMemoryBytes
+	if opts.MaxMemoryBytes != 0 {
+		maxMemoryBytes = opts.MaxMemoryBytes
+	}
+	config := wazero.NewRuntimeConfig().
+		WithCloseOnContextDone(true).
+		WithMemoryLimitPages(uint32(max(maxMemoryBytes/wasmPageSize, 1))) //nolint:gosec
+	ctx := context.Background()
+	runtime := wazero.NewRuntimeWithConfig(ctx, config)
+	module, err := runtime.CompileModule(ctx, wasm)
+	if err != nil {
+		_ = runtime.Close(ctx)
+		return nil, fmt.Errorf("policy %s: %w", name, err)
+	}
+	if !hasWASMPolicyExports(module) {
+		_ = runtime.Close(ctx)
+		return nil, fmt.Errorf("policy %s: %w", name, errWASMPolicyExports)
+	}
+	return &WASMPolicyFilter{name: name, runtime: runtime, module: module, timeout: timeout}, nil
+}
+
+func hasWASMPolicyExports(module wazero.CompiledModule) bool {
+	if _, ok := module.ExportedMemories()["memory"]; !ok {
+		return false
+	}
+	i32 := []api.ValueType{api.ValueTypeI32}
+	functions := module.ExportedFunctions()
+	alloc, ok := functions["alloc"]
+	if !ok || !slices.Equal(alloc.ParamTypes(), i32) || !slices.Equal(alloc.ResultTypes(), i32) {
+		return false
+	}
+	filter, ok := functions["filter"]
+	return ok && slices.Equal(filter.ParamTypes(), []api.ValueType{api.ValueTypeI32, api.ValueTypeI32}) && slices.Equal(filter.ResultTypes(), i32)
+}
+
+func (f *WASMPolicyFilter) Apply(ctx context.Context, req *ParsedRequest) (Decision, error) {
+	start := time.Now()
+	decision, err := f.evaluate(ctx, req)
+	wasmPolicyDuration.Update(float64(time.Since(start).Microseconds()))
+	if err != nil {
+		incWASMPolicyErrors(f.name)
+		return DecisionReject, fmt.Errorf("policy %s: %w", f.name, err)
+	}
+	if decision == DecisionReject {
+		return DecisionReject, fmt.Errorf("%w by policy %s", errRequestRejected, f.name)
+	}
+	return decision, nil
+}
+
+func (f *WASMPolicyFilter) evaluate(ctx context.Context, req *ParsedRequest) (Decision, error) {
+	_, params, _ := req.rpcMethodAndData()
+	input, err := json.Marshal(wasmPolicyInput{
+		Method:         req.method,
+		PublicEndpoint: req.publicEndpoint,
+		Peer:           req.peerName,
+		Signer:         req.originalSigner(),
+		Tenant:         req.tenant,
+		Params:         params,
+	})
+	if err != nil {
+		return DecisionReject, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+	// anonymous instances can run concurrently
+	instance, err := f.runtime.InstantiateModule(ctx, f.module, wazero.NewModuleConfig().WithName("").WithStartFunctions())
+	if err != nil {
+		return DecisionReject, err
+	}
+	defer instance.Close(context.Background())
+
+	results, err := instance.ExportedFunction("alloc").Call(ctx, uint64(len(input)))
+	if err != nil {
+		return DecisionReject, err
+	}
+	ptr := uint32(results[0]) //nolint:gosec
+	if !instance.Memory().Write(ptr, input) {
+		return DecisionReject, errWASMPolicyMemory
+	}
+	results, err = instance.ExportedFunction("filter").Call(ctx, uint64(ptr), uint64(len(input)))
+	if err != nil {
+		return DecisionReject, err
+	}
+	decision := Decision(int32(results[0])) //nolint:gosec
+	switch decision {
+	case DecisionAccept, DecisionReject, DecisionDrop:
+		return decision, nil
+	}
+	return DecisionReject, errWASMPolicyDecision
+}
+
+func (f *WASMPolicyFilter) Close() error {
+	return f.runtime.Close(context.Background())
+}